@@ -1,33 +1,111 @@
 package admin
 
 import (
+	"io"
 	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/workoutlog"
 )
 
 var DBPath string
-var DBBackupDir = "backups"
-var DBDailyBackupFile = "backup.bak"
+var DBBackupDir = filepath.Join("..", "backups")
+
+// Backups maintains the catalog of database backups.
+var Backups *dal.BackupManager
+
+// InitBackups creates the catalog of database backups in the backup directory and schedules the daily backup.
+// A relative backup directory is relative to DBPath.
+// A warning is logged when the backup directory is inside the database directory.
+// The daily backup is saved at a local time of day, expressed as the time since midnight.
+func InitBackups(client *dal.DBClient, backupDir string, retention dal.RetentionPolicy, fullInterval, at time.Duration) error {
+	DBBackupDir = backupDir
+	if !filepath.IsAbs(backupDir) {
+		backupDir = filepath.Join(DBPath, backupDir)
+	}
+
+	if rel, err := filepath.Rel(DBPath, backupDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		slog.Warn("the backup directory is inside the database directory; set backup.dir to a directory outside of it", "dir", backupDir)
+	}
+
+	bm, err := dal.NewBackupManager(client, backupDir, retention)
+	if err != nil {
+		return err
+	}
+	bm.FullInterval = fullInterval
+
+	Backups = bm
+	Backups.ScheduleDaily(at)
+
+	return nil
+}
 
 // DatabaseAdmin defines functions for performing database admin tasks.
 type DatabaseAdmin interface {
-	RestoreBackup(userID, filepath string) error
+	ListBackups() ([]dal.Backup, error)
+	CreateBackup() (*dal.Backup, error)
+	OpenBackup(backupID string) (io.ReadCloser, error)
+	UploadBackup(file io.Reader) (*dal.Backup, error)
+	RestoreBackup(backupID string) (*dal.RestoreSummary, error)
 }
 
 // DatabaseManager implements DatabaseAdmin.
 type DatabaseUtil struct{}
 
-var DatabaseManager DatabaseUtil = DatabaseUtil{}
+var DatabaseManager DatabaseAdmin = &DatabaseUtil{}
+
+// ListBackups returns the backups in the catalog, oldest first.
+func (*DatabaseUtil) ListBackups() ([]dal.Backup, error) {
+	return Backups.Backups()
+}
+
+// CreateBackup saves a backup of the database.
+func (*DatabaseUtil) CreateBackup() (*dal.Backup, error) {
+	backup, err := Backups.Backup()
+	if err != nil {
+		slog.Error("error saving backup", "error", err.Error())
+		return nil, err
+	}
+
+	return backup, nil
+}
+
+// OpenBackup opens the file of a backup in the catalog.
+// The caller closes the file.
+func (*DatabaseUtil) OpenBackup(backupID string) (io.ReadCloser, error) {
+	file, err := Backups.Open(backupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// UploadBackup adds a full backup file to the catalog.
+func (*DatabaseUtil) UploadBackup(file io.Reader) (*dal.Backup, error) {
+	backup, err := Backups.Add(file)
+	if err != nil {
+		slog.Error("error uploading backup", "error", err.Error())
+		return nil, err
+	}
+
+	return backup, nil
+}
+
+// RestoreBackup replaces the database with a backup in the catalog.
+func (*DatabaseUtil) RestoreBackup(backupID string) (*dal.RestoreSummary, error) {
+	summary, err := Backups.Restore(backupID)
+
+	// the restored exercise types can differ from the cached types, and a restore can fail after the swap
+	workoutlog.ClearExerciseTypeCache()
 
-// RestoreBackup restores the database using a backup file.
-func (*DatabaseUtil) RestoreBackup(filepath string) error {
-	err := dal.DB.Restore(filepath)
 	if err != nil {
 		slog.Error("error restoring backup", "error", err.Error())
-		return err
+		return nil, err
 	}
 
-	slog.Info("database restored")
-	return nil
+	return summary, nil
 }
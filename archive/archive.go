@@ -0,0 +1,651 @@
+// Package archive exports and imports all of the data of a user as a portable archive.
+// Archives enable a user to be moved between Home Gym servers.
+// Imported items are created through the workoutlog, programs, and dailystats managers
+// so that they are validated and assigned new IDs.
+package archive
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/scottbrodersen/homegym/dailystats"
+	"github.com/scottbrodersen/homegym/programs"
+	"github.com/scottbrodersen/homegym/workoutlog"
+)
+
+// Version is the version of the archive format that is produced by Export.
+const Version = 1
+
+const pageSize = 100
+
+// An ErrInvalidArchive generates an error for when an archive cannot be imported.
+type ErrInvalidArchive struct {
+	Message string
+}
+
+func (e ErrInvalidArchive) Error() string {
+	return fmt.Sprintf("invalid archive: %s", e.Message)
+}
+
+var ErrUnsupportedVersion = errors.New("unsupported archive version")
+
+// An Archive contains all of the data of a user.
+// IDs are those of the exporting server and are replaced on import.
+type Archive struct {
+	Version          int                        `json:"version"`
+	Exported         int64                      `json:"exported"`
+	Activities       []workoutlog.Activity      `json:"activities"`
+	ExerciseTypes    []workoutlog.ExerciseType  `json:"exerciseTypes"`
+	Events           []workoutlog.Event         `json:"events"`
+	Programs         []programs.Program         `json:"programs"`
+	ProgramInstances []programs.ProgramInstance `json:"programInstances"`
+	ActiveInstances  []ActiveInstance           `json:"activeInstances"`
+	DailyStats       []dailystats.DailyStats    `json:"dailyStats"`
+	OneRMs           map[string]int             `json:"oneRMs"` // key is the exercise type ID
+	PRs              map[string]int             `json:"prs"`    // key is the exercise type ID
+}
+
+// An ActiveInstance identifies a program instance that is active for an activity.
+type ActiveInstance struct {
+	ActivityID string `json:"activityID"`
+	ProgramID  string `json:"programID"`
+	InstanceID string `json:"instanceID"`
+}
+
+// An ImportSummary reports the number of items that were imported.
+// Activities and exercise types that match the name of an existing item are merged with it and are not counted.
+type ImportSummary struct {
+	Activities       int `json:"activities"`
+	ExerciseTypes    int `json:"exerciseTypes"`
+	Events           int `json:"events"`
+	Programs         int `json:"programs"`
+	ProgramInstances int `json:"programInstances"`
+	DailyStats       int `json:"dailyStats"`
+}
+
+// The ArchiveAdmin interface defines routines for exporting and importing user data.
+type ArchiveAdmin interface {
+	Export(userID string) (*Archive, error)
+	Import(userID string, archive Archive) (*ImportSummary, error)
+}
+
+// An ArchiveUtil implements ArchiveAdmin.
+type ArchiveUtil struct{}
+
+var ArchiveManager ArchiveAdmin = ArchiveUtil{}
+
+// Export reads all of the data of a user into an archive.
+func (au ArchiveUtil) Export(userID string) (*Archive, error) {
+	archive := Archive{
+		Version:          Version,
+		Exported:         time.Now().Unix(),
+		Activities:       []workoutlog.Activity{},
+		Events:           []workoutlog.Event{},
+		Programs:         []programs.Program{},
+		ProgramInstances: []programs.ProgramInstance{},
+		ActiveInstances:  []ActiveInstance{},
+		OneRMs:           map[string]int{},
+		PRs:              map[string]int{},
+	}
+
+	activities, err := workoutlog.ActivityManager.GetActivityNames(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export activities: %w", err)
+	}
+
+	for _, a := range activities {
+		if err := a.GetActivityExercises(userID); err != nil {
+			return nil, fmt.Errorf("failed to export activities: %w", err)
+		}
+		archive.Activities = append(archive.Activities, *a)
+
+		if err := exportPrograms(userID, a.ID, &archive); err != nil {
+			return nil, err
+		}
+	}
+
+	archive.ExerciseTypes, err = workoutlog.ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export exercise types: %w", err)
+	}
+
+	for _, t := range archive.ExerciseTypes {
+		oneRM, err := workoutlog.ExerciseManager.Get1RM(userID, t.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export 1RM: %w", err)
+		}
+		if oneRM >= 0 {
+			archive.OneRMs[t.ID] = oneRM
+		}
+
+		pr, err := workoutlog.ExerciseManager.GetPR(userID, t.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export PR: %w", err)
+		}
+		if pr >= 0 {
+			archive.PRs[t.ID] = pr
+		}
+	}
+
+	previousEvent := workoutlog.Event{}
+	for {
+		events, err := workoutlog.EventManager.GetPageOfEvents(userID, previousEvent, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export events: %w", err)
+		}
+		archive.Events = append(archive.Events, events...)
+
+		if len(events) < pageSize {
+			break
+		}
+		previousEvent = events[len(events)-1]
+	}
+
+	archive.DailyStats, err = exportDailyStats(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+func exportPrograms(userID, activityID string, archive *Archive) error {
+	previousProgramID := ""
+	for {
+		page, err := programs.ProgramManager.GetProgramsPageForActivity(userID, activityID, previousProgramID, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to export programs: %w", err)
+		}
+
+		for _, p := range page {
+			archive.Programs = append(archive.Programs, p)
+
+			previousInstanceID := ""
+			for {
+				instances, err := programs.ProgramManager.GetProgramInstancesPage(userID, p.ID, previousInstanceID, pageSize)
+				if err != nil {
+					return fmt.Errorf("failed to export program instances: %w", err)
+				}
+				archive.ProgramInstances = append(archive.ProgramInstances, instances...)
+
+				if len(instances) < pageSize {
+					break
+				}
+				previousInstanceID = instances[len(instances)-1].ID
+			}
+		}
+
+		if len(page) < pageSize {
+			break
+		}
+		previousProgramID = page[len(page)-1].ID
+	}
+
+	previousActiveID := ""
+	for {
+		active, err := programs.ProgramManager.GetActiveProgramInstancesPage(userID, activityID, previousActiveID, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to export active program instances: %w", err)
+		}
+
+		for _, a := range active {
+			archive.ActiveInstances = append(archive.ActiveInstances, ActiveInstance{ActivityID: activityID, ProgramID: a.ProgramID, InstanceID: a.ID})
+		}
+
+		if len(active) < pageSize {
+			break
+		}
+		previousActiveID = active[len(active)-1].ID
+	}
+
+	return nil
+}
+
+func exportDailyStats(userID string) ([]dailystats.DailyStats, error) {
+	statsPageSize := 3000
+	exported := []dailystats.DailyStats{}
+	startDate := int64(0)
+
+	for {
+		statsJSON, err := dailystats.DailyStatsManager.GetBioStatsPage(userID, startDate, 0, statsPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export daily stats: %w", err)
+		}
+
+		stats := []dailystats.DailyStats{}
+		if err := json.Unmarshal(statsJSON, &stats); err != nil {
+			return nil, fmt.Errorf("failed to export daily stats: %w", err)
+		}
+		exported = append(exported, stats...)
+
+		if len(stats) < statsPageSize {
+			break
+		}
+		startDate = stats[len(stats)-1].Date
+	}
+
+	return exported, nil
+}
+
+// Import adds the data in an archive to the data of a user.
+// The archive is checked for consistency and its items are validated before any items are added.
+// Activities and exercise types are merged with existing items that have the same name.
+// All other items are added with new IDs.
+func (au ArchiveUtil) Import(userID string, archive Archive) (*ImportSummary, error) {
+	if archive.Version < 1 || archive.Version > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, archive.Version)
+	}
+
+	typeOrder, err := checkArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	existingTypes, err := workoutlog.ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exercise types: %w", err)
+	}
+
+	if err := checkItems(archive, existingTypes); err != nil {
+		return nil, err
+	}
+
+	summary := ImportSummary{}
+	activityIDs := map[string]string{}
+	typeIDs := map[string]string{}
+	eventIDs := map[string]string{}
+	programIDs := map[string]string{}
+	instanceIDs := map[string]string{}
+
+	// activities
+	existingActivities, err := workoutlog.ActivityManager.GetActivityNames(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read activities: %w", err)
+	}
+	for _, a := range archive.Activities {
+		idx := slices.IndexFunc(existingActivities, func(e *workoutlog.Activity) bool { return e.Name == a.Name })
+		if idx >= 0 {
+			activityIDs[a.ID] = existingActivities[idx].ID
+			continue
+		}
+
+		added, err := workoutlog.ActivityManager.NewActivity(userID, a.Name)
+		if err != nil {
+			return &summary, fmt.Errorf("failed to import activity %s: %w", a.Name, err)
+		}
+		activityIDs[a.ID] = added.ID
+		summary.Activities++
+	}
+
+	// exercise types, ordered so that types are added before the types that reference them
+	archivedTypes := []string{}
+	for _, t := range typeOrder {
+		idx := slices.IndexFunc(existingTypes, func(e workoutlog.ExerciseType) bool { return e.Name == t.Name })
+		if idx >= 0 {
+			typeIDs[t.ID] = existingTypes[idx].ID
+			continue
+		}
+
+		var composition map[string]int
+		if t.Composition != nil {
+			composition = map[string]int{}
+			for id, reps := range t.Composition {
+				composition[typeIDs[id]] = reps
+			}
+		}
+
+		newID, err := workoutlog.ExerciseManager.NewExerciseType(userID, t.Name, t.IntensityType, t.VolumeType, t.VolumeConstraint, composition, typeIDs[t.Basis])
+		if err != nil {
+			return &summary, fmt.Errorf("failed to import exercise type %s: %w", t.Name, err)
+		}
+		typeIDs[t.ID] = *newID
+		summary.ExerciseTypes++
+
+		if t.Archived {
+			archivedTypes = append(archivedTypes, *newID)
+		}
+	}
+
+	// associations between activities and exercise types
+	for _, a := range archive.Activities {
+		activity := workoutlog.Activity{ID: activityIDs[a.ID]}
+		if err := activity.GetActivityExercises(userID); err != nil {
+			return &summary, fmt.Errorf("failed to import activity exercises: %w", err)
+		}
+		for _, id := range a.ExerciseIDs {
+			if !slices.Contains(activity.ExerciseIDs, typeIDs[id]) {
+				activity.ExerciseIDs = append(activity.ExerciseIDs, typeIDs[id])
+			}
+		}
+		if err := workoutlog.ActivityManager.UpdateActivityExercises(userID, activity); err != nil {
+			return &summary, fmt.Errorf("failed to import activity exercises: %w", err)
+		}
+	}
+
+	// events are added in chronological order so that personal records are detected without rebuilding record histories
+	events := slices.Clone(archive.Events)
+	slices.SortStableFunc(events, func(a, b workoutlog.Event) int {
+		return cmp.Compare(a.Date, b.Date)
+	})
+
+	for _, e := range events {
+		event := e
+		event.ID = ""
+		event.ActivityID = activityIDs[e.ActivityID]
+		event.Exercises = map[int]workoutlog.ExerciseInstance{}
+		for k, inst := range e.Exercises {
+			inst.TypeID = typeIDs[inst.TypeID]
+			event.Exercises[k] = inst
+		}
+
+		newID, _, err := workoutlog.EventManager.NewEvent(userID, event)
+		if err != nil {
+			return &summary, fmt.Errorf("failed to import event: %w", err)
+		}
+		eventIDs[e.ID] = *newID
+		summary.Events++
+	}
+
+	for _, p := range archive.Programs {
+		program := remapProgram(p, activityIDs, typeIDs)
+		program.ID = ""
+
+		newID, err := programs.ProgramManager.AddProgram(userID, program)
+		if err != nil {
+			return &summary, fmt.Errorf("failed to import program %s: %w", p.Title, err)
+		}
+		programIDs[p.ID] = *newID
+		summary.Programs++
+	}
+
+	for _, i := range archive.ProgramInstances {
+		instance := i
+		instance.Program = remapProgram(i.Program, activityIDs, typeIDs)
+		instance.ID = ""
+		instance.ProgramID = programIDs[i.ProgramID]
+		if i.Events != nil {
+			instance.Events = map[int]string{}
+			for day, eventID := range i.Events {
+				instance.Events[day] = eventIDs[eventID]
+			}
+		}
+
+		// new instances are activated
+		if err := programs.ProgramManager.AddProgramInstance(userID, &instance); err != nil {
+			return &summary, fmt.Errorf("failed to import program instance %s: %w", i.Title, err)
+		}
+		instanceIDs[i.ID] = instance.ID
+		summary.ProgramInstances++
+
+		isActive := slices.ContainsFunc(archive.ActiveInstances, func(a ActiveInstance) bool { return a.InstanceID == i.ID })
+		if !isActive {
+			if err := programs.ProgramManager.DeactivateProgramInstance(userID, instance.ActivityID, instance.ID); err != nil {
+				return &summary, fmt.Errorf("failed to import program instance %s: %w", i.Title, err)
+			}
+		}
+	}
+
+	for _, s := range archive.DailyStats {
+		statsJSON, err := json.Marshal(s)
+		if err != nil {
+			return &summary, fmt.Errorf("failed to import daily stats: %w", err)
+		}
+		if err := dailystats.DailyStatsManager.AddStats(userID, s.Date, statsJSON); err != nil {
+			return &summary, fmt.Errorf("failed to import daily stats: %w", err)
+		}
+		summary.DailyStats++
+	}
+
+	for id, value := range archive.OneRMs {
+		if err := workoutlog.ExerciseManager.Set1RM(userID, typeIDs[id], value); err != nil {
+			return &summary, fmt.Errorf("failed to import 1RM: %w", err)
+		}
+	}
+
+	for id, value := range archive.PRs {
+		if err := workoutlog.ExerciseManager.SetPR(userID, typeIDs[id], value); err != nil {
+			return &summary, fmt.Errorf("failed to import PR: %w", err)
+		}
+	}
+
+	for _, id := range archivedTypes {
+		if _, err := workoutlog.ExerciseManager.DeleteExerciseType(userID, id, true); err != nil {
+			return &summary, fmt.Errorf("failed to archive exercise type: %w", err)
+		}
+	}
+
+	return &summary, nil
+}
+
+// remapProgram returns a copy of a program that references the mapped activity and exercise type IDs.
+func remapProgram(p programs.Program, activityIDs, typeIDs map[string]string) programs.Program {
+	program := p
+	program.ActivityID = activityIDs[p.ActivityID]
+	program.Blocks = slices.Clone(p.Blocks)
+
+	for b := range program.Blocks {
+		program.Blocks[b].MicroCycles = slices.Clone(p.Blocks[b].MicroCycles)
+		for m := range program.Blocks[b].MicroCycles {
+			program.Blocks[b].MicroCycles[m].Workouts = slices.Clone(p.Blocks[b].MicroCycles[m].Workouts)
+			for w := range program.Blocks[b].MicroCycles[m].Workouts {
+				workout := &program.Blocks[b].MicroCycles[m].Workouts[w]
+				workout.Segments = slices.Clone(workout.Segments)
+				for s := range workout.Segments {
+					workout.Segments[s].ExerciseTypeID = typeIDs[workout.Segments[s].ExerciseTypeID]
+				}
+			}
+		}
+	}
+
+	return program
+}
+
+// checkArchive ensures that all IDs that are referenced in an archive identify items in the archive.
+// Returns the exercise types in an order in which they can be added.
+func checkArchive(archive Archive) ([]workoutlog.ExerciseType, error) {
+	activities := map[string]bool{}
+	for _, a := range archive.Activities {
+		if a.ID == "" || activities[a.ID] {
+			return nil, ErrInvalidArchive{Message: "activities must have unique IDs"}
+		}
+		activities[a.ID] = true
+	}
+
+	types := map[string]workoutlog.ExerciseType{}
+	for _, t := range archive.ExerciseTypes {
+		if t.ID == "" {
+			return nil, ErrInvalidArchive{Message: "exercise types must have IDs"}
+		}
+		if _, ok := types[t.ID]; ok {
+			return nil, ErrInvalidArchive{Message: "exercise types must have unique IDs"}
+		}
+		types[t.ID] = t
+	}
+
+	hasType := func(id string) bool {
+		_, ok := types[id]
+		return ok
+	}
+
+	for _, a := range archive.Activities {
+		for _, id := range a.ExerciseIDs {
+			if !hasType(id) {
+				return nil, ErrInvalidArchive{Message: fmt.Sprintf("activity %s references unknown exercise type %s", a.Name, id)}
+			}
+		}
+	}
+
+	for _, e := range archive.Events {
+		if !activities[e.ActivityID] {
+			return nil, ErrInvalidArchive{Message: fmt.Sprintf("event %s references unknown activity %s", e.ID, e.ActivityID)}
+		}
+		for _, inst := range e.Exercises {
+			if !hasType(inst.TypeID) {
+				return nil, ErrInvalidArchive{Message: fmt.Sprintf("event %s references unknown exercise type %s", e.ID, inst.TypeID)}
+			}
+		}
+	}
+
+	programIDs := map[string]bool{}
+	for _, p := range archive.Programs {
+		if !activities[p.ActivityID] {
+			return nil, ErrInvalidArchive{Message: fmt.Sprintf("program %s references unknown activity %s", p.Title, p.ActivityID)}
+		}
+		if err := checkProgramTypes(p, hasType); err != nil {
+			return nil, err
+		}
+		programIDs[p.ID] = true
+	}
+
+	for _, i := range archive.ProgramInstances {
+		if !programIDs[i.ProgramID] || !activities[i.ActivityID] {
+			return nil, ErrInvalidArchive{Message: fmt.Sprintf("program instance %s references an unknown program or activity", i.Title)}
+		}
+		if err := checkProgramTypes(i.Program, hasType); err != nil {
+			return nil, err
+		}
+	}
+
+	for id := range archive.OneRMs {
+		if !hasType(id) {
+			return nil, ErrInvalidArchive{Message: fmt.Sprintf("1RM references unknown exercise type %s", id)}
+		}
+	}
+
+	for id := range archive.PRs {
+		if !hasType(id) {
+			return nil, ErrInvalidArchive{Message: fmt.Sprintf("PR references unknown exercise type %s", id)}
+		}
+	}
+
+	// order the types so that composition components and bases are added first
+	ordered := []workoutlog.ExerciseType{}
+	added := map[string]bool{}
+	for len(ordered) < len(archive.ExerciseTypes) {
+		progress := false
+		for _, t := range archive.ExerciseTypes {
+			if added[t.ID] {
+				continue
+			}
+
+			ready := t.Basis == "" || added[t.Basis]
+			for id := range t.Composition {
+				ready = ready && added[id]
+			}
+
+			if t.Basis != "" && !hasType(t.Basis) {
+				return nil, ErrInvalidArchive{Message: fmt.Sprintf("exercise type %s references unknown basis %s", t.Name, t.Basis)}
+			}
+			for id := range t.Composition {
+				if !hasType(id) {
+					return nil, ErrInvalidArchive{Message: fmt.Sprintf("exercise type %s references unknown exercise type %s", t.Name, id)}
+				}
+			}
+
+			if ready {
+				ordered = append(ordered, t)
+				added[t.ID] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			return nil, ErrInvalidArchive{Message: "exercise types have circular references"}
+		}
+	}
+
+	return ordered, nil
+}
+
+// checkItems validates the items of an archive so that an invalid item does not leave a partial import.
+// Exercise instances are validated against the existing exercise types that their types are merged with.
+func checkItems(archive Archive, existingTypes []workoutlog.ExerciseType) error {
+	types := map[string]workoutlog.ExerciseType{}
+	for _, t := range archive.ExerciseTypes {
+		idx := slices.IndexFunc(existingTypes, func(e workoutlog.ExerciseType) bool { return e.Name == t.Name })
+		if idx >= 0 {
+			types[t.ID] = existingTypes[idx]
+			continue
+		}
+
+		if err := t.Validate(); err != nil {
+			return ErrInvalidArchive{Message: fmt.Sprintf("exercise type %s: %s", t.Name, err.Error())}
+		}
+		types[t.ID] = t
+	}
+
+	activityTypes := map[string][]string{}
+	for _, a := range archive.Activities {
+		activityTypes[a.ID] = a.ExerciseIDs
+	}
+
+	for _, e := range archive.Events {
+		if e.Date == 0 {
+			return ErrInvalidArchive{Message: fmt.Sprintf("event %s has no date", e.ID)}
+		}
+
+		for _, inst := range e.Exercises {
+			if !slices.Contains(activityTypes[e.ActivityID], inst.TypeID) {
+				return ErrInvalidArchive{Message: fmt.Sprintf("event %s includes exercise type %s, which its activity does not", e.ID, inst.TypeID)}
+			}
+
+			// validation adjusts the values of the instance
+			instance := cloneInstance(inst)
+			if err := types[inst.TypeID].ValidateInstance(&instance); err != nil {
+				return ErrInvalidArchive{Message: fmt.Sprintf("event %s: %s", e.ID, err.Error())}
+			}
+		}
+	}
+
+	for _, p := range archive.Programs {
+		if err := p.Validate(); err != nil {
+			return ErrInvalidArchive{Message: fmt.Sprintf("program %s: %s", p.Title, err.Error())}
+		}
+	}
+
+	for _, i := range archive.ProgramInstances {
+		if err := i.Validate(); err != nil {
+			return ErrInvalidArchive{Message: fmt.Sprintf("program instance %s: %s", i.Title, err.Error())}
+		}
+	}
+
+	for _, s := range archive.DailyStats {
+		if err := s.Validate(); err != nil {
+			return ErrInvalidArchive{Message: fmt.Sprintf("daily stats of %d: %s", s.Date, err.Error())}
+		}
+	}
+
+	return nil
+}
+
+func cloneInstance(inst workoutlog.ExerciseInstance) workoutlog.ExerciseInstance {
+	inst.Segments = slices.Clone(inst.Segments)
+	for i := range inst.Segments {
+		inst.Segments[i].Volume = slices.Clone(inst.Segments[i].Volume)
+		for j := range inst.Segments[i].Volume {
+			inst.Segments[i].Volume[j] = slices.Clone(inst.Segments[i].Volume[j])
+		}
+	}
+
+	return inst
+}
+
+func checkProgramTypes(p programs.Program, hasType func(string) bool) error {
+	for _, b := range p.Blocks {
+		for _, m := range b.MicroCycles {
+			for _, w := range m.Workouts {
+				for _, s := range w.Segments {
+					if !hasType(s.ExerciseTypeID) {
+						return ErrInvalidArchive{Message: fmt.Sprintf("program %s references unknown exercise type %s", p.Title, s.ExerciseTypeID)}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package archive
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/scottbrodersen/homegym/dailystats"
+	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/programs"
+	"github.com/scottbrodersen/homegym/workoutlog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testUserID   = "testuser"
+	testImportID = "importuser"
+)
+
+func seedTestData(t *testing.T) {
+	activity, err := workoutlog.ActivityManager.NewActivity(testUserID, "lifting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	squatID, err := workoutlog.ExerciseManager.NewExerciseType(testUserID, "squat", "weight", "count", 1, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pauseID, err := workoutlog.ExerciseManager.NewExerciseType(testUserID, "pause squat", "weight", "count", 1, nil, *squatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID, err := workoutlog.ExerciseManager.NewExerciseType(testUserID, "old squat", "weight", "count", 1, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activity.ExerciseIDs = []string{*squatID, *pauseID, *oldID}
+	if err := workoutlog.ActivityManager.UpdateActivityExercises(testUserID, *activity); err != nil {
+		t.Fatal(err)
+	}
+
+	event := workoutlog.Event{
+		ActivityID: activity.ID,
+		Date:       1000,
+		EventMeta:  workoutlog.EventMeta{Overall: 3, Notes: "heavy"},
+		Exercises: map[int]workoutlog.ExerciseInstance{
+			0: {TypeID: *squatID, Index: 0, Segments: []workoutlog.ExerciseSegment{{Intensity: 100, Volume: [][]float32{{1, 1, 1}}}}},
+			1: {TypeID: *oldID, Index: 1, Segments: []workoutlog.ExerciseSegment{{Intensity: 80, Volume: [][]float32{{1, 1}}}}},
+		},
+	}
+	eventID, _, err := workoutlog.EventManager.NewEvent(testUserID, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := workoutlog.ExerciseManager.DeleteExerciseType(testUserID, *oldID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	workout := programs.Workout{Title: "day 1", Segments: []programs.WorkoutSegment{{ExerciseTypeID: *pauseID, Prescription: "3x3"}}}
+	program := programs.Program{
+		Title:      "strength",
+		ActivityID: activity.ID,
+		Blocks: []programs.Block{{
+			Title:       "block 1",
+			MicroCycles: []programs.MicroCycle{{Title: "week 1", Span: 1, Workouts: []programs.Workout{workout}}},
+		}},
+	}
+	programID, err := programs.ProgramManager.AddProgram(testUserID, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program.ID = *programID
+
+	instance := programs.ProgramInstance{Program: program, ProgramID: *programID, StartTime: 1000, Events: map[int]string{0: *eventID}}
+	instance.Title = "strength 1"
+	if err := programs.ProgramManager.AddProgramInstance(testUserID, &instance); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := json.Marshal(dailystats.DailyStats{Date: 1000, Sleep: 7.5, BodyWeight: 180})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dailystats.DailyStatsManager.AddStats(testUserID, 1000, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := workoutlog.ExerciseManager.Set1RM(testUserID, *squatID, 150); err != nil {
+		t.Fatal(err)
+	}
+	if err := workoutlog.ExerciseManager.SetPR(testUserID, *squatID, 145); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	Convey("Given a database with user data", t, func() {
+		db, err := dal.InitClient(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dal.DB = db
+		defer db.Destroy()
+
+		seedTestData(t)
+
+		Convey("When the data is exported", func() {
+			exported, err := ArchiveManager.Export(testUserID)
+			So(err, ShouldBeNil)
+
+			Convey("Then the archive contains all of the data", func() {
+				So(exported.Version, ShouldEqual, Version)
+				So(len(exported.Activities), ShouldEqual, 1)
+				So(len(exported.ExerciseTypes), ShouldEqual, 3)
+				So(len(exported.Events), ShouldEqual, 1)
+				So(len(exported.Programs), ShouldEqual, 1)
+				So(len(exported.ProgramInstances), ShouldEqual, 1)
+				So(len(exported.ActiveInstances), ShouldEqual, 1)
+				So(len(exported.DailyStats), ShouldEqual, 1)
+				So(len(exported.OneRMs), ShouldEqual, 1)
+				So(len(exported.PRs), ShouldEqual, 1)
+			})
+
+			Convey("And the archive is imported for another user", func() {
+				archiveJSON, err := json.Marshal(exported)
+				So(err, ShouldBeNil)
+
+				archive := Archive{}
+				So(json.Unmarshal(archiveJSON, &archive), ShouldBeNil)
+
+				summary, err := ArchiveManager.Import(testImportID, archive)
+				So(err, ShouldBeNil)
+				So(*summary, ShouldResemble, ImportSummary{Activities: 1, ExerciseTypes: 3, Events: 1, Programs: 1, ProgramInstances: 1, DailyStats: 1})
+
+				imported, err := ArchiveManager.Export(testImportID)
+				So(err, ShouldBeNil)
+
+				Convey("Then the imported data matches the exported data with new IDs", func() {
+					So(imported.Activities[0].Name, ShouldEqual, "lifting")
+					So(imported.Activities[0].ID, ShouldNotEqual, exported.Activities[0].ID)
+					So(len(imported.Activities[0].ExerciseIDs), ShouldEqual, 3)
+
+					names := map[string]workoutlog.ExerciseType{}
+					for _, et := range imported.ExerciseTypes {
+						names[et.Name] = et
+					}
+					So(names["pause squat"].Basis, ShouldEqual, names["squat"].ID)
+					So(names["old squat"].Archived, ShouldBeTrue)
+					So(names["squat"].Archived, ShouldBeFalse)
+
+					event := imported.Events[0]
+					So(event.ActivityID, ShouldEqual, imported.Activities[0].ID)
+					So(event.EventMeta, ShouldResemble, exported.Events[0].EventMeta)
+					So(event.Exercises[0].TypeID, ShouldEqual, names["squat"].ID)
+					So(event.Exercises[1].TypeID, ShouldEqual, names["old squat"].ID)
+					So(event.Exercises[0].Segments, ShouldResemble, exported.Events[0].Exercises[0].Segments)
+
+					program := imported.Programs[0]
+					So(program.ActivityID, ShouldEqual, imported.Activities[0].ID)
+					So(program.Blocks[0].MicroCycles[0].Workouts[0].Segments[0].ExerciseTypeID, ShouldEqual, names["pause squat"].ID)
+
+					instance := imported.ProgramInstances[0]
+					So(instance.ProgramID, ShouldEqual, program.ID)
+					So(instance.Events[0], ShouldEqual, event.ID)
+					So(imported.ActiveInstances[0].InstanceID, ShouldEqual, instance.ID)
+
+					So(imported.DailyStats, ShouldResemble, exported.DailyStats)
+					So(imported.OneRMs[names["squat"].ID], ShouldEqual, 150)
+					So(imported.PRs[names["squat"].ID], ShouldEqual, 145)
+				})
+			})
+
+			Convey("And the archive is imported for the same user", func() {
+				summary, err := ArchiveManager.Import(testUserID, *exported)
+				So(err, ShouldBeNil)
+
+				Convey("Then activities and exercise types are merged by name", func() {
+					So(summary.Activities, ShouldEqual, 0)
+					So(summary.ExerciseTypes, ShouldEqual, 0)
+					So(summary.Events, ShouldEqual, 1)
+
+					activities, err := workoutlog.ActivityManager.GetActivityNames(testUserID)
+					So(err, ShouldBeNil)
+					So(len(activities), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When an archive references missing items", func() {
+			archive := Archive{
+				Version: Version,
+				Events:  []workoutlog.Event{{ID: "e1", ActivityID: "missing", Date: 1}},
+			}
+
+			_, err := ArchiveManager.Import(testImportID, archive)
+
+			Convey("Then an error is returned and nothing is imported", func() {
+				So(err, ShouldHaveSameTypeAs, ErrInvalidArchive{})
+
+				activities, err := workoutlog.ActivityManager.GetActivityNames(testImportID)
+				So(err, ShouldBeNil)
+				So(len(activities), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an archive has invalid items", func() {
+			exported, err := ArchiveManager.Export(testUserID)
+			So(err, ShouldBeNil)
+
+			Convey("Then an invalid daily stat is reported before anything is imported", func() {
+				exported.DailyStats = append(exported.DailyStats, dailystats.DailyStats{Date: 2000})
+
+				_, err := ArchiveManager.Import(testImportID, *exported)
+
+				So(err, ShouldHaveSameTypeAs, ErrInvalidArchive{})
+				So(err.Error(), ShouldContainSubstring, "daily stats of 2000")
+
+				activities, err := workoutlog.ActivityManager.GetActivityNames(testImportID)
+				So(err, ShouldBeNil)
+				So(len(activities), ShouldEqual, 0)
+
+				types, err := workoutlog.ExerciseManager.GetExerciseTypes(testImportID)
+				So(err, ShouldBeNil)
+				So(len(types), ShouldEqual, 0)
+			})
+
+			Convey("Then an invalid exercise instance is reported before anything is imported", func() {
+				exported.Events[0].Exercises[0].Segments[0].Intensity = -1
+
+				_, err := ArchiveManager.Import(testImportID, *exported)
+
+				So(err, ShouldHaveSameTypeAs, ErrInvalidArchive{})
+				So(err.Error(), ShouldContainSubstring, exported.Events[0].ID)
+
+				activities, err := workoutlog.ActivityManager.GetActivityNames(testImportID)
+				So(err, ShouldBeNil)
+				So(len(activities), ShouldEqual, 0)
+			})
+
+			Convey("Then an invalid program is reported before anything is imported", func() {
+				exported.Programs[0].Title = ""
+
+				_, err := ArchiveManager.Import(testImportID, *exported)
+
+				So(err, ShouldHaveSameTypeAs, ErrInvalidArchive{})
+
+				activities, err := workoutlog.ActivityManager.GetActivityNames(testImportID)
+				So(err, ShouldBeNil)
+				So(len(activities), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When an archive has circular exercise type references", func() {
+			archive := Archive{
+				Version: Version,
+				ExerciseTypes: []workoutlog.ExerciseType{
+					{ID: "a", Name: "a", Basis: "b"},
+					{ID: "b", Name: "b", Basis: "a"},
+				},
+			}
+
+			_, err := ArchiveManager.Import(testImportID, archive)
+
+			So(err, ShouldHaveSameTypeAs, ErrInvalidArchive{})
+		})
+
+		Convey("When an archive has an unsupported version", func() {
+			_, err := ArchiveManager.Import(testImportID, Archive{Version: Version + 1})
+
+			So(err, ShouldWrap, ErrUnsupportedVersion)
+		})
+	})
+}
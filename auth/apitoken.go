@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// Scopes of personal API tokens.
+// A read scope permits GET requests and a write scope permits requests that change data.
+const (
+	ScopeEventsRead      = "events:read"
+	ScopeEventsWrite     = "events:write"
+	ScopeActivitiesRead  = "activities:read"
+	ScopeActivitiesWrite = "activities:write"
+	ScopeExercisesRead   = "exercises:read"
+	ScopeExercisesWrite  = "exercises:write"
+	ScopeDailyStatsRead  = "dailystats:read"
+	ScopeDailyStatsWrite = "dailystats:write"
+	ScopeExportRead      = "export:read"
+	ScopeImportWrite     = "import:write"
+)
+
+// apiTokenPrefix identifies personal API tokens.
+const apiTokenPrefix = "hgp_"
+
+const maxAPITokenNameLength = 100
+
+// IsAPIToken returns true when a secret has the form of a personal API token.
+func IsAPIToken(secret string) bool {
+	return strings.HasPrefix(secret, apiTokenPrefix)
+}
+
+// APITokenScopes are the valid scopes of personal API tokens.
+var APITokenScopes = []string{
+	ScopeEventsRead,
+	ScopeEventsWrite,
+	ScopeActivitiesRead,
+	ScopeActivitiesWrite,
+	ScopeExercisesRead,
+	ScopeExercisesWrite,
+	ScopeDailyStatsRead,
+	ScopeDailyStatsWrite,
+	ScopeExportRead,
+	ScopeImportWrite,
+}
+
+var ErrInvalidAPIToken = errors.New("invalid api token")
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// apiTokenLastUsed holds the time that the last use of each API token was recorded
+// so that it is not written on every request.
+var apiTokenLastUsed sync.Map
+
+// An APIToken describes a personal API token of a user.
+// Times are in seconds since the epoch. Expires is 0 for tokens that do not expire.
+type APIToken struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Created  int64    `json:"created"`
+	Expires  int64    `json:"expires"`
+	LastUsed int64    `json:"lastUsed"`
+}
+
+// CreateAPIToken creates a personal API token for a user with a name and scopes.
+// The token does not expire when ttl is 0.
+// Returns the token, which is only stored as a hash, and its description.
+func (a Authorizer) CreateAPIToken(username, name string, scopes []string, ttl time.Duration) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		return "", nil, fmt.Errorf("%w: name must have 1 to %d characters", ErrInvalidAPIToken, maxAPITokenNameLength)
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIToken)
+	}
+	for _, s := range scopes {
+		if !slices.Contains(APITokenScopes, s) {
+			return "", nil, fmt.Errorf("%w: unknown scope %s", ErrInvalidAPIToken, s)
+		}
+	}
+	if ttl < 0 {
+		return "", nil, fmt.Errorf("%w: ttl must not be negative", ErrInvalidAPIToken)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("failed to create api token: %w", err)
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	now := time.Now()
+	record := dal.APITokenRecord{
+		ID:      uuid.NewString(),
+		UserID:  username,
+		Name:    name,
+		Scopes:  slices.Compact(scopes),
+		Created: now.Unix(),
+	}
+	if ttl > 0 {
+		record.Expires = now.Add(ttl).Unix()
+	}
+
+	if err := dal.DB.AddAPIToken(hashAPIToken(secret), record); err != nil {
+		return "", nil, fmt.Errorf("failed to store api token: %w", err)
+	}
+	slog.Info("api token created", "username", username, "id", record.ID, "scopes", strings.Join(record.Scopes, ","))
+
+	token := apiTokenFromRecord(record)
+	return secret, &token, nil
+}
+
+// APITokens returns the personal API tokens of a user ordered by creation time.
+func (a Authorizer) APITokens(username string) ([]APIToken, error) {
+	records, err := dal.DB.GetUserAPITokens(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api tokens: %w", err)
+	}
+
+	tokens := []APIToken{}
+	for _, r := range records {
+		tokens = append(tokens, apiTokenFromRecord(r))
+	}
+	slices.SortFunc(tokens, func(a, b APIToken) int {
+		return cmp.Compare(a.Created, b.Created)
+	})
+
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes a personal API token of a user.
+// Returns ErrAPITokenNotFound when the token does not belong to the user.
+func (a Authorizer) RevokeAPIToken(username, tokenID string) error {
+	records, err := dal.DB.GetUserAPITokens(username)
+	if err != nil {
+		return fmt.Errorf("failed to get api tokens: %w", err)
+	}
+	if !slices.ContainsFunc(records, func(r dal.APITokenRecord) bool { return r.ID == tokenID }) {
+		return ErrAPITokenNotFound
+	}
+
+	if err := dal.DB.DeleteAPIToken(username, tokenID); err != nil {
+		return fmt.Errorf("failed to revoke api token: %w", err)
+	}
+	slog.Info("api token revoked", "username", username, "id", tokenID)
+
+	return nil
+}
+
+// ValidateAPIToken authenticates a personal API token.
+// Returns the user name and role of the owner of the token and the scopes of the token.
+// Returns ErrUnauthorized when the token is unknown or expired.
+func (a Authorizer) ValidateAPIToken(secret string) (string, string, []string, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return "", "", nil, ErrUnauthorized
+	}
+
+	hash := hashAPIToken(secret)
+	token, err := dal.DB.GetAPIToken(hash)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to get api token: %w", err)
+	}
+	now := time.Now().Unix()
+	if token == nil || (token.Expires != 0 && token.Expires < now) {
+		return "", "", nil, ErrUnauthorized
+	}
+
+	status, err := dal.DB.ReadUserStatus(token.UserID)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to get user status: %w", err)
+	}
+	if status.Disabled {
+		return "", "", nil, ErrAccountDisabled
+	}
+
+	_, _, _, role, err := dal.DB.ReadUser(token.UserID)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if role == nil {
+		return "", "", nil, ErrUnauthorized
+	}
+
+	touchAPIToken(hash, now)
+
+	return token.UserID, *role, token.Scopes, nil
+}
+
+// touchAPIToken records the time that an API token was used.
+// The time is recorded at most once every lastSeenIntervalInSeconds for each token.
+func touchAPIToken(hash string, now int64) {
+	if previous, ok := apiTokenLastUsed.Load(hash); ok && now-previous.(int64) < lastSeenIntervalInSeconds {
+		return
+	}
+	apiTokenLastUsed.Store(hash, now)
+
+	if err := dal.DB.TouchAPIToken(hash, now); err != nil {
+		slog.Warn("failed to update api token", "error", err.Error())
+	}
+}
+
+// hashAPIToken returns the hex-encoded SHA-256 hash of an API token.
+// Tokens are random so a fast hash is sufficient.
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func apiTokenFromRecord(r dal.APITokenRecord) APIToken {
+	return APIToken{
+		ID:       r.ID,
+		Name:     r.Name,
+		Scopes:   r.Scopes,
+		Created:  r.Created,
+		Expires:  r.Expires,
+		LastUsed: r.LastUsed,
+	}
+}
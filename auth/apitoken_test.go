@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottbrodersen/homegym/dal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAPITokens(t *testing.T) {
+	a := NewAuthorizer()
+
+	Convey("Given a user", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		Convey("When we create a token", func() {
+			db.On("AddAPIToken", mock.Anything, mock.Anything).Return(nil)
+
+			secret, token, err := a.CreateAPIToken(testUserName, " scale ", []string{ScopeDailyStatsWrite, ScopeDailyStatsWrite}, time.Hour)
+
+			Convey("Then the token is returned and only its hash is stored", func() {
+				So(err, ShouldBeNil)
+				So(secret, ShouldStartWith, apiTokenPrefix)
+				So(token.Name, ShouldEqual, "scale")
+				So(token.Scopes, ShouldResemble, []string{ScopeDailyStatsWrite})
+				So(token.Expires, ShouldBeGreaterThan, time.Now().Unix())
+
+				So(db.AssertCalled(t, "AddAPIToken", hashAPIToken(secret), mock.MatchedBy(func(r dal.APITokenRecord) bool {
+					return r.ID == token.ID && r.UserID == testUserName
+				})), ShouldBeTrue)
+			})
+		})
+
+		Convey("When we create a token with an unknown scope", func() {
+			_, _, err := a.CreateAPIToken(testUserName, "scale", []string{"admin:write"}, 0)
+
+			So(errors.Is(err, ErrInvalidAPIToken), ShouldBeTrue)
+			db.AssertNotCalled(t, "AddAPIToken", mock.Anything, mock.Anything)
+		})
+
+		Convey("When we create a token without a name", func() {
+			_, _, err := a.CreateAPIToken(testUserName, " ", []string{ScopeEventsRead}, 0)
+
+			So(errors.Is(err, ErrInvalidAPIToken), ShouldBeTrue)
+		})
+
+		Convey("When we revoke a token of another user", func() {
+			db.On("GetUserAPITokens", testUserName).Return([]dal.APITokenRecord{{ID: "mine", UserID: testUserName}}, nil)
+
+			err := a.RevokeAPIToken(testUserName, "theirs")
+
+			So(err, ShouldEqual, ErrAPITokenNotFound)
+			db.AssertNotCalled(t, "DeleteAPIToken", mock.Anything, mock.Anything)
+		})
+	})
+
+	Convey("Given a personal API token", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		secret := apiTokenPrefix + "testtoken"
+		roleStr := string(User)
+		db.On("ReadUser", testUserName).Return(&testEmail, &testEmail, &passwordVersion, &roleStr, nil)
+		db.On("ReadUserStatus", testUserName).Return(&dal.UserStatus{}, nil)
+		db.On("TouchAPIToken", hashAPIToken(secret), mock.Anything).Return(nil)
+
+		Convey("When we validate the token", func() {
+			db.On("GetAPIToken", hashAPIToken(secret)).Return(&dal.APITokenRecord{ID: "id", UserID: testUserName, Scopes: []string{ScopeEventsRead}}, nil)
+
+			username, role, scopes, err := a.ValidateAPIToken(secret)
+
+			Convey("Then the owner and scopes are returned", func() {
+				So(err, ShouldBeNil)
+				So(username, ShouldEqual, testUserName)
+				So(role, ShouldEqual, string(User))
+				So(scopes, ShouldResemble, []string{ScopeEventsRead})
+				So(db.AssertCalled(t, "TouchAPIToken", hashAPIToken(secret), mock.Anything), ShouldBeTrue)
+			})
+		})
+
+		Convey("When we validate an expired token", func() {
+			expired := time.Now().Add(-time.Minute).Unix()
+			db.On("GetAPIToken", hashAPIToken(secret)).Return(&dal.APITokenRecord{ID: "id", UserID: testUserName, Expires: expired}, nil)
+
+			_, _, _, err := a.ValidateAPIToken(secret)
+
+			So(err, ShouldEqual, ErrUnauthorized)
+		})
+
+		Convey("When we validate an unknown token", func() {
+			db.On("GetAPIToken", mock.Anything).Return((*dal.APITokenRecord)(nil), nil)
+
+			_, _, _, err := a.ValidateAPIToken(secret)
+			So(err, ShouldEqual, ErrUnauthorized)
+
+			_, _, _, err = a.ValidateAPIToken(strings.TrimPrefix(secret, apiTokenPrefix))
+			So(err, ShouldEqual, ErrUnauthorized)
+		})
+	})
+}
@@ -12,7 +12,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/scottbrodersen/homegym/dal"
@@ -22,10 +24,14 @@ import (
 )
 
 // A Session represents the state of a user session.
+// Times are in seconds since the epoch.
 type Session struct {
-	UserID    string
-	SessionID string
-	Expiry    int64
+	UserID    string `json:"-"`
+	SessionID string `json:"id"`
+	Expiry    int64  `json:"expiry"`
+	Created   int64  `json:"created"`
+	LastSeen  int64  `json:"lastSeen"`
+	UserAgent string `json:"userAgent"`
 }
 
 type Role string
@@ -38,11 +44,22 @@ type Role string
 var Admin Role = "admin"
 var User Role = "user"
 
+// Valid returns true when the role is a known role.
+func (r Role) Valid() bool {
+	return r == Admin || r == User
+}
+
 // keyRotationTime is the default time of daily key rotation in format "hh:mm:ss".
 var keyRotationTime = "10:00:00"    // default, UTC
 var secondsToDelayKeyDeletion = 600 // 10 mins
-const tokenLifeInSeconds = 1800     // 30 mins
-const sessionLifeInMinutes = 1440   // 24 hours
+var tokenLifetime = 30 * time.Minute
+var sessionLifetime = 24 * time.Hour
+
+const lastSeenIntervalInSeconds = 60
+
+// lastSeen holds the time that the last seen time of each session was recorded
+// so that it is not written on every request.
+var lastSeen sync.Map
 
 // SetKeyRotationTime schedules the time of the daily key rotation.
 // The time argument is expressed as hh:mm:ss
@@ -59,15 +76,32 @@ func SetKeyRotationTime(rotationTime string) error {
 	return nil
 }
 
+// SetLifetimes sets the lifetimes of tokens and sessions.
+// Tokens must last at least a minute and sessions must last at least as long as tokens.
+// Existing sessions are not affected.
+func SetLifetimes(token, session time.Duration) error {
+	if token < time.Minute || session < token {
+		err := fmt.Errorf("invalid lifetimes, using defaults: token %s, session %s", tokenLifetime, sessionLifetime)
+		slog.Error("invalid token or session lifetime", "token", token.String(), "session", session.String())
+		return err
+	}
+	slog.Info("token and session lifetimes set", "token", token.String(), "session", session.String())
+	tokenLifetime = token
+	sessionLifetime = session
+	return nil
+}
+
 // A KeyUsage defines the different uses of keys.
 type KeyUsage struct {
 	Token  string
 	Pepper string
+	TOTP   string
 }
 
 var KeyTypes KeyUsage = KeyUsage{
 	Token:  "token",
 	Pepper: "pepper",
+	TOTP:   "totp",
 }
 
 // InitiateKeyRotation starts the daily key rotation process.
@@ -249,24 +283,51 @@ var (
 	errPwdWrong = "bad credentials"
 )
 
+// minPasswordLength is the minimum number of characters in a new password.
+var minPasswordLength = 10
+
+// LowestMinPasswordLength is the lowest minimum password length that can be configured.
+const LowestMinPasswordLength = 8
+
+// SetMinPasswordLength sets the minimum number of characters in a new password.
+// The length must be at least 8. Existing passwords are not affected.
+func SetMinPasswordLength(length int) error {
+	if length < LowestMinPasswordLength {
+		err := fmt.Errorf("minimum password length must be at least %d, using default: %d", LowestMinPasswordLength, minPasswordLength)
+		slog.Error("invalid minimum password length", "error", err.Error())
+		return err
+	}
+	slog.Info(fmt.Sprintf("minimum password length set to: %d", length))
+	minPasswordLength = length
+	return nil
+}
+
+// MinPasswordLength returns the minimum number of characters in a new password.
+func MinPasswordLength() int {
+	return minPasswordLength
+}
+
 // hashFunctions maps password version names to a hashing functions.
 // Add new versions as needed.
 // keep old function versions to use with non-updated credential versions
 var hashFunctions map[string]hashPasswordVersion = map[string]hashPasswordVersion{
 	"v1":   hashPasswordVersion1,
+	"v2":   hashPasswordVersion2,
 	"mock": hashPasswordMock,
 }
 
 // validateFunctions maps password version names to password validation functions.
 var validateFunctions map[string]validatePasswordVersion = map[string]validatePasswordVersion{
 	"v1":   validatePasswordVersion1,
+	"v2":   validatePasswordVersion2,
 	"mock": validatePasswordMock,
 }
 
 // LatestVersion returns the current version of hashing and validation functions that we're using.
 // This version should be used with new credentials.
+// Passwords of older versions are rehashed with this version when the user logs in.
 func LatestVersion() string {
-	return "v1"
+	return "v2"
 }
 
 // Hash returns the hash of a password.
@@ -289,14 +350,13 @@ func (p *PasswordUtil) ValidatePassword(pwd string, hash string) error {
 
 // The password hashing function for v1.
 func hashPasswordVersion1(pwd string) (string, error) {
-	minPwdLength := 10
 	maxPwdLength := 72 //bytes
-	if len(pwd) < minPwdLength {
-		return "", fmt.Errorf(errPwdShort)
+	if utf8.RuneCountInString(pwd) < minPasswordLength {
+		return "", errors.New(errPwdShort)
 	}
 	pwdBytes := []byte(pwd)
 	if len(pwdBytes) > maxPwdLength {
-		return "", fmt.Errorf(errPwdLong)
+		return "", errors.New(errPwdLong)
 	}
 	hash, err := bcrypt.GenerateFromPassword(pwdBytes, bcrypt.DefaultCost)
 	if err != nil {
@@ -308,7 +368,7 @@ func hashPasswordVersion1(pwd string) (string, error) {
 // The password validation function for v1.
 func validatePasswordVersion1(pwd string, hash string) error {
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pwd)); err != nil {
-		return fmt.Errorf(errPwdWrong)
+		return errors.New(errPwdWrong)
 	}
 	return nil
 }
@@ -330,24 +390,21 @@ func validatePasswordMock(pwd, hash string) error {
 /* TOKENS */
 
 // An Authorizer issues and validates tokens.
-type Authorizer struct {
-	tokenTTL   int
-	sessionTTL int
-}
+// Token and session lifetimes are set with SetLifetimes.
+type Authorizer struct{}
 
+// TokenTTL returns the lifetime of tokens in seconds.
 func (a Authorizer) TokenTTL() int {
-	return a.tokenTTL
+	return int(tokenLifetime.Seconds())
 }
 
+// SessionTTL returns the lifetime of sessions in seconds.
 func (a Authorizer) SessionTTL() int {
-	return a.sessionTTL * 60
+	return int(sessionLifetime.Seconds())
 }
 
 func NewAuthorizer() Authorizer {
-	return Authorizer{
-		tokenTTL:   tokenLifeInSeconds,
-		sessionTTL: sessionLifeInMinutes,
-	}
+	return Authorizer{}
 }
 
 const (
@@ -355,6 +412,8 @@ const (
 )
 
 var ErrUnauthorized error = errors.New("authentication failed")
+var ErrSessionNotFound error = errors.New("session not found")
+var ErrAccountDisabled error = errors.New("account disabled")
 
 // A Claims validates token claims.
 type Claims struct {
@@ -377,23 +436,89 @@ func (c *Claims) Valid() error {
 }
 
 func tokenExpiryTime() *jwt.NumericDate {
-	return jwt.NewNumericDate(time.Now().Add(time.Second * time.Duration(tokenLifeInSeconds)))
+	return jwt.NewNumericDate(time.Now().Add(tokenLifetime))
 }
 
 // IssueToken authenticates user credentials.
 // Creates a token.
-// Stores the session.
+// Stores the session along with the user agent of the client.
 // Returns the token string and sessionID.
-func (a Authorizer) IssueToken(username string, pwd string) (*string, *string, error) {
+// Failed attempts are counted for the user name and the remote address of the client.
+// Returns a LockoutError when there have been too many failed attempts.
+// Returns ErrBusy when too many passwords are being checked; the attempt is not counted.
+// Returns a SecondFactorRequiredError when the user has enabled two-factor authentication.
+// The login is then completed by CompleteLogin.
+// When the password hash uses an older version it is rehashed with the latest version.
+func (a Authorizer) IssueToken(username, pwd, userAgent, remoteAddr string) (*string, *string, error) {
+	release, err := checkLockout(username, remoteAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
 	_, pwdHash, pwdHashVersion, role, err := dal.DB.ReadUser(username)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if pwdHash == nil || pwdHashVersion == nil {
+		recordLoginFailure(username, remoteAddr)
+		return nil, nil, ErrUnauthorized
+	}
 	pwdUtil := PasswordUtil{Version: *pwdHashVersion}
 	if err = pwdUtil.ValidatePassword(pwd, *pwdHash); err != nil {
+		if errors.Is(err, ErrBusy) {
+			return nil, nil, err
+		}
+		recordLoginFailure(username, remoteAddr)
 		return nil, nil, ErrUnauthorized
 	}
 
+	status, err := dal.DB.ReadUserStatus(username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user status: %w", err)
+	}
+	if status.Disabled {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	if *pwdHashVersion != LatestVersion() {
+		upgradePasswordHash(username, pwd, *pwdHashVersion)
+	}
+
+	totp, err := dal.DB.ReadTOTP(username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+	if totp != nil && totp.Enabled {
+		return nil, nil, &SecondFactorRequiredError{Challenge: newChallenge(username)}
+	}
+	clearLoginFailures(username)
+
+	return a.issueSession(username, *role, userAgent)
+}
+
+// upgradePasswordHash rehashes a validated password with the latest version and stores the hash.
+// Failures are logged so that the login is not affected; the upgrade is attempted again at the next login.
+// Passwords that the latest version does not accept, such as passwords shorter than the minimum length,
+// are not upgraded until the user changes the password.
+func upgradePasswordHash(username, pwd, fromVersion string) {
+	pwdUtil := PasswordUtil{Version: LatestVersion()}
+
+	hash, err := pwdUtil.Hash(pwd)
+	if err != nil {
+		slog.Warn("failed to rehash password", "username", username, "version", fromVersion, "error", err.Error())
+		return
+	}
+
+	if err := dal.DB.UpdatePwdVersion(username, hash, LatestVersion()); err != nil {
+		slog.Error("failed to store rehashed password", "username", username, "error", err.Error())
+		return
+	}
+	slog.Info("upgraded password hash", "username", username, "from", fromVersion, "to", LatestVersion())
+}
+
+// issueSession creates a token and a session for an authenticated user.
+func (a Authorizer) issueSession(username, role, userAgent string) (*string, *string, error) {
 	signingKey, err := getSigningKey()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get signing key: %w", err)
@@ -408,7 +533,7 @@ func (a Authorizer) IssueToken(username string, pwd string) (*string, *string, e
 			Issuer:    issuer,
 			Audience:  []string{username},
 		},
-		GymClaims{Role: string(*role)},
+		GymClaims{Role: role},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 	tokenStr, err := token.SignedString(signingKey)
@@ -417,12 +542,12 @@ func (a Authorizer) IssueToken(username string, pwd string) (*string, *string, e
 	}
 
 	sessionID := uuid.NewString()
-	err = dal.DB.AddSession(username, sessionID, a.sessionTTL)
+	err = dal.DB.AddSession(username, sessionID, userAgent, int(sessionLifetime.Minutes()))
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not create user session: %w", err)
 	}
 	slog.Info("Session created ", "id", sessionID)
-	endSessionAfterDuration(sessionID, time.Hour*time.Duration(24))
+	endSessionAfterDuration(sessionID, sessionLifetime)
 
 	return &tokenStr, &sessionID, nil
 }
@@ -475,6 +600,8 @@ func (a Authorizer) ValidateToken(tokenString, sessionID string) (*string, error
 		return nil, fmt.Errorf("token expired")
 	}
 
+	touchSession(*username, sessionID)
+
 	// refresh token
 	refreshedClaims := Claims{
 		jwt.RegisteredClaims{
@@ -526,6 +653,82 @@ func (a Authorizer) TokenClaims(tokenString string) (Claims, error) {
 	return c, nil
 }
 
+// EndSession deletes a session so that it can no longer be used.
+func (a Authorizer) EndSession(sessionID string) error {
+	if err := dal.DB.DeleteSession(sessionID); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	lastSeen.Delete(sessionID)
+
+	slog.Info("session ended", "id", sessionID)
+
+	return nil
+}
+
+// Sessions returns the sessions of a user that have not expired.
+func (a Authorizer) Sessions(username string) ([]Session, error) {
+	sessionInfo, err := dal.DB.GetUserSessions(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	now := time.Now().Unix()
+	sessions := []Session{}
+	for _, si := range sessionInfo {
+		if si.Expires < now {
+			continue
+		}
+		sessions = append(sessions, Session{
+			UserID:    username,
+			SessionID: si.ID,
+			Expiry:    si.Expires,
+			Created:   si.Created,
+			LastSeen:  si.LastSeen,
+			UserAgent: si.UserAgent,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends a session of a user.
+// Returns ErrSessionNotFound when the session does not belong to the user.
+func (a Authorizer) RevokeSession(username, sessionID string) error {
+	owner, _, err := dal.DB.GetSession(sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	if owner == nil || *owner != username {
+		return ErrSessionNotFound
+	}
+
+	return a.EndSession(sessionID)
+}
+
+// PasswordChangeRequired returns true when the user must change their password before using the API.
+func (a Authorizer) PasswordChangeRequired(username string) (bool, error) {
+	status, err := dal.DB.ReadUserStatus(username)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user status: %w", err)
+	}
+
+	return status.PasswordChangeRequired, nil
+}
+
+// touchSession records the time that a session was used.
+// The time is recorded at most once every lastSeenIntervalInSeconds for each session.
+func touchSession(username, sessionID string) {
+	now := time.Now().Unix()
+	if previous, ok := lastSeen.Load(sessionID); ok && now-previous.(int64) < lastSeenIntervalInSeconds {
+		return
+	}
+	lastSeen.Store(sessionID, now)
+
+	if err := dal.DB.TouchSession(username, sessionID, now); err != nil {
+		slog.Warn("failed to update session", "error", err.Error())
+	}
+}
+
 func endSessionAfterDuration(sessionID string, duration time.Duration) {
 	slog.Info("Scheduling deletion of session", "in", duration)
 	time.AfterFunc(duration, func() {
@@ -533,6 +736,7 @@ func endSessionAfterDuration(sessionID string, duration time.Duration) {
 		if err != nil {
 			slog.Warn(fmt.Sprintf("failed to delete session: %s", err.Error()))
 		}
+		lastSeen.Delete(sessionID)
 		slog.Debug("session deleted. ", "sessionID", sessionID)
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -30,18 +31,24 @@ func TestPasswords(t *testing.T) {
 	Convey("When we get the latest version", t, func() {
 		latest := LatestVersion()
 		So(latest, ShouldNotBeEmpty)
-		So(latest, ShouldEqual, "v1")
+		So(latest, ShouldEqual, "v2")
 		Convey("And when we get the hash and validate functions for the latest versionJ", func() {
 			v, okv := validateFunctions[latest]
 			h, okh := hashFunctions[latest]
 			So(okv, ShouldBeTrue)
 			So(okh, ShouldBeTrue)
-			So(v, ShouldEqual, validatePasswordVersion1)
-			So(h, ShouldEqual, hashPasswordVersion1)
+			So(reflect.ValueOf(v).Pointer(), ShouldEqual, reflect.ValueOf(validatePasswordVersion2).Pointer())
+			So(reflect.ValueOf(h).Pointer(), ShouldEqual, reflect.ValueOf(hashPasswordVersion2).Pointer())
 		})
 	})
-	for _, version := range [1]string{"v1"} {
-		Convey("Given a v1 password utility", t, func() {
+
+	db := dal.NewMockDal()
+	dal.DB = db
+	testPepperKeyID := "test-pepper-key-id"
+	db.On("GetKeys", KeyTypes.Pepper).Return(map[string][]byte{testPepperKeyID: []byte(base64.StdEncoding.EncodeToString([]byte("test pepper")))}, map[string][]byte{}, nil)
+
+	for _, version := range [2]string{"v1", "v2"} {
+		Convey(fmt.Sprintf("Given a %s password utility", version), t, func() {
 			pwdUtil := PasswordUtil{Version: version}
 			Convey("When we hash a short password using a versioned hasher", func() {
 				hash, err := pwdUtil.Hash(shortPassword)
@@ -49,21 +56,15 @@ func TestPasswords(t *testing.T) {
 				So(err.Error(), ShouldEqual, errPwdShort)
 				So(hash, ShouldBeEmpty)
 			})
-			Convey("When we hash a long password using v1 hasher", func() {
-				hash, err := pwdUtil.Hash(longPassword)
-				So(err, ShouldNotBeNil)
-				So(err.Error(), ShouldEqual, errPwdLong)
-				So(hash, ShouldBeEmpty)
-			})
-			Convey("When we hash a valid password using v1 hasher", func() {
+			Convey("When we hash a valid password using a versioned hasher", func() {
 				hash, err := pwdUtil.Hash(testPassword)
 				So(err, ShouldBeNil)
 				So(hash, ShouldNotBeEmpty)
-				Convey("And when we validate the password using the v1 validator", func() {
+				Convey("And when we validate the password using the versioned validator", func() {
 					ok := pwdUtil.ValidatePassword(testPassword, hash)
 					So(ok, ShouldBeNil)
 				})
-				Convey("And when we validate the wrong password using the v1 validator", func() {
+				Convey("And when we validate the wrong password using the versioned validator", func() {
 					err := pwdUtil.ValidatePassword("bad", hash)
 					So(err, ShouldNotBeNil)
 					So(err.Error(), ShouldEqual, errPwdWrong)
@@ -71,12 +72,88 @@ func TestPasswords(t *testing.T) {
 			})
 		})
 	}
+
+	Convey("Given a v1 password utility", t, func() {
+		pwdUtil := PasswordUtil{Version: "v1"}
+		Convey("When we hash a long password using v1 hasher", func() {
+			hash, err := pwdUtil.Hash(longPassword)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, errPwdLong)
+			So(hash, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a v2 password utility", t, func() {
+		pwdUtil := PasswordUtil{Version: "v2"}
+		Convey("When we hash a password that is too long for v1", func() {
+			hash, err := pwdUtil.Hash(longPassword)
+			So(err, ShouldBeNil)
+			Convey("Then the hash contains the argon2id parameters and the pepper key ID", func() {
+				So(hash, ShouldStartWith, "$argon2id$v=19$m=65536,t=3,p=4$"+testPepperKeyID+"$")
+				So(pwdUtil.ValidatePassword(longPassword, hash), ShouldBeNil)
+			})
+		})
+		Convey("When we hash a password that is longer than 1024 bytes", func() {
+			hash, err := pwdUtil.Hash(strings.Repeat("a", maxPwdLengthV2+1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, errPwdLong)
+			So(hash, ShouldBeEmpty)
+		})
+		Convey("When all argon2id slots are in use", func() {
+			hash, err := pwdUtil.Hash(testPassword)
+			So(err, ShouldBeNil)
+			for range cap(argon2Slots) {
+				argon2Slots <- struct{}{}
+			}
+			_, hashErr := pwdUtil.Hash(testPassword)
+			validateErr := pwdUtil.ValidatePassword(testPassword, hash)
+			for range cap(argon2Slots) {
+				<-argon2Slots
+			}
+
+			Convey("Then passwords are not hashed", func() {
+				So(hashErr, ShouldEqual, ErrBusy)
+				So(validateErr, ShouldEqual, ErrBusy)
+			})
+		})
+		Convey("When we validate a password with a hash that uses a different pepper", func() {
+			hash, err := pwdUtil.Hash(testPassword)
+			So(err, ShouldBeNil)
+			otherDB := dal.NewMockDal()
+			dal.DB = otherDB
+			otherDB.On("GetKeys", KeyTypes.Pepper).Return(map[string][]byte{testPepperKeyID: []byte(base64.StdEncoding.EncodeToString([]byte("other pepper")))}, map[string][]byte{}, nil)
+			err = pwdUtil.ValidatePassword(testPassword, hash)
+			dal.DB = db
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, errPwdWrong)
+		})
+	})
+
+	Convey("Given a minimum password length", t, func() {
+		defaultLength := MinPasswordLength()
+		Convey("When we set a length that is too short", func() {
+			err := SetMinPasswordLength(LowestMinPasswordLength - 1)
+			So(err, ShouldNotBeNil)
+			So(MinPasswordLength(), ShouldEqual, defaultLength)
+		})
+		Convey("When we set a longer length", func() {
+			err := SetMinPasswordLength(len(testPassword) + 1)
+			So(err, ShouldBeNil)
+			pwdUtil := PasswordUtil{Version: LatestVersion()}
+			_, err = pwdUtil.Hash(testPassword)
+			SetMinPasswordLength(defaultLength)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, errPwdShort)
+		})
+	})
 }
 
 var (
-	testUserName  = "testUsername"
-	testEmail     = "testemail@example.co"
-	testSessionID = "test-session-id"
+	testUserName   = "testUsername"
+	testEmail      = "testemail@example.co"
+	testSessionID  = "test-session-id"
+	testUserAgent  = "test agent"
+	testRemoteAddr = "192.0.2.1"
 )
 
 var testRole Role = "TestRole"
@@ -100,20 +177,29 @@ func TestTokens(t *testing.T) {
 	Convey("When we issue a token", t, func() {
 		db := dal.NewMockDal()
 		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", mock.Anything, mock.Anything).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("DeleteLoginFailures", mock.Anything, mock.Anything).Return(nil)
 		roleStr := string(testRole)
 		db.On("ReadUser", mock.Anything).Return(&testEmail, &testPwdHash, &passwordVersion, &roleStr, nil)
+		db.On("ReadUserStatus", testUserName).Return(&dal.UserStatus{}, nil)
+		db.On("ReadTOTP", testUserName).Return((*dal.TOTPInfo)(nil), nil)
 		db.On("GetKeys", mock.Anything).Return(map[string][]byte{testKeyID: testTokenKeyBytes}, map[string][]byte{}, nil)
-		db.On("AddSession", mock.Anything, mock.Anything).Return(nil)
+		db.On("AddSession", mock.Anything, mock.Anything, testUserAgent).Return(nil)
+		db.On("UpdatePwdVersion", testUserName, mock.Anything, LatestVersion()).Return(nil)
 
-		beforeToken := time.Now().Add(time.Second * time.Duration(tokenLifeInSeconds))
+		beforeToken := time.Now().Add(tokenLifetime)
 		time.Sleep(time.Second * time.Duration(1))
-		testToken, sessionID, err = a.IssueToken(testUserName, testPassword)
-		afterToken := time.Now().Add(time.Second * time.Duration(tokenLifeInSeconds))
+		testToken, sessionID, err = a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+		afterToken := time.Now().Add(tokenLifetime)
 		time.Sleep(time.Second * time.Duration(1))
 
 		So(err, ShouldBeNil)
 		So(testToken, ShouldNotBeNil)
 		So(sessionID, ShouldNotBeNil)
+		// the v1 hash is upgraded to the latest version
+		So(db.AssertCalled(t, "UpdatePwdVersion", testUserName, mock.Anything, LatestVersion()), ShouldBeTrue)
 		claimPart, _ := base64.StdEncoding.DecodeString(strings.Split(*testToken, ".")[1])
 		claims := Claims{}
 		err = json.Unmarshal(claimPart, &claims)
@@ -142,6 +228,7 @@ func TestTokens(t *testing.T) {
 
 		Convey("And when we validate the token with the correct session and there are two token keys", func() {
 			db.On("GetSession", mock.Anything, mock.Anything).Return(&testSession.UserID, &testSession.Expiry, nil)
+			db.On("TouchSession", testUserName, *sessionID).Return(nil)
 
 			newTokenKey, _ := generateRsaPrivate(2048)
 			newTokenKeyBytes := rsaKeyToByteSlice(newTokenKey)
@@ -162,6 +249,14 @@ func TestTokens(t *testing.T) {
 			_ = json.Unmarshal(refreshedClaimPart, &refreshedClaims)
 
 			So(refreshedClaims.ExpiresAt.Time.After(afterToken), ShouldBeTrue)
+			So(db.AssertCalled(t, "TouchSession", testUserName, *sessionID), ShouldBeTrue)
+
+			Convey("Then the last seen time is not recorded again right away", func() {
+				_, err := a.ValidateToken(*testToken, *sessionID)
+
+				So(err, ShouldBeNil)
+				db.AssertNumberOfCalls(t, "TouchSession", 1)
+			})
 		})
 	})
 
@@ -193,14 +288,52 @@ func TestTokens(t *testing.T) {
 	Convey("When we issue a token with a user that isn't in the database", t, func() {
 		db := dal.NewMockDal()
 		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", mock.Anything, mock.Anything).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("DeleteLoginFailures", mock.Anything, mock.Anything).Return(nil)
 		db.On("ReadUser", mock.Anything).Return(nil, nil, nil, nil, fmt.Errorf("test error"))
-		token, sessionID, err := a.IssueToken(testUserName, testPassword)
+		token, sessionID, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
 
 		So(err, ShouldNotBeNil)
 		So(token, ShouldBeNil)
 		So(sessionID, ShouldBeNil)
 	})
 
+	Convey("When we issue a token with a user name that is not found", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", mock.Anything, mock.Anything).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("DeleteLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		var none *string
+		db.On("ReadUser", mock.Anything).Return(none, none, none, none, nil)
+		token, sessionID, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+
+		So(err, ShouldEqual, ErrUnauthorized)
+		So(token, ShouldBeNil)
+		So(sessionID, ShouldBeNil)
+	})
+
+	Convey("When we issue a token for a disabled user", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", mock.Anything, mock.Anything).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("DeleteLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		roleStr := string(testRole)
+		db.On("ReadUser", mock.Anything).Return(&testEmail, &testPwdHash, &passwordVersion, &roleStr, nil)
+		db.On("ReadUserStatus", testUserName).Return(&dal.UserStatus{Disabled: true}, nil)
+		token, sessionID, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+
+		So(err, ShouldEqual, ErrAccountDisabled)
+		So(token, ShouldBeNil)
+		So(sessionID, ShouldBeNil)
+		db.AssertNotCalled(t, "AddSession", mock.Anything, mock.Anything, mock.Anything)
+	})
+
 	Convey("When we validate a token against an unauthenticated user ID", t, func() {
 		db := dal.NewMockDal()
 		dal.DB = db
@@ -228,6 +361,50 @@ func TestTokens(t *testing.T) {
 	})
 }
 
+func TestSessions(t *testing.T) {
+	Convey("Given a user with sessions", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		now := time.Now().Unix()
+		db.On("GetUserSessions", testUserName).Return([]dal.SessionInfo{
+			{ID: testSessionID, Created: now - 100, Expires: now + 100, LastSeen: now - 10, UserAgent: testUserAgent},
+			{ID: "expired-session-id", Created: now - 200, Expires: now - 100},
+		}, nil)
+
+		Convey("When we get the sessions of the user", func() {
+			sessions, err := a.Sessions(testUserName)
+
+			Convey("Then expired sessions are not included", func() {
+				So(err, ShouldBeNil)
+				So(sessions, ShouldResemble, []Session{
+					{UserID: testUserName, SessionID: testSessionID, Expiry: now + 100, Created: now - 100, LastSeen: now - 10, UserAgent: testUserAgent},
+				})
+			})
+		})
+
+		Convey("When the user revokes one of their sessions", func() {
+			db.On("GetSession", testSessionID).Return(&testUserName, &now, nil)
+			db.On("DeleteSession", testSessionID).Return(nil)
+
+			err := a.RevokeSession(testUserName, testSessionID)
+
+			So(err, ShouldBeNil)
+			So(db.AssertCalled(t, "DeleteSession", testSessionID), ShouldBeTrue)
+		})
+
+		Convey("When the user revokes a session of another user", func() {
+			otherUser := "otherUser"
+			db.On("GetSession", testSessionID).Return(&otherUser, &now, nil)
+
+			err := a.RevokeSession(testUserName, testSessionID)
+
+			So(err, ShouldEqual, ErrSessionNotFound)
+			db.AssertNotCalled(t, "DeleteSession", mock.Anything)
+		})
+	})
+}
+
 func TestScheduledTasks(t *testing.T) {
 	Convey("When we schedule a session for deletion", t, func() {
 		duration := time.Millisecond * time.Duration(500)
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/scottbrodersen/homegym/dal"
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id parameters of v2 password hashes.
+// The parameters are stored with each hash so that they can be changed without affecting existing hashes.
+const (
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Time    = 3
+	argon2Threads = 4
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// maxPwdLengthV2 is the maximum length of a v2 password in bytes.
+const maxPwdLengthV2 = 1024
+
+// pepperKeyLock serializes the creation of the pepper key.
+var pepperKeyLock sync.Mutex
+
+// ErrBusy is returned when too many passwords are being hashed to hash another one.
+var ErrBusy = errors.New("too many password checks in progress")
+
+// argon2Slots limits the number of argon2id hashes that are computed at the same time.
+// Each hash uses argon2Memory, so parallel logins could otherwise exhaust the memory of the server.
+var argon2Slots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// argon2Key computes an argon2id hash when a slot is free.
+// Returns ErrBusy without waiting when all slots are in use.
+func argon2Key(password, salt []byte, iterations, memory uint32, threads uint8, keyLen uint32) ([]byte, error) {
+	select {
+	case argon2Slots <- struct{}{}:
+	default:
+		return nil, ErrBusy
+	}
+	defer func() { <-argon2Slots }()
+
+	return argon2.IDKey(password, salt, iterations, memory, threads, keyLen), nil
+}
+
+// The password hashing function for v2.
+// The password is keyed with the pepper using HMAC-SHA256 and then hashed with argon2id.
+// The hash has the format $argon2id$v=19$m={memory},t={time},p={threads}${pepper key ID}${salt}${hash}
+// where the salt and hash are base64-encoded.
+func hashPasswordVersion2(pwd string) (string, error) {
+	if utf8.RuneCountInString(pwd) < minPasswordLength {
+		return "", errors.New(errPwdShort)
+	}
+	if len(pwd) > maxPwdLengthV2 {
+		return "", errors.New(errPwdLong)
+	}
+
+	keyID, pepper, err := getPepperKey()
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to create salt: %w", err)
+	}
+
+	hash, err := argon2Key(pepperPassword(pwd, pepper), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads, keyID,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// The password validation function for v2.
+func validatePasswordVersion2(pwd string, hash string) error {
+	if len(pwd) > maxPwdLengthV2 {
+		return errors.New(errPwdWrong)
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 7 || parts[1] != "argon2id" {
+		return fmt.Errorf("invalid password hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return fmt.Errorf("invalid salt: %w", err)
+	}
+	storedHash, err := base64.RawStdEncoding.DecodeString(parts[6])
+	if err != nil {
+		return fmt.Errorf("invalid hash: %w", err)
+	}
+
+	pepper, err := findPepperKey(parts[4])
+	if err != nil {
+		return err
+	}
+
+	computed, err := argon2Key(pepperPassword(pwd, pepper), salt, iterations, memory, threads, uint32(len(storedHash)))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(computed, storedHash) != 1 {
+		return errors.New(errPwdWrong)
+	}
+
+	return nil
+}
+
+// pepperPassword keys a password with the pepper.
+func pepperPassword(pwd string, pepper []byte) []byte {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(pwd))
+
+	return mac.Sum(nil)
+}
+
+// getPepperKey returns the ID and value of the active pepper key.
+// Creates the key when there is none.
+// The pepper key is not rotated because the hashes that use it cannot be recomputed without the password.
+func getPepperKey() (string, []byte, error) {
+	pepperKeyLock.Lock()
+	defer pepperKeyLock.Unlock()
+
+	active, _, err := dal.DB.GetKeys(KeyTypes.Pepper)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	for id, k := range active {
+		key, err := base64.StdEncoding.DecodeString(string(k))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to decode pepper key: %w", err)
+		}
+		return id, key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, fmt.Errorf("failed to create pepper key: %w", err)
+	}
+	id := uuid.NewString()
+	if err := dal.DB.RotateKeys([]byte(base64.StdEncoding.EncodeToString(key)), id, KeyTypes.Pepper); err != nil {
+		return "", nil, fmt.Errorf("failed to store pepper key: %w", err)
+	}
+	slog.Info("pepper key created", "id", id)
+
+	return id, key, nil
+}
+
+// findPepperKey returns the value of an active or retired pepper key.
+func findPepperKey(id string) ([]byte, error) {
+	active, retired, err := dal.DB.GetKeys(KeyTypes.Pepper)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	k, ok := active[id]
+	if !ok {
+		k, ok = retired[id]
+	}
+	if !ok {
+		return nil, fmt.Errorf("pepper key not found: %s", id)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(k))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pepper key: %w", err)
+	}
+
+	return key, nil
+}
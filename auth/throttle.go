@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// Kinds of IDs that failed login attempts are tracked for.
+const (
+	LockoutUser    = "user"
+	LockoutAddress = "address"
+)
+
+// The number of failed login attempts that are allowed before logins are refused.
+// More attempts are allowed from an address because several users can share one.
+const userFreeAttempts = 5
+const addressFreeAttempts = 20
+
+// maxLockout is the longest time that logins are refused after a failed attempt.
+const maxLockout = 15 * time.Minute
+
+// failureMemory is the time after the last failed attempt that failed attempts are forgotten.
+const failureMemory = 24 * time.Hour
+
+var ErrLockedOut = errors.New("too many failed login attempts")
+
+// throttleLock serializes checks and updates of the records of failed login attempts.
+var throttleLock sync.Mutex
+
+// pendingAttempts counts the login attempts in progress by kind and ID.
+var pendingAttempts = map[string]int64{}
+
+// A LockoutError is returned when logins are refused because of failed login attempts.
+type LockoutError struct {
+	Until time.Time
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", ErrLockedOut.Error(), e.Until.UTC().Format(time.RFC3339))
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == ErrLockedOut
+}
+
+// A Lockout describes the failed login attempts for a user name or remote address.
+// Times are in seconds since the epoch.
+type Lockout struct {
+	Kind        string `json:"kind"`
+	ID          string `json:"id"`
+	Failures    int64  `json:"failures"`
+	LastFailure int64  `json:"lastFailure"`
+	LockedUntil int64  `json:"lockedUntil"`
+}
+
+// lockoutDuration returns the time that logins are refused after a number of failed attempts.
+// The time doubles with each attempt after the free attempts, up to maxLockout.
+func lockoutDuration(failures, freeAttempts int64) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	return min(time.Second<<min(failures-freeAttempts, 20), maxLockout)
+}
+
+func freeAttempts(kind string) int64 {
+	if kind == LockoutAddress {
+		return addressFreeAttempts
+	}
+	return userFreeAttempts
+}
+
+// checkLockout returns a LockoutError when logins are refused for the user name or the remote address.
+// Otherwise the attempt is reserved until the returned release function is called, which must happen
+// after a failure is recorded. While attempts are in progress, more attempts are only allowed when
+// the failure of all of them would not lock out the user name or the remote address. Parallel
+// attempts therefore cannot get past the free attempts before their failures are counted.
+func checkLockout(username, remoteAddr string) (func(), error) {
+	throttleLock.Lock()
+	defer throttleLock.Unlock()
+
+	now := time.Now()
+	until := int64(0)
+	ids := map[string]string{}
+	for kind, id := range map[string]string{LockoutUser: username, LockoutAddress: remoteAddr} {
+		if id == "" {
+			continue
+		}
+		failures, err := dal.DB.GetLoginFailures(kind, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check login failures: %w", err)
+		}
+		if failures == nil {
+			failures = &dal.LoginFailures{}
+		}
+
+		until = max(until, failures.LockedUntil)
+		pending := pendingAttempts[kind+"/"+id]
+		if pending > 0 {
+			if lockout := lockoutDuration(failures.Count+pending, freeAttempts(kind)); lockout > 0 {
+				until = max(until, now.Add(lockout).Unix())
+			}
+		}
+		ids[kind] = id
+	}
+
+	if until > now.Unix() {
+		return nil, &LockoutError{Until: time.Unix(until, 0)}
+	}
+
+	for kind, id := range ids {
+		pendingAttempts[kind+"/"+id]++
+	}
+
+	return func() {
+		throttleLock.Lock()
+		defer throttleLock.Unlock()
+
+		for kind, id := range ids {
+			if pendingAttempts[kind+"/"+id]--; pendingAttempts[kind+"/"+id] <= 0 {
+				delete(pendingAttempts, kind+"/"+id)
+			}
+		}
+	}, nil
+}
+
+// recordLoginFailure counts a failed login attempt for the user name and the remote address.
+// Errors are logged so that the failed attempt is still reported to the client.
+func recordLoginFailure(username, remoteAddr string) {
+	throttleLock.Lock()
+	defer throttleLock.Unlock()
+
+	now := time.Now()
+	for kind, id := range map[string]string{LockoutUser: username, LockoutAddress: remoteAddr} {
+		if id == "" {
+			continue
+		}
+		failures, err := dal.DB.GetLoginFailures(kind, id)
+		if err != nil {
+			slog.Error("failed to read login failures", "kind", kind, "id", id, "error", err.Error())
+			continue
+		}
+		if failures == nil {
+			failures = &dal.LoginFailures{Kind: kind, ID: id}
+		}
+
+		failures.Count++
+		failures.Last = now.Unix()
+		if lockout := lockoutDuration(failures.Count, freeAttempts(kind)); lockout > 0 {
+			failures.LockedUntil = now.Add(lockout).Unix()
+			slog.Warn("login locked out", "kind", kind, "id", id, "failures", failures.Count, "until", failures.LockedUntil)
+		}
+
+		if err := dal.DB.SetLoginFailures(*failures, failureMemory); err != nil {
+			slog.Error("failed to record login failure", "kind", kind, "id", id, "error", err.Error())
+		}
+	}
+}
+
+// clearLoginFailures forgets the failed login attempts for a user name after a successful login.
+// Failed attempts from the remote address are not forgotten so that one account cannot be used to
+// reset the attempts for guessing the passwords of other accounts.
+func clearLoginFailures(username string) {
+	if err := dal.DB.DeleteLoginFailures(LockoutUser, username); err != nil {
+		slog.Error("failed to clear login failures", "username", username, "error", err.Error())
+	}
+}
+
+// Lockouts returns the records of failed login attempts.
+func (a Authorizer) Lockouts() ([]Lockout, error) {
+	records, err := dal.DB.ListLoginFailures()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+
+	lockouts := []Lockout{}
+	for _, r := range records {
+		lockouts = append(lockouts, Lockout{
+			Kind:        r.Kind,
+			ID:          r.ID,
+			Failures:    r.Count,
+			LastFailure: r.Last,
+			LockedUntil: r.LockedUntil,
+		})
+	}
+
+	return lockouts, nil
+}
+
+// ClearLockout forgets the failed login attempts for a user name or remote address.
+func (a Authorizer) ClearLockout(kind, id string) error {
+	if kind != LockoutUser && kind != LockoutAddress {
+		return fmt.Errorf("unknown lockout kind %q", kind)
+	}
+
+	if err := dal.DB.DeleteLoginFailures(kind, id); err != nil {
+		return fmt.Errorf("failed to clear lockout: %w", err)
+	}
+	slog.Info("lockout cleared", "kind", kind, "id", id)
+
+	return nil
+}
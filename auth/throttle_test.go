@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scottbrodersen/homegym/dal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestThrottle(t *testing.T) {
+	a := NewAuthorizer()
+
+	Convey("Given the number of failed login attempts", t, func() {
+		Convey("When there are fewer failures than free attempts", func() {
+			So(lockoutDuration(userFreeAttempts-1, userFreeAttempts), ShouldEqual, 0)
+		})
+
+		Convey("When there are more failures than free attempts", func() {
+			Convey("Then the lockout doubles with each failure", func() {
+				So(lockoutDuration(userFreeAttempts, userFreeAttempts), ShouldEqual, time.Second)
+				So(lockoutDuration(userFreeAttempts+1, userFreeAttempts), ShouldEqual, 2*time.Second)
+				So(lockoutDuration(userFreeAttempts+3, userFreeAttempts), ShouldEqual, 8*time.Second)
+			})
+
+			Convey("Then the lockout does not exceed the maximum", func() {
+				So(lockoutDuration(userFreeAttempts+100, userFreeAttempts), ShouldEqual, maxLockout)
+			})
+		})
+	})
+
+	Convey("Given a user name that is locked out", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		until := time.Now().Add(time.Minute).Unix()
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", LockoutUser, testUserName).Return(&dal.LoginFailures{Kind: LockoutUser, ID: testUserName, Count: userFreeAttempts, LockedUntil: until}, nil)
+		db.On("GetLoginFailures", LockoutAddress, testRemoteAddr).Return(noFailures, nil)
+
+		Convey("When we issue a token", func() {
+			token, sessionID, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+
+			Convey("Then a lockout error is returned without checking the password", func() {
+				So(errors.Is(err, ErrLockedOut), ShouldBeTrue)
+				lockoutErr := &LockoutError{}
+				So(errors.As(err, &lockoutErr), ShouldBeTrue)
+				So(lockoutErr.Until.Unix(), ShouldEqual, until)
+				So(token, ShouldBeNil)
+				So(sessionID, ShouldBeNil)
+				db.AssertNotCalled(t, "ReadUser", mock.Anything)
+			})
+		})
+	})
+
+	Convey("Given a user name with failed login attempts", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		previous := &dal.LoginFailures{Kind: LockoutUser, ID: testUserName, Count: userFreeAttempts - 1}
+		db.On("GetLoginFailures", LockoutUser, testUserName).Return(previous, nil)
+		db.On("GetLoginFailures", LockoutAddress, testRemoteAddr).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, failureMemory).Return(nil)
+
+		Convey("When a login attempt fails", func() {
+			var none *string
+			db.On("ReadUser", testUserName).Return(none, none, none, none, nil)
+
+			_, _, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+
+			Convey("Then the failure is recorded for the user name and the address", func() {
+				So(err, ShouldEqual, ErrUnauthorized)
+
+				So(db.AssertCalled(t, "SetLoginFailures", mock.MatchedBy(func(f dal.LoginFailures) bool {
+					return f.Kind == LockoutUser && f.Count == userFreeAttempts && f.LockedUntil > time.Now().Unix()
+				}), failureMemory), ShouldBeTrue)
+
+				So(db.AssertCalled(t, "SetLoginFailures", mock.MatchedBy(func(f dal.LoginFailures) bool {
+					return f.Kind == LockoutAddress && f.ID == testRemoteAddr && f.Count == 1 && f.LockedUntil == 0
+				}), failureMemory), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given a user with a v2 password hash", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", mock.Anything, mock.Anything).Return(noFailures, nil)
+		db.On("GetKeys", KeyTypes.Pepper).Return(map[string][]byte{"pepper": []byte(base64.StdEncoding.EncodeToString([]byte("test pepper")))}, map[string][]byte{}, nil)
+		hash, version, role := "$argon2id$v=19$m=65536,t=3,p=4$pepper$c2FsdA$aGFzaA", "v2", string(testRole)
+		db.On("ReadUser", testUserName).Return(&testEmail, &hash, &version, &role, nil)
+
+		Convey("When a login attempt is made while all argon2id slots are in use", func() {
+			for range cap(argon2Slots) {
+				argon2Slots <- struct{}{}
+			}
+			_, _, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+			for range cap(argon2Slots) {
+				<-argon2Slots
+			}
+
+			Convey("Then the attempt is refused without counting a failure", func() {
+				So(err, ShouldEqual, ErrBusy)
+				db.AssertNotCalled(t, "SetLoginFailures", mock.Anything, mock.Anything)
+			})
+		})
+	})
+
+	Convey("Given a user name with one free login attempt left", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		previous := &dal.LoginFailures{Kind: LockoutUser, ID: testUserName, Count: userFreeAttempts - 1}
+		db.On("GetLoginFailures", LockoutUser, testUserName).Return(previous, nil)
+		db.On("GetLoginFailures", LockoutAddress, testRemoteAddr).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, failureMemory).Return(nil)
+
+		Convey("When login attempts are made in parallel", func() {
+			var none *string
+			checked := make(chan time.Time)
+			db.On("ReadUser", testUserName).WaitUntil(checked).Return(none, none, none, none, nil)
+
+			attempts := 10
+			results := make(chan error, attempts)
+			for range attempts {
+				go func() {
+					_, _, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+					results <- err
+				}()
+			}
+
+			refused := 0
+			timeout := time.After(5 * time.Second)
+		collect:
+			for range attempts - 1 {
+				select {
+				case err := <-results:
+					if errors.Is(err, ErrLockedOut) {
+						refused++
+					}
+				case <-timeout:
+					break collect
+				}
+			}
+			close(checked)
+			last := <-results
+
+			Convey("Then only one attempt checks the password", func() {
+				So(refused, ShouldEqual, attempts-1)
+				So(last, ShouldEqual, ErrUnauthorized)
+				db.AssertNumberOfCalls(t, "ReadUser", 1)
+				So(pendingAttempts, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given records of failed login attempts", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		db.On("ListLoginFailures").Return([]dal.LoginFailures{{Kind: LockoutAddress, ID: testRemoteAddr, Count: 2, Last: 100, LockedUntil: 0}}, nil)
+		db.On("DeleteLoginFailures", LockoutAddress, testRemoteAddr).Return(nil)
+
+		Convey("When we list the lockouts", func() {
+			lockouts, err := a.Lockouts()
+
+			So(err, ShouldBeNil)
+			So(lockouts, ShouldResemble, []Lockout{{Kind: LockoutAddress, ID: testRemoteAddr, Failures: 2, LastFailure: 100}})
+		})
+
+		Convey("When we clear a lockout", func() {
+			err := a.ClearLockout(LockoutAddress, testRemoteAddr)
+
+			So(err, ShouldBeNil)
+			So(db.AssertCalled(t, "DeleteLoginFailures", LockoutAddress, testRemoteAddr), ShouldBeTrue)
+		})
+
+		Convey("When we clear a lockout of an unknown kind", func() {
+			err := a.ClearLockout("device", testRemoteAddr)
+
+			So(err, ShouldNotBeNil)
+			db.AssertNotCalled(t, "DeleteLoginFailures", mock.Anything, mock.Anything)
+		})
+	})
+}
@@ -0,0 +1,585 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// TOTP parameters, as described in RFC 6238.
+// Codes from the previous and next time step are accepted to allow for clock drift.
+const (
+	totpIssuer     = "HomeGym"
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSkew       = 1
+	totpSecretSize = 20
+)
+
+// The number of recovery codes that are created when two-factor authentication is enabled.
+const recoveryCodeCount = 10
+
+// challengeLifetime is the time that a user has to provide the second factor after their password.
+const challengeLifetime = 5 * time.Minute
+
+// maxChallengeAttempts is the number of codes that can be tried for a login challenge.
+const maxChallengeAttempts = 5
+
+var ErrSecondFactorRequired = errors.New("second factor required")
+var ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+var ErrTOTPNotEnrolled = errors.New("two-factor authentication is not enrolled")
+var ErrInvalidCode = errors.New("invalid code")
+
+// A SecondFactorRequiredError is returned when a password is valid and the user must also provide a code.
+// The challenge identifies the login when the code is provided.
+type SecondFactorRequiredError struct {
+	Challenge string
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return ErrSecondFactorRequired.Error()
+}
+
+func (e *SecondFactorRequiredError) Is(target error) bool {
+	return target == ErrSecondFactorRequired
+}
+
+// A TOTPStatus describes the two-factor authentication state of a user.
+type TOTPStatus struct {
+	Enabled       bool `json:"enabled"`
+	Pending       bool `json:"pending"`
+	RecoveryCodes int  `json:"recoveryCodes"`
+}
+
+// A loginChallenge is a login that is waiting for the second factor.
+type loginChallenge struct {
+	username string
+	expires  time.Time
+	attempts int
+}
+
+var challenges = map[string]*loginChallenge{}
+var challengeLock sync.Mutex
+
+// totpLock serializes code verification so that a code cannot be used twice.
+var totpLock sync.Mutex
+
+// totpKeyLock serializes the creation of the key that encrypts TOTP secrets.
+var totpKeyLock sync.Mutex
+
+// TOTPStatus returns the two-factor authentication state of a user.
+func (a Authorizer) TOTPStatus(username string) (*TOTPStatus, error) {
+	info, err := dal.DB.ReadTOTP(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+	if info == nil {
+		return &TOTPStatus{}, nil
+	}
+
+	return &TOTPStatus{Enabled: info.Enabled, Pending: !info.Enabled, RecoveryCodes: info.RecoveryCodes}, nil
+}
+
+// EnrollTOTP creates a TOTP secret for a user and returns it as an otpauth URI.
+// Two-factor authentication is enabled when the user confirms a code with ConfirmTOTP.
+// Enrolling again replaces a secret that has not been confirmed.
+func (a Authorizer) EnrollTOTP(username string) (string, error) {
+	info, err := dal.DB.ReadTOTP(username)
+	if err != nil {
+		return "", fmt.Errorf("failed to get totp: %w", err)
+	}
+	if info != nil && info.Enabled {
+		return "", ErrTOTPEnabled
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to create totp secret: %w", err)
+	}
+
+	encrypted, err := encryptTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	if err := dal.DB.SetTOTPSecret(username, encrypted); err != nil {
+		return "", fmt.Errorf("failed to store totp secret: %w", err)
+	}
+
+	return totpURI(username, secret), nil
+}
+
+// ConfirmTOTP enables two-factor authentication for a user that provides a valid code.
+// Returns the recovery codes, which are only stored as hashes.
+// Failed attempts are counted like failed passwords.
+func (a Authorizer) ConfirmTOTP(username, code, remoteAddr string) ([]string, error) {
+	release, err := checkLockout(username, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	totpLock.Lock()
+	defer totpLock.Unlock()
+
+	info, err := dal.DB.ReadTOTP(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+	if info == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if info.Enabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	secret, err := decryptTOTPSecret(info.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := validateTOTPCode(secret, code, info.LastStep, time.Now())
+	if !ok {
+		recordLoginFailure(username, remoteAddr)
+		return nil, ErrInvalidCode
+	}
+	clearLoginFailures(username)
+
+	codes := []string{}
+	hashes := []string{}
+	for range recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	if err := dal.DB.EnableTOTP(username, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable totp: %w", err)
+	}
+	if err := dal.DB.SetTOTPStep(username, step); err != nil {
+		return nil, fmt.Errorf("failed to store totp step: %w", err)
+	}
+	slog.Info("two-factor authentication enabled", "username", username)
+
+	return codes, nil
+}
+
+// DisableTOTP removes two-factor authentication from a user that provides their password and a valid
+// code or recovery code.
+// Failed attempts are counted like failed passwords.
+// Returns ErrBusy when too many passwords are being checked.
+func (a Authorizer) DisableTOTP(username, pwd, code, remoteAddr string) error {
+	release, err := checkLockout(username, remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	info, err := dal.DB.ReadTOTP(username)
+	if err != nil {
+		return fmt.Errorf("failed to get totp: %w", err)
+	}
+	if info == nil || !info.Enabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	_, pwdHash, pwdHashVersion, _, err := dal.DB.ReadUser(username)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if pwdHash == nil || pwdHashVersion == nil {
+		recordLoginFailure(username, remoteAddr)
+		return ErrUnauthorized
+	}
+	pwdUtil := PasswordUtil{Version: *pwdHashVersion}
+	if err := pwdUtil.ValidatePassword(pwd, *pwdHash); err != nil {
+		if errors.Is(err, ErrBusy) {
+			return err
+		}
+		recordLoginFailure(username, remoteAddr)
+		return ErrUnauthorized
+	}
+
+	ok, err := verifySecondFactor(username, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		recordLoginFailure(username, remoteAddr)
+		return ErrInvalidCode
+	}
+	clearLoginFailures(username)
+
+	if err := dal.DB.DeleteTOTP(username); err != nil {
+		return fmt.Errorf("failed to delete totp: %w", err)
+	}
+	slog.Info("two-factor authentication disabled", "username", username)
+
+	return nil
+}
+
+// CompleteLogin authenticates the second factor of a login and issues a token.
+// The code is either a TOTP code or a recovery code.
+// Failed attempts are counted like failed passwords.
+// Returns the token string and session ID.
+func (a Authorizer) CompleteLogin(challenge, code, userAgent, remoteAddr string) (*string, *string, error) {
+	username, ok := challengeUser(challenge)
+	if !ok {
+		return nil, nil, ErrUnauthorized
+	}
+
+	release, err := checkLockout(username, remoteAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	valid, err := verifySecondFactor(username, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !valid {
+		recordLoginFailure(username, remoteAddr)
+		failChallenge(challenge)
+		return nil, nil, ErrUnauthorized
+	}
+	endChallenge(challenge)
+
+	_, _, _, role, err := dal.DB.ReadUser(username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if role == nil {
+		return nil, nil, ErrUnauthorized
+	}
+
+	status, err := dal.DB.ReadUserStatus(username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user status: %w", err)
+	}
+	if status.Disabled {
+		return nil, nil, ErrAccountDisabled
+	}
+	clearLoginFailures(username)
+
+	return a.issueSession(username, *role, userAgent)
+}
+
+// newChallenge records a login that is waiting for the second factor and returns its ID.
+// Expired challenges are removed.
+func newChallenge(username string) string {
+	challengeLock.Lock()
+	defer challengeLock.Unlock()
+
+	now := time.Now()
+	for id, c := range challenges {
+		if now.After(c.expires) {
+			delete(challenges, id)
+		}
+	}
+
+	id := uuid.NewString()
+	challenges[id] = &loginChallenge{username: username, expires: now.Add(challengeLifetime)}
+
+	return id
+}
+
+// challengeUser returns the user of a login challenge that has not expired.
+func challengeUser(id string) (string, bool) {
+	challengeLock.Lock()
+	defer challengeLock.Unlock()
+
+	c, ok := challenges[id]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(c.expires) {
+		delete(challenges, id)
+		return "", false
+	}
+
+	return c.username, true
+}
+
+// failChallenge counts a failed attempt for a login challenge.
+// The challenge is removed after maxChallengeAttempts so that the password must be provided again.
+func failChallenge(id string) {
+	challengeLock.Lock()
+	defer challengeLock.Unlock()
+
+	if c, ok := challenges[id]; ok {
+		c.attempts++
+		if c.attempts >= maxChallengeAttempts {
+			delete(challenges, id)
+		}
+	}
+}
+
+func endChallenge(id string) {
+	challengeLock.Lock()
+	defer challengeLock.Unlock()
+
+	delete(challenges, id)
+}
+
+// verifySecondFactor returns true when the code is a valid TOTP code or an unused recovery code of the user.
+// Records the time step of accepted TOTP codes and deletes used recovery codes so that codes cannot be reused.
+func verifySecondFactor(username, code string) (bool, error) {
+	totpLock.Lock()
+	defer totpLock.Unlock()
+
+	info, err := dal.DB.ReadTOTP(username)
+	if err != nil {
+		return false, fmt.Errorf("failed to get totp: %w", err)
+	}
+	if info == nil || !info.Enabled {
+		return false, nil
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		secret, err := decryptTOTPSecret(info.Secret)
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := validateTOTPCode(secret, code, info.LastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+		if err := dal.DB.SetTOTPStep(username, step); err != nil {
+			return false, fmt.Errorf("failed to store totp step: %w", err)
+		}
+		return true, nil
+	}
+
+	used, err := dal.DB.UseRecoveryCode(username, hashRecoveryCode(code))
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if used {
+		slog.Info("recovery code used", "username", username)
+	}
+
+	return used, nil
+}
+
+// totpCode returns the code of a secret for a time step, as described in RFC 4226.
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range totpDigits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// validateTOTPCode checks a code against the time steps around a time.
+// Codes of steps up to and including lastStep are refused so that a code is only used once.
+// Returns the step of the code.
+func validateTOTPCode(secret []byte, code string, lastStep int64, t time.Time) (int64, bool) {
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpURI returns the otpauth URI of a secret that authenticator apps read.
+func totpURI(username string, secret []byte) string {
+	params := url.Values{}
+	params.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     fmt.Sprintf("/%s:%s", totpIssuer, username),
+		RawQuery: params.Encode(),
+	}
+
+	return uri.String()
+}
+
+// newRecoveryCode returns a random recovery code in the format xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create recovery code: %w", err)
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+
+	return fmt.Sprintf("%s-%s", code[:5], code[5:10]), nil
+}
+
+// hashRecoveryCode returns the hex-encoded SHA-256 hash of a recovery code.
+// Case, spaces, and dashes are ignored.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// getTOTPKey returns the ID and value of the active key that encrypts TOTP secrets.
+// Creates the key when there is none.
+func getTOTPKey() (string, []byte, error) {
+	totpKeyLock.Lock()
+	defer totpKeyLock.Unlock()
+
+	active, _, err := dal.DB.GetKeys(KeyTypes.TOTP)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+	for id, k := range active {
+		key, err := base64.StdEncoding.DecodeString(string(k))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to decode totp key: %w", err)
+		}
+		return id, key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, fmt.Errorf("failed to create totp key: %w", err)
+	}
+	id := uuid.NewString()
+	if err := dal.DB.RotateKeys([]byte(base64.StdEncoding.EncodeToString(key)), id, KeyTypes.TOTP); err != nil {
+		return "", nil, fmt.Errorf("failed to store totp key: %w", err)
+	}
+	slog.Info("totp key created", "id", id)
+
+	return id, key, nil
+}
+
+// findTOTPKey returns the value of an active or retired key that encrypts TOTP secrets.
+func findTOTPKey(id string) ([]byte, error) {
+	active, retired, err := dal.DB.GetKeys(KeyTypes.TOTP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %w", err)
+	}
+
+	k, ok := active[id]
+	if !ok {
+		k, ok = retired[id]
+	}
+	if !ok {
+		return nil, fmt.Errorf("totp key not found: %s", id)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(k))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode totp key: %w", err)
+	}
+
+	return key, nil
+}
+
+// encryptTOTPSecret encrypts a secret with AES-GCM.
+// The result is the key ID and the base64-encoded nonce and ciphertext, separated by a period.
+func encryptTOTPSecret(secret []byte) ([]byte, error) {
+	keyID, key, err := getTOTPKey()
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, secret, []byte(keyID))
+
+	return []byte(fmt.Sprintf("%s.%s", keyID, base64.StdEncoding.EncodeToString(sealed))), nil
+}
+
+// decryptTOTPSecret decrypts a secret that was encrypted by encryptTOTPSecret.
+func decryptTOTPSecret(encrypted []byte) ([]byte, error) {
+	keyID, encoded, found := strings.Cut(string(encrypted), ".")
+	if !found {
+		return nil, fmt.Errorf("malformed totp secret")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode totp secret: %w", err)
+	}
+
+	key, err := findTOTPKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed totp secret")
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return gcm, nil
+}
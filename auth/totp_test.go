@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottbrodersen/homegym/dal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTOTP(t *testing.T) {
+	a := NewAuthorizer()
+	// the secret of the RFC 6238 test vectors
+	rfcSecret := []byte("12345678901234567890")
+	testTOTPKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	testTOTPKeyID := "test-totp-key-id"
+
+	Convey("Given a TOTP secret", t, func() {
+		Convey("When we create codes", func() {
+			Convey("Then the codes match the RFC 6238 test vectors", func() {
+				So(totpCode(rfcSecret, 59/totpPeriod), ShouldEqual, "287082")
+				So(totpCode(rfcSecret, 1111111109/totpPeriod), ShouldEqual, "081804")
+				So(totpCode(rfcSecret, 2000000000/totpPeriod), ShouldEqual, "279037")
+			})
+		})
+
+		Convey("When we validate codes", func() {
+			now := time.Unix(1111111109, 0)
+			current := now.Unix() / totpPeriod
+
+			Convey("Then codes of adjacent steps are accepted", func() {
+				step, ok := validateTOTPCode(rfcSecret, totpCode(rfcSecret, current-1), 0, now)
+				So(ok, ShouldBeTrue)
+				So(step, ShouldEqual, current-1)
+
+				_, ok = validateTOTPCode(rfcSecret, totpCode(rfcSecret, current+1), 0, now)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("Then codes of other steps are refused", func() {
+				_, ok := validateTOTPCode(rfcSecret, totpCode(rfcSecret, current-2), 0, now)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("Then codes that were already used are refused", func() {
+				_, ok := validateTOTPCode(rfcSecret, totpCode(rfcSecret, current), current, now)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When we create the otpauth URI", func() {
+			uri, err := url.Parse(totpURI(testUserName, rfcSecret))
+
+			So(err, ShouldBeNil)
+			So(uri.Scheme, ShouldEqual, "otpauth")
+			So(uri.Host, ShouldEqual, "totp")
+			So(uri.Path, ShouldEqual, "/HomeGym:"+testUserName)
+			So(uri.Query().Get("secret"), ShouldEqual, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+			So(uri.Query().Get("issuer"), ShouldEqual, "HomeGym")
+		})
+	})
+
+	Convey("Given a recovery code", t, func() {
+		code, err := newRecoveryCode()
+		So(err, ShouldBeNil)
+		So(code, ShouldHaveLength, 11)
+
+		Convey("Then the hash ignores case and dashes", func() {
+			So(hashRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))), ShouldEqual, hashRecoveryCode(code))
+		})
+	})
+
+	Convey("Given a TOTP encryption key", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		db.On("GetKeys", KeyTypes.TOTP).Return(map[string][]byte{testTOTPKeyID: []byte(testTOTPKey)}, map[string][]byte{}, nil)
+
+		Convey("When we encrypt a secret", func() {
+			encrypted, err := encryptTOTPSecret(rfcSecret)
+			So(err, ShouldBeNil)
+			So(string(encrypted), ShouldStartWith, testTOTPKeyID+".")
+			So(string(encrypted), ShouldNotContainSubstring, string(rfcSecret))
+
+			Convey("Then the secret can be decrypted", func() {
+				secret, err := decryptTOTPSecret(encrypted)
+				So(err, ShouldBeNil)
+				So(secret, ShouldResemble, rfcSecret)
+			})
+
+			Convey("Then a modified secret cannot be decrypted", func() {
+				encrypted[len(encrypted)-2] ^= 1
+				_, err := decryptTOTPSecret(encrypted)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a user that is enrolling", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		db.On("GetKeys", KeyTypes.TOTP).Return(map[string][]byte{testTOTPKeyID: []byte(testTOTPKey)}, map[string][]byte{}, nil)
+		encrypted, _ := encryptTOTPSecret(rfcSecret)
+		db.On("ReadTOTP", testUserName).Return(&dal.TOTPInfo{Secret: encrypted}, nil)
+		db.On("EnableTOTP", testUserName, mock.Anything).Return(nil)
+		db.On("SetTOTPStep", testUserName, mock.Anything).Return(nil)
+		db.On("SetLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("DeleteLoginFailures", mock.Anything, mock.Anything).Return(nil)
+
+		Convey("When the user confirms a valid code", func() {
+			db.On("GetLoginFailures", mock.Anything, mock.Anything).Return((*dal.LoginFailures)(nil), nil)
+
+			codes, err := a.ConfirmTOTP(testUserName, totpCode(rfcSecret, time.Now().Unix()/totpPeriod), testRemoteAddr)
+
+			Convey("Then two-factor authentication is enabled with hashed recovery codes", func() {
+				So(err, ShouldBeNil)
+				So(codes, ShouldHaveLength, recoveryCodeCount)
+				So(db.AssertCalled(t, "EnableTOTP", testUserName, mock.MatchedBy(func(hashes []string) bool {
+					return len(hashes) == recoveryCodeCount && hashes[0] == hashRecoveryCode(codes[0])
+				})), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the user confirms an invalid code", func() {
+			db.On("GetLoginFailures", mock.Anything, mock.Anything).Return((*dal.LoginFailures)(nil), nil)
+
+			codes, err := a.ConfirmTOTP(testUserName, "000000x", testRemoteAddr)
+
+			Convey("Then two-factor authentication is not enabled and the failure is recorded", func() {
+				So(err, ShouldEqual, ErrInvalidCode)
+				So(codes, ShouldBeNil)
+				db.AssertNotCalled(t, "EnableTOTP", mock.Anything, mock.Anything)
+				So(db.AssertCalled(t, "SetLoginFailures", mock.MatchedBy(func(f dal.LoginFailures) bool {
+					return f.Kind == LockoutUser && f.ID == testUserName
+				}), failureMemory), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the user confirms a code after too many failures", func() {
+			locked := dal.LoginFailures{Kind: LockoutUser, ID: testUserName, Count: 10, LockedUntil: time.Now().Add(time.Minute).Unix()}
+			db.On("GetLoginFailures", LockoutUser, testUserName).Return(&locked, nil)
+			db.On("GetLoginFailures", LockoutAddress, testRemoteAddr).Return((*dal.LoginFailures)(nil), nil)
+
+			_, err := a.ConfirmTOTP(testUserName, totpCode(rfcSecret, time.Now().Unix()/totpPeriod), testRemoteAddr)
+
+			Convey("Then the code is not checked", func() {
+				So(errors.As(err, new(*LockoutError)), ShouldBeTrue)
+				db.AssertNotCalled(t, "EnableTOTP", mock.Anything, mock.Anything)
+			})
+		})
+	})
+
+	Convey("Given a user that has enabled two-factor authentication", t, func() {
+		pwdUtil := PasswordUtil{Version: passwordVersion}
+		testPwdHash, _ := pwdUtil.Hash(testPassword)
+		testTokenKey, _ := generateRsaPrivate(2048)
+		roleStr := string(testRole)
+
+		db := dal.NewMockDal()
+		dal.DB = db
+		noFailures := (*dal.LoginFailures)(nil)
+		db.On("GetLoginFailures", mock.Anything, mock.Anything).Return(noFailures, nil)
+		db.On("SetLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("DeleteLoginFailures", mock.Anything, mock.Anything).Return(nil)
+		db.On("ReadUser", testUserName).Return(&testEmail, &testPwdHash, &passwordVersion, &roleStr, nil)
+		db.On("ReadUserStatus", testUserName).Return(&dal.UserStatus{}, nil)
+		db.On("GetKeys", KeyTypes.TOTP).Return(map[string][]byte{testTOTPKeyID: []byte(testTOTPKey)}, map[string][]byte{}, nil)
+		db.On("GetKeys", KeyTypes.Token).Return(map[string][]byte{"test-key-id": rsaKeyToByteSlice(testTokenKey)}, map[string][]byte{}, nil)
+		db.On("GetKeys", KeyTypes.Pepper).Return(map[string][]byte{"test-pepper-key-id": []byte(base64.StdEncoding.EncodeToString([]byte("test pepper")))}, map[string][]byte{}, nil)
+		db.On("UpdatePwdVersion", testUserName, mock.Anything, LatestVersion()).Return(nil)
+		db.On("AddSession", testUserName, mock.Anything, testUserAgent, mock.Anything).Return(nil)
+		encrypted, _ := encryptTOTPSecret(rfcSecret)
+		db.On("ReadTOTP", testUserName).Return(&dal.TOTPInfo{Secret: encrypted, Enabled: true, RecoveryCodes: 1}, nil)
+		db.On("SetTOTPStep", testUserName, mock.Anything).Return(nil)
+
+		Convey("When the user logs in with their password", func() {
+			token, sessionID, err := a.IssueToken(testUserName, testPassword, testUserAgent, testRemoteAddr)
+
+			Convey("Then a second factor is required", func() {
+				So(errors.Is(err, ErrSecondFactorRequired), ShouldBeTrue)
+				So(token, ShouldBeNil)
+				So(sessionID, ShouldBeNil)
+				db.AssertNotCalled(t, "AddSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				db.AssertNotCalled(t, "DeleteLoginFailures", mock.Anything, mock.Anything)
+			})
+
+			challengeErr := &SecondFactorRequiredError{}
+			So(errors.As(err, &challengeErr), ShouldBeTrue)
+
+			Convey("When the user provides a valid code", func() {
+				token, sessionID, err := a.CompleteLogin(challengeErr.Challenge, totpCode(rfcSecret, time.Now().Unix()/totpPeriod), testUserAgent, testRemoteAddr)
+
+				Convey("Then a token is issued", func() {
+					So(err, ShouldBeNil)
+					So(token, ShouldNotBeNil)
+					So(sessionID, ShouldNotBeNil)
+					So(db.AssertCalled(t, "DeleteLoginFailures", LockoutUser, testUserName), ShouldBeTrue)
+				})
+
+				Convey("Then the challenge cannot be used again", func() {
+					_, _, err := a.CompleteLogin(challengeErr.Challenge, totpCode(rfcSecret, time.Now().Unix()/totpPeriod), testUserAgent, testRemoteAddr)
+					So(err, ShouldEqual, ErrUnauthorized)
+				})
+			})
+
+			Convey("When the user provides a recovery code", func() {
+				db.On("UseRecoveryCode", testUserName, hashRecoveryCode("abcde-fghij")).Return(true, nil)
+
+				token, _, err := a.CompleteLogin(challengeErr.Challenge, "abcde-fghij", testUserAgent, testRemoteAddr)
+
+				Convey("Then a token is issued", func() {
+					So(err, ShouldBeNil)
+					So(token, ShouldNotBeNil)
+				})
+			})
+
+			Convey("When the user provides invalid codes", func() {
+				db.On("UseRecoveryCode", testUserName, mock.Anything).Return(false, nil)
+
+				for range maxChallengeAttempts {
+					_, _, err := a.CompleteLogin(challengeErr.Challenge, "wrong", testUserAgent, testRemoteAddr)
+					So(err, ShouldEqual, ErrUnauthorized)
+				}
+
+				Convey("Then the failures are recorded and the challenge is ended", func() {
+					So(db.AssertCalled(t, "SetLoginFailures", mock.MatchedBy(func(f dal.LoginFailures) bool {
+						return f.Kind == LockoutUser && f.ID == testUserName
+					}), failureMemory), ShouldBeTrue)
+
+					_, ok := challengeUser(challengeErr.Challenge)
+					So(ok, ShouldBeFalse)
+				})
+			})
+		})
+
+		Convey("When the user disables two-factor authentication with an invalid code", func() {
+			db.On("UseRecoveryCode", testUserName, mock.Anything).Return(false, nil)
+
+			err := a.DisableTOTP(testUserName, testPassword, "wrong", testRemoteAddr)
+
+			So(err, ShouldEqual, ErrInvalidCode)
+			db.AssertNotCalled(t, "DeleteTOTP", mock.Anything)
+			So(db.AssertCalled(t, "SetLoginFailures", mock.MatchedBy(func(f dal.LoginFailures) bool {
+				return f.Kind == LockoutUser && f.ID == testUserName
+			}), failureMemory), ShouldBeTrue)
+		})
+
+		Convey("When the user disables two-factor authentication with an incorrect password", func() {
+			err := a.DisableTOTP(testUserName, "wrong password", totpCode(rfcSecret, time.Now().Unix()/totpPeriod), testRemoteAddr)
+
+			So(err, ShouldEqual, ErrUnauthorized)
+			db.AssertNotCalled(t, "DeleteTOTP", mock.Anything)
+			db.AssertNotCalled(t, "SetTOTPStep", mock.Anything, mock.Anything)
+		})
+
+		Convey("When the user disables two-factor authentication with their password and a valid code", func() {
+			db.On("DeleteTOTP", testUserName).Return(nil)
+
+			err := a.DisableTOTP(testUserName, testPassword, totpCode(rfcSecret, time.Now().Unix()/totpPeriod), testRemoteAddr)
+
+			So(err, ShouldBeNil)
+			So(db.AssertCalled(t, "DeleteTOTP", testUserName), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,404 @@
+// Package certs provisions the TLS certificate of the Home Gym server.
+// A local certificate authority (CA) signs a server certificate that covers the LAN addresses
+// and the mDNS name of the host. Devices that install the CA certificate trust the server.
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The CA certificate and key are saved in the directory of the server certificate.
+const (
+	CACertFile = "homegym-ca.crt"
+	caKeyFile  = "homegym-ca.key"
+)
+
+const (
+	caLifetime   = 10 * 365 * 24 * time.Hour
+	certLifetime = 365 * 24 * time.Hour // some devices refuse certificates that last longer than 825 days
+	renewBefore  = 30 * 24 * time.Hour
+	renewalCheck = 24 * time.Hour
+)
+
+// A Manager maintains the server certificate and the CA that signs it.
+// The certificate is renewed when it is about to expire or no longer covers the host.
+type Manager struct {
+	certFile string
+	keyFile  string
+	names    []string // DNS names in addition to the default names
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	ca    *x509.Certificate
+	caKey crypto.Signer
+	caPEM []byte
+}
+
+// NewManager returns a Manager that saves the server certificate and key in files.
+// The CA is loaded from the directory of the certificate file or created when there is none.
+// The certificate is loaded from the files, or created or renewed as needed.
+// The certificate covers localhost, the mDNS name of the host, the additional DNS names,
+// and the IP addresses of the host.
+func NewManager(certFile, keyFile string, names []string) (*Manager, error) {
+	m := &Manager{
+		certFile: certFile,
+		keyFile:  keyFile,
+		names:    names,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	if err := m.loadCA(); err != nil {
+		return nil, err
+	}
+	if err := m.Renew(false); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// GetCertificate returns the server certificate.
+// It is used as the GetCertificate function of a tls.Config so that renewed certificates are served without a restart.
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cert, nil
+}
+
+// CACert returns the PEM-encoded certificate of the CA.
+func (m *Manager) CACert() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.caPEM
+}
+
+// CACertPath returns the path to the file of the CA certificate.
+func (m *Manager) CACertPath() string {
+	return filepath.Join(filepath.Dir(m.certFile), CACertFile)
+}
+
+// Names returns the DNS names and IP addresses that the server certificate covers.
+func (m *Manager) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := slices.Clone(m.cert.Leaf.DNSNames)
+	for _, ip := range m.cert.Leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	return names
+}
+
+// Expires returns the expiry time of the server certificate.
+func (m *Manager) Expires() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cert.Leaf.NotAfter
+}
+
+// Renew creates a server certificate when the saved certificate needs renewal, or always when force is true.
+func (m *Manager) Renew(force bool) error {
+	names := hostNames(m.names)
+	ips, err := hostIPs()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !force {
+		if m.cert == nil {
+			if cert, err := m.loadCert(); err == nil {
+				m.cert = cert
+			} else if !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("failed to load server certificate; creating a new one", "error", err.Error())
+			}
+		}
+		if m.cert != nil {
+			reason := renewalReason(m.cert.Leaf, m.ca, names, ips, time.Now())
+			if reason == "" {
+				return nil
+			}
+			slog.Info("renewing server certificate", "reason", reason)
+		}
+	}
+
+	cert, err := m.createCert(names, ips)
+	if err != nil {
+		return err
+	}
+	m.cert = cert
+	slog.Info("server certificate created", "expires", cert.Leaf.NotAfter.Format(time.RFC3339), "names", strings.Join(cert.Leaf.DNSNames, ","))
+
+	return nil
+}
+
+// ScheduleRenewal checks every day whether the server certificate needs renewal.
+func (m *Manager) ScheduleRenewal() {
+	time.AfterFunc(renewalCheck, func() {
+		if err := m.Renew(false); err != nil {
+			slog.Error("failed to renew server certificate", "error", err.Error())
+		}
+
+		m.ScheduleRenewal()
+	})
+}
+
+// renewalReason returns the reason that a certificate needs renewal, or an empty string when it does not.
+func renewalReason(leaf, ca *x509.Certificate, names []string, ips []net.IP, now time.Time) string {
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		return "not signed by the CA"
+	}
+	if now.Add(renewBefore).After(leaf.NotAfter) {
+		return "expires soon"
+	}
+	for _, name := range names {
+		if !slices.Contains(leaf.DNSNames, name) {
+			return "does not cover " + name
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(leaf.IPAddresses, ip.Equal) {
+			return "does not cover " + ip.String()
+		}
+	}
+
+	return ""
+}
+
+// loadCA loads the CA from its files or creates it when there are none.
+// A CA that expires before a server certificate would is replaced.
+func (m *Manager) loadCA() error {
+	dir := filepath.Dir(m.certFile)
+	certPath := filepath.Join(dir, CACertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	switch {
+	case err == nil:
+		signer, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("unsupported CA key type")
+		}
+		if time.Now().Add(certLifetime).After(pair.Leaf.NotAfter) {
+			slog.Warn("CA certificate expires soon; creating a new CA that devices must install", "expires", pair.Leaf.NotAfter.Format(time.RFC3339))
+			break
+		}
+		m.ca = pair.Leaf
+		m.caKey = signer
+		m.caPEM, err = os.ReadFile(certPath)
+		return err
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to create CA key: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Home Gym"},
+			CommonName:   fmt.Sprintf("Home Gym local CA %s", hostname),
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := createCertificate(template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := encodePair(der, key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeFile(certPath, certPEM, 0644); err != nil {
+		return err
+	}
+	slog.Info("CA created", "certificate", certPath)
+
+	m.ca = ca
+	m.caKey = key
+	m.caPEM = certPEM
+
+	return nil
+}
+
+// loadCert loads the server certificate from its files.
+func (m *Manager) loadCert() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// createCert creates a server certificate that the CA signs and saves it to the files.
+func (m *Manager) createCert(names []string, ips []net.IP) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"Home Gym"},
+			CommonName:   names[0],
+		},
+		DNSNames:    names,
+		IPAddresses: ips,
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(certLifetime),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := createCertificate(template, m.ca, key.Public(), m.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	certPEM, keyPEM, err := encodePair(der, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(m.keyFile, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	// the chain includes the CA so that clients can build it
+	chainPEM := append(certPEM, m.caPEM...)
+	if err := writeFile(m.certFile, chainPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(chainPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// createCertificate creates a certificate with a random serial number and returns it in DER encoding.
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create serial number: %w", err)
+	}
+	template.SerialNumber = serial
+
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+}
+
+// encodePair returns the PEM encoding of a certificate and its key.
+func encodePair(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// writeFile replaces the content of a file so that readers never see a partial file.
+func writeFile(path string, content []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// hostNames returns localhost, the mDNS name of the host, and the additional names, without duplicates.
+func hostNames(names []string) []string {
+	all := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		// the mDNS name is the first label of the host name in the .local domain
+		label, _, _ := strings.Cut(strings.ToLower(hostname), ".")
+		all = append(all, label+".local")
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(all, name) {
+			all = append(all, name)
+		}
+	}
+
+	return all
+}
+
+// hostIPs returns the loopback and LAN IP addresses of the host.
+// Link-local IPv6 addresses are excluded because they are not usable in URLs without a zone.
+func hostIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network addresses: %w", err)
+	}
+
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsMulticast() {
+			continue
+		}
+		ip := ipNet.IP
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		if !slices.ContainsFunc(ips, ip.Equal) {
+			ips = append(ips, ip)
+		}
+	}
+	slices.SortFunc(ips, func(a, b net.IP) int { return bytes.Compare(a, b) })
+
+	return ips, nil
+}
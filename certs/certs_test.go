@@ -0,0 +1,106 @@
+package certs
+
+import (
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCerts(t *testing.T) {
+	Convey("Given a directory without certificates", t, func() {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "tls", "homegym.crt")
+		keyFile := filepath.Join(dir, "tls", "homegym.key")
+
+		Convey("When we create a manager", func() {
+			m, err := NewManager(certFile, keyFile, []string{"Gym.Example.com", "localhost"})
+			So(err, ShouldBeNil)
+
+			Convey("Then the CA and server certificate are saved", func() {
+				for _, f := range []string{certFile, keyFile, m.CACertPath(), filepath.Join(dir, "tls", caKeyFile)} {
+					_, err := os.Stat(f)
+					So(err, ShouldBeNil)
+				}
+				info, err := os.Stat(keyFile)
+				So(err, ShouldBeNil)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+			})
+
+			Convey("Then the server certificate is trusted by devices that install the CA", func() {
+				roots := x509.NewCertPool()
+				So(roots.AppendCertsFromPEM(m.CACert()), ShouldBeTrue)
+
+				cert, err := m.GetCertificate(nil)
+				So(err, ShouldBeNil)
+				for _, name := range []string{"localhost", "gym.example.com", "127.0.0.1"} {
+					_, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: name})
+					So(err, ShouldBeNil)
+				}
+				So(m.Names(), ShouldContain, "localhost")
+				So(m.Expires().After(time.Now().Add(renewBefore)), ShouldBeTrue)
+			})
+
+			Convey("And when we create another manager with the same files", func() {
+				cert, _ := m.GetCertificate(nil)
+				other, err := NewManager(certFile, keyFile, []string{"gym.example.com"})
+				So(err, ShouldBeNil)
+
+				Convey("Then the CA and certificate are reused", func() {
+					So(string(other.CACert()), ShouldEqual, string(m.CACert()))
+					otherCert, _ := other.GetCertificate(nil)
+					So(otherCert.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber), ShouldEqual, 0)
+				})
+			})
+
+			Convey("And when we force renewal", func() {
+				cert, _ := m.GetCertificate(nil)
+				So(m.Renew(true), ShouldBeNil)
+
+				Convey("Then a new certificate is served", func() {
+					renewed, _ := m.GetCertificate(nil)
+					So(renewed.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber), ShouldNotEqual, 0)
+				})
+			})
+
+			Convey("And when we add a name", func() {
+				other, err := NewManager(certFile, keyFile, []string{"gym.example.com", "gym.lan"})
+				So(err, ShouldBeNil)
+
+				Convey("Then the certificate is renewed to cover the name", func() {
+					So(other.Names(), ShouldContain, "gym.lan")
+				})
+			})
+		})
+	})
+
+	Convey("Given a certificate", t, func() {
+		dir := t.TempDir()
+		m, err := NewManager(filepath.Join(dir, "homegym.crt"), filepath.Join(dir, "homegym.key"), nil)
+		So(err, ShouldBeNil)
+		cert, _ := m.GetCertificate(nil)
+		names := cert.Leaf.DNSNames
+		ips := cert.Leaf.IPAddresses
+
+		Convey("When it is valid and covers the host", func() {
+			So(renewalReason(cert.Leaf, m.ca, names, ips, time.Now()), ShouldBeEmpty)
+		})
+		Convey("When it expires soon", func() {
+			soon := cert.Leaf.NotAfter.Add(-renewBefore + time.Hour)
+			So(renewalReason(cert.Leaf, m.ca, names, ips, soon), ShouldEqual, "expires soon")
+		})
+		Convey("When the host has a new address", func() {
+			newIP := net.ParseIP("192.0.2.10").To4()
+			So(renewalReason(cert.Leaf, m.ca, names, append(ips, newIP), time.Now()), ShouldEqual, "does not cover 192.0.2.10")
+		})
+		Convey("When it is signed by another CA", func() {
+			other, err := NewManager(filepath.Join(t.TempDir(), "homegym.crt"), filepath.Join(t.TempDir(), "homegym.key"), nil)
+			So(err, ShouldBeNil)
+			So(renewalReason(cert.Leaf, other.ca, names, ips, time.Now()), ShouldEqual, "not signed by the CA")
+		})
+	})
+}
@@ -0,0 +1,327 @@
+// Package config loads the configuration of the Home Gym server.
+// The configuration is read from a YAML file and values are overridden by environment variables.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/workoutlog"
+	"gopkg.in/yaml.v3"
+)
+
+// A Config is the configuration of the server.
+// Durations are expressed as Go durations such as 30m or 24h.
+// Times of day are expressed as hh:mm:ss.
+type Config struct {
+	DBPath   string       `yaml:"dbPath"`
+	Listen   string       `yaml:"listen"` // host:port
+	TLS      TLSConfig    `yaml:"tls"`
+	Auth     AuthConfig   `yaml:"auth"`
+	Backup   BackupConfig `yaml:"backup"`
+	Load     LoadConfig   `yaml:"load"`
+	LogLevel string       `yaml:"logLevel"` // debug, info, warn, or error
+	Signup   string       `yaml:"signup"`   // open, closed, or invite
+}
+
+// A TLSConfig configures serving HTTPS.
+// When TLS is disabled the server uses HTTP and cookies are not secure.
+// When Auto is true the server creates a local CA and a certificate that it signs, saves them
+// to the Cert and Key files, and renews the certificate before it expires.
+// The certificate covers localhost, the mDNS name and IP addresses of the host, and Names.
+type TLSConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Cert    string   `yaml:"cert"`
+	Key     string   `yaml:"key"`
+	Auto    bool     `yaml:"auto"`
+	Names   []string `yaml:"names"`
+}
+
+// An AuthConfig configures authentication.
+type AuthConfig struct {
+	TokenTTL          time.Duration `yaml:"tokenTTL"`
+	SessionTTL        time.Duration `yaml:"sessionTTL"`
+	KeyRotationTime   string        `yaml:"keyRotationTime"` // UTC
+	MinPasswordLength int           `yaml:"minPasswordLength"`
+}
+
+// A BackupConfig configures database backups.
+// A relative directory is relative to the database path. The default is a directory next to the database directory.
+// MaxUpload is the size of the largest backup file that can be uploaded, in MiB.
+type BackupConfig struct {
+	Dir          string              `yaml:"dir"`
+	Time         string              `yaml:"time"` // local time
+	FullInterval time.Duration       `yaml:"fullInterval"`
+	Retention    dal.RetentionPolicy `yaml:"retention"`
+	MaxUpload    int                 `yaml:"maxUpload"`
+}
+
+// A LoadConfig configures the training load model.
+// Warnings are issued when the acute:chronic workload ratio is below ACWRLow or above ACWRHigh.
+type LoadConfig struct {
+	ACWRLow  float64 `yaml:"acwrLow"`
+	ACWRHigh float64 `yaml:"acwrHigh"`
+}
+
+// Environment variables that override configuration values.
+const (
+	ConfigFileEnv        = "HOMEGYM_CONFIG"
+	DBPathEnv            = "HOMEGYM_DB_PATH"
+	ListenEnv            = "HOMEGYM_LISTEN"
+	TLSEnv               = "HOMEGYM_TLS"
+	TLSCertEnv           = "HOMEGYM_TLS_CERT"
+	TLSKeyEnv            = "HOMEGYM_TLS_KEY"
+	TLSAutoEnv           = "HOMEGYM_TLS_AUTO"
+	TLSNamesEnv          = "HOMEGYM_TLS_NAMES"
+	TokenTTLEnv          = "HOMEGYM_TOKEN_TTL"
+	SessionTTLEnv        = "HOMEGYM_SESSION_TTL"
+	KeyRotationTimeEnv   = "HOMEGYM_KEY_ROTATION_TIME"
+	MinPasswordLengthEnv = "HOMEGYM_MIN_PASSWORD_LENGTH"
+	BackupDirEnv         = "HOMEGYM_BACKUP_DIR"
+	BackupTimeEnv        = "HOMEGYM_BACKUP_TIME"
+	BackupFullEnv        = "HOMEGYM_BACKUP_FULL_INTERVAL"
+	BackupDailyEnv       = "HOMEGYM_BACKUP_KEEP_DAILY"
+	BackupWeeklyEnv      = "HOMEGYM_BACKUP_KEEP_WEEKLY"
+	BackupMonthlyEnv     = "HOMEGYM_BACKUP_KEEP_MONTHLY"
+	BackupMaxUploadEnv   = "HOMEGYM_BACKUP_MAX_UPLOAD"
+	ACWRLowEnv           = "HOMEGYM_ACWR_LOW"
+	ACWRHighEnv          = "HOMEGYM_ACWR_HIGH"
+	LogLevelEnv          = "HOMEGYM_LOG_LEVEL"
+	SignupEnv            = "HOMEGYM_SIGNUP"
+)
+
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		Listen: "0.0.0.0:443",
+		TLS: TLSConfig{
+			Enabled: true,
+			Cert:    "../homegym.crt",
+			Key:     "../homegym.key",
+		},
+		Auth: AuthConfig{
+			TokenTTL:          30 * time.Minute,
+			SessionTTL:        24 * time.Hour,
+			KeyRotationTime:   "10:00:00",
+			MinPasswordLength: 10,
+		},
+		Backup: BackupConfig{
+			Dir:          filepath.Join("..", "backups"),
+			Time:         "00:00:00",
+			FullInterval: 7 * 24 * time.Hour,
+			Retention:    dal.DefaultRetention,
+			MaxUpload:    1024,
+		},
+		Load: LoadConfig{
+			ACWRLow:  workoutlog.Load.ACWRLow,
+			ACWRHigh: workoutlog.Load.ACWRHigh,
+		},
+		LogLevel: "info",
+		Signup:   string(workoutlog.SignupOpen),
+	}
+}
+
+// Load reads the configuration file at path over the default configuration
+// and then applies the environment variables.
+// The file is not read when path is empty.
+// The configuration is not validated.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, path, err.Error())
+		}
+		slog.Info("read config file", "path", path)
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// applyEnv overrides configuration values with the environment variables that are set.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	setString := func(v *string) func(string) error {
+		return func(s string) error {
+			*v = s
+			return nil
+		}
+	}
+	setInt := func(v *int) func(string) error {
+		return func(s string) error {
+			i, err := strconv.Atoi(s)
+			*v = i
+			return err
+		}
+	}
+	setFloat := func(v *float64) func(string) error {
+		return func(s string) error {
+			f, err := strconv.ParseFloat(s, 64)
+			*v = f
+			return err
+		}
+	}
+	setBool := func(v *bool) func(string) error {
+		return func(s string) error {
+			b, err := strconv.ParseBool(s)
+			*v = b
+			return err
+		}
+	}
+	setList := func(v *[]string) func(string) error {
+		return func(s string) error {
+			*v = strings.Split(s, ",")
+			return nil
+		}
+	}
+	setDuration := func(v *time.Duration) func(string) error {
+		return func(s string) error {
+			d, err := time.ParseDuration(s)
+			*v = d
+			return err
+		}
+	}
+
+	overrides := []struct {
+		env string
+		set func(string) error
+	}{
+		{DBPathEnv, setString(&c.DBPath)},
+		{ListenEnv, setString(&c.Listen)},
+		{TLSEnv, setBool(&c.TLS.Enabled)},
+		{TLSCertEnv, setString(&c.TLS.Cert)},
+		{TLSKeyEnv, setString(&c.TLS.Key)},
+		{TLSAutoEnv, setBool(&c.TLS.Auto)},
+		{TLSNamesEnv, setList(&c.TLS.Names)},
+		{TokenTTLEnv, setDuration(&c.Auth.TokenTTL)},
+		{SessionTTLEnv, setDuration(&c.Auth.SessionTTL)},
+		{KeyRotationTimeEnv, setString(&c.Auth.KeyRotationTime)},
+		{MinPasswordLengthEnv, setInt(&c.Auth.MinPasswordLength)},
+		{BackupDirEnv, setString(&c.Backup.Dir)},
+		{BackupTimeEnv, setString(&c.Backup.Time)},
+		{BackupFullEnv, setDuration(&c.Backup.FullInterval)},
+		{BackupDailyEnv, setInt(&c.Backup.Retention.Daily)},
+		{BackupWeeklyEnv, setInt(&c.Backup.Retention.Weekly)},
+		{BackupMonthlyEnv, setInt(&c.Backup.Retention.Monthly)},
+		{BackupMaxUploadEnv, setInt(&c.Backup.MaxUpload)},
+		{ACWRLowEnv, setFloat(&c.Load.ACWRLow)},
+		{ACWRHighEnv, setFloat(&c.Load.ACWRHigh)},
+		{LogLevelEnv, setString(&c.LogLevel)},
+		{SignupEnv, setString(&c.Signup)},
+	}
+
+	for _, o := range overrides {
+		value, ok := lookup(o.env)
+		if !ok || value == "" {
+			continue
+		}
+		if err := o.set(value); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidConfig, o.env, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error that describes the first invalid value of the configuration.
+func (c *Config) Validate() error {
+	invalid := func(format string, a ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, fmt.Sprintf(format, a...))
+	}
+
+	if c.DBPath == "" {
+		return invalid("database path not configured")
+	}
+	if c.Listen == "" {
+		return invalid("listen address not configured")
+	}
+	if c.TLS.Enabled && (c.TLS.Cert == "" || c.TLS.Key == "") {
+		return invalid("tls cert and key are required when tls is enabled")
+	}
+	if c.TLS.Auto && !c.TLS.Enabled {
+		return invalid("tls must be enabled to provision certificates")
+	}
+	if c.Auth.TokenTTL < time.Minute {
+		return invalid("token TTL must be at least 1m: %s", c.Auth.TokenTTL)
+	}
+	if c.Auth.SessionTTL < c.Auth.TokenTTL {
+		return invalid("session TTL must be at least the token TTL: %s", c.Auth.SessionTTL)
+	}
+	if _, err := ParseTimeOfDay(c.Auth.KeyRotationTime); err != nil {
+		return invalid("key rotation time: %s", err.Error())
+	}
+	if c.Auth.MinPasswordLength < auth.LowestMinPasswordLength {
+		return invalid("minimum password length must be at least %d: %d", auth.LowestMinPasswordLength, c.Auth.MinPasswordLength)
+	}
+	if c.Backup.Dir == "" {
+		return invalid("backup directory not configured")
+	}
+	if _, err := ParseTimeOfDay(c.Backup.Time); err != nil {
+		return invalid("backup time: %s", err.Error())
+	}
+	if c.Backup.FullInterval <= 0 {
+		return invalid("full backup interval must be positive: %s", c.Backup.FullInterval)
+	}
+	if c.Backup.Retention.Daily < 1 || c.Backup.Retention.Weekly < 0 || c.Backup.Retention.Monthly < 0 {
+		return invalid("backup retention must keep at least 1 daily backup")
+	}
+	if c.Backup.MaxUpload < 1 {
+		return invalid("maximum backup upload size must be at least 1 MiB: %d", c.Backup.MaxUpload)
+	}
+	if c.Load.ACWRLow <= 0 || c.Load.ACWRHigh <= c.Load.ACWRLow {
+		return invalid("ACWR bounds must be positive and the high bound must exceed the low bound: %v, %v", c.Load.ACWRLow, c.Load.ACWRHigh)
+	}
+	if _, err := c.Level(); err != nil {
+		return invalid("log level: %s", err.Error())
+	}
+	if _, err := workoutlog.ParseSignupPolicy(c.Signup); err != nil {
+		return invalid("%s", err.Error())
+	}
+
+	return nil
+}
+
+// Level returns the log level.
+func (c *Config) Level() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+
+	return level, err
+}
+
+var timeOfDayRxp = regexp.MustCompile("^([0-9]{2}):([0-9]{2}):([0-9]{2})$")
+
+// ParseTimeOfDay returns the time since midnight of a time of day in hh:mm:ss format.
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	parts := timeOfDayRxp.FindStringSubmatch(s)
+	if parts == nil {
+		return 0, fmt.Errorf("not in hh:mm:ss format: %s", s)
+	}
+
+	h, _ := strconv.Atoi(parts[1])
+	m, _ := strconv.Atoi(parts[2])
+	sec, _ := strconv.Atoi(parts[3])
+	if h > 23 || m > 59 || sec > 59 {
+		return 0, fmt.Errorf("not a time of day: %s", s)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second, nil
+}
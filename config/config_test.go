@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func validConfig() Config {
+	cfg := Default()
+	cfg.DBPath = "testDB"
+	return cfg
+}
+
+func TestConfig(t *testing.T) {
+	Convey("Given a configuration file", t, func() {
+		path := filepath.Join(t.TempDir(), "homegym.yml")
+		content := `
+dbPath: /var/homegym/db
+listen: 127.0.0.1:8443
+tls:
+  cert: /etc/homegym/homegym.crt
+  key: /etc/homegym/homegym.key
+auth:
+  tokenTTL: 15m
+  minPasswordLength: 12
+backup:
+  time: "02:30:00"
+  retention:
+    daily: 3
+load:
+  acwrHigh: 1.5
+logLevel: debug
+`
+		So(os.WriteFile(path, []byte(content), 0600), ShouldBeNil)
+
+		Convey("When we load the file", func() {
+			cfg, err := Load(path)
+
+			Convey("Then the file values are read over the defaults", func() {
+				So(err, ShouldBeNil)
+				So(cfg.DBPath, ShouldEqual, "/var/homegym/db")
+				So(cfg.Listen, ShouldEqual, "127.0.0.1:8443")
+				So(cfg.TLS.Enabled, ShouldBeTrue)
+				So(cfg.TLS.Cert, ShouldEqual, "/etc/homegym/homegym.crt")
+				So(cfg.Auth.TokenTTL, ShouldEqual, 15*time.Minute)
+				So(cfg.Auth.SessionTTL, ShouldEqual, 24*time.Hour)
+				So(cfg.Auth.MinPasswordLength, ShouldEqual, 12)
+				So(cfg.Backup.Time, ShouldEqual, "02:30:00")
+				So(cfg.Backup.Retention.Daily, ShouldEqual, 3)
+				So(cfg.Backup.Retention.Weekly, ShouldEqual, 4)
+				So(cfg.Load.ACWRLow, ShouldEqual, 0.8)
+				So(cfg.Load.ACWRHigh, ShouldEqual, 1.5)
+				So(cfg.Signup, ShouldEqual, "open")
+				So(cfg.Validate(), ShouldBeNil)
+			})
+
+			Convey("And when environment variables are set", func() {
+				env := map[string]string{
+					ListenEnv:       "0.0.0.0:9000",
+					TLSEnv:          "false",
+					SessionTTLEnv:   "2h",
+					BackupWeeklyEnv: "0",
+					SignupEnv:       "open",
+					TLSNamesEnv:     "gym.lan,gym.example.com",
+					ACWRLowEnv:      "0.7",
+					LogLevelEnv:     "",
+				}
+				err := cfg.applyEnv(func(k string) (string, bool) {
+					v, ok := env[k]
+					return v, ok
+				})
+
+				Convey("Then the environment overrides the file", func() {
+					So(err, ShouldBeNil)
+					So(cfg.Listen, ShouldEqual, "0.0.0.0:9000")
+					So(cfg.TLS.Enabled, ShouldBeFalse)
+					So(cfg.Auth.SessionTTL, ShouldEqual, 2*time.Hour)
+					So(cfg.Backup.Retention.Weekly, ShouldEqual, 0)
+					So(cfg.Load.ACWRLow, ShouldEqual, 0.7)
+					So(cfg.Signup, ShouldEqual, "open")
+					So(cfg.TLS.Names, ShouldResemble, []string{"gym.lan", "gym.example.com"})
+					So(cfg.LogLevel, ShouldEqual, "debug")
+					So(cfg.Validate(), ShouldBeNil)
+				})
+			})
+
+			Convey("And when an environment variable has an invalid value", func() {
+				err := cfg.applyEnv(func(k string) (string, bool) {
+					if k == TokenTTLEnv {
+						return "soon", true
+					}
+					return "", false
+				})
+
+				Convey("Then an error is returned", func() {
+					So(errors.Is(err, ErrInvalidConfig), ShouldBeTrue)
+				})
+			})
+		})
+	})
+
+	Convey("When we load a file that is not YAML", t, func() {
+		path := filepath.Join(t.TempDir(), "homegym.yml")
+		So(os.WriteFile(path, []byte("listen: [\n"), 0600), ShouldBeNil)
+		_, err := Load(path)
+
+		So(errors.Is(err, ErrInvalidConfig), ShouldBeTrue)
+	})
+
+	Convey("When we load a file that does not exist", t, func() {
+		_, err := Load(filepath.Join(t.TempDir(), "missing.yml"))
+
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given an invalid configuration", t, func() {
+		invalid := map[string]func(*Config){
+			"no database path":     func(c *Config) { c.DBPath = "" },
+			"no listen address":    func(c *Config) { c.Listen = "" },
+			"no tls cert":          func(c *Config) { c.TLS.Cert = "" },
+			"auto without tls":     func(c *Config) { c.TLS.Enabled, c.TLS.Auto = false, true },
+			"short token ttl":      func(c *Config) { c.Auth.TokenTTL = time.Second },
+			"short session ttl":    func(c *Config) { c.Auth.SessionTTL = time.Minute },
+			"bad rotation time":    func(c *Config) { c.Auth.KeyRotationTime = "10:00" },
+			"short password":       func(c *Config) { c.Auth.MinPasswordLength = 4 },
+			"no backup dir":        func(c *Config) { c.Backup.Dir = "" },
+			"bad backup time":      func(c *Config) { c.Backup.Time = "24:00:00" },
+			"no full interval":     func(c *Config) { c.Backup.FullInterval = 0 },
+			"no daily backups":     func(c *Config) { c.Backup.Retention.Daily = 0 },
+			"no backup uploads":    func(c *Config) { c.Backup.MaxUpload = 0 },
+			"unknown log level":    func(c *Config) { c.LogLevel = "loud" },
+			"inverted acwr bounds": func(c *Config) { c.Load.ACWRLow, c.Load.ACWRHigh = 1.5, 0.8 },
+			"unknown signup mode":  func(c *Config) { c.Signup = "sometimes" },
+		}
+
+		for name, change := range invalid {
+			Convey("When the configuration has "+name, func() {
+				cfg := validConfig()
+				change(&cfg)
+
+				So(errors.Is(cfg.Validate(), ErrInvalidConfig), ShouldBeTrue)
+			})
+		}
+	})
+
+	Convey("When we disable TLS without a cert", t, func() {
+		cfg := validConfig()
+		cfg.TLS = TLSConfig{Enabled: false}
+
+		So(cfg.Validate(), ShouldBeNil)
+	})
+
+	Convey("When we parse a time of day", t, func() {
+		d, err := ParseTimeOfDay("13:05:09")
+
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 13*time.Hour+5*time.Minute+9*time.Second)
+	})
+}
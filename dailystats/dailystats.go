@@ -64,7 +64,7 @@ func (dsu DailyStatsUtil) AddStats(userID string, date int64, statsJSON []byte)
 		return ErrInvalidStats{Message: "could not unmarshal stats"}
 	}
 
-	if err := stats.validate(); err != nil {
+	if err := stats.Validate(); err != nil {
 		slog.Debug(err.Error())
 		return ErrInvalidStats{Message: err.Error()}
 	}
@@ -111,7 +111,8 @@ func (dsu DailyStatsUtil) GetBioStatsPage(userID string, startDate, endDate int6
 	return statsJSON, nil
 }
 
-func (ds DailyStats) validate() error {
+// Validate ensures that a set of daily stats has a date and at least one valid stat.
+func (ds DailyStats) Validate() error {
 	if ds.Date == 0 {
 		return fmt.Errorf("date is a required field")
 	}
@@ -0,0 +1,151 @@
+package dal
+
+import (
+	"encoding/json"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const (
+	apiTokenKey = "apitoken"
+)
+
+// An APITokenRecord describes a personal API token of a user.
+// The token itself is not stored; records are keyed by the hash of the token.
+// Times are in seconds since the epoch. Expires is 0 for tokens that do not expire.
+type APITokenRecord struct {
+	ID       string
+	UserID   string
+	Name     string
+	Scopes   []string
+	Created  int64
+	Expires  int64
+	LastUsed int64
+}
+
+// AddAPIToken stores a personal API token by the hash of the token.
+// The hash is also stored in the token index of the user so that the tokens of a user can be listed.
+func (c *DBClient) AddAPIToken(hash string, token APITokenRecord) error {
+	value, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal api token: %w", err)
+	}
+
+	entries := []*badger.Entry{
+		badger.NewEntry(key([]string{apiTokenKey, hash}), value),
+		badger.NewEntry(key([]string{userKey, token.UserID, apiTokenKey, token.ID}), []byte(hash)),
+	}
+	if err := writeUpdates(c, entries); err != nil {
+		return fmt.Errorf("failed to store api token: %w", err)
+	}
+
+	return nil
+}
+
+// GetAPIToken returns the personal API token with a hash.
+// Returns nil when there is no such token.
+func (c *DBClient) GetAPIToken(hash string) (*APITokenRecord, error) {
+	entry, err := readItem(c, key([]string{apiTokenKey, hash}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api token: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	token := APITokenRecord{}
+	if err := json.Unmarshal(entry.Value, &token); err != nil {
+		return nil, fmt.Errorf("failed to read api token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// GetUserAPITokens returns the personal API tokens of a user.
+func (c *DBClient) GetUserAPITokens(userID string) ([]APITokenRecord, error) {
+	hashes, err := userAPITokenHashes(c, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []APITokenRecord{}
+	for _, hash := range hashes {
+		token, err := c.GetAPIToken(hash)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			tokens = append(tokens, *token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// TouchAPIToken records the time that a personal API token was used.
+func (c *DBClient) TouchAPIToken(hash string, lastUsed int64) error {
+	tokenKey := key([]string{apiTokenKey, hash})
+
+	err := c.update(func(txn *badger.Txn) error {
+		item, err := txn.Get(tokenKey)
+		if err != nil {
+			return err
+		}
+
+		token := APITokenRecord{}
+		if err := item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &token)
+		}); err != nil {
+			return err
+		}
+		token.LastUsed = lastUsed
+
+		value, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+
+		return txn.Set(tokenKey, value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update api token: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAPIToken deletes a personal API token of a user.
+func (c *DBClient) DeleteAPIToken(userID, tokenID string) error {
+	indexKey := key([]string{userKey, userID, apiTokenKey, tokenID})
+
+	entry, err := readItem(c, indexKey)
+	if err != nil {
+		return fmt.Errorf("failed to read api token: %w", err)
+	}
+	if entry == nil {
+		return nil
+	}
+
+	keys := [][]byte{key([]string{apiTokenKey, string(entry.Value)}), indexKey}
+	if err := deleteItems(c, keys); err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+
+	return nil
+}
+
+// userAPITokenHashes returns the hashes of the personal API tokens of a user.
+func userAPITokenHashes(c *DBClient, userID string) ([]string, error) {
+	entries, err := readKeyPrefix(c, keyPrefix([]string{userKey, userID, apiTokenKey}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api tokens: %w", err)
+	}
+
+	hashes := []string{}
+	for _, e := range entries {
+		hashes = append(hashes, string(e.Value))
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,72 @@
+package dal
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+
+	"testing"
+)
+
+func TestAPITokenDal(t *testing.T) {
+	testHash := "0a1b2c3d"
+	testToken := APITokenRecord{
+		ID:      "testTokenID",
+		UserID:  testUserID,
+		Name:    "scale",
+		Scopes:  []string{"dailystats:write"},
+		Created: 1720181149,
+	}
+
+	defer cleanup()
+	Convey("Given a dal client", t, func() {
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+		defer client.Destroy()
+
+		Convey("When we get a token that does not exist", func() {
+			token, err := client.GetAPIToken(testHash)
+
+			So(err, ShouldBeNil)
+			So(token, ShouldBeNil)
+		})
+
+		Convey("When we add a token", func() {
+			So(client.AddAPIToken(testHash, testToken), ShouldBeNil)
+
+			Convey("Then the token is returned by hash and listed for the user", func() {
+				token, err := client.GetAPIToken(testHash)
+				So(err, ShouldBeNil)
+				So(*token, ShouldResemble, testToken)
+
+				tokens, err := client.GetUserAPITokens(testUserID)
+				So(err, ShouldBeNil)
+				So(tokens, ShouldResemble, []APITokenRecord{testToken})
+			})
+		})
+
+		Convey("When we record the use of a token", func() {
+			So(client.TouchAPIToken(testHash, 1720181200), ShouldBeNil)
+
+			Convey("Then the last used time is updated", func() {
+				token, err := client.GetAPIToken(testHash)
+				So(err, ShouldBeNil)
+				So(token.LastUsed, ShouldEqual, 1720181200)
+			})
+		})
+
+		Convey("When we delete a token", func() {
+			So(client.DeleteAPIToken(testUserID, testToken.ID), ShouldBeNil)
+
+			Convey("Then the token is not returned", func() {
+				token, err := client.GetAPIToken(testHash)
+				So(err, ShouldBeNil)
+				So(token, ShouldBeNil)
+
+				tokens, err := client.GetUserAPITokens(testUserID)
+				So(err, ShouldBeNil)
+				So(tokens, ShouldBeEmpty)
+			})
+		})
+	})
+}
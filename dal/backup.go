@@ -0,0 +1,551 @@
+package dal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const (
+	manifestFile     = "manifest.json"
+	backupIDFormat   = "20060102-150405.000"
+	backupFileSuffix = ".bak"
+)
+
+var ErrBackupNotFound = errors.New("backup not found")
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// A RetentionPolicy sets the number of daily, weekly, and monthly backups to keep.
+// The most recent backup of each day, week, and month is kept.
+type RetentionPolicy struct {
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+}
+
+// DefaultRetention keeps backups for the last 7 days, 4 weeks, and 12 months.
+var DefaultRetention = RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 12}
+
+// A Backup describes a backup in the catalog.
+// Full backups contain all items. Incremental backups contain the item versions after Since up to Version
+// and can only be restored along with the preceding backups back to the last full backup.
+// Uploaded backups are full backups that were not saved from this database.
+// Timeline identifies the restore of the database that the backup was saved after.
+type Backup struct {
+	ID       string `json:"id"`
+	Time     int64  `json:"time"`
+	Full     bool   `json:"full"`
+	Since    uint64 `json:"since"`
+	Version  uint64 `json:"version"`
+	Size     int64  `json:"size"`
+	Uploaded bool   `json:"uploaded,omitempty"`
+	Timeline int    `json:"timeline"`
+}
+
+// A restored database keeps the item versions of the backup, so the versions of later writes can be lower
+// than the versions of backups that were saved before the restore. Each restore starts a new timeline and
+// incremental backups only follow backups of the same timeline.
+type manifest struct {
+	Backups  []Backup `json:"backups"` // oldest first
+	Timeline int      `json:"timeline"`
+}
+
+// A BackupManager maintains a catalog of timestamped backups of the database.
+// Backups are incremental except when a full backup is due.
+// The catalog is described by a manifest file in the backup directory.
+type BackupManager struct {
+	client       *DBClient
+	dir          string
+	Retention    RetentionPolicy
+	FullInterval time.Duration // time between full backups
+	mu           sync.Mutex
+}
+
+// NewBackupManager returns a BackupManager that saves backups of the database in a directory.
+// The directory is created if it does not exist.
+func NewBackupManager(client *DBClient, dir string, retention RetentionPolicy) (*BackupManager, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	return &BackupManager{
+		client:       client,
+		dir:          dir,
+		Retention:    retention,
+		FullInterval: 7 * 24 * time.Hour,
+	}, nil
+}
+
+// Backups returns the backups in the catalog, oldest first.
+func (bm *BackupManager) Backups() ([]Backup, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	m, err := bm.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Backups, nil
+}
+
+// Backup saves a backup of the database and removes the backups that the retention policy does not keep.
+// A full backup is saved when there are no backups, the last backup was uploaded or saved before a restore,
+// or the last full backup is older than FullInterval.
+func (bm *BackupManager) Backup() (*Backup, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	m, err := bm.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	b := Backup{ID: now.UTC().Format(backupIDFormat), Time: now.Unix(), Timeline: m.Timeline}
+
+	lastFull := -1
+	for i, backup := range m.Backups {
+		if backup.Full {
+			lastFull = i
+		}
+	}
+	if lastFull < 0 {
+		b.Full = true
+	} else {
+		last := m.Backups[len(m.Backups)-1]
+		b.Full = last.Uploaded || last.Timeline != m.Timeline || now.Sub(time.Unix(m.Backups[lastFull].Time, 0)) >= bm.FullInterval
+		if !b.Full {
+			b.Since = last.Version
+		}
+	}
+
+	file, err := os.Create(bm.backupPath(b.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer file.Close()
+
+	version, err := bm.client.backup(file, b.Since)
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	// nothing changed since the last backup
+	b.Version = max(version, b.Since)
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+	b.Size = info.Size()
+
+	m.Backups = append(m.Backups, b)
+
+	if err := bm.prune(m); err != nil {
+		return nil, err
+	}
+
+	slog.Info("backup complete", "id", b.ID, "full", b.Full)
+
+	return &b, nil
+}
+
+// Add saves an uploaded backup file in the catalog.
+// The file must be a full backup that can be loaded into a database.
+func (bm *BackupManager) Add(file io.Reader) (*Backup, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	m, err := bm.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	b := Backup{ID: now.UTC().Format(backupIDFormat), Time: now.Unix(), Full: true, Uploaded: true, Timeline: m.Timeline}
+	backupPath := bm.backupPath(b.ID)
+
+	dst, err := os.Create(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer dst.Close()
+
+	b.Size, err = io.Copy(dst, file)
+	if err != nil {
+		os.Remove(backupPath)
+		return nil, fmt.Errorf("failed to save backup file: %w", err)
+	}
+
+	if err := validateBackup(bm.dir, backupPath); err != nil {
+		os.Remove(backupPath)
+		return nil, err
+	}
+
+	m.Backups = append(m.Backups, b)
+
+	if err := bm.prune(m); err != nil {
+		return nil, err
+	}
+
+	slog.Info("backup uploaded", "id", b.ID)
+
+	return &b, nil
+}
+
+// Open opens the file of a backup in the catalog for reading.
+func (bm *BackupManager) Open(backupID string) (*os.File, error) {
+	backups, err := bm.Backups()
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.ContainsFunc(backups, func(b Backup) bool { return b.ID == backupID }) {
+		return nil, ErrBackupNotFound
+	}
+
+	return os.Open(bm.backupPath(backupID))
+}
+
+// PointInTime returns the most recent backup that was saved at or before a time.
+func (bm *BackupManager) PointInTime(t int64) (*Backup, error) {
+	backups, err := bm.Backups()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Time <= t {
+			return &backups[i], nil
+		}
+	}
+
+	return nil, ErrBackupNotFound
+}
+
+// Build creates a database in a directory from a backup and the backups that it depends on.
+// The directory must not contain a database.
+func (bm *BackupManager) Build(backupID, dir string) error {
+	backups, err := bm.Backups()
+	if err != nil {
+		return err
+	}
+
+	chain, err := backupChain(backups, backupID)
+	if err != nil {
+		return err
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	for _, b := range chain {
+		if err := loadBackup(db, bm.backupPath(b.ID)); err != nil {
+			return fmt.Errorf("failed to load backup %s: %w", b.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Restore replaces the database with a backup.
+// The backup is loaded into a fresh database next to the database directory,
+// which is migrated and validated before it is swapped in. The fresh database is removed when the restore fails.
+// Database reads and writes wait until the swap is complete.
+// The restore starts a new timeline so that the next backup is a full backup.
+func (bm *BackupManager) Restore(backupID string) (*RestoreSummary, error) {
+	tmpDir, err := bm.client.tempDir("restore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := bm.Build(backupID, tmpDir); err != nil {
+		return nil, err
+	}
+
+	summary, err := validateRestore(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	summary.BackupID = backupID
+
+	if err := bm.client.swap(tmpDir); err != nil {
+		return nil, fmt.Errorf("failed to restore backup %s: %w", backupID, err)
+	}
+
+	if err := bm.newTimeline(); err != nil {
+		return nil, err
+	}
+
+	slog.Info("database restored", "backup", backupID, "users", summary.Users, "events", summary.Events)
+
+	return summary, nil
+}
+
+// ScheduleDaily saves a backup every day at a local time of day, expressed as the time since midnight.
+func (bm *BackupManager) ScheduleDaily(at time.Duration) {
+	h, m, s := time.Now().Clock()
+	secondsUntil := int(at.Seconds()) - h*60*60 - m*60 - s
+	if secondsUntil <= 0 {
+		secondsUntil += 24 * 60 * 60
+	}
+
+	slog.Info("Scheduling DB backup in", fmt.Sprint(secondsUntil), "seconds")
+
+	time.AfterFunc(time.Second*time.Duration(secondsUntil), func() {
+		if _, err := bm.Backup(); err != nil {
+			slog.Error("backup failed", "error", err.Error())
+		}
+
+		bm.ScheduleDaily(at)
+	})
+}
+
+// newTimeline records that the database was restored.
+func (bm *BackupManager) newTimeline() error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	m, err := bm.readManifest()
+	if err != nil {
+		return err
+	}
+	m.Timeline++
+
+	return bm.writeManifest(m)
+}
+
+func (bm *BackupManager) backupPath(backupID string) string {
+	return filepath.Join(bm.dir, backupID+backupFileSuffix)
+}
+
+func (bm *BackupManager) readManifest() (*manifest, error) {
+	m := manifest{Backups: []Backup{}}
+
+	content, err := os.ReadFile(filepath.Join(bm.dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+
+	return &m, nil
+}
+
+// writeManifest replaces the manifest file so that a failed write does not corrupt the catalog.
+func (bm *BackupManager) writeManifest(m *manifest) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+
+	tmpPath := filepath.Join(bm.dir, manifestFile+".tmp")
+	if err := os.WriteFile(tmpPath, content, 0640); err != nil {
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(bm.dir, manifestFile)); err != nil {
+		return fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+
+	return nil
+}
+
+// prune removes the backups that are not kept by the retention policy and writes the manifest.
+// Backups that a kept incremental backup depends on are also kept.
+func (bm *BackupManager) prune(m *manifest) error {
+	keep := bm.Retention.retained(m.Backups)
+
+	kept := []Backup{}
+	removed := []Backup{}
+
+	// a chain is a full backup and the incremental backups that follow it
+	for start := 0; start < len(m.Backups); {
+		end := start + 1
+		for end < len(m.Backups) && !m.Backups[end].Full {
+			end++
+		}
+
+		last := -1
+		for i := start; i < end; i++ {
+			if keep[m.Backups[i].ID] {
+				last = i
+			}
+		}
+
+		if last < 0 {
+			removed = append(removed, m.Backups[start:end]...)
+		} else {
+			kept = append(kept, m.Backups[start:last+1]...)
+			removed = append(removed, m.Backups[last+1:end]...)
+		}
+
+		start = end
+	}
+
+	m.Backups = kept
+	if err := bm.writeManifest(m); err != nil {
+		return err
+	}
+
+	for _, b := range removed {
+		if err := os.Remove(bm.backupPath(b.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("failed to remove backup", "id", b.ID, "error", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// retained returns the IDs of the backups that the policy keeps.
+// The most recent backup and uploaded backups are always kept.
+func (rp RetentionPolicy) retained(backups []Backup) map[string]bool {
+	keep := map[string]bool{}
+	if len(backups) == 0 {
+		return keep
+	}
+	keep[backups[len(backups)-1].ID] = true
+
+	saved := []Backup{}
+	for _, b := range backups {
+		if b.Uploaded {
+			keep[b.ID] = true
+			continue
+		}
+		saved = append(saved, b)
+	}
+
+	periods := []struct {
+		count  int
+		period func(time.Time) string
+	}{
+		{rp.Daily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{rp.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{rp.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for _, p := range periods {
+		seen := map[string]bool{}
+		for i := len(saved) - 1; i >= 0 && len(seen) < p.count; i-- {
+			period := p.period(time.Unix(saved[i].Time, 0))
+			if seen[period] {
+				continue
+			}
+			seen[period] = true
+			keep[saved[i].ID] = true
+		}
+	}
+
+	return keep
+}
+
+// backupChain returns the backups that are loaded, in order, to restore a backup.
+// The backups of a chain are from the same timeline.
+func backupChain(backups []Backup, backupID string) ([]Backup, error) {
+	idx := slices.IndexFunc(backups, func(b Backup) bool { return b.ID == backupID })
+	if idx < 0 {
+		return nil, ErrBackupNotFound
+	}
+
+	start := idx
+	for start >= 0 && !backups[start].Full && backups[start].Timeline == backups[idx].Timeline {
+		start--
+	}
+	if start < 0 || backups[start].Timeline != backups[idx].Timeline {
+		return nil, fmt.Errorf("no full backup precedes backup %s", backupID)
+	}
+
+	return backups[start : idx+1], nil
+}
+
+// validateBackup loads a backup file into a temporary database.
+// The sizes of the lists in the file are checked first because Load allocates the size that it reads.
+func validateBackup(dir, backupPath string) error {
+	if err := checkListSizes(backupPath); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
+
+	tmpDir, err := os.MkdirTemp(dir, "validate-")
+	if err != nil {
+		return fmt.Errorf("failed to create validation directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := badger.Open(badger.DefaultOptions(tmpDir))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if err := loadBackup(db, backupPath); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
+
+	return nil
+}
+
+// checkListSizes checks that each size prefix of a backup file fits in the rest of the file.
+func checkListSizes(backupPath string) error {
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	for offset := int64(0); offset < info.Size(); {
+		var size uint64
+		if err := binary.Read(file, binary.LittleEndian, &size); err != nil {
+			return err
+		}
+		offset += 8
+
+		if size > uint64(info.Size()-offset) {
+			return errors.New("list size exceeds file size")
+		}
+		if offset, err = file.Seek(int64(size), io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadBackup(db *badger.DB, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return db.Load(bufio.NewReader(file), 256)
+}
@@ -0,0 +1,300 @@
+package dal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackups(t *testing.T) {
+	defer cleanup()
+	Convey("Given a database and a backup manager", t, func() {
+		cleanup()
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+		defer client.Destroy()
+
+		bm, err := NewBackupManager(client, filepath.Join(testFolder, "backups"), DefaultRetention)
+		So(err, ShouldBeNil)
+
+		// restore directories are created next to the database and removed afterwards
+		assertNoRestoreDirs := func() {
+			for _, dir := range []string{testPath, filepath.Dir(filepath.Clean(testPath))} {
+				entries, err := os.ReadDir(dir)
+				So(err, ShouldBeNil)
+				for _, entry := range entries {
+					So(entry.Name(), ShouldNotContainSubstring, "restore-")
+					So(entry.Name(), ShouldNotContainSubstring, "replaced-")
+				}
+			}
+		}
+
+		// backup IDs have millisecond precision
+		backup := func() *Backup {
+			time.Sleep(2 * time.Millisecond)
+			b, err := bm.Backup()
+			So(err, ShouldBeNil)
+			return b
+		}
+
+		keyOne := []byte("backuptest:one")
+		keyTwo := []byte("backuptest:two")
+
+		err = writeUpdates(client, []*badger.Entry{badger.NewEntry(keyOne, []byte("1"))})
+		So(err, ShouldBeNil)
+
+		Convey("When we create a directory for a restore", func() {
+			dir, err := client.tempDir("restore-")
+			So(err, ShouldBeNil)
+			defer os.Remove(dir)
+
+			Convey("Then it is outside of the database directory", func() {
+				So(filepath.Dir(dir), ShouldEqual, filepath.Dir(filepath.Clean(testPath)))
+			})
+		})
+
+		Convey("When we restore a backup of a database that has no users", func() {
+			empty := backup()
+
+			_, err := bm.Restore(empty.ID)
+
+			Convey("Then the backup is not valid and the database is not changed", func() {
+				So(errors.Is(err, ErrInvalidBackup), ShouldBeTrue)
+				assertNoRestoreDirs()
+
+				entry, err := readItem(client, keyOne)
+				So(err, ShouldBeNil)
+				So(string(entry.Value), ShouldEqual, "1")
+			})
+		})
+
+		err = client.NewUser(testUser(testUserID))
+		So(err, ShouldBeNil)
+		err = client.AddEvent(testUserID, "event-id", testActivityID, 1000, testEvent, map[int]string{}, map[int][]byte{})
+		So(err, ShouldBeNil)
+
+		Convey("When we save backups", func() {
+			full := backup()
+
+			err = writeUpdates(client, []*badger.Entry{badger.NewEntry(keyTwo, []byte("2"))})
+			So(err, ShouldBeNil)
+			incremental := backup()
+			unchanged := backup()
+
+			Convey("Then the first backup is full and the rest are incremental", func() {
+				So(full.Full, ShouldBeTrue)
+				So(incremental.Full, ShouldBeFalse)
+				So(incremental.Since, ShouldEqual, full.Version)
+				So(incremental.Version, ShouldBeGreaterThan, full.Version)
+				So(unchanged.Full, ShouldBeFalse)
+				So(unchanged.Version, ShouldEqual, incremental.Version)
+
+				backups, err := bm.Backups()
+				So(err, ShouldBeNil)
+				So(len(backups), ShouldEqual, 3)
+				So(backups[0].ID, ShouldEqual, full.ID)
+				So(backups[0].Size, ShouldBeGreaterThan, 0)
+			})
+
+			Convey("Then a full backup is saved when the last full backup is too old", func() {
+				bm.FullInterval = 0
+				So(backup().Full, ShouldBeTrue)
+			})
+
+			Convey("Then we can find the backup of a point in time", func() {
+				b, err := bm.PointInTime(time.Now().Unix())
+				So(err, ShouldBeNil)
+				So(b.ID, ShouldEqual, unchanged.ID)
+
+				_, err = bm.PointInTime(full.Time - 1)
+				So(err, ShouldEqual, ErrBackupNotFound)
+			})
+
+			Convey("Then we can restore the database to a backup", func() {
+				err := deleteItems(client, [][]byte{keyOne})
+				So(err, ShouldBeNil)
+				err = writeUpdates(client, []*badger.Entry{badger.NewEntry(keyTwo, []byte("changed"))})
+				So(err, ShouldBeNil)
+
+				summary, err := bm.Restore(full.ID)
+				So(err, ShouldBeNil)
+				So(*summary, ShouldResemble, RestoreSummary{BackupID: full.ID, Users: 1, Events: 1})
+
+				assertNoRestoreDirs()
+
+				entry, err := readItem(client, keyOne)
+				So(err, ShouldBeNil)
+				So(string(entry.Value), ShouldEqual, "1")
+
+				entry, err = readItem(client, keyTwo)
+				So(err, ShouldBeNil)
+				So(entry, ShouldBeNil)
+
+				_, err = bm.Restore(incremental.ID)
+				So(err, ShouldBeNil)
+
+				entry, err = readItem(client, keyTwo)
+				So(err, ShouldBeNil)
+				So(string(entry.Value), ShouldEqual, "2")
+			})
+
+			Convey("Then the writes after a restore are included in the next backup", func() {
+				_, err := bm.Restore(full.ID)
+				So(err, ShouldBeNil)
+
+				err = writeUpdates(client, []*badger.Entry{badger.NewEntry(keyTwo, []byte("3"))})
+				So(err, ShouldBeNil)
+				afterRestore := backup()
+				So(afterRestore.Full, ShouldBeTrue)
+				So(afterRestore.Timeline, ShouldEqual, incremental.Timeline+1)
+
+				err = writeUpdates(client, []*badger.Entry{badger.NewEntry(keyOne, []byte("4"))})
+				So(err, ShouldBeNil)
+				next := backup()
+				So(next.Full, ShouldBeFalse)
+				So(next.Since, ShouldEqual, afterRestore.Version)
+
+				err = writeUpdates(client, []*badger.Entry{badger.NewEntry(keyOne, []byte("5")), badger.NewEntry(keyTwo, []byte("5"))})
+				So(err, ShouldBeNil)
+
+				_, err = bm.Restore(next.ID)
+				So(err, ShouldBeNil)
+
+				entry, err := readItem(client, keyOne)
+				So(err, ShouldBeNil)
+				So(string(entry.Value), ShouldEqual, "4")
+
+				entry, err = readItem(client, keyTwo)
+				So(err, ShouldBeNil)
+				So(string(entry.Value), ShouldEqual, "3")
+			})
+
+			Convey("Then restoring an unknown backup fails", func() {
+				_, err := bm.Restore("unknown")
+				So(err, ShouldEqual, ErrBackupNotFound)
+
+				_, err = bm.Open("unknown")
+				So(err, ShouldEqual, ErrBackupNotFound)
+			})
+
+			Convey("Then we can upload a downloaded backup", func() {
+				file, err := bm.Open(full.ID)
+				So(err, ShouldBeNil)
+				defer file.Close()
+
+				time.Sleep(2 * time.Millisecond)
+				uploaded, err := bm.Add(file)
+				So(err, ShouldBeNil)
+				So(uploaded.Full, ShouldBeTrue)
+				So(uploaded.Uploaded, ShouldBeTrue)
+				So(uploaded.Size, ShouldEqual, full.Size)
+
+				So(backup().Full, ShouldBeTrue)
+
+				_, err = bm.Restore(uploaded.ID)
+				So(err, ShouldBeNil)
+
+				entry, err := readItem(client, keyTwo)
+				So(err, ShouldBeNil)
+				So(entry, ShouldBeNil)
+			})
+
+			Convey("Then uploading a file that is not a backup fails", func() {
+				_, err := bm.Add(strings.NewReader("not a backup file"))
+				So(errors.Is(err, ErrInvalidBackup), ShouldBeTrue)
+
+				backups, err := bm.Backups()
+				So(err, ShouldBeNil)
+				So(len(backups), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When an incremental backup follows a backup of another timeline", func() {
+			backups := []Backup{
+				{ID: "a", Full: true},
+				{ID: "b", Timeline: 1},
+			}
+
+			_, err := backupChain(backups, "b")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the retention policy keeps one backup a day", func() {
+			bm.Retention = RetentionPolicy{Daily: 2}
+			day := func(d int) int64 {
+				return time.Date(2024, 1, d, 12, 0, 0, 0, time.Local).Unix()
+			}
+			m := &manifest{Backups: []Backup{
+				{ID: "a", Time: day(1), Full: true},
+				{ID: "b", Time: day(2)},
+				{ID: "c", Time: day(2) + 60},
+				{ID: "d", Time: day(3), Full: true},
+				{ID: "e", Time: day(3) + 60},
+				{ID: "f", Time: day(4)},
+			}}
+			for _, b := range m.Backups {
+				err := os.WriteFile(bm.backupPath(b.ID), []byte{}, 0640)
+				So(err, ShouldBeNil)
+			}
+
+			err := bm.prune(m)
+			So(err, ShouldBeNil)
+
+			Convey("Then the backups that the kept backups depend on are also kept", func() {
+				ids := []string{}
+				for _, b := range m.Backups {
+					ids = append(ids, b.ID)
+				}
+				So(ids, ShouldResemble, []string{"d", "e", "f"})
+
+				_, err := os.Stat(bm.backupPath("a"))
+				So(os.IsNotExist(err), ShouldBeTrue)
+				_, err = os.Stat(bm.backupPath("d"))
+				So(err, ShouldBeNil)
+
+				backups, err := bm.Backups()
+				So(err, ShouldBeNil)
+				So(len(backups), ShouldEqual, 3)
+			})
+		})
+	})
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	Convey("Given backups over several months", t, func() {
+		backups := []Backup{}
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+		for d := 0; d < 90; d++ {
+			for h := 0; h < 2; h++ {
+				backupTime := start.AddDate(0, 0, d).Add(time.Duration(h) * time.Hour)
+				backups = append(backups, Backup{ID: fmt.Sprintf("%d-%d", d, h), Time: backupTime.Unix()})
+			}
+		}
+
+		Convey("When we apply the retention policy", func() {
+			keep := RetentionPolicy{Daily: 3, Weekly: 2, Monthly: 3}.retained(backups)
+
+			Convey("Then the most recent backup of each period is kept", func() {
+				So(keep["89-1"], ShouldBeTrue)
+				So(keep["89-0"], ShouldBeFalse)
+				So(keep["88-1"], ShouldBeTrue)
+				So(keep["87-1"], ShouldBeTrue)
+				So(keep["86-1"], ShouldBeFalse)
+				// the end of January and February
+				So(keep["30-1"], ShouldBeTrue)
+				So(keep["59-1"], ShouldBeTrue)
+				So(keep["0-1"], ShouldBeFalse)
+			})
+		})
+	})
+}
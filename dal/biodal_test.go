@@ -49,5 +49,28 @@ func TestBioDal(t *testing.T) {
 			So(bioStats[1], ShouldResemble, testBioStat)
 
 		})
+
+		Convey("When we get pages of bio stats with and without a start date", func() {
+			laterBioStat := []byte("later bio stats")
+			if err := client.AddBioStats(testUserID, testDate+int64(2), laterBioStat); err != nil {
+				t.Fail()
+			}
+
+			latest, err := client.GetBioStatsPage(testUserID, 0, 1)
+			So(err, ShouldBeNil)
+			fromDate, err := client.GetBioStatsPage(testUserID, testDate+int64(1), 20)
+			So(err, ShouldBeNil)
+
+			Convey("Then the first page starts at the latest bio stat", func() {
+				So(len(latest), ShouldEqual, 1)
+				So(latest[0], ShouldResemble, laterBioStat)
+			})
+
+			Convey("Then a page from a start date starts before that date", func() {
+				So(len(fromDate), ShouldBeGreaterThan, 0)
+				So(fromDate[0], ShouldResemble, testBioStat)
+				So(fromDate, ShouldNotContain, laterBioStat)
+			})
+		})
 	})
 }
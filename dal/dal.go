@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"log/slog"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"log"
@@ -24,23 +26,58 @@ type Dstore interface {
 	UpdateActivity(userID, activityID, activityName string) error
 	AddExerciseToActivity(userID, activityID, exerciseID string) error
 	UpdateActivityExercises(userID, activityID string, exIDsToAdd, exIDsToDelete []string) error
+	HasActivityEvents(userID, activityID string) (bool, error)
+	DeleteActivity(userID, activityID string) error
+	MergeActivities(userID, srcActivityID, dstActivityID string, rewrite func([]byte) ([]byte, error)) error
 
 	NewUser(id, email, pwdHash, pwdHashVersion, role string) error
 	ReadUser(id string) (*string, *string, *string, *string, error)
 	UpdateUserProfile(id, email string) error
 	UpdateUserPassword(id, pwdHash, pwdVersion string) error
 	ChangeUserRole(id, role string) error
-	UpdatePwdVersion(userID, version string) error
+	UpdatePwdVersion(userID, pwdHash, version string) error
+	ListUsers() ([]string, error)
+	ReadUserStatus(id string) (*UserStatus, error)
+	SetUserDisabled(id string, disabled bool) error
+	SetPasswordChangeRequired(id string, required bool) error
+	DeleteUser(id string) error
+
+	AddInvite(code string, expires int64) error
+	GetInvites() (map[string]int64, error)
+	ClaimInvite(code string) (*int64, error)
+	DeleteInvite(code string) error
+
+	GetLoginFailures(kind, id string) (*LoginFailures, error)
+	SetLoginFailures(failures LoginFailures, ttl time.Duration) error
+	ListLoginFailures() ([]LoginFailures, error)
+	DeleteLoginFailures(kind, id string) error
+
+	ReadTOTP(userID string) (*TOTPInfo, error)
+	SetTOTPSecret(userID string, secret []byte) error
+	EnableTOTP(userID string, recoveryCodeHashes []string) error
+	SetTOTPStep(userID string, step int64) error
+	UseRecoveryCode(userID, hash string) (bool, error)
+	DeleteTOTP(userID string) error
+
+	AddAPIToken(hash string, token APITokenRecord) error
+	GetAPIToken(hash string) (*APITokenRecord, error)
+	GetUserAPITokens(userID string) ([]APITokenRecord, error)
+	TouchAPIToken(hash string, lastUsed int64) error
+	DeleteAPIToken(userID, tokenID string) error
 
 	AddExercise(userID, exerciseID string, exercise []byte) error
 	UpdateExercise(userID, exerciseID string, exercise []byte) error
 	GetExercise(userID, exerciseID string) ([]byte, error)
 	GetExercises(userID string) ([][]byte, error)
+	DeleteExercise(userID, exerciseID string) error
+	HasExerciseInstances(userID, exerciseID string) (bool, error)
 
 	AddEvent(userID, eventID, activityID string, date int64, event []byte, exerciseIDs map[int]string, exerciseInstances map[int][]byte) error
 	UpdateEvent(userID, eventID, activityID string, currentDate, newDate int64, event []byte, exerciseIDs map[int]string, exerciseInstances map[int][]byte) error
 	GetEvent(userID, eventID string, eventDate int64) ([]byte, error)
 	GetEventPage(userID, previousEventID string, previousDate int64, pageSize int) ([][]byte, error)
+	GetActivityEventPage(userID, activityID, previousEventID string, startDate, endDate int64, pageSize int) ([][]byte, error)
+	GetExerciseInstancePage(userID, exerciseID, previousEventID string, startDate, endDate int64, pageSize int) ([]int64, []string, [][]byte, error)
 	GetEventExercises(userID, eventID string) ([][]byte, error)
 	DeleteEvent(userID, eventID, activityID string, date int64) error
 
@@ -60,9 +97,11 @@ type Dstore interface {
 	RotateKeys(newRSAKey []byte, keyID, usage string) error
 	DeleteKey(keyID, usage string) error
 
-	AddSession(username, sessionID string, ttl int) error
+	AddSession(username, sessionID, userAgent string, ttl int) error
 	GetSession(sessionID string) (*string, *int64, error)
 	DeleteSession(sessionID string) error
+	TouchSession(username, sessionID string, lastSeen int64) error
+	GetUserSessions(username string) ([]SessionInfo, error)
 	GetSessionExpiries() (map[string]int64, error)
 
 	AddBioStats(userID string, date int64, stats []byte) error
@@ -72,46 +111,93 @@ type Dstore interface {
 	GetOneRM(userID, exerciseID string) (int, error)
 	AddPR(userID, exerciseID string, value int) error
 	GetPR(userID, exerciseID string) (int, error)
+	SetRecords(userID, exerciseID string, records []byte) error
+	GetRecords(userID, exerciseID string) ([]byte, error)
 
 	Iter8er()
-
-	Restore(filepath string) error
 }
 
 var (
-	ErrNotUnique error = errors.New("value not unique")
+	ErrNotUnique     error = errors.New("value not unique")
+	ErrInvalidUserID error = errors.New("user ID can include letters, digits, _, -, ., @, and +")
 )
 
+// userIDRxp matches user IDs that cannot be confused with the separators of keys.
+var userIDRxp = regexp.MustCompile(`^[a-zA-Z0-9_\-.@+]+$`)
+
+// CheckUserID returns ErrInvalidUserID when a user ID includes characters that are not permitted.
+func CheckUserID(id string) error {
+	if !userIDRxp.MatchString(id) {
+		return ErrInvalidUserID
+	}
+
+	return nil
+}
+
 const (
-	userKey           = "user"
-	idKey             = "id"
-	roleKey           = "role"
-	nameKey           = "name"
-	emailKey          = "email"
-	passHashKey       = "phash"
-	versionKey        = "version"
-	activityKey       = "activity"
-	exerciseKey       = "exercise"
-	typeKey           = "type"
-	indexKey          = "index"
-	eventKey          = "event"
-	instanceKey       = "instance"
-	sessionKey        = "session"
-	expiresKey        = "expires"
-	tokenCryptoKeyKey = "tokenkey"
-	oneRMKey          = "onerm"
-	prKey             = "pr"
+	userKey            = "user"
+	idKey              = "id"
+	roleKey            = "role"
+	nameKey            = "name"
+	emailKey           = "email"
+	passHashKey        = "phash"
+	versionKey         = "version"
+	activityKey        = "activity"
+	exerciseKey        = "exercise"
+	typeKey            = "type"
+	indexKey           = "index"
+	eventKey           = "event"
+	instanceKey        = "instance"
+	sessionKey         = "session"
+	expiresKey         = "expires"
+	createdKey         = "created"
+	lastSeenKey        = "lastseen"
+	userAgentKey       = "agent"
+	disabledKey        = "disabled"
+	pwdResetKey        = "pwdreset"
+	tokenCryptoKeyKey  = "tokenkey"
+	totpCryptoKeyKey   = "totpkey"
+	pepperCryptoKeyKey = "pepperkey"
+	oneRMKey           = "onerm"
+	prKey              = "pr"
 )
 
 // A DBClient implements the Dstore interface for a Badger database.
+// Reads and writes hold a read lock on mu so that the database can be swapped while they wait.
 type DBClient struct {
 	db   *badger.DB
 	path string
+	mu   sync.RWMutex
 }
 
 var DB Dstore
 
+// A SessionInfo describes a session of a user.
+// Times are in seconds since the epoch.
+type SessionInfo struct {
+	ID        string
+	Created   int64
+	Expires   int64
+	LastSeen  int64
+	UserAgent string
+}
+
+// A UserStatus describes the state of a user account.
+type UserStatus struct {
+	Disabled               bool
+	PasswordChangeRequired bool
+}
+
+// deleteBatchSize is the number of items that are deleted in each transaction when many items are deleted,
+// such as when a user is deleted.
+const deleteBatchSize = 1000
+
+// writeBatchSize is the number of items that are written in each transaction when many items are written,
+// such as by migrations, so that transactions do not exceed the size limit of the database.
+const writeBatchSize = 1000
+
 // InitClient opens the database, creating it if necessary, and returns a client.
+// Pending schema migrations are run before the client is returned.
 func InitClient(path string) (*DBClient, error) {
 	dalClient := &DBClient{path: path}
 	db, err := badger.Open(badger.DefaultOptions(path))
@@ -120,17 +206,46 @@ func InitClient(path string) (*DBClient, error) {
 		return nil, err
 	}
 	dalClient.db = db
+
+	if err := migrate(dalClient, true); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
 	DB = dalClient
 	return dalClient, nil
 }
 
 func (c *DBClient) Destroy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	slog.Info("closing database")
 	c.db.Close()
 }
 
+// view runs a read-only transaction.
+func (c *DBClient) view(fn func(txn *badger.Txn) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.db.View(fn)
+}
+
+// update runs a read-write transaction.
+func (c *DBClient) update(fn func(txn *badger.Txn) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.db.Update(fn)
+}
+
 // NewUser adds an item that represents a user.
 func (c *DBClient) NewUser(id, email, pwdHash, pwdHashVersion, role string) error {
+	if err := CheckUserID(id); err != nil {
+		return err
+	}
+
 	prefix := []string{userKey, id}
 	idKey := key(append(prefix, "id"))
 
@@ -199,13 +314,14 @@ func (c *DBClient) UpdateUserProfile(id, email string) error {
 
 // UpdateUserPassword updates the stored password hash.
 // The pwdVersion indicates which hashing function was used.
+// A required password change is cleared.
 func (c *DBClient) UpdateUserPassword(id, pwdHash, pwdVersion string) error {
 	prefix := []string{userKey, id}
 
 	pwdVersionEntry := badger.NewEntry(key(append(prefix, versionKey)), []byte(pwdVersion))
 	hashEntry := badger.NewEntry(key(append(prefix, passHashKey)), []byte(pwdHash))
 
-	if err := writeUpdates(c, []*badger.Entry{pwdVersionEntry, hashEntry}); err != nil {
+	if err := updateDeleteItems(c, []*badger.Entry{pwdVersionEntry, hashEntry}, [][]byte{key(append(prefix, pwdResetKey))}); err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
@@ -225,20 +341,136 @@ func (c *DBClient) ChangeUserRole(id, role string) error {
 	return nil
 }
 
-// UpdatePwdVersion stores the password version to use for authenticating a user.
-// TODO: restrict to admin
-func (c *DBClient) UpdatePwdVersion(userID, version string) error {
+// UpdatePwdVersion stores a password hash that was rehashed with a different version.
+// Unlike UpdateUserPassword, a required password change is not cleared.
+func (c *DBClient) UpdatePwdVersion(userID, pwdHash, version string) error {
 	prefix := []string{userKey, userID}
 
 	versionEntry := badger.NewEntry(key(append(prefix, versionKey)), []byte(version))
+	hashEntry := badger.NewEntry(key(append(prefix, passHashKey)), []byte(pwdHash))
 
-	if err := writeUpdates(c, []*badger.Entry{versionEntry}); err != nil {
+	if err := writeUpdates(c, []*badger.Entry{versionEntry, hashEntry}); err != nil {
 		return fmt.Errorf("failed to update user hash version: %w", err)
 	}
 
 	return nil
 }
 
+// ListUsers returns the IDs of all users.
+func (c *DBClient) ListUsers() ([]string, error) {
+	rxp := regexp.MustCompile(fmt.Sprintf("^%s:([^#]+)#%s$", userKey, idKey))
+
+	keys, err := readKeys(c, []byte(userKey+":"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+
+	users := []string{}
+	for _, k := range keys {
+		if parts := rxp.FindSubmatch(k); parts != nil {
+			users = append(users, string(parts[1]))
+		}
+	}
+
+	return users, nil
+}
+
+// ReadUserStatus returns the status of a user account.
+func (c *DBClient) ReadUserStatus(id string) (*UserStatus, error) {
+	prefix := []string{userKey, id}
+	status := UserStatus{}
+
+	disabled, err := readItem(c, key(append(prefix, disabledKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user status: %w", err)
+	}
+	status.Disabled = disabled != nil
+
+	pwdReset, err := readItem(c, key(append(prefix, pwdResetKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user status: %w", err)
+	}
+	status.PasswordChangeRequired = pwdReset != nil
+
+	return &status, nil
+}
+
+// SetUserDisabled disables or enables a user account.
+func (c *DBClient) SetUserDisabled(id string, disabled bool) error {
+	if err := setUserFlag(c, id, disabledKey, disabled); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+// SetPasswordChangeRequired sets whether a user must change their password.
+func (c *DBClient) SetPasswordChangeRequired(id string, required bool) error {
+	if err := setUserFlag(c, id, pwdResetKey, required); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+// setUserFlag writes a user item when the flag is set and deletes it when it is not.
+func setUserFlag(c *DBClient, id, flag string, set bool) error {
+	flagKey := key([]string{userKey, id, flag})
+	if set {
+		return writeUpdates(c, []*badger.Entry{badger.NewEntry(flagKey, []byte("true"))})
+	}
+	return deleteItems(c, [][]byte{flagKey})
+}
+
+// DeleteUser deletes a user, the sessions of the user, and all of the data of the user.
+// Items are deleted in batches and the user ID item is deleted last so that a failed deletion can be repeated.
+// IDs that CheckUserID rejects are not deleted because their key prefix can match the items of other users.
+func (c *DBClient) DeleteUser(id string) error {
+	if err := CheckUserID(id); err != nil {
+		return err
+	}
+
+	sessions, err := c.GetUserSessions(id)
+	if err != nil {
+		return fmt.Errorf("failed to read user sessions: %w", err)
+	}
+
+	keys := [][]byte{}
+	for _, s := range sessions {
+		keys = append(keys, key([]string{sessionKey, s.ID, userKey, id, expiresKey}))
+	}
+
+	tokenHashes, err := userAPITokenHashes(c, id)
+	if err != nil {
+		return err
+	}
+	for _, hash := range tokenHashes {
+		keys = append(keys, key([]string{apiTokenKey, hash}))
+	}
+
+	userKeys, err := readKeys(c, keyPrefix([]string{userKey, id}))
+	if err != nil {
+		return fmt.Errorf("failed to read user items: %w", err)
+	}
+
+	idItemKey := key([]string{userKey, id, idKey})
+	for _, k := range userKeys {
+		if !bytes.Equal(k, idItemKey) {
+			keys = append(keys, k)
+		}
+	}
+	keys = append(keys, idItemKey)
+
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		end := min(start+deleteBatchSize, len(keys))
+		if err := deleteItems(c, keys[start:end]); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // AddActivity adds items that represent a user's activity.
 // Use [AddExercise] to add exercises to the activity.
 func (c *DBClient) AddActivity(userID, activityID, activityName string) error {
@@ -348,6 +580,237 @@ func (c *DBClient) UpdateActivityExercises(userID, activityID string, exIDsToAdd
 	return nil
 }
 
+// HasActivityEvents returns true when at least one event is associated with an activity.
+func (c *DBClient) HasActivityEvents(userID, activityID string) (bool, error) {
+	prefix := keyPrefix([]string{userKey, userID, byActivityKey, activityID})
+
+	found, err := hasKeyMatch(c, prefix, activityIndexRx)
+	if err != nil {
+		return false, fmt.Errorf("failed to read activity events: %w", err)
+	}
+
+	return found, nil
+}
+
+// DeleteActivity deletes an activity and all items that are keyed by the activity.
+// The items include exercise type associations, programs, and active program flags.
+func (c *DBClient) DeleteActivity(userID, activityID string) error {
+	entries, err := readKeyPrefix(c, keyPrefix([]string{userKey, userID, activityKey, activityID}))
+	if err != nil {
+		return fmt.Errorf("failed to read activity: %w", err)
+	}
+
+	deletes := [][]byte{}
+	for _, e := range entries {
+		deletes = append(deletes, e.Key)
+	}
+
+	if err := deleteItems(c, deletes); err != nil {
+		return fmt.Errorf("failed to delete activity: %w", err)
+	}
+
+	return nil
+}
+
+// An itemMove re-keys an item of a merged activity.
+// The item at from is deleted unless it is the item at to.
+type itemMove struct {
+	from    []byte
+	to      []byte
+	value   []byte
+	rewrite bool
+}
+
+// MergeActivities moves the events, exercise types, programs, program instances, and active program flags
+// of the source activity to the destination activity, then deletes the source activity.
+// The active program flags of the source are deleted instead of moved when the destination has an active program.
+// The rewrite function is applied to the stored events, programs, and program instances
+// so that their values can be changed to reference the destination activity.
+//
+// A merge can be too large for one transaction, so it is not atomic. The active program flags are moved first,
+// then the other items are moved in batches of transactions, and the source activity is deleted last.
+// Each item is written and deleted in the same transaction, and an event is moved with its index entry,
+// so items are never stored under both activities. When a merge fails it can be run again to complete it.
+func (c *DBClient) MergeActivities(userID, srcActivityID, dstActivityID string, rewrite func([]byte) ([]byte, error)) error {
+	eventPrefix := keyPrefix([]string{userKey, userID, eventKey})
+	eventRx := regexp.MustCompile(fmt.Sprintf("^%s:[^#]+#%s:([^#]+)#%s:([^#]+)#%s:%s$", userKey, eventKey, idKey, activityKey, regexp.QuoteMeta(srcActivityID)))
+	srcPrefix := keyPrefix([]string{userKey, userID, activityKey, srcActivityID})
+	dstPrefix := keyPrefix([]string{userKey, userID, activityKey, dstActivityID})
+	srcActivePrefix := keyPrefix([]string{userKey, userID, activityKey, srcActivityID, activeProgramKey})
+	dstActivePrefix := keyPrefix([]string{userKey, userID, activityKey, dstActivityID, activeProgramKey})
+
+	// the destination keeps its active program
+	err := c.update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		it.Seek(dstActivePrefix)
+		dstHasActiveProgram := it.ValidForPrefix(dstActivePrefix)
+
+		moved := []*badger.Entry{}
+		deletes := [][]byte{}
+		for it.Seek(srcActivePrefix); it.ValidForPrefix(srcActivePrefix); it.Next() {
+			item := it.Item()
+			deletes = append(deletes, item.KeyCopy(nil))
+			if dstHasActiveProgram {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			suffix := strings.TrimPrefix(string(item.Key()), string(srcPrefix))
+			moved = append(moved, badger.NewEntry([]byte(string(dstPrefix)+suffix), value))
+		}
+
+		for _, k := range deletes {
+			if err := txn.Delete(k); err != nil {
+				return fmt.Errorf("delete failed: %w", err)
+			}
+		}
+		for _, e := range moved {
+			if err := txn.SetEntry(e); err != nil {
+				return fmt.Errorf("transaction commit failed: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge active programs: %w", err)
+	}
+
+	// each group of moves is made in one transaction
+	moves := [][]itemMove{}
+
+	err = c.view(func(txn *badger.Txn) error {
+		programIDs := []string{}
+		movedIndexes := map[string]bool{}
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		// re-key the events of the source activity with their activity index
+		for it.Seek(eventPrefix); it.ValidForPrefix(eventPrefix); it.Next() {
+			item := it.Item()
+			parts := eventRx.FindSubmatch(item.Key())
+			if parts == nil {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			date, eventID := string(parts[1]), string(parts[2])
+			newKey := key([]string{userKey, userID, eventKey, date, idKey, eventID, activityKey, dstActivityID})
+			srcIndex := key([]string{userKey, userID, byActivityKey, srcActivityID, dateKey, date, idKey, eventID})
+			dstIndex := key([]string{userKey, userID, byActivityKey, dstActivityID, dateKey, date, idKey, eventID})
+			movedIndexes[string(srcIndex)] = true
+
+			moves = append(moves, []itemMove{
+				{from: item.KeyCopy(nil), to: newKey, value: value, rewrite: true},
+				{from: srcIndex, to: dstIndex, value: []byte{}},
+			})
+		}
+
+		// re-key the activity index entries that do not have an event
+		srcIndexPrefix := keyPrefix([]string{userKey, userID, byActivityKey, srcActivityID})
+		dstIndexPrefix := keyPrefix([]string{userKey, userID, byActivityKey, dstActivityID})
+		for it.Seek(srcIndexPrefix); it.ValidForPrefix(srcIndexPrefix); it.Next() {
+			itemKey := it.Item().KeyCopy(nil)
+			if movedIndexes[string(itemKey)] {
+				continue
+			}
+			suffix := strings.TrimPrefix(string(itemKey), string(srcIndexPrefix))
+			moves = append(moves, []itemMove{{from: itemKey, to: []byte(string(dstIndexPrefix) + suffix), value: []byte{}}})
+		}
+
+		// re-key the items of the source activity, except for its name which is deleted last
+		for it.Seek(srcPrefix); it.ValidForPrefix(srcPrefix); it.Next() {
+			item := it.Item()
+			itemKey := item.KeyCopy(nil)
+			suffix := strings.TrimPrefix(string(itemKey), string(srcPrefix))
+
+			if suffix == nameKey {
+				continue
+			}
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			isProgram := strings.HasPrefix(suffix, programKey+":")
+			if isProgram {
+				programIDs = append(programIDs, strings.TrimPrefix(suffix, programKey+":"))
+			}
+
+			moves = append(moves, []itemMove{{from: itemKey, to: []byte(string(dstPrefix) + suffix), value: value, rewrite: isProgram}})
+		}
+
+		// update the program instances of the moved programs
+		for _, programID := range programIDs {
+			instancePrefix := keyPrefix([]string{userKey, userID, programKey, programID, programInstanceKey})
+			for it.Seek(instancePrefix); it.ValidForPrefix(instancePrefix); it.Next() {
+				item := it.Item()
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				itemKey := item.KeyCopy(nil)
+				moves = append(moves, []itemMove{{from: itemKey, to: itemKey, value: value, rewrite: true}})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge activities: %w", err)
+	}
+
+	for start := 0; start < len(moves); start += writeBatchSize {
+		end := min(start+writeBatchSize, len(moves))
+		if err := c.update(func(txn *badger.Txn) error {
+			return moveItems(txn, moves[start:end], rewrite)
+		}); err != nil {
+			return fmt.Errorf("failed to merge activities: %w", err)
+		}
+	}
+
+	srcNameKey := key([]string{userKey, userID, activityKey, srcActivityID, nameKey})
+	if err := deleteItems(c, [][]byte{srcNameKey}); err != nil {
+		return fmt.Errorf("failed to delete merged activity: %w", err)
+	}
+
+	return nil
+}
+
+// moveItems makes groups of item moves in a transaction.
+func moveItems(txn *badger.Txn, groups [][]itemMove, rewrite func([]byte) ([]byte, error)) error {
+	for _, group := range groups {
+		for _, move := range group {
+			value := move.value
+			if move.rewrite {
+				var err error
+				value, err = rewrite(value)
+				if err != nil {
+					return fmt.Errorf("failed to rewrite %s: %w", move.from, err)
+				}
+			}
+			if err := txn.Set(move.to, value); err != nil {
+				return fmt.Errorf("transaction commit failed: %w", err)
+			}
+			if !bytes.Equal(move.from, move.to) {
+				if err := txn.Delete(move.from); err != nil {
+					return fmt.Errorf("delete failed: %w", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 // AddExercise stores an exercise definition.
 func (c *DBClient) AddExercise(userID, exerciseID string, exercise []byte) error {
 	prefix := []string{userKey, userID, exerciseKey, exerciseID, typeKey}
@@ -405,6 +868,35 @@ func (c *DBClient) GetExercises(userID string) ([][]byte, error) {
 	return types, nil
 }
 
+// DeleteExercise deletes an exercise type along with the stored 1RM, PR, and record history of the type.
+// References to the exercise type from other items are not removed.
+func (c *DBClient) DeleteExercise(userID, exerciseID string) error {
+	deletes := [][]byte{
+		key([]string{userKey, userID, exerciseKey, exerciseID, typeKey}),
+		key([]string{userKey, userID, oneRMKey, exerciseID}),
+		key([]string{userKey, userID, prKey, exerciseID}),
+		key([]string{userKey, userID, recordKey, exerciseID}),
+	}
+
+	if err := deleteItems(c, deletes); err != nil {
+		return fmt.Errorf("failed to delete exercise: %w", err)
+	}
+
+	return nil
+}
+
+// HasExerciseInstances returns true when an event includes at least one instance of an exercise type.
+func (c *DBClient) HasExerciseInstances(userID, exerciseID string) (bool, error) {
+	prefix := keyPrefix([]string{userKey, userID, byTypeKey, exerciseID})
+
+	found, err := hasKeyMatch(c, prefix, typeIndexRx)
+	if err != nil {
+		return false, fmt.Errorf("failed to read exercise instances: %w", err)
+	}
+
+	return found, nil
+}
+
 func (c *DBClient) AddOneRM(userID, exerciseID string, value int) error {
 	prefix := []string{userKey, userID, oneRMKey, exerciseID}
 
@@ -487,7 +979,7 @@ func (c *DBClient) AddEvent(userID, eventID, activityID string, date int64, even
 	// user:{id}#event:{date}#id:{id}#activity:{activityID}
 	eventEntry := badger.NewEntry(key(eventPrefix), event)
 
-	updates := []*badger.Entry{eventEntry}
+	updates := []*badger.Entry{eventEntry, badger.NewEntry(activityIndex(userID, activityID, eventID, date), []byte{})}
 
 	exercisePrefix := []string{userKey, userID, eventKey, eventID, exerciseKey}
 
@@ -499,6 +991,7 @@ func (c *DBClient) AddEvent(userID, eventID, activityID string, date int64, even
 		prefix := append(exercisePrefix, exerciseID, indexKey, fmt.Sprint(k), instanceKey)
 		entry := badger.NewEntry(key(prefix), e)
 		updates = append(updates, entry)
+		updates = append(updates, badger.NewEntry(typeIndex(userID, exerciseID, eventID, date, fmt.Sprint(k)), []byte{}))
 	}
 
 	if err := writeUpdates(c, updates); err != nil {
@@ -519,7 +1012,7 @@ func (c *DBClient) UpdateEvent(userID, eventID, activityID string, currentDate,
 	currentEventPrefix := []string{userKey, userID, eventKey, fmt.Sprint(currentDate), idKey, eventID, activityKey, activityID}
 
 	if currentDate != newDate {
-		deletes = append(deletes, key(currentEventPrefix))
+		deletes = append(deletes, key(currentEventPrefix), activityIndex(userID, activityID, eventID, currentDate))
 
 		shiftedPrefix := []string{userKey, userID, eventKey, fmt.Sprint(newDate), idKey, eventID, activityKey, activityID}
 
@@ -527,10 +1020,11 @@ func (c *DBClient) UpdateEvent(userID, eventID, activityID string, currentDate,
 	} else {
 		updates = append(updates, badger.NewEntry(key(currentEventPrefix), event))
 	}
+	updates = append(updates, badger.NewEntry(activityIndex(userID, activityID, eventID, newDate), []byte{}))
 
 	delExercisePrefix := []string{userKey, userID, eventKey, eventID, exerciseKey}
 
-	// delete existing exercise instances
+	// delete existing exercise instances and their index keys
 	exEntries, err := readKeyPrefix(c, keyPrefix(delExercisePrefix))
 	if err != nil {
 		return fmt.Errorf("error getting event exercises")
@@ -540,6 +1034,12 @@ func (c *DBClient) UpdateEvent(userID, eventID, activityID string, currentDate,
 		deletes = append(deletes, e.Key)
 	}
 
+	indexes, err := instanceIndexes(c, userID, eventID, currentDate)
+	if err != nil {
+		return fmt.Errorf("error getting event exercises")
+	}
+	deletes = append(deletes, indexes...)
+
 	addExercisePrefix := []string{userKey, userID, eventKey, eventID, exerciseKey}
 
 	for k, e := range exerciseInstances {
@@ -550,6 +1050,7 @@ func (c *DBClient) UpdateEvent(userID, eventID, activityID string, currentDate,
 		prefix := append(addExercisePrefix, exerciseID, indexKey, fmt.Sprint(k), instanceKey)
 		entry := badger.NewEntry(key(prefix), e)
 		updates = append(updates, entry)
+		updates = append(updates, badger.NewEntry(typeIndex(userID, exerciseID, eventID, newDate, fmt.Sprint(k)), []byte{}))
 	}
 
 	return updateDeleteItems(c, updates, deletes)
@@ -595,8 +1096,7 @@ func (c *DBClient) GetEvent(userID, eventID string, eventDate int64) ([]byte, er
 }
 
 // GetEventPage gets a page of events.
-// Items are iterated in reverse order so a value of zero for previous date returns no results.
-// To get the latest events, set previous date to now.
+// Items are iterated in reverse order so a value of zero for previous date returns the latest events.
 // For subsequent pages, previousDate and optionally previousEventID are used to identify the last item in the previous page
 func (c *DBClient) GetEventPage(userID, previousEventID string, previousDate int64, pageSize int) (
 	[][]byte, error) {
@@ -637,11 +1137,11 @@ func (c *DBClient) DeleteEvent(userID, eventID, activityID string, date int64) e
 	deletes := [][]byte{}
 
 	eventPrefix := []string{userKey, userID, eventKey, fmt.Sprint(date), idKey, eventID, activityKey, activityID}
-	deletes = append(deletes, key(eventPrefix))
+	deletes = append(deletes, key(eventPrefix), activityIndex(userID, activityID, eventID, date))
 
 	exercisePrefix := []string{userKey, userID, eventKey, eventID, exerciseKey}
 
-	// delete existing exercise instances
+	// delete existing exercise instances and their index keys
 	exEntries, err := readKeyPrefix(c, keyPrefix(exercisePrefix))
 	if err != nil {
 		return fmt.Errorf("error getting event exercises")
@@ -651,6 +1151,12 @@ func (c *DBClient) DeleteEvent(userID, eventID, activityID string, date int64) e
 		deletes = append(deletes, e.Key)
 	}
 
+	indexes, err := instanceIndexes(c, userID, eventID, date)
+	if err != nil {
+		return fmt.Errorf("error getting event exercises")
+	}
+	deletes = append(deletes, indexes...)
+
 	return deleteItems(c, deletes)
 }
 
@@ -686,7 +1192,7 @@ func (c *DBClient) RotateKeys(newKey []byte, keyID, usage string) error {
 	if err != nil {
 		return fmt.Errorf("failed to get existing keys: %w", err)
 	}
-	err = c.db.Update(func(txn *badger.Txn) error {
+	err = c.update(func(txn *badger.Txn) error {
 		for k, v := range active {
 			prefix := []string{keyByCryptoUsage(usage), k}
 			entry := badger.NewEntry(key(prefix), v).WithMeta(byte(1))
@@ -720,17 +1226,30 @@ func (c *DBClient) DeleteKey(keyID, usage string) error {
 }
 
 // Stores a user session.
-// A session is stored across 3 items.
+// A session is stored as an item keyed by session ID and
+// as items in the session index of the user that hold the creation time, expiry, last seen time, and user agent.
 // ttl is in minutes
-func (c *DBClient) AddSession(username, sessionID string, ttl int) error {
+func (c *DBClient) AddSession(username, sessionID, userAgent string, ttl int) error {
 	prefix := []string{sessionKey, sessionID, userKey, username, expiresKey}
+	indexPrefix := []string{userKey, username, sessionKey, sessionID}
 
-	expBytes, err := int64ToBSlice(time.Now().Add(time.Minute * time.Duration(ttl)).Unix())
+	now := time.Now()
+	expBytes, err := int64ToBSlice(now.Add(time.Minute * time.Duration(ttl)).Unix())
 	if err != nil {
 		return fmt.Errorf("failed to convert expiry to byte slice: %w", err)
 	}
-	expEntry := badger.NewEntry(key(prefix), expBytes)
-	updates := []*badger.Entry{expEntry}
+	nowBytes, err := int64ToBSlice(now.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to convert time to byte slice: %w", err)
+	}
+
+	updates := []*badger.Entry{
+		badger.NewEntry(key(prefix), expBytes),
+		badger.NewEntry(key(append(indexPrefix, expiresKey)), expBytes),
+		badger.NewEntry(key(append(indexPrefix, createdKey)), nowBytes),
+		badger.NewEntry(key(append(indexPrefix, lastSeenKey)), nowBytes),
+		badger.NewEntry(key(append(indexPrefix, userAgentKey)), []byte(userAgent)),
+	}
 
 	err = writeUpdates(c, updates)
 	if err != nil {
@@ -768,22 +1287,130 @@ func (c *DBClient) GetSession(sessionID string) (*string, *int64, error) {
 }
 
 // DeleteSession deletes a user session by ID.
+// Deleting a session that does not exist is not an error.
 func (c *DBClient) DeleteSession(sessionID string) error {
 	username, _, err := c.GetSession(sessionID)
 	if err != nil {
 		return fmt.Errorf("failed to get session: %w", err)
 	}
+	if username == nil {
+		return nil
+	}
 
 	sessionPrefix := []string{sessionKey, sessionID, userKey, *username, expiresKey}
-	sessionKey := key(sessionPrefix)
+	indexPrefix := []string{userKey, *username, sessionKey, sessionID}
+
+	keys := [][]byte{key(sessionPrefix)}
+	for _, item := range []string{expiresKey, createdKey, lastSeenKey, userAgentKey} {
+		keys = append(keys, key(append(indexPrefix, item)))
+	}
 
-	keys := [][]byte{sessionKey}
 	if err := deleteItems(c, keys); err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
 	return nil
 }
 
+// TouchSession records the time that a session of a user was last used.
+func (c *DBClient) TouchSession(username, sessionID string, lastSeen int64) error {
+	lastSeenBytes, err := int64ToBSlice(lastSeen)
+	if err != nil {
+		return fmt.Errorf("failed to convert time to byte slice: %w", err)
+	}
+
+	entry := badger.NewEntry(key([]string{userKey, username, sessionKey, sessionID, lastSeenKey}), lastSeenBytes)
+	if err := writeUpdates(c, []*badger.Entry{entry}); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserSessions returns the sessions of a user from the session index.
+func (c *DBClient) GetUserSessions(username string) ([]SessionInfo, error) {
+	// user:{username}#session:{sessionID}#{item}
+	prefix := keyPrefix([]string{userKey, username, sessionKey})
+	entries, err := readKeyPrefix(c, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not read sessions: %w", err)
+	}
+
+	rxp := regexp.MustCompile(fmt.Sprintf(`^%s:[^#]+#%s:([a-zA-Z0-9-]+)#([a-z]+)$`, userKey, sessionKey))
+
+	sessions := []SessionInfo{}
+	index := map[string]int{}
+	for _, e := range entries {
+		parts := rxp.FindStringSubmatch(string(e.Key))
+		if parts == nil {
+			continue
+		}
+
+		i, ok := index[parts[1]]
+		if !ok {
+			i = len(sessions)
+			index[parts[1]] = i
+			sessions = append(sessions, SessionInfo{ID: parts[1]})
+		}
+
+		if parts[2] == userAgentKey {
+			sessions[i].UserAgent = string(e.Value)
+			continue
+		}
+
+		value, err := bSliceToInt64(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("could not read session: %w", err)
+		}
+
+		switch parts[2] {
+		case expiresKey:
+			sessions[i].Expires = *value
+		case createdKey:
+			sessions[i].Created = *value
+		case lastSeenKey:
+			sessions[i].LastSeen = *value
+		}
+	}
+
+	// a session can be touched while it is deleted
+	sessions = slices.DeleteFunc(sessions, func(si SessionInfo) bool { return si.Expires == 0 })
+
+	return sessions, nil
+}
+
+// indexSessions adds the sessions that were created before sessions were indexed by user to the session index.
+// The creation time of these sessions is not known.
+func indexSessions(c *DBClient) error {
+	sessionRx := regexp.MustCompile(fmt.Sprintf("^%s:([^#]+)#%s:([^#]+)#%s$", sessionKey, userKey, expiresKey))
+
+	updates := []*badger.Entry{}
+
+	prefix := []byte(sessionKey + ":")
+	err := c.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			parts := sessionRx.FindStringSubmatch(string(it.Item().Key()))
+			if parts == nil {
+				continue
+			}
+
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			updates = append(updates, badger.NewEntry(key([]string{userKey, parts[2], sessionKey, parts[1], expiresKey}), value))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	return writeUpdatesInBatches(c, updates)
+}
+
 // GetSessionExpiries returns a map of session IDs (keys) and expiry times.
 func (c *DBClient) GetSessionExpiries() (map[string]int64, error) {
 	prefix := []byte(sessionKey)
@@ -828,7 +1455,7 @@ func int64ToBSlice(i int64) ([]byte, error) {
 // Returns nil, nil if key not found
 func readItem(c *DBClient, key []byte) (*badger.Entry, error) {
 	var entry *badger.Entry
-	err := c.db.View(func(txn *badger.Txn) error {
+	err := c.view(func(txn *badger.Txn) error {
 		item, err := txn.Get(key)
 		if err != nil {
 			return err
@@ -852,7 +1479,7 @@ func readItem(c *DBClient, key []byte) (*badger.Entry, error) {
 // Iter8er prints the entire contents of the database.
 // Useful for testing.
 func (c *DBClient) Iter8er() {
-	c.db.View(func(txn *badger.Txn) error {
+	c.view(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchSize = 10
 		it := txn.NewIterator(opts)
@@ -875,7 +1502,7 @@ func (c *DBClient) Iter8er() {
 func readKeyPrefix(c *DBClient, prefix []byte) ([]*badger.Entry, error) {
 	entries := []*badger.Entry{}
 	itOptions := badger.DefaultIteratorOptions
-	err := c.db.View(func(txn *badger.Txn) error {
+	err := c.view(func(txn *badger.Txn) error {
 		it := txn.NewIterator(itOptions)
 		defer it.Close()
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
@@ -898,15 +1525,22 @@ func readKeyPrefix(c *DBClient, prefix []byte) ([]*badger.Entry, error) {
 	return entries, nil
 }
 
+// readKeyPrefixPage returns a page of the items with validPrefix.
+// Iteration starts at previousPrefix, or at the first item with validPrefix when previousPrefix is nil.
+// When reverse is true the first item is the last item with validPrefix, so the first page holds the latest items.
 func readKeyPrefixPage(c *DBClient, previousPrefix, validPrefix []byte, pageSize int, exclude string, includePrevious bool, reverse bool) ([]*badger.Entry, error) {
 	entries := []*badger.Entry{}
 	startPrefix := previousPrefix
 	if previousPrefix == nil {
 		startPrefix = validPrefix
+		if reverse {
+			// seek past the last item with the prefix
+			startPrefix = append(append([]byte{}, validPrefix...), 0xFF)
+		}
 	}
 	itOptions := badger.DefaultIteratorOptions
 	itOptions.Reverse = reverse
-	err := c.db.View(func(txn *badger.Txn) error {
+	err := c.view(func(txn *badger.Txn) error {
 		it := txn.NewIterator(itOptions)
 		defer it.Close()
 		for it.Seek(startPrefix); it.ValidForPrefix(validPrefix); it.Next() {
@@ -937,9 +1571,52 @@ func readKeyPrefixPage(c *DBClient, previousPrefix, validPrefix []byte, pageSize
 	return entries, nil
 }
 
+// readKeys returns the keys of the items with the prefix.
+// Values are not read.
+func readKeys(c *DBClient, prefix []byte) ([][]byte, error) {
+	keys := [][]byte{}
+	itOptions := badger.DefaultIteratorOptions
+	itOptions.PrefetchValues = false
+	err := c.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// hasKeyMatch returns true when the key of at least one item with the prefix matches a regular expression.
+// Values are not read.
+func hasKeyMatch(c *DBClient, prefix []byte, rx *regexp.Regexp) (bool, error) {
+	found := false
+	itOptions := badger.DefaultIteratorOptions
+	itOptions.PrefetchValues = false
+	err := c.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if rx.Match(it.Item().Key()) {
+				found = true
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
 // Deletes items and sets items in a single transaction
 func updateDeleteItems(c *DBClient, updates []*badger.Entry, deletes [][]byte) error {
-	err := c.db.Update(func(txn *badger.Txn) error {
+	err := c.update(func(txn *badger.Txn) error {
 		for _, v := range deletes {
 			if err := txn.Delete(v); err != nil {
 				return fmt.Errorf("delete failed: %w", err)
@@ -961,7 +1638,7 @@ func updateDeleteItems(c *DBClient, updates []*badger.Entry, deletes [][]byte) e
 }
 
 func writeUpdates(c *DBClient, updates []*badger.Entry) error {
-	err := c.db.Update(func(txn *badger.Txn) error {
+	err := c.update(func(txn *badger.Txn) error {
 		for _, v := range updates {
 			if err := txn.SetEntry(v); err != nil {
 				return fmt.Errorf("transaction commit failed: %w", err)
@@ -975,8 +1652,21 @@ func writeUpdates(c *DBClient, updates []*badger.Entry) error {
 	return nil
 }
 
+// writeUpdatesInBatches writes updates in transactions of at most writeBatchSize items.
+// The updates are not written atomically.
+func writeUpdatesInBatches(c *DBClient, updates []*badger.Entry) error {
+	for start := 0; start < len(updates); start += writeBatchSize {
+		end := min(start+writeBatchSize, len(updates))
+		if err := writeUpdates(c, updates[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func deleteItems(c *DBClient, keys [][]byte) error {
-	err := c.db.Update(func(txn *badger.Txn) error {
+	err := c.update(func(txn *badger.Txn) error {
 		for _, v := range keys {
 			if err := txn.Delete(v); err != nil {
 				return fmt.Errorf("delete failed: %w", err)
@@ -994,6 +1684,10 @@ func keyByCryptoUsage(usage string) string {
 	switch usage {
 	case "token":
 		return tokenCryptoKeyKey
+	case "totp":
+		return totpCryptoKeyKey
+	case "pepper":
+		return pepperCryptoKeyKey
 	}
 	return ""
 }
@@ -2,6 +2,7 @@ package dal
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -12,6 +13,7 @@ import (
 
 	"log"
 
+	badger "github.com/dgraph-io/badger/v4"
 	"github.com/google/uuid"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -31,6 +33,7 @@ const (
 	testSessionID    = "test-session-id"
 	testRole         = "testRole"
 	testSessionTTL   = 14
+	testUserAgent    = "test agent"
 	testPR           = 100
 	test1RM          = 90
 )
@@ -97,6 +100,11 @@ func TestUsersDal(t *testing.T) {
 				So(err, ShouldBeNil)
 			})
 		})
+		Convey("When we add a user with an id that includes key separators", func() {
+			for _, id := range []string{"", "user#id", "user:id", "user id"} {
+				So(client.NewUser(testUser(id)), ShouldEqual, ErrInvalidUserID)
+			}
+		})
 		Convey("When we read the user", func() {
 			e, ph, phv, r, err := client.ReadUser(testUserID)
 
@@ -145,13 +153,101 @@ func TestUsersDal(t *testing.T) {
 
 		Convey("When we update the user with a new hashing version", func() {
 			newPwdHashVersion := "blah"
-			err := client.UpdatePwdVersion(testUserID, newPwdHashVersion)
+			newPwdHash := "rehashed"
+			err := client.UpdatePwdVersion(testUserID, newPwdHash, newPwdHashVersion)
 			Convey("Then no error is returned", func() {
 				So(err, ShouldBeNil)
-				_, _, phv, _, _ := client.ReadUser(testUserID)
+				_, ph, phv, _, _ := client.ReadUser(testUserID)
+				So(*ph, ShouldEqual, newPwdHash)
 				So(*phv, ShouldEqual, newPwdHashVersion)
 			})
 		})
+
+		Convey("When we list the users", func() {
+			users, err := client.ListUsers()
+
+			Convey("Then the IDs of all users are returned", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldResemble, []string{testUserID, testUserID + "2"})
+			})
+		})
+
+		Convey("When we disable the user and require a password change", func() {
+			So(client.SetUserDisabled(testUserID, true), ShouldBeNil)
+			So(client.SetPasswordChangeRequired(testUserID, true), ShouldBeNil)
+
+			Convey("Then the user status is updated", func() {
+				status, err := client.ReadUserStatus(testUserID)
+				So(err, ShouldBeNil)
+				So(status.Disabled, ShouldBeTrue)
+				So(status.PasswordChangeRequired, ShouldBeTrue)
+
+				other, err := client.ReadUserStatus(testUserID + "2")
+				So(err, ShouldBeNil)
+				So(*other, ShouldResemble, UserStatus{})
+			})
+
+			Convey("Then updating the password clears the required password change", func() {
+				So(client.UpdateUserPassword(testUserID, testHash, testPwdVersion), ShouldBeNil)
+
+				status, err := client.ReadUserStatus(testUserID)
+				So(err, ShouldBeNil)
+				So(status.PasswordChangeRequired, ShouldBeFalse)
+				So(status.Disabled, ShouldBeTrue)
+			})
+
+			Convey("Then enabling the user clears the disabled status", func() {
+				So(client.SetUserDisabled(testUserID, false), ShouldBeNil)
+
+				status, err := client.ReadUserStatus(testUserID)
+				So(err, ShouldBeNil)
+				So(status.Disabled, ShouldBeFalse)
+			})
+		})
+
+		Convey("When we delete a user with an id that matches the key prefix of another user", func() {
+			So(client.AddActivity(testUserID, testActivityID, testActivityName), ShouldBeNil)
+
+			err := client.DeleteUser(testUserID + "#activity:" + testActivityID)
+
+			Convey("Then the id is rejected and the items of the other user are not deleted", func() {
+				So(err, ShouldEqual, ErrInvalidUserID)
+
+				name, _, err := client.ReadActivity(testUserID, testActivityID)
+				So(err, ShouldBeNil)
+				So(*name, ShouldEqual, testActivityName)
+			})
+		})
+
+		Convey("When we delete a user that has data and a session", func() {
+			otherUserID := testUserID + "2"
+			So(client.AddActivity(otherUserID, testActivityID, testActivityName), ShouldBeNil)
+			So(client.AddEvent(otherUserID, testEventID, testActivityID, testEventTime, testEvent, map[int]string{0: testExerciseID}, map[int][]byte{0: testExerciseInstance}), ShouldBeNil)
+			So(client.AddSession(otherUserID, testSessionID, testUserAgent, testSessionTTL), ShouldBeNil)
+			So(client.AddAPIToken("tokenhash", APITokenRecord{ID: "tokenID", UserID: otherUserID}), ShouldBeNil)
+
+			err := client.DeleteUser(otherUserID)
+
+			Convey("Then the user and all of their items are deleted", func() {
+				So(err, ShouldBeNil)
+
+				keys, err := readKeys(client, keyPrefix([]string{userKey, otherUserID}))
+				So(err, ShouldBeNil)
+				So(keys, ShouldBeEmpty)
+
+				username, _, err := client.GetSession(testSessionID)
+				So(err, ShouldBeNil)
+				So(username, ShouldBeNil)
+
+				token, err := client.GetAPIToken("tokenhash")
+				So(err, ShouldBeNil)
+				So(token, ShouldBeNil)
+
+				users, err := client.ListUsers()
+				So(err, ShouldBeNil)
+				So(users, ShouldResemble, []string{testUserID})
+			})
+		})
 	})
 }
 
@@ -368,6 +464,19 @@ func TestLogItemsDal(t *testing.T) {
 					So(lastEvent, ShouldEqual, lastInPage)
 				})
 			})
+
+			Convey("And then we get the first page of events without a start date", func() {
+				eventsByte, err := client.GetEventPage(testUserID, "", 0, pagesize)
+				So(err, ShouldBeNil)
+
+				Convey("Then the page is the same as a page that starts after the latest event", func() {
+					startTime := testEventTimes[len(testEventTimes)-1] + 1
+					fromStart, err := client.GetEventPage(testUserID, "", startTime, pagesize)
+					So(err, ShouldBeNil)
+					So(len(eventsByte), ShouldEqual, pagesize)
+					So(eventsByte, ShouldResemble, fromStart)
+				})
+			})
 		})
 		Convey("When we delete an event", func() {
 			err := client.DeleteEvent(testUserID, testEventID, testActivityID, testEventTime)
@@ -406,6 +515,335 @@ func TestLogItemsDal(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(oneRM, ShouldEqual, test1RM)
 		})
+
+		Convey("When we check whether exercise types are logged in events", func() {
+			logged, err := client.HasExerciseInstances(testUserID, "id1")
+			So(err, ShouldBeNil)
+			So(logged, ShouldBeTrue)
+
+			logged, err = client.HasExerciseInstances(testUserID, "notLogged")
+			So(err, ShouldBeNil)
+			So(logged, ShouldBeFalse)
+
+			// only logged in the deleted event
+			logged, err = client.HasExerciseInstances(testUserID, "id4")
+			So(err, ShouldBeNil)
+			So(logged, ShouldBeFalse)
+		})
+
+		Convey("When we delete an exercise", func() {
+			err := client.DeleteExercise(testUserID, testExerciseID)
+			So(err, ShouldBeNil)
+
+			Convey("Then the exercise, PR, and 1RM are removed", func() {
+				exercise, err := client.GetExercise(testUserID, testExerciseID)
+				So(err, ShouldBeNil)
+				So(exercise, ShouldBeNil)
+
+				pr, err := client.GetPR(testUserID, testExerciseID)
+				So(err, ShouldBeNil)
+				So(pr, ShouldEqual, -1)
+
+				oneRM, err := client.GetOneRM(testUserID, testExerciseID)
+				So(err, ShouldBeNil)
+				So(oneRM, ShouldEqual, -1)
+			})
+		})
+
+		Convey("When we get a page of events for an activity within a date range", func() {
+			// events 1 to 24 are 100s apart
+			start := testEventTime + 2000
+			end := testEventTime + 1000
+			events, err := client.GetActivityEventPage(testUserID, testActivityID, "", start, end, 5)
+
+			So(err, ShouldBeNil)
+			So(len(events), ShouldEqual, 5)
+			So(string(events[0]), ShouldEqual, fmt.Sprintf("%s20_%d", testEventID, start))
+
+			Convey("And then we get the next page", func() {
+				events, err := client.GetActivityEventPage(testUserID, testActivityID, fmt.Sprintf("%s16", testEventID), testEventTime+1600, end, 5)
+
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 5)
+				So(string(events[4]), ShouldEqual, fmt.Sprintf("%s11_%d", testEventID, testEventTime+1100))
+
+				events, err = client.GetActivityEventPage(testUserID, testActivityID, fmt.Sprintf("%s11", testEventID), testEventTime+1100, end, 5)
+
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 1)
+				So(string(events[0]), ShouldEqual, fmt.Sprintf("%s10_%d", testEventID, end))
+			})
+		})
+
+		Convey("When we get a page of exercise instances within a date range", func() {
+			dates, eventIDs, instances, err := client.GetExerciseInstancePage(testUserID, "id2", "", 0, testEventTime+2300, 10)
+
+			So(err, ShouldBeNil)
+			So(len(instances), ShouldEqual, 2)
+			So(dates, ShouldResemble, []int64{testEventTime + 2400, testEventTime + 2300})
+			So(eventIDs, ShouldResemble, []string{testEventID + "24", testEventID + "23"})
+			So(instances[0], ShouldResemble, testExerciseInstance)
+
+			Convey("And then we get the next page", func() {
+				dates, _, _, err := client.GetExerciseInstancePage(testUserID, "id2", testEventID+"23", testEventTime+2300, 0, 3)
+
+				So(err, ShouldBeNil)
+				So(dates, ShouldResemble, []int64{testEventTime + 2200, testEventTime + 2100, testEventTime + 2000})
+			})
+		})
+
+		Convey("When events are stored without index keys", func() {
+			event := key([]string{userKey, "unindexed", eventKey, fmt.Sprint(testEventTime), idKey, "e1", activityKey, "a1"})
+			instance := key([]string{userKey, "unindexed", eventKey, "e1", exerciseKey, "x1", indexKey, "0", instanceKey})
+			err := writeUpdates(client, []*badger.Entry{badger.NewEntry(event, testEvent), badger.NewEntry(instance, testExerciseInstance)})
+			So(err, ShouldBeNil)
+
+			err = indexEvents(client)
+			So(err, ShouldBeNil)
+
+			Convey("Then the events are indexed", func() {
+				events, err := client.GetActivityEventPage("unindexed", "a1", "", 0, 0, 10)
+				So(err, ShouldBeNil)
+				So(events, ShouldResemble, [][]byte{testEvent})
+
+				dates, _, instances, err := client.GetExerciseInstancePage("unindexed", "x1", "", 0, 0, 10)
+				So(err, ShouldBeNil)
+				So(dates, ShouldResemble, []int64{testEventTime})
+				So(instances, ShouldResemble, [][]byte{testExerciseInstance})
+			})
+		})
+
+		Convey("When more events are stored without index keys than fit in a transaction", func() {
+			count := int(client.db.MaxBatchCount()) + 1
+			updates := []*badger.Entry{}
+			for i := range count {
+				updates = append(updates, badger.NewEntry(key([]string{userKey, "large", eventKey, fmt.Sprint(testEventTime + int64(i)), idKey, fmt.Sprintf("e%d", i), activityKey, "a1"}), testEvent))
+			}
+			So(writeUpdatesInBatches(client, updates), ShouldBeNil)
+
+			err = indexEvents(client)
+
+			Convey("Then the events are indexed", func() {
+				So(err, ShouldBeNil)
+
+				indexes, err := readKeys(client, keyPrefix([]string{userKey, "large", byActivityKey, "a1"}))
+				So(err, ShouldBeNil)
+				So(len(indexes), ShouldEqual, count)
+			})
+		})
+
+		Convey("When we check whether activities have events", func() {
+			hasEvents, err := client.HasActivityEvents(testUserID, testActivityID)
+			So(err, ShouldBeNil)
+			So(hasEvents, ShouldBeTrue)
+
+			hasEvents, err = client.HasActivityEvents(testUserID, "noEvents")
+			So(err, ShouldBeNil)
+			So(hasEvents, ShouldBeFalse)
+		})
+
+		Convey("When we merge an activity into another activity", func() {
+			dstActivityID := "mergeDestination"
+			err := client.AddActivity(testUserID, dstActivityID, "destination")
+			So(err, ShouldBeNil)
+
+			rewrite := func(value []byte) ([]byte, error) {
+				return append([]byte("merged "), value...), nil
+			}
+			err = client.MergeActivities(testUserID, testActivityID, dstActivityID, rewrite)
+			So(err, ShouldBeNil)
+
+			Convey("Then the events and exercise types are moved to the destination", func() {
+				hasEvents, err := client.HasActivityEvents(testUserID, testActivityID)
+				So(err, ShouldBeNil)
+				So(hasEvents, ShouldBeFalse)
+
+				hasEvents, err = client.HasActivityEvents(testUserID, dstActivityID)
+				So(err, ShouldBeNil)
+				So(hasEvents, ShouldBeTrue)
+
+				name, _, err := client.ReadActivity(testUserID, testActivityID)
+				So(err, ShouldBeNil)
+				So(name, ShouldBeNil)
+
+				name, exerciseIDs, err := client.ReadActivity(testUserID, dstActivityID)
+				So(err, ShouldBeNil)
+				So(*name, ShouldEqual, "destination")
+				So(exerciseIDs, ShouldContain, "a")
+
+				event, err := client.GetEvent(testUserID, testEventID+"1", testEventTime+100)
+				So(err, ShouldBeNil)
+				So(string(event), ShouldStartWith, "merged ")
+			})
+
+			Convey("And when we delete the destination activity", func() {
+				err := client.DeleteActivity(testUserID, dstActivityID)
+				So(err, ShouldBeNil)
+
+				name, exerciseIDs, err := client.ReadActivity(testUserID, dstActivityID)
+				So(err, ShouldBeNil)
+				So(name, ShouldBeNil)
+				So(exerciseIDs, ShouldBeNil)
+			})
+		})
+
+		Convey("When we merge activities that both have an active program", func() {
+			srcActivityID, dstActivityID := "activeSource", "activeDestination"
+			So(client.AddActivity(testUserID, srcActivityID, "source"), ShouldBeNil)
+			So(client.AddActivity(testUserID, dstActivityID, "destination"), ShouldBeNil)
+			So(client.ActivateProgramInstance(testUserID, srcActivityID, "srcProgram", "srcInstance"), ShouldBeNil)
+			So(client.ActivateProgramInstance(testUserID, dstActivityID, "dstProgram", "dstInstance"), ShouldBeNil)
+
+			err := client.MergeActivities(testUserID, srcActivityID, dstActivityID, func(value []byte) ([]byte, error) { return value, nil })
+			So(err, ShouldBeNil)
+
+			Convey("Then the destination keeps its active program", func() {
+				active, err := client.GetActiveProgramInstancePage(testUserID, dstActivityID, "", 10)
+				So(err, ShouldBeNil)
+				So(active, ShouldResemble, [][]byte{[]byte("dstProgram:dstInstance")})
+
+				active, err = client.GetActiveProgramInstancePage(testUserID, srcActivityID, "", 10)
+				So(err, ShouldBeNil)
+				So(active, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a merge fails after the first batch", func() {
+			count := writeBatchSize + 10
+			updates := []*badger.Entry{}
+			for i := range count {
+				eventID := fmt.Sprintf("e%d", i)
+				date := testEventTime + int64(i)
+				updates = append(updates, badger.NewEntry(key([]string{userKey, "resume", eventKey, fmt.Sprint(date), idKey, eventID, activityKey, "a1"}), testEvent))
+				updates = append(updates, badger.NewEntry(activityIndex("resume", "a1", eventID, date), []byte{}))
+			}
+			So(writeUpdatesInBatches(client, updates), ShouldBeNil)
+			So(client.AddActivity("resume", "a1", "source"), ShouldBeNil)
+			So(client.AddActivity("resume", "a2", "destination"), ShouldBeNil)
+
+			rewrites := 0
+			failing := func(value []byte) ([]byte, error) {
+				rewrites++
+				if rewrites > writeBatchSize {
+					return nil, errors.New("test failure")
+				}
+				return value, nil
+			}
+			err := client.MergeActivities("resume", "a1", "a2", failing)
+			So(err, ShouldNotBeNil)
+
+			countKeys := func(prefix []byte) int {
+				keys, err := readKeys(client, prefix)
+				So(err, ShouldBeNil)
+				return len(keys)
+			}
+			countEvents := func(activityID string) int {
+				n := 0
+				keys, err := readKeys(client, keyPrefix([]string{userKey, "resume", eventKey}))
+				So(err, ShouldBeNil)
+				for _, k := range keys {
+					if strings.HasSuffix(string(k), fmt.Sprintf("#%s:%s", activityKey, activityID)) {
+						n++
+					}
+				}
+				return n
+			}
+
+			Convey("Then the events of the first batch are moved with their index and none are duplicated", func() {
+				So(countEvents("a2"), ShouldEqual, writeBatchSize)
+				So(countEvents("a1"), ShouldEqual, count-writeBatchSize)
+				So(countKeys(keyPrefix([]string{userKey, "resume", byActivityKey, "a2"})), ShouldEqual, writeBatchSize)
+				So(countKeys(keyPrefix([]string{userKey, "resume", byActivityKey, "a1"})), ShouldEqual, count-writeBatchSize)
+
+				name, _, err := client.ReadActivity("resume", "a1")
+				So(err, ShouldBeNil)
+				So(*name, ShouldEqual, "source")
+			})
+
+			Convey("Then the merge is completed when it is run again", func() {
+				err := client.MergeActivities("resume", "a1", "a2", func(value []byte) ([]byte, error) { return value, nil })
+				So(err, ShouldBeNil)
+
+				So(countEvents("a2"), ShouldEqual, count)
+				So(countEvents("a1"), ShouldEqual, 0)
+				So(countKeys(keyPrefix([]string{userKey, "resume", byActivityKey, "a2"})), ShouldEqual, count)
+				So(countKeys(keyPrefix([]string{userKey, "resume", byActivityKey, "a1"})), ShouldEqual, 0)
+
+				name, _, err := client.ReadActivity("resume", "a1")
+				So(err, ShouldBeNil)
+				So(name, ShouldBeNil)
+			})
+		})
+
+		Convey("When we merge an activity that has more events than fit in a transaction", func() {
+			count := int(client.db.MaxBatchCount()) + 1
+			updates := []*badger.Entry{}
+			for i := range count {
+				eventID := fmt.Sprintf("e%d", i)
+				date := testEventTime + int64(i)
+				updates = append(updates, badger.NewEntry(key([]string{userKey, "merge", eventKey, fmt.Sprint(date), idKey, eventID, activityKey, "a1"}), testEvent))
+				updates = append(updates, badger.NewEntry(activityIndex("merge", "a1", eventID, date), []byte{}))
+			}
+			So(writeUpdatesInBatches(client, updates), ShouldBeNil)
+			So(client.AddActivity("merge", "a1", "source"), ShouldBeNil)
+			So(client.AddActivity("merge", "a2", "destination"), ShouldBeNil)
+
+			err := client.MergeActivities("merge", "a1", "a2", func(value []byte) ([]byte, error) { return value, nil })
+
+			Convey("Then all of the events are moved", func() {
+				So(err, ShouldBeNil)
+
+				indexes, err := readKeys(client, keyPrefix([]string{userKey, "merge", byActivityKey, "a2"}))
+				So(err, ShouldBeNil)
+				So(len(indexes), ShouldEqual, count)
+
+				hasEvents, err := client.HasActivityEvents("merge", "a1")
+				So(err, ShouldBeNil)
+				So(hasEvents, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestReadKeyPrefixPage(t *testing.T) {
+	defer cleanup()
+
+	Convey("Given items that share a prefix", t, func() {
+		client, err := InitClient(testPath)
+		if err != nil {
+			log.Fatal()
+		}
+		defer client.Destroy()
+
+		prefix := keyPrefix([]string{userKey, testUserID, "page"})
+		updates := []*badger.Entry{}
+		for i := 1; i <= 3; i++ {
+			updates = append(updates, badger.NewEntry(key([]string{userKey, testUserID, "page", fmt.Sprint(i)}), []byte(fmt.Sprint(i))))
+		}
+		// an item that sorts after the prefix
+		updates = append(updates, badger.NewEntry(key([]string{userKey, testUserID, "pages", "1"}), []byte("other")))
+		So(writeUpdates(client, updates), ShouldBeNil)
+
+		Convey("When we read the first page in reverse order", func() {
+			entries, err := readKeyPrefixPage(client, nil, prefix, 2, "", true, true)
+
+			Convey("Then the page starts with the last item with the prefix", func() {
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(string(entries[0].Value), ShouldEqual, "3")
+				So(string(entries[1].Value), ShouldEqual, "2")
+			})
+		})
+
+		Convey("When we read the first page in order", func() {
+			entries, err := readKeyPrefixPage(client, nil, prefix, 2, "", true, false)
+
+			Convey("Then the page starts with the first item with the prefix", func() {
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(string(entries[0].Value), ShouldEqual, "1")
+			})
+		})
 	})
 }
 
@@ -505,7 +943,7 @@ func TestSessionsDal(t *testing.T) {
 		}
 		defer client.Destroy()
 		Convey("When we track an authenticated user", func() {
-			err := client.AddSession(testUserID, testSessionID, testSessionTTL)
+			err := client.AddSession(testUserID, testSessionID, testUserAgent, testSessionTTL)
 			So(err, ShouldBeNil)
 			username, expiry, err := client.GetSession(testSessionID)
 			So(err, ShouldBeNil)
@@ -514,7 +952,7 @@ func TestSessionsDal(t *testing.T) {
 			So(expiry, ShouldNotBeNil)
 		})
 		Convey("When we add the user again", func() {
-			err = client.AddSession(testUserID, testSessionID, testSessionTTL)
+			err = client.AddSession(testUserID, testSessionID, testUserAgent, testSessionTTL)
 			So(err, ShouldBeNil)
 		})
 		Convey("When we delete the session", func() {
@@ -529,11 +967,11 @@ func TestSessionsDal(t *testing.T) {
 			expiries, err := client.GetSessionExpiries()
 			So(err, ShouldBeNil)
 			So(expiries, ShouldBeEmpty)
-			err = client.AddSession(testUserID, testSessionID, testSessionTTL)
+			err = client.AddSession(testUserID, testSessionID, testUserAgent, testSessionTTL)
 			if err != nil {
 				log.Fatal(err.Error())
 			}
-			err = client.AddSession("testuserid2", "testsessionid2", testSessionTTL)
+			err = client.AddSession("testuserid2", "testsessionid2", testUserAgent, testSessionTTL)
 			if err != nil {
 				log.Fatal(err.Error())
 			}
@@ -541,6 +979,54 @@ func TestSessionsDal(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(len(expiries), ShouldEqual, 2)
 		})
+		Convey("When we get the sessions of a user", func() {
+			err := client.AddSession(testUserID, testSessionID, testUserAgent, testSessionTTL)
+			So(err, ShouldBeNil)
+			err = client.AddSession("testuserid2", "testsessionid2", testUserAgent, testSessionTTL)
+			So(err, ShouldBeNil)
+			err = client.TouchSession(testUserID, testSessionID, 1000)
+			So(err, ShouldBeNil)
+
+			sessions, err := client.GetUserSessions(testUserID)
+			So(err, ShouldBeNil)
+			So(len(sessions), ShouldEqual, 1)
+			So(sessions[0].ID, ShouldEqual, testSessionID)
+			So(sessions[0].UserAgent, ShouldEqual, testUserAgent)
+			So(sessions[0].LastSeen, ShouldEqual, 1000)
+			So(sessions[0].Created, ShouldBeGreaterThan, 0)
+			So(sessions[0].Expires, ShouldBeGreaterThan, sessions[0].Created)
+
+			Convey("Then deleting a session removes it from the index", func() {
+				err := client.DeleteSession(testSessionID)
+				So(err, ShouldBeNil)
+
+				sessions, err := client.GetUserSessions(testUserID)
+				So(err, ShouldBeNil)
+				So(sessions, ShouldBeEmpty)
+
+				err = client.DeleteSession(testSessionID)
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("When sessions were created before sessions were indexed", func() {
+			err := client.AddSession(testUserID, testSessionID, testUserAgent, testSessionTTL)
+			So(err, ShouldBeNil)
+			indexKeys := [][]byte{}
+			for _, item := range []string{expiresKey, createdKey, lastSeenKey, userAgentKey} {
+				indexKeys = append(indexKeys, key([]string{userKey, testUserID, sessionKey, testSessionID, item}))
+			}
+			err = deleteItems(client, indexKeys)
+			So(err, ShouldBeNil)
+
+			err = indexSessions(client)
+			So(err, ShouldBeNil)
+
+			sessions, err := client.GetUserSessions(testUserID)
+			So(err, ShouldBeNil)
+			So(len(sessions), ShouldEqual, 1)
+			So(sessions[0].Expires, ShouldBeGreaterThan, 0)
+			So(sessions[0].Created, ShouldEqual, 0)
+		})
 	})
 }
 
@@ -0,0 +1,249 @@
+package dal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	dateKey       = "date"
+	byActivityKey = "byactivity"
+	byTypeKey     = "bytype"
+)
+
+// Index keys have empty values. They point to the keys of events and exercise instances.
+// user:{id}#byactivity:{activityID}#date:{date}#id:{eventID}
+// user:{id}#bytype:{exerciseID}#date:{date}#id:{eventID}#index:{index}
+
+var activityIndexRx = regexp.MustCompile(fmt.Sprintf("^%s:[^#]+#%s:[^#]+#%s:([^#]+)#%s:([^#]+)$", userKey, byActivityKey, dateKey, idKey))
+var typeIndexRx = regexp.MustCompile(fmt.Sprintf("^%s:[^#]+#%s:[^#]+#%s:([^#]+)#%s:([^#]+)#%s:([^#]+)$", userKey, byTypeKey, dateKey, idKey, indexKey))
+var instanceKeyRx = regexp.MustCompile(fmt.Sprintf("^%s:[^#]+#%s:[^#]+#%s:([^#]+)#%s:([^#]+)#%s$", userKey, eventKey, exerciseKey, indexKey, instanceKey))
+
+func activityIndex(userID, activityID, eventID string, date int64) []byte {
+	return key([]string{userKey, userID, byActivityKey, activityID, dateKey, fmt.Sprint(date), idKey, eventID})
+}
+
+func typeIndex(userID, exerciseID, eventID string, date int64, index string) []byte {
+	return key([]string{userKey, userID, byTypeKey, exerciseID, dateKey, fmt.Sprint(date), idKey, eventID, indexKey, index})
+}
+
+// instanceIndexes returns the type index keys of the stored exercise instances of an event.
+func instanceIndexes(c *DBClient, userID, eventID string, date int64) ([][]byte, error) {
+	prefix := []string{userKey, userID, eventKey, eventID, exerciseKey}
+	exEntries, err := readKeyPrefix(c, keyPrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := [][]byte{}
+	for _, e := range exEntries {
+		if parts := instanceKeyRx.FindStringSubmatch(string(e.Key)); parts != nil {
+			indexes = append(indexes, typeIndex(userID, parts[1], eventID, date, parts[2]))
+		}
+	}
+
+	return indexes, nil
+}
+
+// GetActivityEventPage gets a page of the events of an activity that occurred within a date range.
+// Events are returned in reverse chronological order.
+// The startDate is the latest date of the range and endDate is the earliest. Set startDate to 0 to start at the most recent event.
+// For subsequent pages set previousEventID and startDate to the ID and date of the last event of the previous page.
+func (c *DBClient) GetActivityEventPage(userID, activityID, previousEventID string, startDate, endDate int64, pageSize int) ([][]byte, error) {
+	prefix := keyPrefix([]string{userKey, userID, byActivityKey, activityID, dateKey})
+	seekKey := indexSeekKey(prefix, startDate, previousEventID)
+
+	events := [][]byte{}
+
+	err := c.view(func(txn *badger.Txn) error {
+		itOptions := badger.DefaultIteratorOptions
+		itOptions.PrefetchValues = false
+		itOptions.Reverse = true
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+
+		for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+			parts := activityIndexRx.FindStringSubmatch(string(it.Item().Key()))
+			if parts == nil {
+				continue
+			}
+			date, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			if date < endDate {
+				break
+			}
+			if date == startDate && parts[2] == previousEventID {
+				continue
+			}
+
+			eventItem, err := txn.Get(key([]string{userKey, userID, eventKey, parts[1], idKey, parts[2], activityKey, activityID}))
+			if err != nil {
+				return fmt.Errorf("failed to read indexed event: %w", err)
+			}
+			event, err := eventItem.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+
+			if len(events) == pageSize {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read activity events: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetExerciseInstancePage gets a page of the instances of an exercise type that were performed within a date range.
+// Instances are returned in reverse chronological order with the date and event ID of each instance.
+// The startDate is the latest date of the range and endDate is the earliest. Set startDate to 0 to start at the most recent instance.
+// For subsequent pages set previousEventID and startDate to the event ID and date of the last instance of the previous page.
+// All instances of an event are included in the same page so the page size can be exceeded.
+func (c *DBClient) GetExerciseInstancePage(userID, exerciseID, previousEventID string, startDate, endDate int64, pageSize int) ([]int64, []string, [][]byte, error) {
+	prefix := keyPrefix([]string{userKey, userID, byTypeKey, exerciseID, dateKey})
+	seekKey := indexSeekKey(prefix, startDate, previousEventID)
+
+	dates := []int64{}
+	eventIDs := []string{}
+	instances := [][]byte{}
+
+	err := c.view(func(txn *badger.Txn) error {
+		itOptions := badger.DefaultIteratorOptions
+		itOptions.PrefetchValues = false
+		itOptions.Reverse = true
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+
+		for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+			parts := typeIndexRx.FindStringSubmatch(string(it.Item().Key()))
+			if parts == nil {
+				continue
+			}
+			date, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			eventID := parts[2]
+			if date < endDate {
+				break
+			}
+			if date == startDate && eventID == previousEventID {
+				continue
+			}
+			// finish the last event before ending the page
+			if len(instances) >= pageSize && eventID != eventIDs[len(eventIDs)-1] {
+				break
+			}
+
+			instanceItem, err := txn.Get(key([]string{userKey, userID, eventKey, eventID, exerciseKey, exerciseID, indexKey, parts[3], instanceKey}))
+			if err != nil {
+				return fmt.Errorf("failed to read indexed exercise instance: %w", err)
+			}
+			instance, err := instanceItem.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			dates = append(dates, date)
+			eventIDs = append(eventIDs, eventID)
+			instances = append(instances, instance)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read exercise instances: %w", err)
+	}
+
+	return dates, eventIDs, instances, nil
+}
+
+// indexSeekKey returns the key at which to start a reverse iteration of a date index.
+func indexSeekKey(prefix []byte, startDate int64, previousEventID string) []byte {
+	seekKey := append([]byte{}, prefix...)
+	if startDate == 0 {
+		return append(seekKey, 0xFF)
+	}
+
+	seekKey = append(seekKey, []byte(fmt.Sprintf("%d#%s:", startDate, idKey))...)
+	if previousEventID == "" {
+		return append(seekKey, 0xFF)
+	}
+
+	// the index of an instance follows the event ID
+	return append(seekKey, []byte(previousEventID+"#\xFF")...)
+}
+
+// indexEvents writes the activity and exercise type index keys of stored events.
+// Only missing index keys are written. The keys are written in batches so that the migration
+// of a large database does not exceed the transaction size limit.
+func indexEvents(c *DBClient) error {
+	eventRx := regexp.MustCompile(fmt.Sprintf("^%s:([^#]+)#%s:([^#]+)#%s:([^#]+)#%s:([^#]+)$", userKey, eventKey, idKey, activityKey))
+	instanceRx := regexp.MustCompile(fmt.Sprintf("^%s:([^#]+)#%s:([^#]+)#%s:([^#]+)#%s:([^#]+)#%s$", userKey, eventKey, exerciseKey, indexKey, instanceKey))
+
+	type instance struct {
+		userID, eventID, exerciseID, index string
+	}
+
+	eventDates := map[string]int64{}
+	instances := []instance{}
+	existing := map[string]bool{}
+	wanted := [][]byte{}
+
+	itOptions := badger.DefaultIteratorOptions
+	itOptions.PrefetchValues = false
+	prefix := []byte(userKey + ":")
+
+	err := c.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := string(it.Item().Key())
+			if activityIndexRx.MatchString(k) || typeIndexRx.MatchString(k) {
+				existing[k] = true
+			} else if parts := eventRx.FindStringSubmatch(k); parts != nil {
+				date, err := strconv.ParseInt(parts[2], 10, 64)
+				if err != nil {
+					return fmt.Errorf("bad event date: %w", err)
+				}
+				eventDates[parts[1]+"#"+parts[3]] = date
+				wanted = append(wanted, activityIndex(parts[1], parts[4], parts[3], date))
+			} else if parts := instanceRx.FindStringSubmatch(k); parts != nil {
+				instances = append(instances, instance{userID: parts[1], eventID: parts[2], exerciseID: parts[3], index: parts[4]})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+
+	for _, i := range instances {
+		date, ok := eventDates[i.userID+"#"+i.eventID]
+		if !ok {
+			continue
+		}
+		wanted = append(wanted, typeIndex(i.userID, i.exerciseID, i.eventID, date, i.index))
+	}
+
+	updates := []*badger.Entry{}
+	for _, k := range wanted {
+		if !existing[string(k)] {
+			updates = append(updates, badger.NewEntry(k, []byte{}))
+		}
+	}
+
+	if err := writeUpdatesInBatches(c, updates); err != nil {
+		return fmt.Errorf("failed to write event index: %w", err)
+	}
+
+	return nil
+}
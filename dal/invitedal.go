@@ -0,0 +1,93 @@
+package dal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const (
+	inviteKey = "invite"
+)
+
+// AddInvite stores a signup invite code and the time that it expires.
+func (c *DBClient) AddInvite(code string, expires int64) error {
+	expiresBytes, err := int64ToBSlice(expires)
+	if err != nil {
+		return fmt.Errorf("failed to convert expiry to byte slice: %w", err)
+	}
+
+	entry := badger.NewEntry(key([]string{inviteKey, code, expiresKey}), expiresBytes)
+	if err := writeUpdates(c, []*badger.Entry{entry}); err != nil {
+		return fmt.Errorf("failed to add invite: %w", err)
+	}
+
+	return nil
+}
+
+// GetInvites returns the expiry times of all invite codes, keyed by code.
+func (c *DBClient) GetInvites() (map[string]int64, error) {
+	rxp := regexp.MustCompile(fmt.Sprintf("^%s:([^#]+)#%s$", inviteKey, expiresKey))
+
+	entries, err := readKeyPrefix(c, []byte(inviteKey+":"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read invites: %w", err)
+	}
+
+	invites := map[string]int64{}
+	for _, e := range entries {
+		parts := rxp.FindStringSubmatch(string(e.Key))
+		if parts == nil {
+			continue
+		}
+		expires, err := bSliceToInt64(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read invite: %w", err)
+		}
+		invites[parts[1]] = *expires
+	}
+
+	return invites, nil
+}
+
+// ClaimInvite deletes an invite code and returns the time that it expires.
+// Returns nil when the invite code is not found.
+// An invite code can be claimed only once.
+func (c *DBClient) ClaimInvite(code string) (*int64, error) {
+	var expires *int64
+	inviteItemKey := key([]string{inviteKey, code, expiresKey})
+
+	err := c.update(func(txn *badger.Txn) error {
+		item, err := txn.Get(inviteItemKey)
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if expires, err = bSliceToInt64(value); err != nil {
+			return err
+		}
+		return txn.Delete(inviteItemKey)
+	})
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim invite: %w", err)
+	}
+
+	return expires, nil
+}
+
+// DeleteInvite deletes an invite code.
+func (c *DBClient) DeleteInvite(code string) error {
+	if err := deleteItems(c, [][]byte{key([]string{inviteKey, code, expiresKey})}); err != nil {
+		return fmt.Errorf("failed to delete invite: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package dal
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+
+	"testing"
+)
+
+func TestInviteDal(t *testing.T) {
+	testCode := "TESTCODE"
+	testExpires := int64(1720181149)
+
+	defer cleanup()
+	Convey("Given a dal client", t, func() {
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+		defer client.Destroy()
+
+		Convey("When we add invites", func() {
+			So(client.AddInvite(testCode, testExpires), ShouldBeNil)
+			So(client.AddInvite(testCode+"2", testExpires+1), ShouldBeNil)
+
+			Convey("Then the invites are returned", func() {
+				invites, err := client.GetInvites()
+				So(err, ShouldBeNil)
+				So(invites, ShouldResemble, map[string]int64{testCode: testExpires, testCode + "2": testExpires + 1})
+			})
+		})
+
+		Convey("When we claim an invite", func() {
+			expires, err := client.ClaimInvite(testCode)
+
+			Convey("Then the expiry is returned and the invite cannot be claimed again", func() {
+				So(err, ShouldBeNil)
+				So(*expires, ShouldEqual, testExpires)
+
+				again, err := client.ClaimInvite(testCode)
+				So(err, ShouldBeNil)
+				So(again, ShouldBeNil)
+			})
+		})
+
+		Convey("When we delete an invite", func() {
+			err := client.DeleteInvite(testCode + "2")
+
+			Convey("Then the invite is not returned", func() {
+				So(err, ShouldBeNil)
+
+				invites, err := client.GetInvites()
+				So(err, ShouldBeNil)
+				So(invites, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,87 @@
+package dal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const (
+	loginFailureKey = "loginfailure"
+)
+
+// LoginFailures records the failed login attempts for a user name or a remote address.
+// Kind identifies what the ID is.
+// Times are in seconds since the epoch.
+type LoginFailures struct {
+	Kind        string
+	ID          string
+	Count       int64
+	Last        int64
+	LockedUntil int64
+}
+
+// GetLoginFailures returns the failed login attempts for an ID.
+// Returns nil when there are none.
+func (c *DBClient) GetLoginFailures(kind, id string) (*LoginFailures, error) {
+	entry, err := readItem(c, key([]string{loginFailureKey, kind, idKey, id}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login failures: %w", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	failures := LoginFailures{}
+	if err := json.Unmarshal(entry.Value, &failures); err != nil {
+		return nil, fmt.Errorf("failed to read login failures: %w", err)
+	}
+
+	return &failures, nil
+}
+
+// SetLoginFailures stores the failed login attempts for an ID.
+// The record is deleted after the ttl.
+func (c *DBClient) SetLoginFailures(failures LoginFailures, ttl time.Duration) error {
+	value, err := json.Marshal(failures)
+	if err != nil {
+		return fmt.Errorf("failed to marshal login failures: %w", err)
+	}
+
+	entry := badger.NewEntry(key([]string{loginFailureKey, failures.Kind, idKey, failures.ID}), value).WithTTL(ttl)
+	if err := writeUpdates(c, []*badger.Entry{entry}); err != nil {
+		return fmt.Errorf("failed to store login failures: %w", err)
+	}
+
+	return nil
+}
+
+// ListLoginFailures returns all records of failed login attempts.
+func (c *DBClient) ListLoginFailures() ([]LoginFailures, error) {
+	entries, err := readKeyPrefix(c, []byte(loginFailureKey+":"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login failures: %w", err)
+	}
+
+	records := []LoginFailures{}
+	for _, e := range entries {
+		failures := LoginFailures{}
+		if err := json.Unmarshal(e.Value, &failures); err != nil {
+			return nil, fmt.Errorf("failed to read login failures: %w", err)
+		}
+		records = append(records, failures)
+	}
+
+	return records, nil
+}
+
+// DeleteLoginFailures deletes the failed login attempts for an ID.
+func (c *DBClient) DeleteLoginFailures(kind, id string) error {
+	if err := deleteItems(c, [][]byte{key([]string{loginFailureKey, kind, idKey, id})}); err != nil {
+		return fmt.Errorf("failed to delete login failures: %w", err)
+	}
+
+	return nil
+}
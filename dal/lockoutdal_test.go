@@ -0,0 +1,57 @@
+package dal
+
+import (
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"testing"
+)
+
+func TestLockoutDal(t *testing.T) {
+	testFailures := LoginFailures{Kind: "user", ID: testUserID, Count: 3, Last: 1720181149, LockedUntil: 1720181150}
+	addressFailures := LoginFailures{Kind: "address", ID: "192.0.2.1", Count: 1, Last: 1720181149}
+
+	defer cleanup()
+	Convey("Given a dal client", t, func() {
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+		defer client.Destroy()
+
+		Convey("When we get login failures that are not recorded", func() {
+			failures, err := client.GetLoginFailures("user", testUserID)
+
+			So(err, ShouldBeNil)
+			So(failures, ShouldBeNil)
+		})
+
+		Convey("When we store login failures", func() {
+			So(client.SetLoginFailures(testFailures, time.Hour), ShouldBeNil)
+			So(client.SetLoginFailures(addressFailures, time.Hour), ShouldBeNil)
+
+			Convey("Then the login failures are returned", func() {
+				failures, err := client.GetLoginFailures("user", testUserID)
+				So(err, ShouldBeNil)
+				So(*failures, ShouldResemble, testFailures)
+
+				all, err := client.ListLoginFailures()
+				So(err, ShouldBeNil)
+				So(all, ShouldResemble, []LoginFailures{addressFailures, testFailures})
+			})
+		})
+
+		Convey("When we delete login failures", func() {
+			err := client.DeleteLoginFailures("user", testUserID)
+
+			Convey("Then the login failures are not returned", func() {
+				So(err, ShouldBeNil)
+
+				failures, err := client.GetLoginFailures("user", testUserID)
+				So(err, ShouldBeNil)
+				So(failures, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,205 @@
+package dal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const schemaVersionKey = "schemaversion"
+
+// MigrationBackupDir is the directory where pre-migration backups are saved.
+// Set it to the backup directory of the configuration before the client is initialized.
+// A relative directory is relative to the database path. The default is a directory next to the database directory
+// so that backups are not saved with the database files.
+var MigrationBackupDir = filepath.Join("..", "backups")
+
+// A migration changes the layout of stored items from the previous schema version.
+// Migrations can write in several transactions. When a migration fails the database is restored
+// from the pre-migration backup, so a migration never leaves the database partly migrated.
+type migration struct {
+	version     int
+	description string
+	migrate     func(c *DBClient) error
+}
+
+// migrations are run in order of version.
+// Append new migrations to the end and never change the version of a released migration.
+var migrations = []migration{
+	{version: 1, description: "index events by activity and exercise type", migrate: indexEvents},
+	{version: 2, description: "index sessions by user", migrate: indexSessions},
+}
+
+// latestSchemaVersion returns the schema version that results from running all migrations.
+func latestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the schema version of the database.
+// Databases created before schemas were versioned have a version of 0.
+func (c *DBClient) SchemaVersion() (int, error) {
+	entry, err := readItem(c, []byte(schemaVersionKey))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if entry == nil {
+		return 0, nil
+	}
+
+	version, err := bSliceToInt64(entry.Value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return int(*version), nil
+}
+
+// migrate runs the migrations that have not yet been applied to the database.
+// When backup is true, a backup of the database is saved before the first pending migration runs
+// and the database is restored from the backup when a migration fails.
+// Databases that are discarded when migrations fail, such as a restore that is being validated, are not backed up.
+// The schema version is updated after each migration succeeds.
+func migrate(c *DBClient, backup bool) error {
+	current, err := c.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := latestSchemaVersion()
+	if current >= latest {
+		return nil
+	}
+
+	empty, err := isEmpty(c)
+	if err != nil {
+		return err
+	}
+
+	// new databases have nothing to migrate
+	if empty {
+		return c.update(func(txn *badger.Txn) error {
+			return setSchemaVersion(txn, latest)
+		})
+	}
+
+	backupPath := ""
+	if backup {
+		backupPath, err = preMigrationBackup(c, current)
+		if err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		slog.Info("migrating database", "version", m.version, "description", m.description)
+
+		err := m.migrate(c)
+		if err != nil {
+			err = fmt.Errorf("migration to version %d failed: %w", m.version, err)
+		} else {
+			err = c.update(func(txn *badger.Txn) error {
+				return setSchemaVersion(txn, m.version)
+			})
+			if err != nil {
+				err = fmt.Errorf("failed to update schema version to %d: %w", m.version, err)
+			}
+		}
+
+		if err != nil {
+			if backupPath == "" {
+				return err
+			}
+			if rollbackErr := rollbackMigrations(c, backupPath); rollbackErr != nil {
+				return errors.Join(err, fmt.Errorf("failed to restore pre-migration backup %s: %w", backupPath, rollbackErr))
+			}
+			slog.Warn("restored the database from the pre-migration backup", "version", current, "file", backupPath)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollbackMigrations replaces the items of the database with the items of a pre-migration backup.
+func rollbackMigrations(c *DBClient, backupPath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.db.DropAll(); err != nil {
+		return err
+	}
+
+	return loadBackup(c.db, backupPath)
+}
+
+func setSchemaVersion(txn *badger.Txn, version int) error {
+	value, err := int64ToBSlice(int64(version))
+	if err != nil {
+		return err
+	}
+
+	return txn.Set([]byte(schemaVersionKey), value)
+}
+
+// isEmpty returns true when the database has no items.
+func isEmpty(c *DBClient) (bool, error) {
+	empty := true
+	itOptions := badger.DefaultIteratorOptions
+	itOptions.PrefetchValues = false
+	err := c.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read database: %w", err)
+	}
+
+	return empty, nil
+}
+
+// preMigrationBackup saves a full backup of the database and returns the path of the backup file.
+// The file name includes the schema version of the backed-up database.
+func preMigrationBackup(c *DBClient, version int) (string, error) {
+	dir := MigrationBackupDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.path, dir)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", err
+	}
+
+	filePath := filepath.Join(dir, fmt.Sprintf("premigration_v%d_%d.bak", version, time.Now().Unix()))
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := c.backup(file, 0); err != nil {
+		return "", err
+	}
+
+	if err := file.Sync(); err != nil {
+		return "", err
+	}
+
+	slog.Info("saved pre-migration backup", "file", filePath)
+
+	return filePath, nil
+}
@@ -0,0 +1,158 @@
+package dal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrations(t *testing.T) {
+	defer cleanup()
+	Convey("Given a new database", t, func() {
+		cleanup()
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+
+		Convey("Then the schema version is the latest version", func() {
+			version, err := client.SchemaVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, latestSchemaVersion())
+
+			_, err = os.Stat(filepath.Join(testPath, MigrationBackupDir))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			client.Destroy()
+		})
+
+		Convey("When a migration is pending", func() {
+			err := client.AddActivity(testUserID, testActivityID, testActivityName)
+			So(err, ShouldBeNil)
+
+			err = deleteItems(client, [][]byte{[]byte(schemaVersionKey)})
+			So(err, ShouldBeNil)
+			client.Destroy()
+
+			testKey := []byte("testmigration")
+			registered := migrations
+			defer func() { migrations = registered }()
+			migrations = append(migrations, migration{
+				version:     latestSchemaVersion() + 1,
+				description: "test migration",
+				migrate: func(c *DBClient) error {
+					return writeUpdates(c, []*badger.Entry{badger.NewEntry(testKey, []byte("migrated"))})
+				},
+			})
+
+			client, err := InitClient(testPath)
+			So(err, ShouldBeNil)
+			defer client.Destroy()
+
+			Convey("Then the migrations run and the schema version is updated", func() {
+				version, err := client.SchemaVersion()
+				So(err, ShouldBeNil)
+				So(version, ShouldEqual, latestSchemaVersion())
+
+				entry, err := readItem(client, testKey)
+				So(err, ShouldBeNil)
+				So(string(entry.Value), ShouldEqual, "migrated")
+			})
+
+			Convey("Then a pre-migration backup is saved outside of the database directory", func() {
+				backups, err := filepath.Glob(filepath.Join(testPath, MigrationBackupDir, "premigration_v0_*.bak"))
+				So(err, ShouldBeNil)
+				So(len(backups), ShouldEqual, 1)
+
+				dir, err := filepath.Rel(testPath, backups[0])
+				So(err, ShouldBeNil)
+				So(dir, ShouldStartWith, "..")
+			})
+		})
+
+		Convey("When a migration fails after it writes", func() {
+			err := client.AddActivity(testUserID, testActivityID, testActivityName)
+			So(err, ShouldBeNil)
+
+			err = deleteItems(client, [][]byte{[]byte(schemaVersionKey)})
+			So(err, ShouldBeNil)
+			client.Destroy()
+
+			testKey := []byte("testmigration")
+			registered := migrations
+			defer func() { migrations = registered }()
+			migrations = append(migrations, migration{
+				version:     latestSchemaVersion() + 1,
+				description: "failed test migration",
+				migrate: func(c *DBClient) error {
+					if err := writeUpdates(c, []*badger.Entry{badger.NewEntry(testKey, []byte("migrated"))}); err != nil {
+						return err
+					}
+					return errors.New("test failure")
+				},
+			})
+
+			_, err = InitClient(testPath)
+			So(err, ShouldNotBeNil)
+
+			Convey("Then the database is restored from the pre-migration backup", func() {
+				migrations = registered
+				client, err := InitClient(testPath)
+				So(err, ShouldBeNil)
+				defer client.Destroy()
+
+				entry, err := readItem(client, testKey)
+				So(err, ShouldBeNil)
+				So(entry, ShouldBeNil)
+
+				name, _, err := client.ReadActivity(testUserID, testActivityID)
+				So(err, ShouldBeNil)
+				So(*name, ShouldEqual, testActivityName)
+
+				version, err := client.SchemaVersion()
+				So(err, ShouldBeNil)
+				So(version, ShouldEqual, latestSchemaVersion())
+			})
+		})
+
+		Convey("When the migrations of a restore are validated", func() {
+			err := client.AddActivity(testUserID, testActivityID, testActivityName)
+			So(err, ShouldBeNil)
+
+			err = deleteItems(client, [][]byte{[]byte(schemaVersionKey)})
+			So(err, ShouldBeNil)
+
+			err = migrate(client, false)
+			client.Destroy()
+
+			Convey("Then the migrations run without a pre-migration backup", func() {
+				So(err, ShouldBeNil)
+
+				_, err = os.Stat(filepath.Join(testPath, MigrationBackupDir))
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the backup directory is an absolute path", func() {
+			defer client.Destroy()
+
+			dir := t.TempDir()
+			defaultDir := MigrationBackupDir
+			MigrationBackupDir = dir
+			defer func() { MigrationBackupDir = defaultDir }()
+
+			backupPath, err := preMigrationBackup(client, 1)
+
+			Convey("Then the pre-migration backup is saved in the directory", func() {
+				So(err, ShouldBeNil)
+				So(filepath.Dir(backupPath), ShouldEqual, dir)
+				backups, err := filepath.Glob(filepath.Join(dir, "premigration_v1_*.bak"))
+				So(err, ShouldBeNil)
+				So(len(backups), ShouldEqual, 1)
+			})
+		})
+	})
+}
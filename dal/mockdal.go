@@ -1,6 +1,8 @@
 package dal
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -56,6 +58,21 @@ func (d *MockDal) UpdateActivityExercises(userID, activityID string, exIDsToAdd,
 	return args.Error(0)
 }
 
+func (d *MockDal) HasActivityEvents(userID, activityID string) (bool, error) {
+	args := d.Called(userID, activityID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (d *MockDal) DeleteActivity(userID, activityID string) error {
+	args := d.Called(userID, activityID)
+	return args.Error(0)
+}
+
+func (d *MockDal) MergeActivities(userID, srcActivityID, dstActivityID string, rewrite func([]byte) ([]byte, error)) error {
+	args := d.Called(userID, srcActivityID, dstActivityID, rewrite)
+	return args.Error(0)
+}
+
 func (d *MockDal) ReadUser(id string) (*string, *string, *string, *string, error) {
 	args := d.Called(id)
 	if args.Error(4) != nil {
@@ -89,8 +106,8 @@ func (d *MockDal) ChangeUserRole(id, role string) error {
 	}
 	return nil
 }
-func (d *MockDal) UpdatePwdVersion(userID, version string) error {
-	args := d.Called(userID, version)
+func (d *MockDal) UpdatePwdVersion(userID, pwdHash, version string) error {
+	args := d.Called(userID, pwdHash, version)
 
 	if args.Error(0) != nil {
 		return args.Error(0)
@@ -130,6 +147,16 @@ func (d *MockDal) GetExercises(userID string) ([][]byte, error) {
 	return args.Get(0).([][]byte), args.Error(1)
 }
 
+func (d *MockDal) DeleteExercise(userID, exerciseID string) error {
+	args := d.Called(userID, exerciseID)
+	return args.Error(0)
+}
+
+func (d *MockDal) HasExerciseInstances(userID, exerciseID string) (bool, error) {
+	args := d.Called(userID, exerciseID)
+	return args.Bool(0), args.Error(1)
+}
+
 func (d *MockDal) AddExerciseToActivity(userID, activityID, exerciseID string) error {
 	args := d.Called(userID, activityID, exerciseID)
 	if args.Error(0) != nil {
@@ -183,6 +210,26 @@ func (d *MockDal) GetEventPage(userID, previousEventID string, previousDate int6
 
 }
 
+func (d *MockDal) GetActivityEventPage(userID, activityID, previousEventID string, startDate, endDate int64, pageSize int) ([][]byte, error) {
+	args := d.Called(userID, activityID, previousEventID, startDate, endDate, pageSize)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([][]byte), nil
+}
+
+func (d *MockDal) GetExerciseInstancePage(userID, exerciseID, previousEventID string, startDate, endDate int64, pageSize int) ([]int64, []string, [][]byte, error) {
+	args := d.Called(userID, exerciseID, previousEventID, startDate, endDate, pageSize)
+
+	if args.Error(3) != nil {
+		return nil, nil, nil, args.Error(3)
+	}
+
+	return args.Get(0).([]int64), args.Get(1).([]string), args.Get(2).([][]byte), nil
+}
+
 func (d *MockDal) GetEventExercises(userID, eventID string) ([][]byte, error) {
 	args := d.Called(userID, eventID)
 
@@ -226,8 +273,8 @@ func (d *MockDal) DeleteKey(keyID, usage string) error {
 	return args.Error(0)
 }
 
-func (d *MockDal) AddSession(username, sessionID string, ttl int) error {
-	args := d.Called(username, sessionID)
+func (d *MockDal) AddSession(username, sessionID, userAgent string, ttl int) error {
+	args := d.Called(username, sessionID, userAgent)
 	return args.Error(0)
 }
 
@@ -244,6 +291,19 @@ func (d *MockDal) DeleteSession(sessionID string) error {
 	return args.Error(0)
 }
 
+func (d *MockDal) TouchSession(username, sessionID string, lastSeen int64) error {
+	args := d.Called(username, sessionID)
+	return args.Error(0)
+}
+
+func (d *MockDal) GetUserSessions(username string) ([]SessionInfo, error) {
+	args := d.Called(username)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]SessionInfo), nil
+}
+
 func (d *MockDal) GetSessionExpiries() (map[string]int64, error) {
 	args := d.Called()
 	if args.Error(1) == nil {
@@ -343,12 +403,6 @@ func (d *MockDal) Iter8er() {
 
 }
 
-func (d *MockDal) Restore(filename string) error {
-	args := d.Called(filename)
-
-	return args.Error(1)
-}
-
 func (d *MockDal) AddOneRM(userID, exerciseID string, value int) error {
 	args := d.Called(userID, exerciseID, value)
 	return args.Error(0)
@@ -374,3 +428,175 @@ func (d *MockDal) GetPR(userID, exerciseID string) (int, error) {
 	}
 	return args.Int(0), nil
 }
+
+func (d *MockDal) SetRecords(userID, exerciseID string, records []byte) error {
+	args := d.Called(userID, exerciseID, records)
+	return args.Error(0)
+}
+
+func (d *MockDal) GetRecords(userID, exerciseID string) ([]byte, error) {
+	args := d.Called(userID, exerciseID)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	if args.Get(0) == nil {
+		return nil, nil
+	}
+	return args.Get(0).([]byte), nil
+}
+
+func (d *MockDal) ListUsers() ([]string, error) {
+	args := d.Called()
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]string), nil
+}
+
+func (d *MockDal) ReadUserStatus(id string) (*UserStatus, error) {
+	args := d.Called(id)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*UserStatus), nil
+}
+
+func (d *MockDal) SetUserDisabled(id string, disabled bool) error {
+	args := d.Called(id, disabled)
+	return args.Error(0)
+}
+
+func (d *MockDal) SetPasswordChangeRequired(id string, required bool) error {
+	args := d.Called(id, required)
+	return args.Error(0)
+}
+
+func (d *MockDal) DeleteUser(id string) error {
+	args := d.Called(id)
+	return args.Error(0)
+}
+
+func (d *MockDal) AddInvite(code string, expires int64) error {
+	args := d.Called(code, expires)
+	return args.Error(0)
+}
+
+func (d *MockDal) GetInvites() (map[string]int64, error) {
+	args := d.Called()
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(map[string]int64), nil
+}
+
+func (d *MockDal) ClaimInvite(code string) (*int64, error) {
+	args := d.Called(code)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*int64), nil
+}
+
+func (d *MockDal) DeleteInvite(code string) error {
+	args := d.Called(code)
+	return args.Error(0)
+}
+
+func (d *MockDal) GetLoginFailures(kind, id string) (*LoginFailures, error) {
+	args := d.Called(kind, id)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*LoginFailures), nil
+}
+
+func (d *MockDal) SetLoginFailures(failures LoginFailures, ttl time.Duration) error {
+	args := d.Called(failures, ttl)
+	return args.Error(0)
+}
+
+func (d *MockDal) ListLoginFailures() ([]LoginFailures, error) {
+	args := d.Called()
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]LoginFailures), nil
+}
+
+func (d *MockDal) DeleteLoginFailures(kind, id string) error {
+	args := d.Called(kind, id)
+	return args.Error(0)
+}
+
+func (d *MockDal) ReadTOTP(userID string) (*TOTPInfo, error) {
+	args := d.Called(userID)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*TOTPInfo), nil
+}
+
+func (d *MockDal) SetTOTPSecret(userID string, secret []byte) error {
+	args := d.Called(userID, secret)
+	return args.Error(0)
+}
+
+func (d *MockDal) EnableTOTP(userID string, recoveryCodeHashes []string) error {
+	args := d.Called(userID, recoveryCodeHashes)
+	return args.Error(0)
+}
+
+func (d *MockDal) SetTOTPStep(userID string, step int64) error {
+	args := d.Called(userID, step)
+	return args.Error(0)
+}
+
+func (d *MockDal) UseRecoveryCode(userID, hash string) (bool, error) {
+	args := d.Called(userID, hash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (d *MockDal) DeleteTOTP(userID string) error {
+	args := d.Called(userID)
+	return args.Error(0)
+}
+
+func (d *MockDal) AddAPIToken(hash string, token APITokenRecord) error {
+	args := d.Called(hash, token)
+	return args.Error(0)
+}
+
+func (d *MockDal) GetAPIToken(hash string) (*APITokenRecord, error) {
+	args := d.Called(hash)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*APITokenRecord), nil
+}
+
+func (d *MockDal) GetUserAPITokens(userID string) ([]APITokenRecord, error) {
+	args := d.Called(userID)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]APITokenRecord), nil
+}
+
+func (d *MockDal) TouchAPIToken(hash string, lastUsed int64) error {
+	args := d.Called(hash, lastUsed)
+	return args.Error(0)
+}
+
+func (d *MockDal) DeleteAPIToken(userID, tokenID string) error {
+	args := d.Called(userID, tokenID)
+	return args.Error(0)
+}
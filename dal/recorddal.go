@@ -0,0 +1,38 @@
+package dal
+
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const (
+	recordKey = "record"
+)
+
+// SetRecords replaces the personal record history of an exercise type.
+// user:{id}#record:{exerciseID}
+func (c *DBClient) SetRecords(userID, exerciseID string, records []byte) error {
+	entry := badger.NewEntry(key([]string{userKey, userID, recordKey, exerciseID}), records)
+
+	if err := writeUpdates(c, []*badger.Entry{entry}); err != nil {
+		return fmt.Errorf("failed to store records: %w", err)
+	}
+
+	return nil
+}
+
+// GetRecords returns the personal record history of an exercise type.
+// Returns nil when no history is stored.
+func (c *DBClient) GetRecords(userID, exerciseID string) ([]byte, error) {
+	entry, err := readItem(c, key([]string{userKey, userID, recordKey, exerciseID}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	return entry.Value, nil
+}
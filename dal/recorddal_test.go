@@ -0,0 +1,50 @@
+package dal
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+
+	"testing"
+)
+
+func TestRecordDal(t *testing.T) {
+	testRecords := []byte("test records")
+
+	defer cleanup()
+	Convey("Given a dal client", t, func() {
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+		defer client.Destroy()
+
+		Convey("When we get records that were not stored", func() {
+			records, err := client.GetRecords(testUserID, testExerciseID)
+
+			So(err, ShouldBeNil)
+			So(records, ShouldBeNil)
+		})
+
+		Convey("When we store records", func() {
+			err := client.SetRecords(testUserID, testExerciseID, testRecords)
+			So(err, ShouldBeNil)
+
+			Convey("Then we can read them", func() {
+				records, err := client.GetRecords(testUserID, testExerciseID)
+
+				So(err, ShouldBeNil)
+				So(records, ShouldResemble, testRecords)
+			})
+
+			Convey("And when we delete the exercise type", func() {
+				So(client.DeleteExercise(testUserID, testExerciseID), ShouldBeNil)
+
+				Convey("Then the records are removed", func() {
+					records, err := client.GetRecords(testUserID, testExerciseID)
+
+					So(err, ShouldBeNil)
+					So(records, ShouldBeNil)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,154 @@
+package dal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// A RestoreSummary describes a restored database.
+type RestoreSummary struct {
+	BackupID string `json:"backupId"`
+	Users    int    `json:"users"`
+	Events   int    `json:"events"`
+}
+
+// backup writes a backup of the item versions after since.
+func (c *DBClient) backup(w io.Writer, since uint64) (uint64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.db.Backup(w, since)
+}
+
+// validateRestore opens a restored database, runs pending migrations without a pre-migration backup,
+// and counts its users and events.
+// A database without users is not valid.
+func validateRestore(dir string) (*RestoreSummary, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
+	restored := &DBClient{db: db, path: dir}
+	defer restored.Destroy()
+
+	// the restored database is discarded when a migration fails, so it is not backed up
+	if err := migrate(restored, false); err != nil {
+		return nil, fmt.Errorf("failed to migrate restored database: %w", err)
+	}
+
+	// user:{id}#id and user:{id}#event:{date}#id:{id}#activity:{activityID}
+	userRx := regexp.MustCompile(fmt.Sprintf("^%s:[^#]+#%s$", userKey, idKey))
+	eventRx := regexp.MustCompile(fmt.Sprintf("^%s:[^#]+#%s:[^#]+#%s:[^#]+#%s:[^#]+$", userKey, eventKey, idKey, activityKey))
+
+	summary := RestoreSummary{}
+	prefix := []byte(userKey + ":")
+	err = restored.view(func(txn *badger.Txn) error {
+		itOptions := badger.DefaultIteratorOptions
+		itOptions.PrefetchValues = false
+		it := txn.NewIterator(itOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().Key()
+			if userRx.Match(k) {
+				summary.Users++
+			} else if eventRx.Match(k) {
+				summary.Events++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restored database: %w", err)
+	}
+
+	if summary.Users == 0 {
+		return nil, fmt.Errorf("%w: the backup has no users", ErrInvalidBackup)
+	}
+
+	return &summary, nil
+}
+
+// tempDir creates a directory next to the database directory, on the same file system,
+// so that files can be moved between the two without being read as part of the database.
+func (c *DBClient) tempDir(pattern string) (string, error) {
+	path := filepath.Clean(c.path)
+	return os.MkdirTemp(filepath.Dir(path), fmt.Sprintf("%s-%s", filepath.Base(path), pattern))
+}
+
+// swap replaces the database files with the database files in a directory and reopens the database.
+// Subdirectories of the database directory, such as backups, are not changed.
+// The previous files are moved back if the swap fails.
+func (c *DBClient) swap(dir string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.db.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+
+	replacedDir, err := c.tempDir("replaced-")
+	if err != nil {
+		return errors.Join(err, c.reopen())
+	}
+	defer func() {
+		// the directory is only empty when the previous files were moved back
+		if err != nil {
+			os.Remove(replacedDir)
+		}
+	}()
+
+	if err := moveFiles(c.path, replacedDir); err != nil {
+		return errors.Join(err, moveFiles(replacedDir, c.path), c.reopen())
+	}
+
+	if err := moveFiles(dir, c.path); err != nil {
+		return errors.Join(err, moveFiles(c.path, dir), moveFiles(replacedDir, c.path), c.reopen())
+	}
+
+	if err := c.reopen(); err != nil {
+		return errors.Join(err, moveFiles(c.path, dir), moveFiles(replacedDir, c.path), c.reopen())
+	}
+
+	if err := os.RemoveAll(replacedDir); err != nil {
+		slog.Error("failed to remove replaced database files", "dir", replacedDir, "error", err.Error())
+	}
+
+	return nil
+}
+
+func (c *DBClient) reopen() error {
+	db, err := badger.Open(badger.DefaultOptions(c.path))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	c.db = db
+
+	return nil
+}
+
+// moveFiles moves the files of a directory, but not its subdirectories, to another directory.
+func moveFiles(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
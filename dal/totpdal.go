@@ -0,0 +1,172 @@
+package dal
+
+import (
+	"errors"
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const (
+	totpSecretKey   = "totpsecret"
+	totpEnabledKey  = "totpenabled"
+	totpStepKey     = "totpstep"
+	recoveryCodeKey = "recoverycode"
+)
+
+// A TOTPInfo describes the two-factor authentication state of a user.
+// Secret is the encrypted TOTP secret.
+// LastStep is the time step of the last code that was accepted so that codes cannot be reused.
+type TOTPInfo struct {
+	Secret        []byte
+	Enabled       bool
+	LastStep      int64
+	RecoveryCodes int
+}
+
+// ReadTOTP returns the two-factor authentication state of a user.
+// Returns nil when the user has not enrolled.
+func (c *DBClient) ReadTOTP(userID string) (*TOTPInfo, error) {
+	prefix := []string{userKey, userID}
+
+	secret, err := readItem(c, key(append(prefix, totpSecretKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read totp secret: %w", err)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+
+	info := TOTPInfo{Secret: secret.Value}
+
+	enabled, err := readItem(c, key(append(prefix, totpEnabledKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read totp status: %w", err)
+	}
+	info.Enabled = enabled != nil
+
+	step, err := readItem(c, key(append(prefix, totpStepKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read totp step: %w", err)
+	}
+	if step != nil {
+		lastStep, err := bSliceToInt64(step.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read totp step: %w", err)
+		}
+		info.LastStep = *lastStep
+	}
+
+	codes, err := readKeys(c, keyPrefix(append(prefix, recoveryCodeKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recovery codes: %w", err)
+	}
+	info.RecoveryCodes = len(codes)
+
+	return &info, nil
+}
+
+// SetTOTPSecret stores the encrypted TOTP secret of a user that is enrolling.
+// Two-factor authentication is not enabled until EnableTOTP is called.
+func (c *DBClient) SetTOTPSecret(userID string, secret []byte) error {
+	deletes, err := totpKeys(c, userID)
+	if err != nil {
+		return err
+	}
+
+	entry := badger.NewEntry(key([]string{userKey, userID, totpSecretKey}), secret)
+	if err := updateDeleteItems(c, []*badger.Entry{entry}, deletes); err != nil {
+		return fmt.Errorf("failed to store totp secret: %w", err)
+	}
+
+	return nil
+}
+
+// EnableTOTP enables two-factor authentication for a user and stores the hashes of their recovery codes.
+// Existing recovery codes are replaced.
+func (c *DBClient) EnableTOTP(userID string, recoveryCodeHashes []string) error {
+	prefix := []string{userKey, userID}
+
+	existing, err := readKeys(c, keyPrefix(append(prefix, recoveryCodeKey)))
+	if err != nil {
+		return fmt.Errorf("failed to read recovery codes: %w", err)
+	}
+
+	updates := []*badger.Entry{badger.NewEntry(key(append(prefix, totpEnabledKey)), []byte("true"))}
+	for _, hash := range recoveryCodeHashes {
+		updates = append(updates, badger.NewEntry(key(append(prefix, recoveryCodeKey, hash)), []byte{}))
+	}
+
+	if err := updateDeleteItems(c, updates, existing); err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	return nil
+}
+
+// SetTOTPStep records the time step of the last TOTP code that was accepted for a user.
+func (c *DBClient) SetTOTPStep(userID string, step int64) error {
+	stepBytes, err := int64ToBSlice(step)
+	if err != nil {
+		return fmt.Errorf("failed to convert step to byte slice: %w", err)
+	}
+
+	entry := badger.NewEntry(key([]string{userKey, userID, totpStepKey}), stepBytes)
+	if err := writeUpdates(c, []*badger.Entry{entry}); err != nil {
+		return fmt.Errorf("failed to store totp step: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode deletes the recovery code of a user with the given hash.
+// Returns false when the user has no such recovery code.
+func (c *DBClient) UseRecoveryCode(userID, hash string) (bool, error) {
+	codeKey := key([]string{userKey, userID, recoveryCodeKey, hash})
+
+	err := c.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(codeKey); err != nil {
+			return err
+		}
+		return txn.Delete(codeKey)
+	})
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return true, nil
+}
+
+// DeleteTOTP removes two-factor authentication from a user.
+func (c *DBClient) DeleteTOTP(userID string) error {
+	deletes, err := totpKeys(c, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteItems(c, deletes); err != nil {
+		return fmt.Errorf("failed to delete totp: %w", err)
+	}
+
+	return nil
+}
+
+// totpKeys returns the keys of all two-factor authentication items of a user.
+func totpKeys(c *DBClient, userID string) ([][]byte, error) {
+	prefix := []string{userKey, userID}
+	keys := [][]byte{
+		key(append(prefix, totpSecretKey)),
+		key(append(prefix, totpEnabledKey)),
+		key(append(prefix, totpStepKey)),
+	}
+
+	codes, err := readKeys(c, keyPrefix(append(prefix, recoveryCodeKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recovery codes: %w", err)
+	}
+
+	return append(keys, codes...), nil
+}
@@ -0,0 +1,79 @@
+package dal
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+
+	"testing"
+)
+
+func TestTOTPDal(t *testing.T) {
+	testSecret := []byte("encryptedsecret")
+	testHashes := []string{"0a1b", "2c3d"}
+
+	defer cleanup()
+	Convey("Given a dal client", t, func() {
+		client, err := InitClient(testPath)
+		if err != nil {
+			t.Fatal()
+		}
+		defer client.Destroy()
+
+		Convey("When we read totp of a user that has not enrolled", func() {
+			info, err := client.ReadTOTP(testUserID)
+
+			So(err, ShouldBeNil)
+			So(info, ShouldBeNil)
+		})
+
+		Convey("When we store a totp secret", func() {
+			So(client.SetTOTPSecret(testUserID, testSecret), ShouldBeNil)
+
+			Convey("Then totp is not enabled", func() {
+				info, err := client.ReadTOTP(testUserID)
+				So(err, ShouldBeNil)
+				So(*info, ShouldResemble, TOTPInfo{Secret: testSecret})
+			})
+		})
+
+		Convey("When we enable totp and record a step", func() {
+			So(client.EnableTOTP(testUserID, testHashes), ShouldBeNil)
+			So(client.SetTOTPStep(testUserID, 57000000), ShouldBeNil)
+
+			Convey("Then totp is enabled", func() {
+				info, err := client.ReadTOTP(testUserID)
+				So(err, ShouldBeNil)
+				So(*info, ShouldResemble, TOTPInfo{Secret: testSecret, Enabled: true, LastStep: 57000000, RecoveryCodes: 2})
+			})
+		})
+
+		Convey("When we use a recovery code", func() {
+			used, err := client.UseRecoveryCode(testUserID, testHashes[0])
+			So(err, ShouldBeNil)
+			So(used, ShouldBeTrue)
+
+			Convey("Then the code cannot be used again", func() {
+				used, err := client.UseRecoveryCode(testUserID, testHashes[0])
+				So(err, ShouldBeNil)
+				So(used, ShouldBeFalse)
+
+				info, err := client.ReadTOTP(testUserID)
+				So(err, ShouldBeNil)
+				So(info.RecoveryCodes, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When we delete totp", func() {
+			So(client.DeleteTOTP(testUserID), ShouldBeNil)
+
+			Convey("Then totp is not returned", func() {
+				info, err := client.ReadTOTP(testUserID)
+				So(err, ShouldBeNil)
+				So(info, ShouldBeNil)
+
+				used, err := client.UseRecoveryCode(testUserID, testHashes[1])
+				So(err, ShouldBeNil)
+				So(used, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -940,9 +940,10 @@ const deactivateProgramInstance = async (activityID, instanceID) => {
 // event param has no id if it is new
 /**
  * Adds or updates a workout event on the backend. When the event object contains an ID, the existing event is updated. No ID creates a new event.
+ * The ID of a new event is set on the event object.
  * @param {String} url The URL of the REST operation.
  * @param {Object} event The event object.
- * @returns The event object.
+ * @returns An array of the personal records that the event set.
  */
 const storeEvent = async (url, event) => {
   const headers = new Headers();
@@ -964,12 +965,10 @@ const storeEvent = async (url, event) => {
     throw new Error();
   }
 
-  if (resp.status != 204) {
-    const body = await resp.json();
-    event.id = body.id;
-  }
+  const body = await resp.json();
+  event.id = body.id;
 
-  return event;
+  return body.records || [];
 };
 
 /**
@@ -1007,6 +1006,86 @@ const deleteEvent = async (event) => {
   return;
 };
 
+/**
+ * The scopes that personal API tokens can have.
+ */
+const apiTokenScopes = [
+  'events:read',
+  'events:write',
+  'activities:read',
+  'activities:write',
+  'exercises:read',
+  'exercises:write',
+  'dailystats:read',
+  'dailystats:write',
+  'export:read',
+  'import:write',
+];
+
+/**
+ * Retrieves the personal API tokens of the user from the back end.
+ * @returns An array of token descriptions.
+ */
+const fetchAPITokens = async () => {
+  const resp = await fetch('/homegym/api/account/tokens', {
+    method: 'GET',
+    mode: 'same-origin',
+  });
+
+  if (resp.status == 401) {
+    throw new ErrNotLoggedIn('unauthorized fetch of api tokens');
+  } else if (resp.status < 200 || resp.status >= 300) {
+    throw new Error('failed to fetch api tokens');
+  }
+
+  return resp.json();
+};
+
+/**
+ * Creates a personal API token on the back end.
+ * @param {String} name The name of the token.
+ * @param {Array} scopes The scopes of the token.
+ * @param {Number} ttl The lifetime of the token in seconds, or 0 for a token that does not expire.
+ * @returns The token description with the token in the token property. The token cannot be retrieved again.
+ */
+const createAPIToken = async (name, scopes, ttl) => {
+  const headers = new Headers();
+  headers.set('content-type', 'application/json');
+
+  const options = {
+    method: 'POST',
+    body: JSON.stringify({ name: name, scopes: scopes, ttl: ttl }),
+    headers: headers,
+  };
+
+  const resp = await fetch('/homegym/api/account/tokens', options);
+
+  if (resp.status == 401) {
+    throw new ErrNotLoggedIn('unauthorized creation of api token');
+  } else if (resp.status < 200 || resp.status >= 300) {
+    const body = await resp.json();
+    throw new Error(body.message);
+  }
+
+  return resp.json();
+};
+
+/**
+ * Revokes a personal API token on the back end.
+ * @param {String} tokenID The ID of the token.
+ */
+const revokeAPIToken = async (tokenID) => {
+  const resp = await fetch(`/homegym/api/account/tokens/${tokenID}`, {
+    method: 'DELETE',
+  });
+
+  if (resp.status == 401) {
+    throw new ErrNotLoggedIn('unauthorized revocation of api token');
+  } else if (resp.status < 200 || resp.status >= 300) {
+    throw new Error('failed to revoke api token');
+  }
+};
+
 /**
  * Displays a toast notification in the UI to indicate a successful or failed operation.
  * @param {String} message The message to display on the toast
@@ -1233,6 +1312,10 @@ export {
   deactivateProgramInstance,
   storeEvent,
   deleteEvent,
+  apiTokenScopes,
+  fetchAPITokens,
+  createAPIToken,
+  revokeAPIToken,
   openVolumeModal,
   toast,
   openCompositionModal,
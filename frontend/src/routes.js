@@ -7,6 +7,7 @@ import ExerciseTypesPage from './components/ExerciseTypesPage.vue';
 import ProgramsPage from './components/ProgramsPage.vue';
 import AnalyzePage from './components/AnalyzePage.vue';
 import DownloadEventsPage from './components/DownloadEventsPage.vue';
+import TokensPage from './components/TokensPage.vue';
 
 const routes = [
   {
@@ -34,6 +35,11 @@ const routes = [
     components: { default: PageHeader, main: DownloadEventsPage },
     name: 'download',
   },
+  {
+    path: '/homegym/tokens/',
+    components: { default: PageHeader, main: TokensPage },
+    name: 'tokens',
+  },
   {
     path: '/homegym/event/:eventId?',
     components: { default: PageHeader, main: EventPage },
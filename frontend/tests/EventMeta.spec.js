@@ -22,6 +22,7 @@ describe('EventMeta component', () => {
   it('renders correctly with all meta in read only', () => {
     const testMeta = {
       overall: 4,
+      duration: 45,
       notes: 'test notes',
     };
 
@@ -29,6 +30,7 @@ describe('EventMeta component', () => {
       components: { QInput, QRating },
       props: {
         overall: testMeta.overall,
+        duration: testMeta.duration,
         notes: testMeta.notes,
         readonly: true,
       },
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scottbrodersen/homegym/certs"
+	"github.com/scottbrodersen/homegym/config"
+)
+
+// certCommand creates or renews the local CA and the server certificate in the files of the TLS configuration.
+func certCommand(args []string) error {
+	fs := flag.NewFlagSet("cert", flag.ExitOnError)
+	configFlag := fs.String("config", os.Getenv(config.ConfigFileEnv), "path to the configuration file")
+	forceFlag := fs.Bool("force", false, "create a new certificate even when the current certificate is valid")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configFlag)
+	if err != nil {
+		return err
+	}
+	if cfg.TLS.Cert == "" || cfg.TLS.Key == "" {
+		return fmt.Errorf("%w: tls cert and key are required", config.ErrInvalidConfig)
+	}
+
+	manager, err := certs.NewManager(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.Names)
+	if err != nil {
+		return err
+	}
+	if *forceFlag {
+		if err := manager.Renew(true); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("CA certificate:     %s\n", manager.CACertPath())
+	fmt.Printf("Server certificate: %s\n", cfg.TLS.Cert)
+	fmt.Printf("Expires:            %s\n", manager.Expires().Format("2006-01-02"))
+	fmt.Printf("Covers:             %s\n", strings.Join(manager.Names(), ", "))
+	fmt.Println()
+	fmt.Println("Install the CA certificate on your devices so that they trust the server.")
+	fmt.Println("When the server runs with tls.auto enabled, devices can download it from https://{server}/homegym/ca.crt")
+
+	return nil
+}
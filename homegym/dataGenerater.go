@@ -120,7 +120,7 @@ func newEvent(date int64, activity workoutlog.Activity) (*workoutlog.Event, erro
 
 	event.Exercises = exInstances
 
-	eventID, err := workoutlog.EventManager.NewEvent(username, event)
+	eventID, _, err := workoutlog.EventManager.NewEvent(username, event)
 	if err != nil {
 		return nil, err
 	}
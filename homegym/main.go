@@ -1,78 +1,147 @@
 /*
 homegym initializes a database client and starts the Home Gym server.
 The program runs in test mode or production mode according to a flag.
-When in test mode, the path to the database is provided as a flag.
-In production mode, the path is obtained from the HOMEGYM_DB_PATH environment variable.
 
 Usage:
 
-	homegym -testmode true|false [-path path]
+	homegym [-testmode] [-config file] [-path path] [-listen address]
+	homegym cert [-config file] [-force]
+
+The cert command creates a local certificate authority (CA) and a server certificate that it signs
+in the files of the TLS configuration, or renews the certificate when it expires soon or does not
+cover the host. The certificate covers localhost, the mDNS name of the host ({hostname}.local),
+the IP addresses of the host, and the names in tls.names. The CA certificate is saved as
+homegym-ca.crt in the directory of the server certificate. Use -force to create a new certificate.
 
 The flags are:
 
 	-testmode
-		Set to true to run in test mode and false otherwise.
-		When true, you must provide a path.
-		When false, the path must be stored in the environment variable.
+		Run in test mode. The test database is used and test data is added to it.
+		The server uses HTTP on port 3000.
+	-config
+		The path to the YAML configuration file. The default is the value of the
+		HOMEGYM_CONFIG environment variable. When neither is set, no file is read.
 	-path
-		For testmode, the relative path to the database.
+		The path to the database. Overrides the configuration.
+	-listen
+		The address that the server listens on, in host:port format. Overrides the configuration.
+
+The configuration file sets the following values. Each value is overridden by
+the environment variable that follows it:
+
+	dbPath                    HOMEGYM_DB_PATH
+	listen                    HOMEGYM_LISTEN                default 0.0.0.0:443
+	tls.enabled               HOMEGYM_TLS                   default true
+	tls.cert                  HOMEGYM_TLS_CERT              default ../homegym.crt
+	tls.key                   HOMEGYM_TLS_KEY               default ../homegym.key
+	tls.auto                  HOMEGYM_TLS_AUTO              default false
+	tls.names                 HOMEGYM_TLS_NAMES             comma-separated
+	auth.tokenTTL             HOMEGYM_TOKEN_TTL             default 30m
+	auth.sessionTTL           HOMEGYM_SESSION_TTL           default 24h
+	auth.keyRotationTime      HOMEGYM_KEY_ROTATION_TIME     default 10:00:00 (UTC)
+	auth.minPasswordLength    HOMEGYM_MIN_PASSWORD_LENGTH   default 10, at least 8
+	backup.dir                HOMEGYM_BACKUP_DIR            default ../backups, relative to the database path
+	backup.time               HOMEGYM_BACKUP_TIME           default 00:00:00 (local time)
+	backup.fullInterval       HOMEGYM_BACKUP_FULL_INTERVAL  default 168h
+	backup.retention.daily    HOMEGYM_BACKUP_KEEP_DAILY     default 7
+	backup.retention.weekly   HOMEGYM_BACKUP_KEEP_WEEKLY    default 4
+	backup.retention.monthly  HOMEGYM_BACKUP_KEEP_MONTHLY   default 12
+	backup.maxUpload          HOMEGYM_BACKUP_MAX_UPLOAD     default 1024 (MiB)
+	load.acwrLow              HOMEGYM_ACWR_LOW              default 0.8
+	load.acwrHigh             HOMEGYM_ACWR_HIGH             default 1.3
+	logLevel                  HOMEGYM_LOG_LEVEL             debug, info, warn, or error; default info
+	signup                    HOMEGYM_SIGNUP                open, closed, or invite; default open
+
+When tls.auto is true the server runs the cert command at startup, checks every day whether the
+certificate needs renewal, and serves the CA certificate at /homegym/ca.crt so that devices can install it.
+
+The configuration is validated at startup and the program exits when it is invalid.
+
+When the database has no users, an admin user is created from the
+HOMEGYM_ADMIN_USER, HOMEGYM_ADMIN_EMAIL, and HOMEGYM_ADMIN_PASSWORD environment variables.
+The password can instead be read from the file at HOMEGYM_ADMIN_PASSWORD_FILE.
+When these are not set, the first user to sign up becomes an admin.
+
+Earlier versions created an admin user named admin with a fixed password. When that user still has the
+fixed password, it is disabled at startup. To keep using it, set HOMEGYM_ADMIN_USER to admin and set an
+admin password; the password is reset to it and must be changed after the next login.
 */
 package main
 
 import (
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
-
-	"path/filepath"
+	"strings"
 
 	"log"
 
 	"github.com/scottbrodersen/homegym/admin"
 	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/certs"
+	"github.com/scottbrodersen/homegym/config"
 	"github.com/scottbrodersen/homegym/dal"
 	"github.com/scottbrodersen/homegym/server"
 	"github.com/scottbrodersen/homegym/workoutlog"
 )
 
 const (
-	dbPathEnv  = "HOMEGYM_DB_PATH"
-	testDBPath = "./../server/testDB"
+	adminUserEnv         = "HOMEGYM_ADMIN_USER"
+	adminEmailEnv        = "HOMEGYM_ADMIN_EMAIL"
+	adminPasswordEnv     = "HOMEGYM_ADMIN_PASSWORD"
+	adminPasswordFileEnv = "HOMEGYM_ADMIN_PASSWORD_FILE"
+	testDBPath           = "./../server/testDB"
+	testListen           = "0.0.0.0:3000"
 )
 
-var DailyBackupFile string
-
 func main() {
 	var currentLogLevel = slog.SetLogLoggerLevel(slog.LevelInfo)
 	defer slog.SetLogLoggerLevel(currentLogLevel)
 
+	if len(os.Args) > 1 && os.Args[1] == "cert" {
+		if err := certCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	configFlag := flag.String("config", os.Getenv(config.ConfigFileEnv), "path to the configuration file")
 	pathFlag := flag.String("path", "", "path to the homegym database")
+	listenFlag := flag.String("listen", "", "address to listen on, in host:port format")
 	testModeFlag := flag.Bool("testmode", false, "run in test mode")
 
 	flag.Parse()
 
-	dbPath := ""
-	port := 0
-
-	switch *testModeFlag {
-	case false:
-		dbPath = *pathFlag
-		if dbPath == "" {
-			dbPath = os.Getenv(dbPathEnv)
-		}
-		port = 80
-	case true:
-		dbPath = testDBPath
-		port = 3000
+	cfg, err := config.Load(*configFlag)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	admin.DBPath = dbPath
+	if *pathFlag != "" {
+		cfg.DBPath = *pathFlag
+	}
+	if *listenFlag != "" {
+		cfg.Listen = *listenFlag
+	}
+	if *testModeFlag {
+		cfg.DBPath = testDBPath
+		cfg.Listen = testListen
+		cfg.TLS.Enabled = false
+	}
 
-	if dbPath == "" {
-		log.Fatal("Database path not configured")
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
 	}
+	if err := configure(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	admin.DBPath = cfg.DBPath
 	slog.Debug("using database", "path", admin.DBPath)
 
+	// pre-migration backups are saved with the other backups
+	dal.MigrationBackupDir = cfg.Backup.Dir
 	db, err := dal.InitClient(admin.DBPath)
 	if err != nil {
 		log.Fatal(err)
@@ -84,16 +153,21 @@ func main() {
 	}
 	auth.CleanupSessions()
 
-	dal.InitHourlyGC(*db)
+	dal.InitHourlyGC(db)
 
-	dbBackupDir := filepath.Join(admin.DBPath, admin.DBBackupDir)
-	if err := os.Mkdir(dbBackupDir, 0750); err != nil && !os.IsExist(err) {
+	// validated with the configuration
+	backupTime, _ := config.ParseTimeOfDay(cfg.Backup.Time)
+	if err := admin.InitBackups(db, cfg.Backup.Dir, cfg.Backup.Retention, cfg.Backup.FullInterval, backupTime); err != nil {
 		// backups are important
 		log.Fatal(err)
 	}
 
-	dbBackupFile := filepath.Join(dbBackupDir, admin.DBDailyBackupFile)
-	dal.InitDailyBackup(*db, dbBackupFile)
+	if err := bootstrapAdmin(); err != nil {
+		log.Fatal(err)
+	}
+	if err := secureLegacyAdmin(); err != nil {
+		log.Fatal(err)
+	}
 
 	if admin.DBPath == testDBPath {
 		if err := AddData(); err != nil {
@@ -102,23 +176,126 @@ func main() {
 		}
 	}
 
-	createAdmin()
+	if cfg.TLS.Auto {
+		manager, err := certs.NewManager(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.Names)
+		if err != nil {
+			log.Fatal(err)
+		}
+		manager.ScheduleRenewal()
+		server.StartManagedTLS(server.DefaultShutdown, cfg.Listen, manager)
+	} else if cfg.TLS.Enabled {
+		server.StartSafe(server.DefaultShutdown, cfg.Listen, cfg.TLS.Cert, cfg.TLS.Key)
+	} else {
+		// for dev purposes only!
+		server.StartUnsafe(server.DefaultShutdown, cfg.Listen)
+	}
+}
 
-	// StartUnsafe for dev purposes only!
-	//server.StartUnsafe(server.DefaultShutdown, port)
-	server.StartSafe(server.DefaultShutdown, port)
+// configure applies a validated configuration to the packages that it affects.
+func configure(cfg *config.Config) error {
+	// validated with the configuration
+	level, _ := cfg.Level()
+	slog.SetLogLoggerLevel(level)
+
+	if err := auth.SetLifetimes(cfg.Auth.TokenTTL, cfg.Auth.SessionTTL); err != nil {
+		return err
+	}
+	if err := auth.SetKeyRotationTime(cfg.Auth.KeyRotationTime); err != nil {
+		return err
+	}
+	if err := auth.SetMinPasswordLength(cfg.Auth.MinPasswordLength); err != nil {
+		return err
+	}
+
+	if err := workoutlog.SetACWRBounds(cfg.Load.ACWRLow, cfg.Load.ACWRHigh); err != nil {
+		return err
+	}
+
+	if err := server.SetMaxBackupUpload(cfg.Backup.MaxUpload); err != nil {
+		return err
+	}
+
+	policy, err := workoutlog.ParseSignupPolicy(cfg.Signup)
+	if err != nil {
+		return err
+	}
+	workoutlog.Signups = policy
+	slog.Info("signup policy", "policy", workoutlog.Signups)
+
+	return nil
 }
 
-func createAdmin() {
-	username := "admin"
-	password := "homegymadmin1234"
-	email := "admin@example.com"
-	role := auth.Admin
+// bootstrapAdmin creates an admin user from the environment when the database has no users.
+func bootstrapAdmin() error {
+	users, err := workoutlog.FrontDesk.ListUsers()
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	if len(users) > 0 {
+		return nil
+	}
 
-	_, err := workoutlog.FrontDesk.NewUser(username, role, email, password)
+	username := os.Getenv(adminUserEnv)
+	email := os.Getenv(adminEmailEnv)
+	password, err := adminPassword()
 	if err != nil {
-		slog.Error("error creating admin user", "error", err.Error())
-	} else {
-		slog.Info("created admin user")
+		return err
+	}
+
+	if username == "" || password == "" {
+		slog.Info("no users found: the first user to sign up will be an admin")
+		return nil
+	}
+	if email == "" {
+		return fmt.Errorf("%s is required to create the admin user", adminEmailEnv)
+	}
+
+	if _, err := workoutlog.FrontDesk.NewUser(username, auth.Admin, email, password); err != nil {
+		return fmt.Errorf("failed to create admin user: %w", err)
 	}
+	slog.Info("created admin user", "username", username)
+
+	return nil
+}
+
+// adminPassword returns the admin password from the environment.
+func adminPassword() (string, error) {
+	if passwordFile := os.Getenv(adminPasswordFileEnv); passwordFile != "" {
+		passwordBytes, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read admin password file: %w", err)
+		}
+		return strings.TrimSpace(string(passwordBytes)), nil
+	}
+
+	return os.Getenv(adminPasswordEnv), nil
+}
+
+// secureLegacyAdmin resets or disables the admin account that earlier versions created with a fixed password.
+// The password is reset to the admin password of the environment when HOMEGYM_ADMIN_USER is the legacy admin.
+func secureLegacyAdmin() error {
+	password := ""
+	if os.Getenv(adminUserEnv) == workoutlog.LegacyAdminName {
+		var err error
+		if password, err = adminPassword(); err != nil {
+			return err
+		}
+	}
+
+	secured, err := workoutlog.SecureLegacyAdmin(password)
+	if err != nil {
+		return err
+	}
+	if !secured {
+		return nil
+	}
+
+	if password == "" {
+		slog.Warn("disabled the admin account because it has the default password of an earlier version; "+
+			"to use it, set "+adminUserEnv+" and "+adminPasswordEnv+" and restart, or delete it", "username", workoutlog.LegacyAdminName)
+		return nil
+	}
+	slog.Warn("reset the default password of the admin account of an earlier version; change it after you log in", "username", workoutlog.LegacyAdminName)
+
+	return nil
 }
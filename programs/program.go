@@ -37,7 +37,8 @@ func (e ErrInvalidProgram) Error() string {
 
 var ErrInvalidProgramInstance = errors.New("invalid program instance")
 
-func (pi ProgramInstance) validate() error {
+// Validate ensures that a program instance has the required fields.
+func (pi ProgramInstance) Validate() error {
 	if pi.ID == "" {
 		return errors.Join(ErrInvalidProgramInstance, fmt.Errorf("missing ID"))
 	}
@@ -156,7 +157,8 @@ type Program struct {
 	Blocks     []Block `json:"blocks,omitempty"`
 }
 
-func (p Program) validate() error {
+// Validate ensures that a program has the required fields and that its blocks are valid.
+func (p Program) Validate() error {
 	if p.ID == "" {
 		return ErrInvalidProgram{Message: "missing ID"}
 	}
@@ -177,6 +179,23 @@ func (p Program) validate() error {
 	return nil
 }
 
+// UsesExerciseType returns true when a workout of the program includes an exercise type.
+func (p Program) UsesExerciseType(exerciseTypeID string) bool {
+	for _, b := range p.Blocks {
+		for _, m := range b.MicroCycles {
+			for _, w := range m.Workouts {
+				for _, s := range w.Segments {
+					if s.ExerciseTypeID == exerciseTypeID {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
 // The ProgramAdmin type defines routines for interacting with programs in the database.
 type ProgramAdmin interface {
 	AddProgram(userID string, program Program) (*string, error)
@@ -204,7 +223,7 @@ func (pu ProgramUtil) AddProgram(userID string, program Program) (*string, error
 
 	program.ID = uuid.New().String()
 
-	if err := program.validate(); err != nil {
+	if err := program.Validate(); err != nil {
 		return nil, err
 	}
 
@@ -233,7 +252,7 @@ func (pu ProgramUtil) AddProgram(userID string, program Program) (*string, error
 // UpdateProgram updates a program on the database.
 // If the program is not yet stored an error is returned.
 func (pu ProgramUtil) UpdateProgram(userID string, program Program) error {
-	if err := program.validate(); err != nil {
+	if err := program.Validate(); err != nil {
 		return err
 	}
 
@@ -332,7 +351,7 @@ func (pu ProgramUtil) AddProgramInstance(userID string, instance *ProgramInstanc
 
 	instance.ID = uuid.New().String()
 
-	if err := instance.validate(); err != nil {
+	if err := instance.Validate(); err != nil {
 		return err
 	}
 
@@ -357,7 +376,7 @@ func (pu ProgramUtil) AddProgramInstance(userID string, instance *ProgramInstanc
 // A pointer to the instance is returned.
 // An error is returned when the instance is not already in the database.
 func (pu ProgramUtil) UpdateProgramInstance(userID string, instance ProgramInstance) (*ProgramInstance, error) {
-	if err := instance.validate(); err != nil {
+	if err := instance.Validate(); err != nil {
 		return nil, err
 	}
 
@@ -2,11 +2,28 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
+
+	"github.com/scottbrodersen/homegym/auth"
 )
 
+// apiTokenResources maps API paths to the resources of personal API token scopes.
+// Paths that are not listed cannot be accessed with personal API tokens.
+var apiTokenResources = map[string]string{
+	"/homegym/api/events":     "events",
+	"/homegym/api/analytics":  "events",
+	"/homegym/api/activities": "activities",
+	"/homegym/api/exercises":  "exercises",
+	"/homegym/api/dailystats": "dailystats",
+	"/homegym/api/export":     "export",
+	"/homegym/api/import":     "import",
+}
+
 // A gateway is middleware that handles all requests that require authentication (except for initial login requests)
 type gateway struct {
 	handler http.Handler
@@ -17,6 +34,8 @@ func newGateway(h http.Handler) *gateway {
 }
 
 // ServeHTTP validates the session and the token in the request cookies then passes the request to the handler.
+// Requests with a personal API token in a Bearer Authorization header are authenticated with the token instead.
+// Other Authorization headers, such as the Basic credentials of a proxy, are ignored.
 func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("authorizing request", "url", r.URL.String())
 	if strings.HasPrefix(r.URL.Path, homePath) && r.Method != http.MethodGet {
@@ -24,6 +43,11 @@ func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if secret, ok := bearerAPIToken(r); ok {
+		g.serveAPITokenRequest(secret, w, r)
+		return
+	}
+
 	sessionIDCookie, err := r.Cookie(cookieSession)
 	if err != nil {
 		slog.Debug("session cookie not found")
@@ -86,6 +110,20 @@ func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, internalServerError, http.StatusInternalServerError)
 	}
 
+	// users who must change their password can only manage their account
+	if !strings.HasPrefix(r.URL.Path, accountPath) {
+		required, err := authorizer.PasswordChangeRequired(claims.Audience[0])
+		if err != nil {
+			slog.Error(err.Error())
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			return
+		}
+		if required {
+			http.Error(w, `{"message": "password change required"}`, http.StatusForbidden)
+			return
+		}
+	}
+
 	ctx := r.Context()
 	ctx = context.WithValue(ctx, usernameKey, claims.Audience[0])
 	ctx = context.WithValue(ctx, roleKey, claims.Role)
@@ -94,3 +132,83 @@ func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	g.handler.ServeHTTP(w, r)
 }
+
+// bearerAPIToken returns the personal API token of a request with a Bearer Authorization header.
+func bearerAPIToken(r *http.Request) (string, bool) {
+	scheme, secret, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	secret = strings.TrimSpace(secret)
+
+	return secret, auth.IsAPIToken(secret)
+}
+
+// serveAPITokenRequest validates a personal API token and checks that
+// the scopes of the token permit the request, then passes the request to the handler.
+func (g *gateway) serveAPITokenRequest(secret string, w http.ResponseWriter, r *http.Request) {
+	username, role, scopes, err := authorizer.ValidateAPIToken(secret)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnauthorized) || errors.Is(err, auth.ErrAccountDisabled) {
+			slog.Debug(err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "not authorized", http.StatusUnauthorized)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	scope := requiredScope(r)
+	if scope == "" {
+		http.Error(w, `{"message": "api tokens cannot be used for this request"}`, http.StatusForbidden)
+		return
+	}
+	if !slices.Contains(scopes, scope) {
+		http.Error(w, fmt.Sprintf(`{"message": "api token does not have the %s scope"}`, scope), http.StatusForbidden)
+		return
+	}
+
+	required, err := authorizer.PasswordChangeRequired(username)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, `{"message": "password change required"}`, http.StatusForbidden)
+		return
+	}
+	slog.Info("authorized api token", "url", r.URL.String())
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, usernameKey, username)
+	ctx = context.WithValue(ctx, roleKey, role)
+
+	r = r.Clone(ctx)
+
+	g.handler.ServeHTTP(w, r)
+}
+
+// requiredScope returns the personal API token scope that permits a request.
+// Returns an empty string when personal API tokens cannot be used for the request.
+func requiredScope(r *http.Request) string {
+	access := "write"
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		access = "read"
+	}
+
+	for path, resource := range apiTokenResources {
+		if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/") {
+			scope := fmt.Sprintf("%s:%s", resource, access)
+			if slices.Contains(auth.APITokenScopes, scope) {
+				return scope
+			}
+			return ""
+		}
+	}
+
+	return ""
+}
@@ -99,6 +99,7 @@ func TestGateway(t *testing.T) {
 		Convey("When we make an api call using valid access tokens", func() {
 			mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(&testToken, nil)
 			mockAuth.On("TokenClaims", mock.Anything).Return(testClaims, nil)
+			mockAuth.On("PasswordChangeRequired", testClaims.Audience[0]).Return(false, nil)
 
 			req := httptest.NewRequest(http.MethodGet, "/homegym/api/foo", nil)
 			req.AddCookie(&testTokenCookie)
@@ -118,6 +119,106 @@ func TestGateway(t *testing.T) {
 			So(expectedReqCtx.Role, ShouldEqual, testClaims.Role)
 			So(expectedReqCtx.User, ShouldEqual, testClaims.Audience[0])
 		})
+
+		Convey("When a request with valid access tokens has an Authorization header that is not an API token", func() {
+			mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(&testToken, nil)
+			mockAuth.On("TokenClaims", mock.Anything).Return(testClaims, nil)
+			mockAuth.On("PasswordChangeRequired", testClaims.Audience[0]).Return(false, nil)
+
+			for _, header := range []string{"Basic cHJveHk6c2VjcmV0", "Bearer proxy-token"} {
+				req := httptest.NewRequest(http.MethodGet, "/homegym/api/foo", nil)
+				req.Header.Set("Authorization", header)
+				req.AddCookie(&testTokenCookie)
+				req.AddCookie(&testSessionCookie)
+
+				w := httptest.NewRecorder()
+
+				gw.ServeHTTP(w, req)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			}
+			mockAuth.AssertNotCalled(t, "ValidateAPIToken", mock.Anything)
+		})
+
+		Convey("When a user who must change their password makes an api call", func() {
+			mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(&testToken, nil)
+			mockAuth.On("TokenClaims", mock.Anything).Return(testClaims, nil)
+			mockAuth.On("PasswordChangeRequired", testClaims.Audience[0]).Return(true, nil)
+
+			Convey("Then calls to the account api are passed to the handler", func() {
+				req := httptest.NewRequest(http.MethodPost, "/homegym/api/account/password", nil)
+				req.AddCookie(&testTokenCookie)
+				req.AddCookie(&testSessionCookie)
+
+				w := httptest.NewRecorder()
+
+				gw.ServeHTTP(w, req)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then other calls are forbidden", func() {
+				req := httptest.NewRequest(http.MethodGet, "/homegym/api/foo", nil)
+				req.AddCookie(&testTokenCookie)
+				req.AddCookie(&testSessionCookie)
+
+				w := httptest.NewRecorder()
+
+				gw.ServeHTTP(w, req)
+				So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("When we make an api call using a personal API token", func() {
+			testAPIToken := "hgp_testtoken"
+			mockAuth.On("ValidateAPIToken", testAPIToken).Return(testUserName, string(auth.User), []string{auth.ScopeEventsRead}, nil)
+			mockAuth.On("PasswordChangeRequired", testUserName).Return(false, nil)
+
+			apiTokenRequest := func(method, path string) *http.Request {
+				req := httptest.NewRequest(method, path, nil)
+				req.Header.Set("Authorization", "Bearer "+testAPIToken)
+				return req
+			}
+
+			Convey("Then calls that the token scopes permit are passed to the handler", func() {
+				w := httptest.NewRecorder()
+				gw.ServeHTTP(w, apiTokenRequest(http.MethodGet, "/homegym/api/events/metrics"))
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				expectedReqCtx := expectedCtx{}
+				So(json.NewDecoder(w.Result().Body).Decode(&expectedReqCtx), ShouldBeNil)
+				So(expectedReqCtx.User, ShouldEqual, testUserName)
+				So(expectedReqCtx.Role, ShouldEqual, string(auth.User))
+			})
+
+			Convey("Then calls that the token scopes do not permit are forbidden", func() {
+				w := httptest.NewRecorder()
+				gw.ServeHTTP(w, apiTokenRequest(http.MethodPost, "/homegym/api/events/"))
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+
+			Convey("Then calls to paths without scopes are forbidden", func() {
+				for _, path := range []string{"/homegym/api/account/tokens", "/homegym/api/admin/users", "/homegym/home/"} {
+					w := httptest.NewRecorder()
+					gw.ServeHTTP(w, apiTokenRequest(http.MethodGet, path))
+
+					So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+				}
+			})
+		})
+
+		Convey("When we make an api call using an invalid personal API token", func() {
+			mockAuth.On("ValidateAPIToken", mock.Anything).Return("", "", nil, auth.ErrUnauthorized)
+
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/events/", nil)
+			req.Header.Set("Authorization", "Bearer hgp_wrong")
+
+			w := httptest.NewRecorder()
+			gw.ServeHTTP(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusUnauthorized)
+			So(w.Result().Header.Get("WWW-Authenticate"), ShouldStartWith, "Bearer")
+		})
 	})
 
 }
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/workoutlog"
+)
+
+// emailChange is the body of a request to change the email address of the user.
+type emailChange struct {
+	Email string `json:"email"`
+}
+
+// passwordChange is the body of a request to change the password of the user.
+type passwordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// newAPIToken is the body of a request to create a personal API token.
+// TTL is the lifetime of the token in seconds; tokens with a ttl of 0 do not expire.
+type newAPIToken struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	TTL    int64    `json:"ttl"`
+}
+
+// createdAPIToken is a new personal API token along with its description.
+type createdAPIToken struct {
+	auth.APIToken
+	Token string `json:"token"`
+}
+
+// totpCode is the body of a request to confirm or disable two-factor authentication.
+// The password is required to disable two-factor authentication.
+type totpCode struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// AccountApi handles requests from users to manage their own account.
+func AccountApi(w http.ResponseWriter, r *http.Request) {
+	rootpath := accountPath
+
+	username, _, err := whoIsIt(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// path to the account
+	rxpRootPath := regexp.MustCompile(fmt.Sprintf("^%s/?$", rootpath))
+	// path to the email address
+	rxpEmail := regexp.MustCompile(fmt.Sprintf("^%s/email/?$", rootpath))
+	// path to the password
+	rxpPassword := regexp.MustCompile(fmt.Sprintf("^%s/password/?$", rootpath))
+	// path to two-factor authentication
+	rxpTOTP := regexp.MustCompile(fmt.Sprintf("^%s/totp/?$", rootpath))
+	// path to confirm two-factor authentication
+	rxpTOTPConfirm := regexp.MustCompile(fmt.Sprintf("^%s/totp/confirm/?$", rootpath))
+	// path to personal API tokens
+	rxpTokens := regexp.MustCompile(fmt.Sprintf("^%s/tokens/?$", rootpath))
+	// path to a personal API token
+	rxpToken := regexp.MustCompile(fmt.Sprintf("^%s/tokens/([a-zA-Z0-9-]+)/?$", rootpath))
+
+	if rxpRootPath.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			getAccount(*username, w)
+			return
+		}
+	} else if rxpEmail.MatchString(r.URL.Path) {
+		if r.Method == http.MethodPost {
+			changeEmail(*username, w, r)
+			return
+		}
+	} else if rxpPassword.MatchString(r.URL.Path) {
+		if r.Method == http.MethodPost {
+			changePassword(*username, w, r)
+			return
+		}
+	} else if rxpTOTP.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			getTOTPStatus(*username, w)
+			return
+		} else if r.Method == http.MethodPost {
+			enrollTOTP(*username, w)
+			return
+		} else if r.Method == http.MethodDelete {
+			disableTOTP(*username, w, r)
+			return
+		}
+	} else if rxpTOTPConfirm.MatchString(r.URL.Path) {
+		if r.Method == http.MethodPost {
+			confirmTOTP(*username, w, r)
+			return
+		}
+	} else if rxpTokens.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			listAPITokens(*username, w)
+			return
+		} else if r.Method == http.MethodPost {
+			createAPIToken(*username, w, r)
+			return
+		}
+	} else if rxpToken.MatchString(r.URL.Path) {
+		tokenID := rxpToken.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodDelete {
+			revokeAPIToken(*username, tokenID, w)
+			return
+		}
+	}
+	http.Error(w, "", http.StatusNotFound)
+}
+
+func getAccount(username string, w http.ResponseWriter) {
+	user, err := workoutlog.FrontDesk.GetUser(username)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	writeAccount(user, w)
+}
+
+func changeEmail(username string, w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	change := emailChange{}
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := workoutlog.FrontDesk.ChangeEmail(username, strings.TrimSpace(change.Email))
+	if err != nil {
+		if errors.Is(err, workoutlog.ErrInvalidEmail) {
+			http.Error(w, `{"message": "invalid email address"}`, http.StatusBadRequest)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	writeAccount(user, w)
+}
+
+// changePassword changes the password of the user and ends all other sessions of the user.
+func changePassword(username string, w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	change := passwordChange{}
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := workoutlog.FrontDesk.ChangePassword(username, change.CurrentPassword, change.NewPassword); err != nil {
+		if errors.Is(err, workoutlog.ErrIncorrectPassword) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "current password is incorrect"}`, http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, auth.ErrBusy) {
+			slog.Warn(err.Error())
+			writeBusyError(w)
+			return
+		}
+		if errors.Is(err, workoutlog.ErrInvalidPassword) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	currentSessionID := ""
+	if sessionCookie, err := r.Cookie(cookieSession); err == nil {
+		currentSessionID = strings.TrimSpace(sessionCookie.Value)
+	}
+
+	if err := endOtherSessions(username, currentSessionID); err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func getTOTPStatus(username string, w http.ResponseWriter) {
+	status, err := authorizer.TOTPStatus(username)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// enrollTOTP creates a TOTP secret for the user and returns it as an otpauth URI.
+func enrollTOTP(username string, w http.ResponseWriter) {
+	uri, err := authorizer.EnrollTOTP(username)
+	if err != nil {
+		if errors.Is(err, auth.ErrTOTPEnabled) {
+			http.Error(w, `{"message": "two-factor authentication is already enabled"}`, http.StatusConflict)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(map[string]string{"uri": uri})
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// confirmTOTP enables two-factor authentication and returns the recovery codes.
+func confirmTOTP(username string, w http.ResponseWriter, r *http.Request) {
+	request, ok := readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := authorizer.ConfirmTOTP(username, request.Code, remoteHost(r))
+	if err != nil {
+		writeTOTPError(err, w)
+		return
+	}
+
+	body, err := json.Marshal(map[string][]string{"recoveryCodes": recoveryCodes})
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// disableTOTP removes two-factor authentication when the user provides their password and a code or
+// recovery code.
+func disableTOTP(username string, w http.ResponseWriter, r *http.Request) {
+	request, ok := readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	if err := authorizer.DisableTOTP(username, request.Password, request.Code, remoteHost(r)); err != nil {
+		writeTOTPError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func listAPITokens(username string, w http.ResponseWriter) {
+	tokens, err := authorizer.APITokens(username)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(tokens)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// createAPIToken creates a personal API token and returns it.
+// The token cannot be retrieved again.
+func createAPIToken(username string, w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	request := newAPIToken{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	secret, token, err := authorizer.CreateAPIToken(username, request.Name, request.Scopes, time.Duration(request.TTL)*time.Second)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAPIToken) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(createdAPIToken{APIToken: *token, Token: secret})
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func revokeAPIToken(username, tokenID string, w http.ResponseWriter) {
+	if err := authorizer.RevokeAPIToken(username, tokenID); err != nil {
+		if errors.Is(err, auth.ErrAPITokenNotFound) {
+			http.Error(w, `{"message": "api token not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func readTOTPCode(w http.ResponseWriter, r *http.Request) (*totpCode, bool) {
+	if r.Body == nil {
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return nil, false
+	}
+
+	body := totpCode{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &body, true
+}
+
+func writeTOTPError(err error, w http.ResponseWriter) {
+	lockoutErr := &auth.LockoutError{}
+	switch {
+	case errors.As(err, &lockoutErr):
+		retryAfter := max(int(math.Ceil(time.Until(lockoutErr.Until).Seconds())), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, `{"message": "too many failed attempts"}`, http.StatusTooManyRequests)
+	case errors.Is(err, auth.ErrBusy):
+		slog.Warn(err.Error())
+		writeBusyError(w)
+	case errors.Is(err, auth.ErrUnauthorized):
+		http.Error(w, `{"message": "incorrect password"}`, http.StatusForbidden)
+	case errors.Is(err, auth.ErrInvalidCode):
+		http.Error(w, `{"message": "invalid code"}`, http.StatusForbidden)
+	case errors.Is(err, auth.ErrTOTPNotEnrolled):
+		http.Error(w, `{"message": "two-factor authentication is not enrolled"}`, http.StatusConflict)
+	case errors.Is(err, auth.ErrTOTPEnabled):
+		http.Error(w, `{"message": "two-factor authentication is already enabled"}`, http.StatusConflict)
+	default:
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+	}
+}
+
+func writeAccount(user *workoutlog.User, w http.ResponseWriter) {
+	body, err := json.Marshal(user)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/workoutlog"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAccountApi(t *testing.T) {
+	otherSessionID := "other-session-id"
+	testEmail := "test@example.com"
+
+	Convey("Given a user account", t, func() {
+		mockUserAdmin := newMockUserAdmin()
+		workoutlog.FrontDesk = mockUserAdmin
+		mockAuth := NewMockAuthorizer()
+		authorizer = mockAuth
+
+		user := &workoutlog.User{ID: testUserName, Role: auth.User, Email: testEmail, PwdHash: "secret"}
+
+		accountRequest := func(method, path, body string) *http.Request {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.AddCookie(&http.Cookie{Name: cookieSession, Value: testSessionID})
+			return req.WithContext(testContext())
+		}
+
+		Convey("When we receive a request for the account", func() {
+			mockUserAdmin.On("GetUser", testUserName).Return(user, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodGet, "/homegym/api/account", ""))
+
+			Convey("Then the profile is returned without the password hash", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := map[string]any{}
+				err := json.NewDecoder(w.Result().Body).Decode(&returned)
+				So(err, ShouldBeNil)
+				So(returned["id"], ShouldEqual, testUserName)
+				So(returned["email"], ShouldEqual, testEmail)
+				So(returned, ShouldNotContainKey, "PwdHash")
+			})
+		})
+
+		Convey("When we receive a request to change the email address", func() {
+			newEmail := "new@example.com"
+			updated := *user
+			updated.Email = newEmail
+			mockUserAdmin.On("ChangeEmail", testUserName, newEmail).Return(&updated, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/email", fmt.Sprintf(`{"email": "%s"}`, newEmail)))
+
+			Convey("Then the email address is changed", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := workoutlog.User{}
+				err := json.NewDecoder(w.Result().Body).Decode(&returned)
+				So(err, ShouldBeNil)
+				So(returned.Email, ShouldEqual, newEmail)
+			})
+		})
+
+		Convey("When we receive a request to change the email address to an invalid address", func() {
+			mockUserAdmin.On("ChangeEmail", testUserName, "nope").Return(nil, workoutlog.ErrInvalidEmail)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/email", `{"email": "nope"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When we receive a request to change the password", func() {
+			mockUserAdmin.On("ChangePassword", testUserName, "oldpassword", "newpassword").Return(nil)
+			mockAuth.On("Sessions", testUserName).Return([]auth.Session{
+				{UserID: testUserName, SessionID: testSessionID},
+				{UserID: testUserName, SessionID: otherSessionID},
+			}, nil)
+			mockAuth.On("RevokeSession", testUserName, otherSessionID).Return(nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/password", `{"currentPassword": "oldpassword", "newPassword": "newpassword"}`))
+
+			Convey("Then the password is changed and the other sessions are revoked", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(mockUserAdmin.AssertCalled(t, "ChangePassword", testUserName, "oldpassword", "newpassword"), ShouldBeTrue)
+				So(mockAuth.AssertCalled(t, "RevokeSession", testUserName, otherSessionID), ShouldBeTrue)
+				mockAuth.AssertNotCalled(t, "RevokeSession", testUserName, testSessionID)
+			})
+		})
+
+		Convey("When we receive a request to change the password with the wrong current password", func() {
+			mockUserAdmin.On("ChangePassword", testUserName, "wrong", "newpassword").Return(workoutlog.ErrIncorrectPassword)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/password", `{"currentPassword": "wrong", "newPassword": "newpassword"}`))
+
+			Convey("Then the request is refused and no sessions are revoked", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+				mockAuth.AssertNotCalled(t, "RevokeSession", mock.Anything, mock.Anything)
+			})
+		})
+
+		Convey("When we receive a request to change the password to an invalid password", func() {
+			mockUserAdmin.On("ChangePassword", testUserName, "oldpassword", "short").Return(fmt.Errorf("%w: too short", workoutlog.ErrInvalidPassword))
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/password", `{"currentPassword": "oldpassword", "newPassword": "short"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockAuth.AssertNotCalled(t, "RevokeSession", mock.Anything, mock.Anything)
+		})
+
+		Convey("When we receive a request for the two-factor authentication status", func() {
+			mockAuth.On("TOTPStatus", testUserName).Return(&auth.TOTPStatus{Enabled: true, RecoveryCodes: 8}, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodGet, "/homegym/api/account/totp", ""))
+
+			Convey("Then the status is returned", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := auth.TOTPStatus{}
+				So(json.NewDecoder(w.Result().Body).Decode(&returned), ShouldBeNil)
+				So(returned, ShouldResemble, auth.TOTPStatus{Enabled: true, RecoveryCodes: 8})
+			})
+		})
+
+		Convey("When we receive a request to enroll in two-factor authentication", func() {
+			testURI := "otpauth://totp/HomeGym:testUserName?secret=ABC"
+			mockAuth.On("EnrollTOTP", testUserName).Return(testURI, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/totp", ""))
+
+			Convey("Then the otpauth URI is returned", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := map[string]string{}
+				So(json.NewDecoder(w.Result().Body).Decode(&returned), ShouldBeNil)
+				So(returned["uri"], ShouldEqual, testURI)
+			})
+		})
+
+		Convey("When we receive a request to enroll when two-factor authentication is enabled", func() {
+			mockAuth.On("EnrollTOTP", testUserName).Return("", auth.ErrTOTPEnabled)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/totp", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("When we receive a request to confirm two-factor authentication", func() {
+			mockAuth.On("ConfirmTOTP", testUserName, "123456", mock.Anything).Return([]string{"abcde-fghij"}, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/totp/confirm", `{"code": "123456"}`))
+
+			Convey("Then the recovery codes are returned", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := map[string][]string{}
+				So(json.NewDecoder(w.Result().Body).Decode(&returned), ShouldBeNil)
+				So(returned["recoveryCodes"], ShouldResemble, []string{"abcde-fghij"})
+			})
+		})
+
+		Convey("When we receive a request to confirm two-factor authentication with an invalid code", func() {
+			mockAuth.On("ConfirmTOTP", testUserName, "000000", mock.Anything).Return(nil, auth.ErrInvalidCode)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/totp/confirm", `{"code": "000000"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("When we receive a request to disable two-factor authentication", func() {
+			mockAuth.On("DisableTOTP", testUserName, "password", "123456", mock.Anything).Return(nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodDelete, "/homegym/api/account/totp", `{"code": "123456", "password": "password"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockAuth.AssertCalled(t, "DisableTOTP", testUserName, "password", "123456", mock.Anything), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to disable two-factor authentication with an incorrect password", func() {
+			mockAuth.On("DisableTOTP", testUserName, "wrong", "123456", mock.Anything).Return(auth.ErrUnauthorized)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodDelete, "/homegym/api/account/totp", `{"code": "123456", "password": "wrong"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("When we receive a request to disable two-factor authentication after too many failures", func() {
+			mockAuth.On("DisableTOTP", testUserName, "password", "123456", mock.Anything).Return(&auth.LockoutError{Until: time.Now().Add(time.Minute)})
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodDelete, "/homegym/api/account/totp", `{"code": "123456", "password": "password"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusTooManyRequests)
+			So(w.Result().Header.Get("Retry-After"), ShouldNotBeEmpty)
+		})
+
+		Convey("When we receive a request for the personal API tokens", func() {
+			tokens := []auth.APIToken{{ID: "token-id", Name: "scale", Scopes: []string{auth.ScopeDailyStatsWrite}}}
+			mockAuth.On("APITokens", testUserName).Return(tokens, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodGet, "/homegym/api/account/tokens", ""))
+
+			Convey("Then the tokens are returned", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := []auth.APIToken{}
+				So(json.NewDecoder(w.Result().Body).Decode(&returned), ShouldBeNil)
+				So(returned, ShouldResemble, tokens)
+			})
+		})
+
+		Convey("When we receive a request to create a personal API token", func() {
+			token := &auth.APIToken{ID: "token-id", Name: "scale", Scopes: []string{auth.ScopeDailyStatsWrite}}
+			mockAuth.On("CreateAPIToken", testUserName, "scale", []string{auth.ScopeDailyStatsWrite}, time.Hour).Return("hgp_secret", token, nil)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/tokens", `{"name": "scale", "scopes": ["dailystats:write"], "ttl": 3600}`))
+
+			Convey("Then the token is returned with its description", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := map[string]any{}
+				So(json.NewDecoder(w.Result().Body).Decode(&returned), ShouldBeNil)
+				So(returned["token"], ShouldEqual, "hgp_secret")
+				So(returned["id"], ShouldEqual, "token-id")
+			})
+		})
+
+		Convey("When we receive a request to create a personal API token with an invalid scope", func() {
+			mockAuth.On("CreateAPIToken", testUserName, "scale", []string{"admin:write"}, time.Duration(0)).Return("", nil, fmt.Errorf("%w: unknown scope admin:write", auth.ErrInvalidAPIToken))
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodPost, "/homegym/api/account/tokens", `{"name": "scale", "scopes": ["admin:write"]}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When we receive a request to revoke a personal API token that is not found", func() {
+			mockAuth.On("RevokeAPIToken", testUserName, "token-id").Return(auth.ErrAPITokenNotFound)
+
+			w := httptest.NewRecorder()
+			AccountApi(w, accountRequest(http.MethodDelete, "/homegym/api/account/tokens/token-id", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -27,6 +27,8 @@ func ActivitiesApi(w http.ResponseWriter, r *http.Request) {
 	rxpExercises := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/exercises/?$", rootpath))
 	// path to an activity
 	rxpActivity := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/?$", rootpath))
+	// path to merge an activity into another activity
+	rxpMerge := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/merge/?$", rootpath))
 	// path to the programs of an activity
 	rxpPrograms := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/programs/?$", rootpath))
 	// path to an activity program
@@ -57,9 +59,25 @@ func ActivitiesApi(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else if rxpActivity.MatchString(r.URL.Path) {
+		activityID := rxpActivity.FindStringSubmatch(r.URL.Path)[1]
 		if r.Method == http.MethodPost {
 			updateActivity(*username, w, r)
 			return
+		} else if r.Method == http.MethodDelete {
+			deleteActivity(*username, activityID, w)
+			return
+		}
+	} else if rxpMerge.MatchString(r.URL.Path) {
+		activityID := rxpMerge.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodPost {
+			dstActivityID := r.URL.Query().Get("into")
+			if dstActivityID == "" {
+				slog.Debug("No into query parameter")
+				http.Error(w, `{"message":"missing into query parameter"}`, http.StatusBadRequest)
+				return
+			}
+			mergeActivities(*username, activityID, dstActivityID, w)
+			return
 		}
 	} else if rxpPrograms.MatchString(r.URL.Path) {
 		activityID := rxpPrograms.FindStringSubmatch(r.URL.Path)[1]
@@ -269,3 +287,42 @@ func updateActivity(username string, w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func deleteActivity(username, activityID string, w http.ResponseWriter) {
+	if err := workoutlog.ActivityManager.DeleteActivity(username, activityID); err != nil {
+		if errors.Is(err, workoutlog.ErrNotFound) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message":"activity not found"}`, http.StatusNotFound)
+		} else if errors.Is(err, workoutlog.ErrActivityInUse) {
+			slog.Debug(err.Error())
+			http.Error(w, fmt.Sprintf(`{"message":"%s"}`, jsonSafeError(err)), http.StatusConflict)
+		} else {
+			slog.Error(err.Error())
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func mergeActivities(username, srcActivityID, dstActivityID string, w http.ResponseWriter) {
+	if srcActivityID == dstActivityID {
+		slog.Debug("Merge source and destination are the same")
+		http.Error(w, `{"message":"cannot merge an activity with itself"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := workoutlog.ActivityManager.MergeActivities(username, srcActivityID, dstActivityID); err != nil {
+		if errors.Is(err, workoutlog.ErrNotFound) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message":"activity not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
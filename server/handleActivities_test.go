@@ -153,5 +153,53 @@ func TestHandleActivities(t *testing.T) {
 
 			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
 		})
+
+		Convey("When we receive a request to delete an activity", func() {
+			mockActivityManager.On("DeleteActivity", mock.Anything, testActivityID).Return(nil)
+
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("%s%s", url, testActivityID), nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+			ActivitiesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			mockActivityManager.AssertCalled(t, "DeleteActivity", mock.Anything, testActivityID)
+		})
+
+		Convey("When we receive a request to delete an activity that is in use", func() {
+			mockActivityManager.On("DeleteActivity", mock.Anything, testActivityID).Return(fmt.Errorf("%w: activity has events", workoutlog.ErrActivityInUse))
+
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("%s%s", url, testActivityID), nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+			ActivitiesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("When we receive a request to merge an activity", func() {
+			mockActivityManager.On("MergeActivities", mock.Anything, testActivityID, "dst-id").Return(nil)
+
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s%s/merge?into=dst-id", url, testActivityID), nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+			ActivitiesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			mockActivityManager.AssertCalled(t, "MergeActivities", mock.Anything, testActivityID, "dst-id")
+		})
+
+		Convey("When we receive a request to merge an activity without a destination", func() {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s%s/merge", url, testActivityID), nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+			ActivitiesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
 	})
 }
@@ -1,20 +1,69 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
-	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/scottbrodersen/homegym/admin"
 	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/workoutlog"
 )
 
+// maxBackupUploadBytes is the size of the largest backup file that can be uploaded.
+var maxBackupUploadBytes int64 = 1024 << 20
+
+// SetMaxBackupUpload sets the size of the largest backup file that can be uploaded, in MiB.
+func SetMaxBackupUpload(mib int) error {
+	if mib < 1 {
+		return fmt.Errorf("invalid maximum backup upload size: %d MiB", mib)
+	}
+	maxBackupUploadBytes = int64(mib) << 20
+
+	return nil
+}
+
+// restoreRequest is the body of a request to restore a backup.
+// Confirm must be true to prevent accidental restores.
+type restoreRequest struct {
+	Confirm bool `json:"confirm"`
+}
+
+// newUserRequest is the body of a request to create a user.
+type newUserRequest struct {
+	ID       string    `json:"id"`
+	Email    string    `json:"email"`
+	Role     auth.Role `json:"role"`
+	Password string    `json:"password"`
+}
+
+// roleRequest is the body of a request to change the role of a user.
+type roleRequest struct {
+	Role auth.Role `json:"role"`
+}
+
+// inviteRequest is the body of a request to create an invite code.
+// TTL is the number of seconds that the code is valid.
+type inviteRequest struct {
+	TTL int64 `json:"ttl"`
+}
+
+// passwordResetRequest is the body of a request to reset the password of a user.
+type passwordResetRequest struct {
+	Password string `json:"password"`
+}
+
 // AdminAPI handles requests for admin tasks.
 func AdminApi(w http.ResponseWriter, r *http.Request) {
 	rootpath := "/homegym/api/admin/"
 
-	_, role, err := whoIsIt(r.Context())
+	username, role, err := whoIsIt(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
@@ -26,12 +75,106 @@ func AdminApi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// path to restore daily backup
-	rxpRestoreDaily := regexp.MustCompile(fmt.Sprintf("^%srestoredaily/?$", rootpath))
+	// path to the backups
+	rxpBackups := regexp.MustCompile(fmt.Sprintf("^%sbackups/?$", rootpath))
+	// path to upload a backup
+	rxpUpload := regexp.MustCompile(fmt.Sprintf("^%sbackups/upload/?$", rootpath))
+	// path to a backup
+	rxpBackupID := regexp.MustCompile(fmt.Sprintf("^%sbackups/([0-9]{8}-[0-9]{6}\\.[0-9]{3})/?$", rootpath))
+	// path to restore a backup
+	rxpRestore := regexp.MustCompile(fmt.Sprintf("^%sbackups/([0-9]{8}-[0-9]{6}\\.[0-9]{3})/restore/?$", rootpath))
+	// path to the users
+	rxpUsers := regexp.MustCompile(fmt.Sprintf("^%susers/?$", rootpath))
+	// path to a user
+	rxpUserID := regexp.MustCompile(fmt.Sprintf("^%susers/([^/]+)/?$", rootpath))
+	// path to an action on a user
+	rxpUserAction := regexp.MustCompile(fmt.Sprintf("^%susers/([^/]+)/(role|password|disable|enable)/?$", rootpath))
+	// path to the invite codes
+	rxpInvites := regexp.MustCompile(fmt.Sprintf("^%sinvites/?$", rootpath))
+	// path to an invite code
+	rxpInvite := regexp.MustCompile(fmt.Sprintf("^%sinvites/([A-Z2-7]+)/?$", rootpath))
+	// path to the lockouts
+	rxpLockouts := regexp.MustCompile(fmt.Sprintf("^%slockouts/?$", rootpath))
+	// path to a lockout
+	rxpLockout := regexp.MustCompile(fmt.Sprintf("^%slockouts/(%s|%s)/([^/]+)/?$", rootpath, auth.LockoutUser, auth.LockoutAddress))
 
-	if rxpRestoreDaily.MatchString(r.URL.Path) {
+	if rxpBackups.MatchString(r.URL.Path) {
 		if r.Method == http.MethodGet {
-			restoreDailyBackup(w)
+			listBackups(w)
+			return
+		} else if r.Method == http.MethodPost {
+			createBackup(w)
+			return
+		}
+	} else if rxpUpload.MatchString(r.URL.Path) {
+		if r.Method == http.MethodPost {
+			uploadBackup(w, r)
+			return
+		}
+	} else if rxpBackupID.MatchString(r.URL.Path) {
+		backupID := rxpBackupID.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodGet {
+			downloadBackup(backupID, w)
+			return
+		}
+	} else if rxpRestore.MatchString(r.URL.Path) {
+		backupID := rxpRestore.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodPost {
+			restoreBackup(backupID, w, r)
+			return
+		}
+	} else if rxpUsers.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			listUsers(w)
+			return
+		} else if r.Method == http.MethodPost {
+			createUser(w, r)
+			return
+		}
+	} else if rxpUserID.MatchString(r.URL.Path) {
+		userID := rxpUserID.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodDelete {
+			deleteUser(*username, userID, w)
+			return
+		}
+	} else if rxpUserAction.MatchString(r.URL.Path) {
+		parts := rxpUserAction.FindStringSubmatch(r.URL.Path)
+		if r.Method == http.MethodPost {
+			switch parts[2] {
+			case "role":
+				changeUserRole(*username, parts[1], w, r)
+			case "password":
+				resetPassword(parts[1], w, r)
+			case "disable":
+				setUserDisabled(*username, parts[1], true, w)
+			case "enable":
+				setUserDisabled(*username, parts[1], false, w)
+			}
+			return
+		}
+	} else if rxpInvites.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			listInvites(w)
+			return
+		} else if r.Method == http.MethodPost {
+			createInvite(w, r)
+			return
+		}
+	} else if rxpInvite.MatchString(r.URL.Path) {
+		code := rxpInvite.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodDelete {
+			deleteInvite(code, w)
+			return
+		}
+	} else if rxpLockouts.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			listLockouts(w)
+			return
+		}
+	} else if rxpLockout.MatchString(r.URL.Path) {
+		parts := rxpLockout.FindStringSubmatch(r.URL.Path)
+		if r.Method == http.MethodDelete {
+			clearLockout(parts[1], parts[2], w)
 			return
 		}
 	}
@@ -39,11 +182,418 @@ func AdminApi(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func restoreDailyBackup(w http.ResponseWriter) {
-	backupPath := filepath.Join(admin.DBPath, admin.DBBackupDir, admin.DBDailyBackupFile)
+func listBackups(w http.ResponseWriter) {
+	backups, err := admin.DatabaseManager.ListBackups()
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(backups)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func createBackup(w http.ResponseWriter) {
+	backup, err := admin.DatabaseManager.CreateBackup()
+	if err != nil {
+		slog.Error("failed to create backup", "error", err)
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(backup)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func downloadBackup(backupID string, w http.ResponseWriter) {
+	file, err := admin.DatabaseManager.OpenBackup(backupID)
+	if err != nil {
+		if errors.Is(err, dal.ErrBackupNotFound) {
+			http.Error(w, `{"message": "backup not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"homegym-%s.bak\"", backupID))
+
+	if _, err := io.Copy(w, file); err != nil {
+		slog.Error("failed to send backup", "id", backupID, "error", err.Error())
+	}
+}
+
+func uploadBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		slog.Debug("No request body")
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBackupUploadBytes)
+
+	backup, err := admin.DatabaseManager.UploadBackup(r.Body)
+	if err != nil {
+		if errors.Is(err, dal.ErrInvalidBackup) {
+			http.Error(w, `{"message": "invalid backup file"}`, http.StatusBadRequest)
+			return
+		}
+		if errors.As(err, new(*http.MaxBytesError)) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"backup file exceeds %d MiB\"}", maxBackupUploadBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(backup)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func restoreBackup(backupID string, w http.ResponseWriter, r *http.Request) {
+	restore := restoreRequest{}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&restore); err != nil {
+			slog.Debug("Failed to decode restore request", "error", err.Error())
+		}
+	}
+
+	if !restore.Confirm {
+		http.Error(w, `{"message": "restoring a backup replaces all data and must be confirmed"}`, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := admin.DatabaseManager.RestoreBackup(backupID)
+	if err != nil {
+		if errors.Is(err, dal.ErrBackupNotFound) {
+			http.Error(w, `{"message": "backup not found"}`, http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, dal.ErrInvalidBackup) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(summary)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func listUsers(w http.ResponseWriter) {
+	users, err := workoutlog.FrontDesk.ListUsers()
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(users)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func createUser(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return
+	}
 
-	if err := admin.DatabaseManager.RestoreBackup(backupPath); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	newUser := newUserRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if newUser.ID == "" || newUser.Email == "" || newUser.Password == "" {
+		http.Error(w, `{"message": "id, email, and password are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	if newUser.Role == "" {
+		newUser.Role = auth.User
+	}
+	if !newUser.Role.Valid() {
+		http.Error(w, `{"message": "invalid role"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := workoutlog.FrontDesk.NewUser(newUser.ID, newUser.Role, newUser.Email, newUser.Password)
+	if err != nil {
+		if errors.Is(err, dal.ErrNotUnique) {
+			http.Error(w, `{"message": "user id is not unique"}`, http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, auth.ErrBusy) {
+			slog.Warn(err.Error())
+			writeBusyError(w)
+			return
+		}
+		if errors.Is(err, workoutlog.ErrInvalidPassword) || errors.Is(err, dal.ErrInvalidUserID) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(user)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// changeUserRole changes the role of a user and ends their sessions so that the role takes effect.
+// Admins cannot change their own role.
+func changeUserRole(adminID, userID string, w http.ResponseWriter, r *http.Request) {
+	if adminID == userID {
+		http.Error(w, `{"message": "cannot change your own role"}`, http.StatusBadRequest)
+		return
+	}
+
+	change := roleRequest{}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := workoutlog.FrontDesk.ChangeUserRole(userID, change.Role); err != nil {
+		writeUserAdminError(err, w)
+		return
+	}
+
+	endUserSessions(userID, w)
+}
+
+// resetPassword sets the password of a user, ends their sessions, and requires them to change the password after they log in.
+func resetPassword(userID string, w http.ResponseWriter, r *http.Request) {
+	reset := passwordResetRequest{}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := workoutlog.FrontDesk.ResetPassword(userID, reset.Password); err != nil {
+		writeUserAdminError(err, w)
+		return
+	}
+
+	endUserSessions(userID, w)
+}
+
+// setUserDisabled disables or enables a user. The sessions of disabled users are ended.
+// Admins cannot disable themselves.
+func setUserDisabled(adminID, userID string, disabled bool, w http.ResponseWriter) {
+	if disabled && adminID == userID {
+		http.Error(w, `{"message": "cannot disable your own account"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := workoutlog.FrontDesk.SetUserDisabled(userID, disabled); err != nil {
+		writeUserAdminError(err, w)
+		return
+	}
+
+	if disabled {
+		endUserSessions(userID, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteUser deletes a user and all of their data.
+// Admins cannot delete themselves.
+func deleteUser(adminID, userID string, w http.ResponseWriter) {
+	if adminID == userID {
+		http.Error(w, `{"message": "cannot delete your own account"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := workoutlog.FrontDesk.DeleteUser(userID); err != nil {
+		writeUserAdminError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// endUserSessions ends all sessions of a user.
+func endUserSessions(userID string, w http.ResponseWriter) {
+	if err := endOtherSessions(userID, ""); err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeUserAdminError(err error, w http.ResponseWriter) {
+	switch {
+	case errors.Is(err, workoutlog.ErrUserNotFound):
+		http.Error(w, `{"message": "user not found"}`, http.StatusNotFound)
+	case errors.Is(err, auth.ErrBusy):
+		slog.Warn(err.Error())
+		writeBusyError(w)
+	case errors.Is(err, workoutlog.ErrInvalidRole), errors.Is(err, workoutlog.ErrRoleUnchanged), errors.Is(err, workoutlog.ErrInvalidPassword):
+		http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+	default:
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+	}
+}
+
+func listInvites(w http.ResponseWriter) {
+	invites, err := workoutlog.FrontDesk.ListInvites()
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(invites)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// createInvite creates an invite code. When no ttl is provided the default is used.
+func createInvite(w http.ResponseWriter, r *http.Request) {
+	request := inviteRequest{}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "could not parse the request body"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if request.TTL < 0 {
+		http.Error(w, `{"message": "ttl must not be negative"}`, http.StatusBadRequest)
+		return
+	}
+
+	invite, err := workoutlog.FrontDesk.CreateInvite(time.Duration(request.TTL) * time.Second)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(invite)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func deleteInvite(code string, w http.ResponseWriter) {
+	if err := workoutlog.FrontDesk.DeleteInvite(code); err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func listLockouts(w http.ResponseWriter) {
+	lockouts, err := authorizer.Lockouts()
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(lockouts)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// clearLockout forgets the failed login attempts for a user name or remote address.
+func clearLockout(kind, id string, w http.ResponseWriter) {
+	if err := authorizer.ClearLockout(kind, id); err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
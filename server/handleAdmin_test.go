@@ -0,0 +1,431 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottbrodersen/homegym/admin"
+	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/workoutlog"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAdminApi(t *testing.T) {
+	testBackupID := "20240101-000000.000"
+
+	Convey("Given a database manager", t, func() {
+		mockDatabaseManager := newMockDatabaseAdmin()
+		admin.DatabaseManager = mockDatabaseManager
+
+		testBackup := dal.Backup{ID: testBackupID, Time: 1704067200, Full: true, Size: 1024}
+
+		Convey("When a user who is not an admin sends a request", func() {
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/admin/backups", nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+			mockDatabaseManager.AssertNotCalled(t, "ListBackups")
+		})
+
+		Convey("When we receive a request to list backups", func() {
+			mockDatabaseManager.On("ListBackups").Return([]dal.Backup{testBackup}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/admin/backups", nil)
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			backups := []dal.Backup{}
+			err := json.NewDecoder(w.Result().Body).Decode(&backups)
+			So(err, ShouldBeNil)
+			So(backups, ShouldResemble, []dal.Backup{testBackup})
+		})
+
+		Convey("When we receive a request to save a backup", func() {
+			mockDatabaseManager.On("CreateBackup").Return(&testBackup, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/admin/backups", nil)
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockDatabaseManager.AssertCalled(t, "CreateBackup"), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to download a backup", func() {
+			mockDatabaseManager.On("OpenBackup", testBackupID).Return(io.NopCloser(strings.NewReader("backup")), nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/admin/backups/"+testBackupID, nil)
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(w.Result().Header.Get("Content-Disposition"), ShouldStartWith, "attachment")
+			So(w.Body.String(), ShouldEqual, "backup")
+		})
+
+		Convey("When we receive a request to download a backup that does not exist", func() {
+			mockDatabaseManager.On("OpenBackup", testBackupID).Return(nil, dal.ErrBackupNotFound)
+
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/admin/backups/"+testBackupID, nil)
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When we receive a request to upload an invalid backup", func() {
+			mockDatabaseManager.On("UploadBackup", mock.Anything).Return(nil, dal.ErrInvalidBackup)
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/admin/backups/upload", bytes.NewBufferString("not a backup"))
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When we receive a request to upload a backup that is too large", func() {
+			So(SetMaxBackupUpload(1), ShouldBeNil)
+			defer SetMaxBackupUpload(1024)
+
+			var readErr error
+			mockDatabaseManager.On("UploadBackup", mock.Anything).Run(func(args mock.Arguments) {
+				_, readErr = io.ReadAll(args.Get(0).(io.Reader))
+			}).Return(nil, fmt.Errorf("failed to save backup file: %w", &http.MaxBytesError{Limit: 1 << 20}))
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/admin/backups/upload", bytes.NewReader(make([]byte, 1<<20+1)))
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(errors.As(readErr, new(*http.MaxBytesError)), ShouldBeTrue)
+			So(w.Result().StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("When we receive a confirmed request to restore a backup", func() {
+			summary := dal.RestoreSummary{BackupID: testBackupID, Users: 1, Events: 10}
+			mockDatabaseManager.On("RestoreBackup", testBackupID).Return(&summary, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/admin/backups/"+testBackupID+"/restore", bytes.NewBufferString(`{"confirm": true}`))
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			returned := dal.RestoreSummary{}
+			err := json.NewDecoder(w.Result().Body).Decode(&returned)
+			So(err, ShouldBeNil)
+			So(returned, ShouldResemble, summary)
+		})
+
+		Convey("When we receive an unconfirmed request to restore a backup", func() {
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/admin/backups/"+testBackupID+"/restore", nil)
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockDatabaseManager.AssertNotCalled(t, "RestoreBackup", mock.Anything)
+		})
+
+		Convey("When restoring a backup fails", func() {
+			mockDatabaseManager.On("RestoreBackup", testBackupID).Return(nil, dal.ErrBackupNotFound)
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/admin/backups/"+testBackupID+"/restore", bytes.NewBufferString(`{"confirm": true}`))
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When we receive a GET request to restore a backup", func() {
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/admin/backups/"+testBackupID+"/restore", nil)
+			req = req.WithContext(testAdminContext())
+			w := httptest.NewRecorder()
+
+			AdminApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			mockDatabaseManager.AssertNotCalled(t, "RestoreBackup", mock.Anything)
+		})
+	})
+}
+
+func TestAdminUsersApi(t *testing.T) {
+	otherUserID := "otheruser"
+
+	Convey("Given a user admin", t, func() {
+		mockUserAdmin := newMockUserAdmin()
+		workoutlog.FrontDesk = mockUserAdmin
+		mockAuth := NewMockAuthorizer()
+		authorizer = mockAuth
+
+		otherUser := workoutlog.User{ID: otherUserID, Role: auth.User, Email: "other@example.com"}
+
+		adminRequest := func(method, path, body string) *http.Request {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			return req.WithContext(testAdminContext())
+		}
+
+		Convey("When we receive a request to list users", func() {
+			mockUserAdmin.On("ListUsers").Return([]workoutlog.User{otherUser}, nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodGet, "/homegym/api/admin/users", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			users := []workoutlog.User{}
+			err := json.NewDecoder(w.Result().Body).Decode(&users)
+			So(err, ShouldBeNil)
+			So(users, ShouldResemble, []workoutlog.User{otherUser})
+		})
+
+		Convey("When we receive a request to create a user", func() {
+			mockUserAdmin.On("NewUser", otherUserID, auth.User, otherUser.Email, "password0123456789").Return(&otherUser, nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users", `{"id": "otheruser", "email": "other@example.com", "password": "password0123456789"}`))
+
+			Convey("Then the user is created with the user role", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(mockUserAdmin.AssertCalled(t, "NewUser", otherUserID, auth.User, otherUser.Email, "password0123456789"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When we receive a request to create a user with an invalid id", func() {
+			mockUserAdmin.On("NewUser", "other#user", auth.User, otherUser.Email, "password0123456789").Return(nil, dal.ErrInvalidUserID)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users", `{"id": "other#user", "email": "other@example.com", "password": "password0123456789"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When we receive a request to create a user with an unknown role", func() {
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users", `{"id": "otheruser", "email": "other@example.com", "role": "owner", "password": "password0123456789"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockUserAdmin.AssertNotCalled(t, "NewUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		Convey("When we receive a request to change the role of a user", func() {
+			mockUserAdmin.On("ChangeUserRole", otherUserID, auth.Admin).Return(nil)
+			mockAuth.On("Sessions", otherUserID).Return([]auth.Session{{UserID: otherUserID, SessionID: testSessionID}}, nil)
+			mockAuth.On("RevokeSession", otherUserID, testSessionID).Return(nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users/otheruser/role", `{"role": "admin"}`))
+
+			Convey("Then the role is changed and the sessions of the user are ended", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(mockUserAdmin.AssertCalled(t, "ChangeUserRole", otherUserID, auth.Admin), ShouldBeTrue)
+				So(mockAuth.AssertCalled(t, "RevokeSession", otherUserID, testSessionID), ShouldBeTrue)
+			})
+		})
+
+		Convey("When we receive a request to change the role of the admin", func() {
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users/"+testUserName+"/role", `{"role": "user"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockUserAdmin.AssertNotCalled(t, "ChangeUserRole", mock.Anything, mock.Anything)
+		})
+
+		Convey("When we receive a request to reset the password of a user", func() {
+			mockUserAdmin.On("ResetPassword", otherUserID, "temporary0123456789").Return(nil)
+			mockAuth.On("Sessions", otherUserID).Return([]auth.Session{}, nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users/otheruser/password", `{"password": "temporary0123456789"}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockUserAdmin.AssertCalled(t, "ResetPassword", otherUserID, "temporary0123456789"), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to disable a user", func() {
+			mockUserAdmin.On("SetUserDisabled", otherUserID, true).Return(nil)
+			mockAuth.On("Sessions", otherUserID).Return([]auth.Session{{UserID: otherUserID, SessionID: testSessionID}}, nil)
+			mockAuth.On("RevokeSession", otherUserID, testSessionID).Return(nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users/otheruser/disable", ""))
+
+			Convey("Then the user is disabled and their sessions are ended", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(mockUserAdmin.AssertCalled(t, "SetUserDisabled", otherUserID, true), ShouldBeTrue)
+				So(mockAuth.AssertCalled(t, "RevokeSession", otherUserID, testSessionID), ShouldBeTrue)
+			})
+		})
+
+		Convey("When we receive a request to enable a user", func() {
+			mockUserAdmin.On("SetUserDisabled", otherUserID, false).Return(nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/users/otheruser/enable", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockUserAdmin.AssertCalled(t, "SetUserDisabled", otherUserID, false), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to delete a user", func() {
+			mockUserAdmin.On("DeleteUser", otherUserID).Return(nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodDelete, "/homegym/api/admin/users/otheruser", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockUserAdmin.AssertCalled(t, "DeleteUser", otherUserID), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to delete a user that does not exist", func() {
+			mockUserAdmin.On("DeleteUser", otherUserID).Return(workoutlog.ErrUserNotFound)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodDelete, "/homegym/api/admin/users/otheruser", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When we receive a request to delete the admin", func() {
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodDelete, "/homegym/api/admin/users/"+testUserName, ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockUserAdmin.AssertNotCalled(t, "DeleteUser", mock.Anything)
+		})
+	})
+}
+
+func TestAdminInvitesApi(t *testing.T) {
+	testInvite := workoutlog.Invite{Code: "ABCDEFGHIJKLMNOP", Expires: 1704067200}
+
+	Convey("Given a user admin", t, func() {
+		mockUserAdmin := newMockUserAdmin()
+		workoutlog.FrontDesk = mockUserAdmin
+
+		adminRequest := func(method, path, body string) *http.Request {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			return req.WithContext(testAdminContext())
+		}
+
+		Convey("When we receive a request to list invites", func() {
+			mockUserAdmin.On("ListInvites").Return([]workoutlog.Invite{testInvite}, nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodGet, "/homegym/api/admin/invites", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			invites := []workoutlog.Invite{}
+			err := json.NewDecoder(w.Result().Body).Decode(&invites)
+			So(err, ShouldBeNil)
+			So(invites, ShouldResemble, []workoutlog.Invite{testInvite})
+		})
+
+		Convey("When we receive a request to create an invite with a ttl", func() {
+			mockUserAdmin.On("CreateInvite", time.Hour).Return(&testInvite, nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/invites", `{"ttl": 3600}`))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockUserAdmin.AssertCalled(t, "CreateInvite", time.Hour), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to create an invite without a body", func() {
+			mockUserAdmin.On("CreateInvite", time.Duration(0)).Return(&testInvite, nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodPost, "/homegym/api/admin/invites", ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockUserAdmin.AssertCalled(t, "CreateInvite", time.Duration(0)), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to delete an invite", func() {
+			mockUserAdmin.On("DeleteInvite", testInvite.Code).Return(nil)
+
+			w := httptest.NewRecorder()
+			AdminApi(w, adminRequest(http.MethodDelete, "/homegym/api/admin/invites/"+testInvite.Code, ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockUserAdmin.AssertCalled(t, "DeleteInvite", testInvite.Code), ShouldBeTrue)
+		})
+	})
+}
+
+func TestAdminLockoutsApi(t *testing.T) {
+	testLockout := auth.Lockout{Kind: auth.LockoutAddress, ID: "192.0.2.1", Failures: 25, LastFailure: 1704067200, LockedUntil: 1704067300}
+
+	Convey("Given an authorizer", t, func() {
+		mockAuth := NewMockAuthorizer()
+		authorizer = mockAuth
+
+		Convey("When we receive a request to list lockouts", func() {
+			mockAuth.On("Lockouts").Return([]auth.Lockout{testLockout}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/admin/lockouts", nil)
+			w := httptest.NewRecorder()
+			AdminApi(w, req.WithContext(testAdminContext()))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			lockouts := []auth.Lockout{}
+			err := json.NewDecoder(w.Result().Body).Decode(&lockouts)
+			So(err, ShouldBeNil)
+			So(lockouts, ShouldResemble, []auth.Lockout{testLockout})
+		})
+
+		Convey("When we receive a request to clear a lockout", func() {
+			mockAuth.On("ClearLockout", auth.LockoutAddress, "192.0.2.1").Return(nil)
+
+			req := httptest.NewRequest(http.MethodDelete, "/homegym/api/admin/lockouts/address/192.0.2.1", nil)
+			w := httptest.NewRecorder()
+			AdminApi(w, req.WithContext(testAdminContext()))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockAuth.AssertCalled(t, "ClearLockout", auth.LockoutAddress, "192.0.2.1"), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to clear a lockout of an unknown kind", func() {
+			req := httptest.NewRequest(http.MethodDelete, "/homegym/api/admin/lockouts/device/abc", nil)
+			w := httptest.NewRecorder()
+			AdminApi(w, req.WithContext(testAdminContext()))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+			mockAuth.AssertNotCalled(t, "ClearLockout", mock.Anything, mock.Anything)
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/scottbrodersen/homegym/workoutlog"
+)
+
+// AnalyticsApi handles requests for training analytics.
+func AnalyticsApi(w http.ResponseWriter, r *http.Request) {
+	username, _, err := whoIsIt(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", err.Error()), http.StatusForbidden)
+		return
+	}
+
+	if (r.URL.Path != "/homegym/api/analytics" && r.URL.Path != "/homegym/api/analytics/") || r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	getAnalytics(*username, w, r)
+}
+
+// getAnalytics writes the analytics of the events that match the URL query parameters.
+// e.g. /homegym/api/analytics?period=week&group=exercise&type=blah&variations=true&activity=blah&start=blah&end=blah&tz=America/Toronto
+// Microcycle periods require the program and instance parameters.
+func getAnalytics(username string, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse URL query parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := stringToInt64(r.Form.Get("start"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad start value"}`, http.StatusBadRequest)
+		return
+	}
+
+	endDate, err := stringToInt64(r.Form.Get("end"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad end value"}`, http.StatusBadRequest)
+		return
+	}
+
+	location := time.UTC
+	if tz := r.Form.Get("tz"); tz != "" {
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "unknown time zone"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	period := r.Form.Get("period")
+	if period == "" {
+		period = workoutlog.PeriodWeek
+	}
+
+	query := workoutlog.AnalyticsQuery{
+		Period:     period,
+		GroupBy:    r.Form.Get("group"),
+		ActivityID: r.Form.Get("activity"),
+		Filter: workoutlog.ExerciseFilter{
+			StartDate:     startDate,
+			EndDate:       endDate,
+			ExerciseTypes: r.Form["type"],
+			Variations:    r.Form.Get("variations") == "true",
+		},
+		Location:          location,
+		ProgramID:         r.Form.Get("program"),
+		ProgramInstanceID: r.Form.Get("instance"),
+	}
+
+	analytics, err := workoutlog.EventManager.GetAnalytics(username, query)
+	if err != nil {
+		if errors.Is(err, workoutlog.ErrInvalidAnalyticsQuery) {
+			slog.Debug(err.Error())
+			http.Error(w, fmt.Sprintf(`{"message": "%s"}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, workoutlog.ErrNotFound) {
+			http.Error(w, `{"message": "program instance not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(analytics)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, `{"message": "failed to marshal response body"}`, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/scottbrodersen/homegym/workoutlog"
+)
+
+func TestHandleAnalytics(t *testing.T) {
+	analyticsURL := "/homegym/api/analytics"
+
+	Convey("Given an event manager", t, func() {
+		mockEventManager := workoutlog.NewMockEventAdmin()
+		workoutlog.EventManager = mockEventManager
+
+		Convey("When we get analytics", func() {
+			analytics := workoutlog.Analytics{
+				Period:  workoutlog.PeriodMonth,
+				GroupBy: workoutlog.GroupExercise,
+				Buckets: []workoutlog.AnalyticsBucket{{
+					Start: 1000,
+					Groups: []workoutlog.AnalyticsGroup{{
+						ID:       "squat-id",
+						Name:     "squat",
+						Sessions: 2,
+						Volumes:  map[string]*workoutlog.VolumeStats{"count": {Sets: 3, Reps: 10, Tonnage: 1040}},
+					}},
+				}},
+			}
+			mockEventManager.On("GetAnalytics", testUserName, mock.Anything).Return(&analytics, nil)
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?period=month&group=exercise&type=squat-id&type=run-id&variations=true&activity=lifting-id&start=3000&end=1000&tz=UTC", analyticsURL), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			AnalyticsApi(w, req)
+
+			Convey("Then the query parameters are passed to the event manager", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				query := mockEventManager.Calls[0].Arguments.Get(1).(workoutlog.AnalyticsQuery)
+				So(query, ShouldResemble, workoutlog.AnalyticsQuery{
+					Period:     workoutlog.PeriodMonth,
+					GroupBy:    workoutlog.GroupExercise,
+					ActivityID: "lifting-id",
+					Filter:     workoutlog.ExerciseFilter{StartDate: 3000, EndDate: 1000, ExerciseTypes: []string{"squat-id", "run-id"}, Variations: true},
+					Location:   time.UTC,
+				})
+
+				returned := workoutlog.Analytics{}
+				err := json.NewDecoder(w.Result().Body).Decode(&returned)
+				So(err, ShouldBeNil)
+				So(returned, ShouldResemble, analytics)
+			})
+		})
+
+		Convey("When we get analytics without a period", func() {
+			mockEventManager.On("GetAnalytics", testUserName, mock.Anything).Return(&workoutlog.Analytics{}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, analyticsURL, nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			AnalyticsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockEventManager.Calls[0].Arguments.Get(1).(workoutlog.AnalyticsQuery).Period, ShouldEqual, workoutlog.PeriodWeek)
+		})
+
+		Convey("When the query is invalid", func() {
+			mockEventManager.On("GetAnalytics", testUserName, mock.Anything).Return(nil, fmt.Errorf("%w: unknown period", workoutlog.ErrInvalidAnalyticsQuery))
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?period=year", analyticsURL), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			AnalyticsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When the time zone is unknown", func() {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?tz=Nowhere/Special", analyticsURL), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			AnalyticsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockEventManager.AssertNotCalled(t, "GetAnalytics", mock.Anything, mock.Anything)
+		})
+
+		Convey("When the program instance is not found", func() {
+			mockEventManager.On("GetAnalytics", testUserName, mock.Anything).Return(nil, fmt.Errorf("failed to get microcycles: %w", workoutlog.ErrNotFound))
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%s?period=microcycle&program=p&instance=i", analyticsURL), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			AnalyticsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/scottbrodersen/homegym/archive"
+	"github.com/scottbrodersen/homegym/workoutlog"
+)
+
+// maxArchiveBytes is the largest archive that can be imported.
+const maxArchiveBytes int64 = 64 << 20
+
+// importFailure is the response body of an import that failed after some items were added.
+type importFailure struct {
+	Message string                 `json:"message"`
+	Summary *archive.ImportSummary `json:"summary"`
+}
+
+// csvImportFailure is the response body of a CSV import that failed after some items were added.
+type csvImportFailure struct {
+	Message string                      `json:"message"`
+	Report  *workoutlog.CSVImportReport `json:"report"`
+}
+
+// ExportApi handles requests to export all of the data of the user.
+func ExportApi(w http.ResponseWriter, r *http.Request) {
+	username, _, err := whoIsIt(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", err.Error()), http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path != "/homegym/api/export" || r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	exported, err := archive.ArchiveManager.Export(*username)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(exported)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	h.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"homegym-%s.json\"", time.Now().Format("20060102")))
+	w.Write(body)
+}
+
+// ImportApi handles requests to import an archive into the data of the user.
+func ImportApi(w http.ResponseWriter, r *http.Request) {
+	username, _, err := whoIsIt(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", err.Error()), http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path != "/homegym/api/import" || r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	if r.Body == nil {
+		slog.Debug("No request body")
+		http.Error(w, `{"message": "request body is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveBytes)
+
+	imported := archive.Archive{}
+	if err := json.NewDecoder(r.Body).Decode(&imported); err != nil {
+		slog.Debug("Failed to decode archive", "error", err.Error())
+		http.Error(w, `{"message": "could not read archive"}`, http.StatusBadRequest)
+		return
+	}
+
+	summary, err := archive.ArchiveManager.Import(*username, imported)
+	if err != nil {
+		slog.Error(err.Error())
+		if errors.As(err, new(archive.ErrInvalidArchive)) || errors.Is(err, archive.ErrUnsupportedVersion) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+			return
+		}
+		if summary == nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			return
+		}
+
+		// report the items that were imported before the failure
+		failure, err := json.Marshal(importFailure{Message: "import failed", Summary: summary})
+		if err != nil {
+			slog.Error(err.Error())
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, string(failure), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(summary)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// ImportCSVApi handles requests to import workout history from a CSV file.
+// The request is a multipart form with a spec part that contains the column mapping
+// and a file part that contains the CSV file.
+// Set the dryrun query parameter to true to get the report without importing.
+func ImportCSVApi(w http.ResponseWriter, r *http.Request) {
+	username, _, err := whoIsIt(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", err.Error()), http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path != "/homegym/api/import/csv" || r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveBytes)
+	if err := r.ParseMultipartForm(maxArchiveBytes); err != nil {
+		slog.Debug("Failed to parse multipart form", "error", err.Error())
+		http.Error(w, `{"message": "could not read form"}`, http.StatusBadRequest)
+		return
+	}
+
+	spec := workoutlog.CSVImportSpec{}
+	if err := json.Unmarshal([]byte(r.FormValue("spec")), &spec); err != nil {
+		slog.Debug("Failed to decode CSV import spec", "error", err.Error())
+		http.Error(w, `{"message": "could not read spec"}`, http.StatusBadRequest)
+		return
+	}
+
+	csvFile, _, err := r.FormFile("file")
+	if err != nil {
+		slog.Debug("No CSV file", "error", err.Error())
+		http.Error(w, `{"message": "file is required"}`, http.StatusBadRequest)
+		return
+	}
+	defer csvFile.Close()
+
+	dryRun := r.URL.Query().Get("dryrun") == "true"
+
+	report, err := workoutlog.CSVImportManager.ImportCSV(*username, csvFile, spec, dryRun)
+	if err != nil {
+		slog.Error(err.Error())
+		if errors.As(err, new(workoutlog.ErrInvalidCSV)) {
+			http.Error(w, fmt.Sprintf("{\"message\": \"%s\"}", jsonSafeError(err)), http.StatusBadRequest)
+			return
+		}
+		if report == nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			return
+		}
+
+		// report the items that were imported before the failure
+		failure, err := json.Marshal(csvImportFailure{Message: "import failed", Report: report})
+		if err != nil {
+			slog.Error(err.Error())
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, string(failure), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scottbrodersen/homegym/archive"
+	"github.com/scottbrodersen/homegym/workoutlog"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleArchive(t *testing.T) {
+	Convey("Given an archive manager", t, func() {
+		mockArchiveManager := newMockArchiveAdmin()
+		archive.ArchiveManager = mockArchiveManager
+
+		testArchive := archive.Archive{Version: archive.Version, OneRMs: map[string]int{"eid1": 100}}
+
+		Convey("When we receive a request to export data", func() {
+			mockArchiveManager.On("Export", testUserName).Return(&testArchive, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/homegym/api/export", nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			ExportApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(w.Result().Header.Get("Content-Disposition"), ShouldStartWith, "attachment")
+
+			exported := archive.Archive{}
+			err := json.NewDecoder(w.Result().Body).Decode(&exported)
+			So(err, ShouldBeNil)
+			So(exported, ShouldResemble, testArchive)
+		})
+
+		Convey("When we receive a request to import an archive", func() {
+			summary := archive.ImportSummary{Events: 1}
+			mockArchiveManager.On("Import", testUserName, testArchive).Return(&summary, nil)
+
+			body, err := json.Marshal(testArchive)
+			So(err, ShouldBeNil)
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/import", bytes.NewBuffer(body))
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			ImportApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			returned := archive.ImportSummary{}
+			err = json.NewDecoder(w.Result().Body).Decode(&returned)
+			So(err, ShouldBeNil)
+			So(returned, ShouldResemble, summary)
+		})
+
+		Convey("When we receive a request to import an invalid archive", func() {
+			mockArchiveManager.On("Import", testUserName, mock.Anything).Return(nil, archive.ErrInvalidArchive{Message: "bad"})
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/import", bytes.NewBufferString(`{"version":1}`))
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			ImportApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When an import fails after items are added", func() {
+			summary := archive.ImportSummary{Activities: 1, Events: 2}
+			mockArchiveManager.On("Import", testUserName, mock.Anything).Return(&summary, errors.New("failed to import event"))
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/import", bytes.NewBufferString(`{"version":1}`))
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			ImportApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+
+			failure := importFailure{}
+			So(json.NewDecoder(w.Result().Body).Decode(&failure), ShouldBeNil)
+			So(*failure.Summary, ShouldResemble, summary)
+		})
+
+		Convey("When we receive a request to import an unsupported version", func() {
+			mockArchiveManager.On("Import", testUserName, mock.Anything).Return(nil, fmt.Errorf("%w: 2", archive.ErrUnsupportedVersion))
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/import", bytes.NewBufferString(`{"version":2}`))
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			ImportApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When we receive a request to import a malformed archive", func() {
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/import", bytes.NewBufferString(`{"version":`))
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			ImportApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockArchiveManager.AssertNotCalled(t, "Import", mock.Anything, mock.Anything)
+		})
+	})
+
+	Convey("Given a CSV importer", t, func() {
+		mockImporter := workoutlog.NewMockCSVImporter()
+		workoutlog.CSVImportManager = mockImporter
+
+		spec := workoutlog.CSVImportSpec{ActivityID: testActivityID, Columns: workoutlog.CSVColumns{Date: "date", Exercise: "exercise", Reps: "reps"}}
+
+		csvRequest := func(spec string, csvFile string) *http.Request {
+			body := new(bytes.Buffer)
+			form := multipart.NewWriter(body)
+			form.WriteField("spec", spec)
+			if csvFile != "" {
+				part, err := form.CreateFormFile("file", "history.csv")
+				if err != nil {
+					t.Fatal(err)
+				}
+				part.Write([]byte(csvFile))
+			}
+			form.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/api/import/csv?dryrun=true", body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			return req.WithContext(testContext())
+		}
+
+		specJSON, err := json.Marshal(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Convey("When we receive a request for a dry run of a CSV import", func() {
+			report := workoutlog.CSVImportReport{DryRun: true, Rows: 1}
+			mockImporter.On("ImportCSV", testUserName, mock.Anything, spec, true).Return(&report, nil)
+
+			w := httptest.NewRecorder()
+			ImportCSVApi(w, csvRequest(string(specJSON), "date,exercise,reps\n2024-01-01,squat,5\n"))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			returned := workoutlog.CSVImportReport{}
+			err := json.NewDecoder(w.Result().Body).Decode(&returned)
+			So(err, ShouldBeNil)
+			So(returned, ShouldResemble, report)
+		})
+
+		Convey("When we receive a request with an invalid column mapping", func() {
+			mockImporter.On("ImportCSV", testUserName, mock.Anything, spec, true).Return(nil, workoutlog.ErrInvalidCSV{Message: "column not found: reps"})
+
+			w := httptest.NewRecorder()
+			ImportCSVApi(w, csvRequest(string(specJSON), "date,exercise\n"))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When a CSV import fails after items are added", func() {
+			report := workoutlog.CSVImportReport{
+				Rows:    2,
+				Events:  []workoutlog.CSVImportEvent{{ID: "event-id", ActivityID: testActivityID}},
+				Errors:  []workoutlog.CSVRowError{},
+				Failure: &workoutlog.CSVRowError{Row: 3, Message: "failed to add event"},
+			}
+			mockImporter.On("ImportCSV", testUserName, mock.Anything, spec, false).Return(&report, errors.New("failed to add event"))
+
+			w := httptest.NewRecorder()
+			req := csvRequest(string(specJSON), "date,exercise,reps\n2024-01-01,squat,5\n2024-01-02,squat,5\n")
+			req.URL.RawQuery = ""
+			ImportCSVApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusInternalServerError)
+
+			failure := csvImportFailure{}
+			So(json.NewDecoder(w.Result().Body).Decode(&failure), ShouldBeNil)
+			So(*failure.Report, ShouldResemble, report)
+		})
+
+		Convey("When we receive a request without a file", func() {
+			w := httptest.NewRecorder()
+			ImportCSVApi(w, csvRequest(string(specJSON), ""))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			mockImporter.AssertNotCalled(t, "ImportCSV", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+}
@@ -3,24 +3,34 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
 	"github.com/scottbrodersen/homegym/workoutlog"
 )
 
 type creds struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Form     string `json:"form"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+	Form      string `json:"form"`
 }
 
 // HandleLogin handles log in requests.
 // Generates a JWT and returns it in a cookie.
 // Generates a session ID and returns it in a cookie.
+// When the user has enabled two-factor authentication, responds with status 202 and a challenge
+// that is posted with a code to HandleLoginTOTP.
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 
@@ -28,40 +38,142 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 		*credentials = readPostedBody(w, r, 10000)
 
-		token, sessionID, err := authorizer.IssueToken(credentials.Username, credentials.Password)
+		remoteAddr := remoteHost(r)
+		token, sessionID, err := authorizer.IssueToken(credentials.Username, credentials.Password, r.UserAgent(), remoteAddr)
 		if err != nil {
-			if errors.Is(err, auth.ErrUnauthorized) {
-				slog.Debug(err.Error())
-				http.Error(w, "Authentication failed", http.StatusUnauthorized)
+			challengeErr := &auth.SecondFactorRequiredError{}
+			if errors.As(err, &challengeErr) {
+				h := w.Header()
+				standardHeaders(&h)
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte(fmt.Sprintf(`{"challenge": "%s", "message": "second factor required"}`, challengeErr.Challenge)))
 				return
 			}
+			writeLoginError(err, credentials.Username, remoteAddr, w)
+			return
+		}
+
+		setSessionCookies(token, sessionID, credentials.Form, w, r)
+	}
+}
+
+// HandleLoginTOTP handles the second step of log in requests for users that have enabled two-factor authentication.
+// The request provides the challenge from HandleLogin and a TOTP code or recovery code.
+// Generates a JWT and session ID and returns them in cookies.
+func HandleLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		slog.Debug("Request is not a POST")
+		http.Error(w, "request must be a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentials := readPostedBody(w, r, 10000)
+
+	remoteAddr := remoteHost(r)
+	token, sessionID, err := authorizer.CompleteLogin(credentials.Challenge, credentials.Code, r.UserAgent(), remoteAddr)
+	if err != nil {
+		writeLoginError(err, "", remoteAddr, w)
+		return
+	}
+
+	setSessionCookies(token, sessionID, credentials.Form, w, r)
+}
+
+// writeLoginError responds to a failed login attempt.
+func writeLoginError(err error, username, remoteAddr string, w http.ResponseWriter) {
+	lockoutErr := &auth.LockoutError{}
+	if errors.As(err, &lockoutErr) {
+		slog.Warn("login refused", "username", username, "remote", remoteAddr, "error", err.Error())
+		retryAfter := max(int(math.Ceil(time.Until(lockoutErr.Until).Seconds())), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, auth.ErrBusy) {
+		slog.Warn("login refused", "username", username, "remote", remoteAddr, "error", err.Error())
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server busy", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, auth.ErrUnauthorized) {
+		slog.Info("login failed", "username", username, "remote", remoteAddr)
+		slog.Debug(err.Error())
+		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, auth.ErrAccountDisabled) {
+		slog.Debug(err.Error())
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+	slog.Error(err.Error())
+	http.Error(w, internalServerError, http.StatusInternalServerError)
+}
+
+// writeBusyError responds to a request that is refused because too many passwords are being checked.
+func writeBusyError(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, `{"message": "the server is busy"}`, http.StatusServiceUnavailable)
+}
+
+// setSessionCookies returns the token and session ID of a successful login in cookies.
+// Redirects to the home page when the login was posted from the login form.
+func setSessionCookies(token, sessionID *string, form string, w http.ResponseWriter, r *http.Request) {
+	tokenCookie := tokenCookieMaker(token)
+	sessionCookie := &http.Cookie{
+		Name:     "session",
+		Value:    *sessionID,
+		Secure:   isSafe,
+		HttpOnly: isSafe,
+		Path:     "/homegym/",
+		SameSite: samesite,
+		MaxAge:   authorizer.SessionTTL(),
+	}
+
+	http.SetCookie(w, tokenCookie)
+	http.SetCookie(w, sessionCookie)
+	h := w.Header()
+	standardHeaders(&h)
+
+	if form == "true" {
+		// redirect to home handler
+		http.Redirect(w, r, "/homegym/home/", http.StatusFound)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleLogout handles log out requests.
+// Ends the session in the session cookie and clears the token and session cookies.
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		slog.Debug("Request is not a POST")
+		http.Error(w, "request must be a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if sessionCookie, err := r.Cookie(cookieSession); err == nil {
+		if err := authorizer.EndSession(strings.TrimSpace(sessionCookie.Value)); err != nil {
 			slog.Error(err.Error())
 			http.Error(w, internalServerError, http.StatusInternalServerError)
 			return
 		}
+	}
 
-		tokenCookie := tokenCookieMaker(token)
-		sessionCookie := &http.Cookie{
-			Name:     "session",
-			Value:    *sessionID,
+	for _, name := range []string{cookieToken, cookieSession} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
 			Secure:   isSafe,
 			HttpOnly: isSafe,
 			Path:     "/homegym/",
 			SameSite: samesite,
-			MaxAge:   authorizer.SessionTTL(),
-		}
-
-		http.SetCookie(w, tokenCookie)
-		http.SetCookie(w, sessionCookie)
-		h := w.Header()
-		standardHeaders(&h)
-
-		if credentials.Form == "true" {
-			// redirect to home handler
-			http.Redirect(w, r, "/homegym/home/", http.StatusFound)
-		}
-		w.WriteHeader(http.StatusOK)
+			MaxAge:   -1,
+		})
 	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.WriteHeader(http.StatusOK)
 }
 
 // HandleSignup handles requests to create a new user account.
@@ -83,20 +195,38 @@ func HandleSignup(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 	email := r.FormValue("email")
+	invite := strings.TrimSpace(r.FormValue("invite"))
 
 	if username == "" || password == "" || email == "" {
 		slog.Debug("Either the user name, password, or email are missing")
 		http.Error(w, "missing required field values", http.StatusBadRequest)
 		return
 	}
-	// TODO: check if this is the first user and if so make them admin
-	role := auth.User
 
-	_, err := workoutlog.FrontDesk.NewUser(username, role, email, password)
+	// the signup policy determines the role
+	_, err := workoutlog.FrontDesk.SignUp(username, email, password, invite)
 
 	if err != nil {
-		slog.Error(err.Error())
-		http.Error(w, internalServerError, http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, workoutlog.ErrSignupClosed):
+			slog.Debug(err.Error())
+			http.Error(w, "signup is closed", http.StatusForbidden)
+		case errors.Is(err, workoutlog.ErrInvalidInvite):
+			slog.Debug(err.Error())
+			http.Error(w, "invalid invite code", http.StatusForbidden)
+		case errors.Is(err, dal.ErrNotUnique):
+			slog.Debug(err.Error())
+			http.Error(w, "user name is taken", http.StatusBadRequest)
+		case errors.Is(err, auth.ErrBusy):
+			slog.Warn(err.Error())
+			writeBusyError(w)
+		case errors.Is(err, workoutlog.ErrInvalidPassword), errors.Is(err, dal.ErrInvalidUserID):
+			slog.Debug(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			slog.Error(err.Error())
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -113,6 +243,15 @@ func HandleSignup(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/homegym/login/", http.StatusFound)
 }
 
+// remoteHost returns the host of the remote address of a request.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func readPostedBody(w http.ResponseWriter, r *http.Request, maxBytes int64) creds {
 	if ct := r.Header.Get("Content-Type"); ct != "" {
 		if strings.ToLower(ct) != "application/json" {
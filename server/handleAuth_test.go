@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/scottbrodersen/homegym/auth"
 	"github.com/scottbrodersen/homegym/workoutlog"
@@ -57,7 +58,7 @@ func TestHandleAuth(t *testing.T) {
 		mockUserAdmin := newMockUserAdmin()
 
 		Convey("When we receive a login request with valid credentials", func() {
-			mockAuth.On("IssueToken", mock.Anything, mock.Anything).Return(&testToken, &testSessionID, nil)
+			mockAuth.On("IssueToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&testToken, &testSessionID, nil)
 
 			url := "/homegym/login"
 
@@ -115,6 +116,119 @@ func TestHandleAuth(t *testing.T) {
 			So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
 		})
 
+		Convey("When we receive a login request while logins are locked out", func() {
+			until := time.Now().Add(time.Minute)
+			mockAuth.On("IssueToken", testUserName, testPassword, mock.Anything, "192.0.2.1").Return(nil, nil, &auth.LockoutError{Until: until})
+
+			bodyBytes, err := json.Marshal(testAuthBody)
+			if err != nil {
+				t.Fatalf("failed to marshal auth body: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/login", bytes.NewReader(bodyBytes))
+			req.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+
+			HandleLogin(w, req)
+
+			Convey("Then the request is refused and the client is told when to retry", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusTooManyRequests)
+				So(w.Result().Header.Get("Retry-After"), ShouldBeIn, []string{"59", "60"})
+				So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When we receive a login request while too many passwords are being checked", func() {
+			mockAuth.On("IssueToken", testUserName, testPassword, mock.Anything, "192.0.2.1").Return(nil, nil, auth.ErrBusy)
+
+			bodyBytes, err := json.Marshal(testAuthBody)
+			if err != nil {
+				t.Fatalf("failed to marshal auth body: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/login", bytes.NewReader(bodyBytes))
+			req.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+
+			HandleLogin(w, req)
+
+			Convey("Then the request is refused and the client is told to retry", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Result().Header.Get("Retry-After"), ShouldEqual, "1")
+				So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When we receive a login request for a disabled account", func() {
+			mockAuth.On("IssueToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, auth.ErrAccountDisabled)
+
+			bodyBytes, err := json.Marshal(testAuthBody)
+			if err != nil {
+				t.Fatalf("failed to marshal auth body: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/login", bytes.NewReader(bodyBytes))
+			w := httptest.NewRecorder()
+
+			HandleLogin(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+			So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
+		})
+
+		Convey("When we receive a login request for a user with two-factor authentication", func() {
+			testChallenge := "test-challenge"
+			mockAuth.On("IssueToken", testUserName, testPassword, mock.Anything, mock.Anything).Return(nil, nil, &auth.SecondFactorRequiredError{Challenge: testChallenge})
+
+			bodyBytes, err := json.Marshal(testAuthBody)
+			if err != nil {
+				t.Fatalf("failed to marshal auth body: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/login", bytes.NewReader(bodyBytes))
+			w := httptest.NewRecorder()
+
+			HandleLogin(w, req)
+
+			Convey("Then the challenge is returned without cookies", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusAccepted)
+				So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
+
+				returned := map[string]string{}
+				So(json.NewDecoder(w.Result().Body).Decode(&returned), ShouldBeNil)
+				So(returned["challenge"], ShouldEqual, testChallenge)
+			})
+
+			Convey("When we receive the second factor", func() {
+				mockAuth.On("CompleteLogin", testChallenge, "123456", mock.Anything, "192.0.2.1").Return(&testToken, &testSessionID, nil)
+
+				body := fmt.Sprintf(`{"challenge": "%s", "code": "123456", "form": "true"}`, testChallenge)
+				req := httptest.NewRequest(http.MethodPost, "/homegym/login/totp", strings.NewReader(body))
+				req.RemoteAddr = "192.0.2.1:1234"
+				w := httptest.NewRecorder()
+
+				HandleLoginTOTP(w, req)
+
+				Convey("Then the token and session cookies are returned", func() {
+					So(w.Result().StatusCode, ShouldEqual, http.StatusFound)
+					So(w.Result().Header.Values("Set-Cookie"), ShouldHaveLength, 2)
+				})
+			})
+
+			Convey("When we receive an invalid second factor", func() {
+				mockAuth.On("CompleteLogin", testChallenge, "000000", mock.Anything, mock.Anything).Return(nil, nil, auth.ErrUnauthorized)
+
+				body := fmt.Sprintf(`{"challenge": "%s", "code": "000000"}`, testChallenge)
+				req := httptest.NewRequest(http.MethodPost, "/homegym/login/totp", strings.NewReader(body))
+				w := httptest.NewRecorder()
+
+				HandleLoginTOTP(w, req)
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusUnauthorized)
+				So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
+			})
+		})
+
 		Convey("When we receive a request with invalid credentials", func() {
 			badTestAuthBody := struct {
 				Username string `json:"username"`
@@ -126,7 +240,7 @@ func TestHandleAuth(t *testing.T) {
 				Form:     "true",
 			}
 
-			mockAuth.On("IssueToken", mock.Anything, mock.Anything).Return(nil, nil, auth.ErrUnauthorized)
+			mockAuth.On("IssueToken", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, auth.ErrUnauthorized)
 
 			bodyBytes, err := json.Marshal(badTestAuthBody)
 			if err != nil {
@@ -146,9 +260,9 @@ func TestHandleAuth(t *testing.T) {
 		Convey("When we receive a valid signup request", func() {
 			workoutlog.FrontDesk = mockUserAdmin
 
-			mockUserAdmin.On("NewUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&workoutlog.User{}, nil)
+			mockUserAdmin.On("SignUp", testUserName, testEmail, testPassword, "INVITE").Return(&workoutlog.User{}, nil)
 
-			url := fmt.Sprintf("/homegym/signup/?username=%s&password=%s&email=%s", testUserName, testPassword, testEmail)
+			url := fmt.Sprintf("/homegym/signup/?username=%s&password=%s&email=%s&invite=INVITE", testUserName, testPassword, testEmail)
 			req := httptest.NewRequest(http.MethodPost, url, nil)
 
 			w := httptest.NewRecorder()
@@ -163,5 +277,62 @@ func TestHandleAuth(t *testing.T) {
 			ss := samesiteString()
 			So(strings.Contains(setCookie[0], fmt.Sprintf("SameSite=%s", ss)), ShouldBeTrue)
 		})
+
+		Convey("When we receive a signup request that the signup policy does not permit", func() {
+			workoutlog.FrontDesk = mockUserAdmin
+
+			mockUserAdmin.On("SignUp", testUserName, testEmail, testPassword, "").Return(nil, workoutlog.ErrSignupClosed)
+
+			url := fmt.Sprintf("/homegym/signup/?username=%s&password=%s&email=%s", testUserName, testPassword, testEmail)
+			req := httptest.NewRequest(http.MethodPost, url, nil)
+
+			w := httptest.NewRecorder()
+
+			HandleSignup(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusForbidden)
+			So(w.Result().Header.Values("Set-Cookie"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestHandleLogout(t *testing.T) {
+	Convey("Given an authorizer", t, func() {
+		mockAuth := NewMockAuthorizer()
+		authorizer = mockAuth
+
+		Convey("When we receive a logout request", func() {
+			mockAuth.On("EndSession", testSessionID).Return(nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/homegym/logout", nil)
+			req.AddCookie(&http.Cookie{Name: cookieSession, Value: testSessionID})
+			w := httptest.NewRecorder()
+
+			HandleLogout(w, req)
+
+			Convey("Then the session is ended and the cookies are cleared", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(mockAuth.AssertCalled(t, "EndSession", testSessionID), ShouldBeTrue)
+
+				cleared := map[string]bool{}
+				for _, c := range w.Result().Cookies() {
+					if c.MaxAge < 0 && c.Value == "" {
+						cleared[c.Name] = true
+					}
+				}
+				So(cleared[cookieToken], ShouldBeTrue)
+				So(cleared[cookieSession], ShouldBeTrue)
+			})
+		})
+
+		Convey("When we receive a logout request with the incorrect method", func() {
+			req := httptest.NewRequest(http.MethodGet, "/homegym/logout", nil)
+			w := httptest.NewRecorder()
+
+			HandleLogout(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+			mockAuth.AssertNotCalled(t, "EndSession", mock.Anything)
+		})
 	})
 }
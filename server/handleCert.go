@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// caCertPath is the public path to the certificate of the local CA.
+const caCertPath = "/homegym/ca.crt"
+
+// caCert returns the PEM-encoded certificate of the local CA that signs the server certificate.
+// It is set when the server manages its certificate.
+var caCert func() []byte
+
+// HandleCACert responds with the certificate of the local CA so that devices can install it and trust the server.
+// Responds with status 404 when the server does not manage its certificate.
+func HandleCACert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		slog.Debug("Request is not a GET")
+		http.Error(w, "request must be a GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if caCert == nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/x-x509-ca-cert")
+	h.Set("Content-Disposition", `attachment; filename="homegym-ca.crt"`)
+	w.Write(caCert())
+}
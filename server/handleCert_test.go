@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandleCACert(t *testing.T) {
+	Convey("Given a server that does not manage its certificate", t, func() {
+		caCert = nil
+
+		Convey("When we request the CA certificate", func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, caCertPath, nil)
+			publicMux.ServeHTTP(w, req)
+
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+
+	Convey("Given a server that manages its certificate", t, func() {
+		testCACert := []byte("-----BEGIN CERTIFICATE-----\ntest\n-----END CERTIFICATE-----\n")
+		caCert = func() []byte { return testCACert }
+		defer func() { caCert = nil }()
+
+		Convey("When we request the CA certificate", func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, caCertPath, nil)
+			publicMux.ServeHTTP(w, req)
+
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/x-x509-ca-cert")
+			So(w.Body.String(), ShouldEqual, string(testCACert))
+		})
+
+		Convey("When we post to the CA certificate", func() {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, caCertPath, nil)
+			publicMux.ServeHTTP(w, req)
+
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
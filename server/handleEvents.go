@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/scottbrodersen/homegym/workoutlog"
 )
@@ -20,6 +22,20 @@ type metrics struct {
 	MaxIntensity []map[string]float32 `json:"maxIntensity"`
 }
 
+// oneRMMetrics is the estimated 1RM of an exercise type for each event in which it was performed.
+type oneRMMetrics struct {
+	Formula workoutlog.OneRMFormula `json:"formula"`
+	Dates   []int64                 `json:"dates"`
+	OneRM   []float32               `json:"e1rm"`
+}
+
+// A savedEvent is the response to adding or updating an event.
+// Records are the personal records that the event set.
+type savedEvent struct {
+	ID      string                      `json:"id"`
+	Records []workoutlog.PersonalRecord `json:"records"`
+}
+
 // EventsAi handles requests for events.
 func EventsApi(w http.ResponseWriter, r *http.Request) {
 	rootPath := "/homegym/api/events/"
@@ -39,6 +55,12 @@ func EventsApi(w http.ResponseWriter, r *http.Request) {
 	rxpExercisesPath := regexp.MustCompile(fmt.Sprintf("^%s(\\d+)/([a-zA-Z0-9-]+)/exercises/?$", rootPath))
 	//  path to metrics for a range of events e.g. /api/events/metrics?type=blah&start=blah&end=blah
 	rxpMetrics := regexp.MustCompile(fmt.Sprintf("^%smetrics(\\?[a-z]+=[a-zA-Z0-9-]+((&[a-z]+=[a-zA-Z0-9-]+)*)?)?$", rootPath))
+	//  path to estimated 1RM metrics e.g. /api/events/metrics/e1rm?type=blah&formula=epley&start=blah&end=blah
+	rxpOneRMMetrics := regexp.MustCompile(fmt.Sprintf("^%smetrics/e1rm/?$", rootPath))
+	//  path to training load metrics e.g. /api/events/metrics/load?start=blah&end=blah&tz=blah
+	rxpLoadMetrics := regexp.MustCompile(fmt.Sprintf("^%smetrics/load/?$", rootPath))
+	//  path to export sets e.g. /api/events/export?format=csv&start=blah&end=blah&activity=blah&type=blah
+	rxpExport := regexp.MustCompile(fmt.Sprintf("^%sexport/?$", rootPath))
 
 	slog.Debug("parsing path", "path", r.URL.Path)
 
@@ -75,6 +97,21 @@ func EventsApi(w http.ResponseWriter, r *http.Request) {
 			getMetrics(*username, w, r)
 			return
 		}
+	} else if rxpOneRMMetrics.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			getOneRMMetrics(*username, w, r)
+			return
+		}
+	} else if rxpLoadMetrics.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			getTrainingLoad(*username, w, r)
+			return
+		}
+	} else if rxpExport.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			exportEvents(*username, w, r)
+			return
+		}
 	}
 
 	http.Error(w, `{"message": "unsupported request type"}`, http.StatusBadRequest)
@@ -89,7 +126,7 @@ func addEvent(username string, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	eventID, err := workoutlog.EventManager.NewEvent(username, *newEvent)
+	eventID, records, err := workoutlog.EventManager.NewEvent(username, *newEvent)
 
 	if err != nil {
 		if errors.Is(err, workoutlog.ErrInvalidEvent) {
@@ -102,7 +139,7 @@ func addEvent(username string, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body := returnedID{ID: *eventID}
+	body := savedEvent{ID: *eventID, Records: records}
 	bodyJson, err := json.Marshal(body)
 	if err != nil {
 		slog.Error(err.Error())
@@ -131,7 +168,8 @@ func updateEvent(username, currentDate string, w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if err := workoutlog.EventManager.UpdateEvent(username, currentDateInt, *updatedEvent); err != nil {
+	records, err := workoutlog.EventManager.UpdateEvent(username, currentDateInt, *updatedEvent)
+	if err != nil {
 		if errors.Is(err, workoutlog.ErrNotFound) {
 			slog.Debug(err.Error())
 			http.Error(w, `{"message":"event not found"}`, http.StatusNotFound)
@@ -147,9 +185,17 @@ func updateEvent(username, currentDate string, w http.ResponseWriter, r *http.Re
 		}
 	}
 
+	body := savedEvent{ID: updatedEvent.ID, Records: records}
+	bodyJson, err := json.Marshal(body)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
 	h := w.Header()
 	standardHeaders(&h)
-	w.WriteHeader(http.StatusNoContent)
+	w.Write(bodyJson)
 }
 
 func deleteEvent(username, eventID, eventDate string, w http.ResponseWriter, r *http.Request) {
@@ -289,6 +335,7 @@ func getMetrics(username string, w http.ResponseWriter, r *http.Request) {
 		StartDate:     int64(startDate),
 		EndDate:       int64(endDate),
 		ExerciseTypes: []string(r.Form["type"]),
+		Variations:    r.Form.Get("variations") == "true",
 	}
 
 	dateStack, instancesStack, err := workoutlog.EventManager.GetPageOfInstances(username, filter, 0)
@@ -346,6 +393,245 @@ func getMetrics(username string, w http.ResponseWriter, r *http.Request) {
 
 }
 
+// getOneRMMetrics returns the estimated 1RM time series of an exercise type.
+func getOneRMMetrics(username string, w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse URL query parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	exerciseID := r.Form.Get("type")
+	if exerciseID == "" {
+		http.Error(w, `{"message": "type is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	formula, err := workoutlog.ParseOneRMFormula(r.Form.Get("formula"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "unknown formula"}`, http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := stringToInt64(r.Form.Get("start"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad start value"}`, http.StatusBadRequest)
+		return
+	}
+
+	endDate, err := stringToInt64(r.Form.Get("end"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad end value"}`, http.StatusBadRequest)
+		return
+	}
+
+	filter := workoutlog.ExerciseFilter{StartDate: startDate, EndDate: endDate}
+
+	dates, oneRMs, err := workoutlog.EventManager.GetOneRMSeries(username, exerciseID, formula, filter)
+	if err != nil {
+		if errors.As(err, &workoutlog.ErrInvalidExercise{}) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "1RM cannot be estimated for the exercise type"}`, http.StatusBadRequest)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(oneRMMetrics{Formula: formula, Dates: dates, OneRM: oneRMs})
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, `{"message": "failed to marshal response body"}`, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// getTrainingLoad returns the daily training load of a date range and the models that are derived from it.
+// The range defaults to the 90 days that end now.
+func getTrainingLoad(username string, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse URL query parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := stringToInt64(r.Form.Get("start"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad start value"}`, http.StatusBadRequest)
+		return
+	}
+	if startDate == 0 {
+		startDate = time.Now().Unix()
+	}
+
+	endDate, err := stringToInt64(r.Form.Get("end"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad end value"}`, http.StatusBadRequest)
+		return
+	}
+	if endDate == 0 {
+		endDate = startDate - 90*24*60*60
+	}
+
+	if endDate > startDate {
+		http.Error(w, `{"message": "end must precede start"}`, http.StatusBadRequest)
+		return
+	}
+
+	location := time.UTC
+	if tz := r.Form.Get("tz"); tz != "" {
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "unknown time zone"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	trainingLoad, err := workoutlog.EventManager.GetTrainingLoad(username, startDate, endDate, location)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(trainingLoad)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, `{"message": "failed to marshal response body"}`, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// exportEvents streams the sets of the events that match the query parameters, one set per row.
+// The format parameter is csv or json. The default is json.
+func exportEvents(username string, w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse URL query parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	format := r.Form.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, `{"message": "format must be csv or json"}`, http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := stringToInt64(r.Form.Get("start"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad start value"}`, http.StatusBadRequest)
+		return
+	}
+
+	endDate, err := stringToInt64(r.Form.Get("end"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "bad end value"}`, http.StatusBadRequest)
+		return
+	}
+
+	filter := workoutlog.ExerciseFilter{
+		StartDate:     startDate,
+		EndDate:       endDate,
+		ExerciseTypes: []string(r.Form["type"]),
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	h.Set("content-type", "application/json")
+	if format == "csv" {
+		h.Set("content-type", "text/csv")
+	}
+	h.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"homegym-sets-%s.%s\"", time.Now().Format("20060102"), format))
+
+	// headers are sent with the first set so errors can only be reported before then
+	started := false
+	csvWriter := csv.NewWriter(w)
+	csvHeader := []string{"date", "activity", "exercise", "intensity", "intensityType", "volume", "volumeType"}
+
+	emit := func(set workoutlog.ExportedSet) error {
+		if format == "csv" {
+			if !started {
+				started = true
+				if err := csvWriter.Write(csvHeader); err != nil {
+					return err
+				}
+			}
+			return csvWriter.Write([]string{
+				time.Unix(set.Date, 0).UTC().Format(time.RFC3339),
+				set.Activity,
+				set.Exercise,
+				strconv.FormatFloat(float64(set.Intensity), 'f', -1, 32),
+				set.IntensityType,
+				strconv.FormatFloat(float64(set.Volume), 'f', -1, 32),
+				set.VolumeType,
+			})
+		}
+
+		setJSON, err := json.Marshal(set)
+		if err != nil {
+			return err
+		}
+		separator := ","
+		if !started {
+			started = true
+			separator = "["
+		}
+		if _, err := w.Write([]byte(separator)); err != nil {
+			return err
+		}
+		_, err = w.Write(setJSON)
+		return err
+	}
+
+	activityID := r.Form.Get("activity")
+	if err := workoutlog.EventManager.ExportSets(username, activityID, filter, emit); err != nil {
+		slog.Error("failed to export events", "error", err.Error())
+		if !started {
+			h.Del("Content-Disposition")
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if format == "csv" {
+		if !started {
+			csvWriter.Write(csvHeader)
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			slog.Error("failed to export events", "error", err.Error())
+		}
+		return
+	}
+
+	if !started {
+		w.Write([]byte("["))
+	}
+	w.Write([]byte("]"))
+}
+
 // TODO: centralize these helper functions
 func stringToInt64(str string) (int64, error) {
 	if str == "" {
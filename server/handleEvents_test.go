@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
@@ -75,7 +77,8 @@ func TestHandleEvents(t *testing.T) {
 
 		Convey("When we add an event", func() {
 			eventID := "test-event"
-			mockEventManager.On("NewEvent", mock.Anything, mock.Anything).Return(&eventID, nil)
+			records := []workoutlog.PersonalRecord{{ExerciseID: "test-exercise-id", Kind: workoutlog.RecordOneRM, Value: 120, Previous: 110, Date: 1234, EventID: eventID}}
+			mockEventManager.On("NewEvent", mock.Anything, mock.Anything).Return(&eventID, records, nil)
 
 			testEvent := `{"date": 1234, "activityID": "test-activity-id","mood": 1}`
 
@@ -92,12 +95,17 @@ func TestHandleEvents(t *testing.T) {
 			_ = json.Unmarshal([]byte(testEvent), expectedEvent)
 
 			So(mockEventManager.AssertCalled(t, "NewEvent", GymContextValue(testContext(), usernameKey), *expectedEvent), ShouldBeTrue)
+
+			saved := savedEvent{}
+			So(json.NewDecoder(w.Result().Body).Decode(&saved), ShouldBeNil)
+			So(saved.ID, ShouldEqual, eventID)
+			So(saved.Records, ShouldResemble, records)
 		})
 
 		Convey("When we update an event", func() {
 			eventID := "test-event-id"
 			currentDate := "1234"
-			mockEventManager.On("UpdateEvent", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			mockEventManager.On("UpdateEvent", mock.Anything, mock.Anything, mock.Anything).Return([]workoutlog.PersonalRecord{}, nil)
 
 			testEvent := `{"date": 1234, "id": "` + eventID + `", "activityID": "test-activity-id","mood": 1}`
 
@@ -110,7 +118,12 @@ func TestHandleEvents(t *testing.T) {
 
 			EventsApi(w, req)
 
-			So(w.Result().StatusCode, ShouldEqual, http.StatusNoContent)
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			saved := savedEvent{}
+			So(json.NewDecoder(w.Result().Body).Decode(&saved), ShouldBeNil)
+			So(saved.ID, ShouldEqual, eventID)
+			So(saved.Records, ShouldBeEmpty)
 
 			eventStruct := &workoutlog.Event{}
 			_ = json.Unmarshal([]byte(testEvent), eventStruct)
@@ -228,5 +241,171 @@ func TestHandleEvents(t *testing.T) {
 				So(returnedMetrics.Load[i], ShouldEqual, numSets*numReps*testIntensityValue)
 			}
 		})
+
+		Convey("When we get the estimated 1RM metrics of an exercise type", func() {
+			dates := []int64{1000, 2000}
+			oneRMs := []float32{110, 116.7}
+			mockEventManager.On("GetOneRMSeries", testUserName, "test-exercise-id", workoutlog.Brzycki, workoutlog.ExerciseFilter{StartDate: 3000}).Return(dates, oneRMs, nil)
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%smetrics/e1rm?type=test-exercise-id&formula=brzycki&start=3000", url), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			EventsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			returned := oneRMMetrics{}
+			err := json.NewDecoder(w.Result().Body).Decode(&returned)
+			So(err, ShouldBeNil)
+			So(returned, ShouldResemble, oneRMMetrics{Formula: workoutlog.Brzycki, Dates: dates, OneRM: oneRMs})
+		})
+
+		Convey("When we get estimated 1RM metrics with bad parameters", func() {
+			for _, query := range []string{"", "?type=test-exercise-id&formula=guess", "?type=test-exercise-id&start=soon"} {
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%smetrics/e1rm%s", url, query), nil)
+				req = req.WithContext(testContext())
+				w := httptest.NewRecorder()
+
+				EventsApi(w, req)
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+
+		Convey("When we get the estimated 1RM metrics of an exercise type that does not support estimates", func() {
+			mockEventManager.On("GetOneRMSeries", testUserName, "run-id", workoutlog.Epley, workoutlog.ExerciseFilter{}).Return(nil, nil, workoutlog.ErrInvalidExercise{Message: "nope"})
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%smetrics/e1rm?type=run-id", url), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			EventsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When we get the training load of a date range", func() {
+			trainingLoad := workoutlog.TrainingLoad{
+				Dates:    []int64{1000},
+				Load:     []float32{300},
+				Acute:    []float32{150},
+				Chronic:  []float32{113.8},
+				ACWR:     []float32{1.32},
+				Fitness:  []float32{2400},
+				Fatigue:  []float32{700},
+				Form:     []float32{1000},
+				ACWRLow:  0.8,
+				ACWRHigh: 1.3,
+				Warnings: []workoutlog.LoadWarning{{Start: 1000, End: 1000, Peak: 1.32, Message: "too high"}},
+			}
+			mockEventManager.On("GetTrainingLoad", testUserName, int64(5000), int64(1000), time.UTC).Return(&trainingLoad, nil)
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%smetrics/load?start=5000&end=1000", url), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			EventsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+			returned := workoutlog.TrainingLoad{}
+			err := json.NewDecoder(w.Result().Body).Decode(&returned)
+			So(err, ShouldBeNil)
+			So(returned, ShouldResemble, trainingLoad)
+		})
+
+		Convey("When we get the training load without a date range", func() {
+			mockEventManager.On("GetTrainingLoad", testUserName, mock.Anything, mock.Anything, time.UTC).Return(&workoutlog.TrainingLoad{}, nil)
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%smetrics/load", url), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			EventsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			start := mockEventManager.Calls[0].Arguments.Get(1).(int64)
+			end := mockEventManager.Calls[0].Arguments.Get(2).(int64)
+			So(start-end, ShouldEqual, 90*24*60*60)
+		})
+
+		Convey("When we get the training load with bad parameters", func() {
+			for _, query := range []string{"?start=1000&end=5000", "?start=soon", "?tz=Nowhere/Special"} {
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%smetrics/load%s", url, query), nil)
+				req = req.WithContext(testContext())
+				w := httptest.NewRecorder()
+
+				EventsApi(w, req)
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+
+		Convey("When we export sets", func() {
+			sets := []workoutlog.ExportedSet{
+				{Date: testTime, Activity: "lifting", Exercise: "squat", Intensity: 102.5, IntensityType: "weight", Volume: 5, VolumeType: "count"},
+				{Date: testTime, Activity: "lifting", Exercise: "squat", Intensity: 100, IntensityType: "weight", Volume: 3, VolumeType: "count"},
+			}
+			filter := workoutlog.ExerciseFilter{StartDate: 2000, EndDate: 1000, ExerciseTypes: []string{"squat-id", "bench-id"}}
+			mockEventManager.On("ExportSets", testUserName, testActivityID, filter, mock.Anything).Return(sets, nil)
+
+			query := fmt.Sprintf("start=2000&end=1000&activity=%s&type=squat-id&type=bench-id", testActivityID)
+
+			Convey("As CSV", func() {
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%sexport?format=csv&%s", url, query), nil)
+				req = req.WithContext(testContext())
+				w := httptest.NewRecorder()
+
+				EventsApi(w, req)
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(w.Result().Header.Get("content-type"), ShouldEqual, "text/csv")
+
+				lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+				So(len(lines), ShouldEqual, 3)
+				So(lines[0], ShouldEqual, "date,activity,exercise,intensity,intensityType,volume,volumeType")
+				So(lines[1], ShouldEqual, "2024-07-01T08:58:01Z,lifting,squat,102.5,weight,5,count")
+			})
+
+			Convey("As JSON", func() {
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%sexport?%s", url, query), nil)
+				req = req.WithContext(testContext())
+				w := httptest.NewRecorder()
+
+				EventsApi(w, req)
+
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(w.Result().Header.Get("content-type"), ShouldEqual, "application/json")
+
+				returned := []workoutlog.ExportedSet{}
+				err := json.NewDecoder(w.Result().Body).Decode(&returned)
+				So(err, ShouldBeNil)
+				So(returned, ShouldResemble, sets)
+			})
+		})
+
+		Convey("When we export sets and there are none", func() {
+			mockEventManager.On("ExportSets", testUserName, "", workoutlog.ExerciseFilter{}, mock.Anything).Return(nil, nil)
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%sexport", url), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			EventsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "[]")
+		})
+
+		Convey("When we export sets in an unsupported format", func() {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("%sexport?format=xml", url), nil)
+			req = req.WithContext(testContext())
+			w := httptest.NewRecorder()
+
+			EventsApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusBadRequest)
+		})
 	})
 }
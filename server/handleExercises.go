@@ -20,6 +20,18 @@ type returnedValue = struct {
 	Value int `json:"value"`
 }
 
+type returnedArchived = struct {
+	Archived bool `json:"archived"`
+}
+
+// returnedOneRM is the current 1RM of an exercise type.
+// Weights maps requested percentages of the 1RM to weights.
+type returnedOneRM = struct {
+	Value     float32            `json:"value"`
+	Estimated bool               `json:"estimated"`
+	Weights   map[string]float32 `json:"weights,omitempty"`
+}
+
 // Handles requests for exercise types.
 func ExerciseTypesApi(w http.ResponseWriter, r *http.Request) {
 	rootpath := "/homegym/api/exercises/"
@@ -35,6 +47,8 @@ func ExerciseTypesApi(w http.ResponseWriter, r *http.Request) {
 	rxpUpdateType := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/?$", rootpath))
 	rxpTypeOneRM := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/onerm/?$", rootpath))
 	rxpTypePR := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/pr/?$", rootpath))
+	rxpTypeRecords := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/records/?$", rootpath))
+	rxpTypeE1RM := regexp.MustCompile(fmt.Sprintf("^%s([a-zA-Z0-9-]+)/e1rm/?$", rootpath))
 
 	if rxpNewType.MatchString(r.URL.Path) {
 		if r.Method == http.MethodPost {
@@ -50,6 +64,11 @@ func ExerciseTypesApi(w http.ResponseWriter, r *http.Request) {
 
 			updateExerciseType(*username, typeID, w, r)
 			return
+		} else if r.Method == http.MethodDelete {
+			typeID := rxpUpdateType.FindStringSubmatch(r.URL.Path)[1]
+
+			deleteExerciseType(*username, typeID, w, r)
+			return
 		}
 	} else if rxpTypeOneRM.MatchString(r.URL.Path) {
 		typeID := rxpTypeOneRM.FindStringSubmatch(r.URL.Path)[1]
@@ -71,6 +90,20 @@ func ExerciseTypesApi(w http.ResponseWriter, r *http.Request) {
 			getPR(*username, typeID, w)
 			return
 		}
+	} else if rxpTypeRecords.MatchString(r.URL.Path) {
+		typeID := rxpTypeRecords.FindStringSubmatch(r.URL.Path)[1]
+
+		if r.Method == http.MethodGet {
+			getRecords(*username, typeID, w)
+			return
+		}
+	} else if rxpTypeE1RM.MatchString(r.URL.Path) {
+		typeID := rxpTypeE1RM.FindStringSubmatch(r.URL.Path)[1]
+
+		if r.Method == http.MethodGet {
+			getCurrent1RM(*username, typeID, w, r)
+			return
+		}
 	}
 
 	http.Error(w, "", http.StatusNotFound)
@@ -160,6 +193,38 @@ func updateExerciseType(username, typeID string, w http.ResponseWriter, r *http.
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// deleteExerciseType deletes an exercise type.
+// When the archive query parameter is true, exercise types that are in use are archived instead.
+func deleteExerciseType(username, typeID string, w http.ResponseWriter, r *http.Request) {
+	archive := r.URL.Query().Get("archive") == "true"
+
+	archived, err := workoutlog.ExerciseManager.DeleteExerciseType(username, typeID, archive)
+	if err != nil {
+		if errors.Is(err, workoutlog.ErrNotFound) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "exercise type not found"}`, http.StatusNotFound)
+		} else if errors.Is(err, workoutlog.ErrExerciseTypeInUse) {
+			slog.Debug(err.Error())
+			http.Error(w, fmt.Sprintf(`{"message": "%s"}`, jsonSafeError(err)), http.StatusConflict)
+		} else {
+			slog.Error(err.Error())
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body, err := json.Marshal(returnedArchived{Archived: archived})
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
 func listExerciseTypes(username string, w http.ResponseWriter) {
 	types, err := workoutlog.ExerciseManager.GetExerciseTypes(username)
 	if err != nil {
@@ -238,6 +303,93 @@ func getPR(username, exID string, w http.ResponseWriter) {
 	w.Write(bodyJSON)
 }
 
+// getRecords writes the personal record history of an exercise type.
+func getRecords(username, exID string, w http.ResponseWriter) {
+	records, err := workoutlog.ExerciseManager.GetRecords(username, exID)
+	if err != nil {
+		if errors.Is(err, workoutlog.ErrNotFound) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "exercise type not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(records)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+// getCurrent1RM writes the 1RM that percentOfMax intensities of an exercise type are relative to.
+// The formula query parameter selects the formula that estimates the 1RM when none was entered.
+// Each percent query parameter is resolved to a weight.
+func getCurrent1RM(username, exID string, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "could not parse URL query parameters"}`, http.StatusBadRequest)
+		return
+	}
+
+	formula, err := workoutlog.ParseOneRMFormula(r.Form.Get("formula"))
+	if err != nil {
+		slog.Debug(err.Error())
+		http.Error(w, `{"message": "unknown formula"}`, http.StatusBadRequest)
+		return
+	}
+
+	oneRM, estimated, err := workoutlog.ExerciseManager.Current1RM(username, exID, formula)
+	if err != nil {
+		if errors.Is(err, workoutlog.ErrNotFound) {
+			slog.Debug(err.Error())
+			http.Error(w, `{"message": "exercise type not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if oneRM == 0 {
+		http.Error(w, `{"message": "no 1RM"}`, http.StatusNotFound)
+		return
+	}
+
+	body := returnedOneRM{Value: oneRM, Estimated: estimated}
+
+	for _, p := range r.Form["percent"] {
+		percent, err := strconv.ParseFloat(p, 32)
+		if err != nil || percent <= 0 {
+			slog.Debug("bad percent value", "percent", p)
+			http.Error(w, `{"message": "bad percent value"}`, http.StatusBadRequest)
+			return
+		}
+		if body.Weights == nil {
+			body.Weights = map[string]float32{}
+		}
+		body.Weights[p] = workoutlog.ResolvePercentOfMax(float32(percent), oneRM)
+	}
+
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(bodyJSON)
+}
+
 func newOneRM(username, exID string, w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -246,6 +246,60 @@ func TestHandleExercises(t *testing.T) {
 			So(w.Result().StatusCode, ShouldNotEqual, http.StatusOK)
 		})
 
+		Convey("When we receive a request to delete an exercise type", func() {
+			mockEmgr.On("DeleteExerciseType", mock.Anything, testExerciseID, false).Return(false, nil)
+
+			url := fmt.Sprintf("%s%s", baseURL, testExerciseID)
+			req := httptest.NewRequest(http.MethodDelete, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			archived := returnedArchived{}
+			if err := json.NewDecoder(w.Result().Body).Decode(&archived); err != nil {
+				t.Fail()
+			}
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(archived.Archived, ShouldBeFalse)
+		})
+
+		Convey("When we receive a request to delete an exercise type that is in use", func() {
+			mockEmgr.On("DeleteExerciseType", mock.Anything, testExerciseID, false).Return(false, workoutlog.ErrExerciseTypeInUse)
+
+			url := fmt.Sprintf("%s%s", baseURL, testExerciseID)
+			req := httptest.NewRequest(http.MethodDelete, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("When we receive a request to delete or archive an exercise type that is in use", func() {
+			mockEmgr.On("DeleteExerciseType", mock.Anything, testExerciseID, true).Return(true, nil)
+
+			url := fmt.Sprintf("%s%s?archive=true", baseURL, testExerciseID)
+			req := httptest.NewRequest(http.MethodDelete, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			archived := returnedArchived{}
+			if err := json.NewDecoder(w.Result().Body).Decode(&archived); err != nil {
+				t.Fail()
+			}
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(archived.Archived, ShouldBeTrue)
+		})
+
 		Convey("When we receive a request to set a new PR", func() {
 			mockEmgr.On("SetPR", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
@@ -323,5 +377,93 @@ func TestHandleExercises(t *testing.T) {
 			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
 			So(rm.Value, ShouldEqual, test1RM)
 		})
+
+		Convey("When we receive a request to get the record history", func() {
+			history := []workoutlog.PersonalRecord{
+				{ExerciseID: testExerciseID, Kind: workoutlog.RecordWeight, Reps: 5, Value: 100, Date: 1000, EventID: "event-1"},
+				{ExerciseID: testExerciseID, Kind: workoutlog.RecordWeight, Reps: 5, Value: 105, Previous: 100, Date: 2000, EventID: "event-2"},
+			}
+			mockEmgr.On("GetRecords", mock.Anything, testExerciseID).Return(history, nil)
+
+			url := fmt.Sprintf("%s%s/records", baseURL, testExerciseID)
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			records := []workoutlog.PersonalRecord{}
+
+			if err := json.NewDecoder(w.Result().Body).Decode(&records); err != nil {
+				t.Fail()
+			}
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(records, ShouldResemble, history)
+		})
+
+		Convey("When we receive a request to get the current 1RM of an exercise type", func() {
+			mockEmgr.On("Current1RM", mock.Anything, testExerciseID, workoutlog.Lombardi).Return(float32(142.5), true, nil)
+
+			url := fmt.Sprintf("%s%s/e1rm?formula=lombardi&percent=80&percent=92.5", baseURL, testExerciseID)
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			returned := returnedOneRM{}
+
+			if err := json.NewDecoder(w.Result().Body).Decode(&returned); err != nil {
+				t.Fail()
+			}
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(returned, ShouldResemble, returnedOneRM{Value: 142.5, Estimated: true, Weights: map[string]float32{"80": 114, "92.5": 131.8}})
+		})
+
+		Convey("When we receive a request to get the current 1RM of an exercise type without one", func() {
+			mockEmgr.On("Current1RM", mock.Anything, testExerciseID, workoutlog.Epley).Return(float32(0), false, nil)
+
+			url := fmt.Sprintf("%s%s/e1rm", baseURL, testExerciseID)
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When we receive a request to get the current 1RM of an unknown exercise type", func() {
+			mockEmgr.On("Current1RM", mock.Anything, "unknown", workoutlog.Epley).Return(float32(0), false, fmt.Errorf("failed to get exercise type: %w", workoutlog.ErrNotFound))
+
+			url := fmt.Sprintf("%s%s/e1rm", baseURL, "unknown")
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When we receive a request to get the record history of an unknown exercise type", func() {
+			mockEmgr.On("GetRecords", mock.Anything, "unknown").Return(nil, workoutlog.ErrNotFound)
+
+			url := fmt.Sprintf("%sunknown/records", baseURL)
+			req := httptest.NewRequest(http.MethodGet, url, nil)
+			req = req.WithContext(testContext())
+
+			w := httptest.NewRecorder()
+
+			ExerciseTypesApi(w, req)
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
 	})
 }
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/scottbrodersen/homegym/auth"
+)
+
+// A userSession is a session of the user with an indication of whether it is the session of the request.
+type userSession struct {
+	auth.Session
+	Current bool `json:"current"`
+}
+
+// SessionsApi handles requests to list and revoke the sessions of the user.
+func SessionsApi(w http.ResponseWriter, r *http.Request) {
+	rootpath := "/homegym/api/sessions"
+
+	username, _, err := whoIsIt(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	currentSessionID := ""
+	if sessionCookie, err := r.Cookie(cookieSession); err == nil {
+		currentSessionID = strings.TrimSpace(sessionCookie.Value)
+	}
+
+	// path to all sessions
+	rxpRootPath := regexp.MustCompile(fmt.Sprintf("^%s/?$", rootpath))
+	// path to a session
+	rxpSession := regexp.MustCompile(fmt.Sprintf("^%s/([a-zA-Z0-9-]+)/?$", rootpath))
+
+	if rxpRootPath.MatchString(r.URL.Path) {
+		if r.Method == http.MethodGet {
+			listSessions(*username, currentSessionID, w)
+			return
+		} else if r.Method == http.MethodDelete {
+			revokeOtherSessions(*username, currentSessionID, w)
+			return
+		}
+	} else if rxpSession.MatchString(r.URL.Path) {
+		sessionID := rxpSession.FindStringSubmatch(r.URL.Path)[1]
+		if r.Method == http.MethodDelete {
+			revokeSession(*username, sessionID, w)
+			return
+		}
+	}
+	http.Error(w, "", http.StatusNotFound)
+}
+
+func listSessions(username, currentSessionID string, w http.ResponseWriter) {
+	sessions, err := authorizer.Sessions(username)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	userSessions := []userSession{}
+	for _, s := range sessions {
+		userSessions = append(userSessions, userSession{Session: s, Current: s.SessionID == currentSessionID})
+	}
+
+	body, err := json.Marshal(userSessions)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	standardHeaders(&h)
+	w.Write(body)
+}
+
+func revokeOtherSessions(username, currentSessionID string, w http.ResponseWriter) {
+	if err := endOtherSessions(username, currentSessionID); err != nil {
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// endOtherSessions ends all sessions of the user except for the session of the request.
+func endOtherSessions(username, currentSessionID string) error {
+	sessions, err := authorizer.Sessions(username)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if s.SessionID == currentSessionID {
+			continue
+		}
+		if err := authorizer.RevokeSession(username, s.SessionID); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func revokeSession(username, sessionID string, w http.ResponseWriter) {
+	if err := authorizer.RevokeSession(username, sessionID); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			http.Error(w, `{"message": "session not found"}`, http.StatusNotFound)
+			return
+		}
+		slog.Error(err.Error())
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
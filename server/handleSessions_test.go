@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scottbrodersen/homegym/auth"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSessionsApi(t *testing.T) {
+	otherSessionID := "other-session-id"
+
+	Convey("Given a user with two sessions", t, func() {
+		mockAuth := NewMockAuthorizer()
+		authorizer = mockAuth
+
+		sessions := []auth.Session{
+			{UserID: testUserName, SessionID: testSessionID, Expiry: 2000, Created: 1000, LastSeen: 1500, UserAgent: "browser"},
+			{UserID: testUserName, SessionID: otherSessionID, Expiry: 3000, Created: 2000, LastSeen: 2500, UserAgent: "phone"},
+		}
+		mockAuth.On("Sessions", testUserName).Return(sessions, nil)
+
+		sessionRequest := func(method, path string) *http.Request {
+			req := httptest.NewRequest(method, path, nil)
+			req.AddCookie(&http.Cookie{Name: cookieSession, Value: testSessionID})
+			return req.WithContext(testContext())
+		}
+
+		Convey("When we receive a request to list the sessions", func() {
+			w := httptest.NewRecorder()
+			SessionsApi(w, sessionRequest(http.MethodGet, "/homegym/api/sessions"))
+
+			Convey("Then the sessions are returned and the current session is indicated", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+
+				returned := []userSession{}
+				err := json.NewDecoder(w.Result().Body).Decode(&returned)
+				So(err, ShouldBeNil)
+				So(len(returned), ShouldEqual, 2)
+				So(returned[0].SessionID, ShouldEqual, testSessionID)
+				So(returned[0].Current, ShouldBeTrue)
+				So(returned[0].UserAgent, ShouldEqual, "browser")
+				So(returned[1].Current, ShouldBeFalse)
+			})
+		})
+
+		Convey("When we receive a request to revoke the other sessions", func() {
+			mockAuth.On("RevokeSession", testUserName, otherSessionID).Return(nil)
+
+			w := httptest.NewRecorder()
+			SessionsApi(w, sessionRequest(http.MethodDelete, "/homegym/api/sessions"))
+
+			Convey("Then all sessions except the current session are revoked", func() {
+				So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+				So(mockAuth.AssertCalled(t, "RevokeSession", testUserName, otherSessionID), ShouldBeTrue)
+				mockAuth.AssertNotCalled(t, "RevokeSession", testUserName, testSessionID)
+			})
+		})
+
+		Convey("When we receive a request to revoke a session", func() {
+			mockAuth.On("RevokeSession", testUserName, otherSessionID).Return(nil)
+
+			w := httptest.NewRecorder()
+			SessionsApi(w, sessionRequest(http.MethodDelete, "/homegym/api/sessions/"+otherSessionID))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusOK)
+			So(mockAuth.AssertCalled(t, "RevokeSession", testUserName, otherSessionID), ShouldBeTrue)
+		})
+
+		Convey("When we receive a request to revoke a session of another user", func() {
+			mockAuth.On("RevokeSession", testUserName, mock.Anything).Return(auth.ErrSessionNotFound)
+
+			w := httptest.NewRecorder()
+			SessionsApi(w, sessionRequest(http.MethodDelete, "/homegym/api/sessions/not-my-session"))
+
+			So(w.Result().StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -35,10 +35,13 @@ passwordInput.addEventListener('keydown', function (event) {
 
 submitButton.addEventListener('click', login);
 
+// The minimum length of new passwords is configured on the server.
+// The minlength attribute of the input is the lowest length that the server accepts.
 function validatePassword() {
   let message = '';
-  if (!/.{10,}/.test(passwordInput.value)) {
-    message = 'At least ten characters. ';
+  const minLength = Math.max(passwordInput.minLength, 1);
+  if ([...passwordInput.value].length < minLength) {
+    message = `At least ${minLength} characters. `;
     submitButton.disabled = true;
   } else {
     submitButton.disabled = false;
@@ -59,7 +62,11 @@ async function login() {
     headers: headers,
   };
 
-  const resp = await fetch(url, options);
+  let resp = await fetch(url, options);
+
+  if (resp.status == 202) {
+    resp = await secondFactor(await resp.json(), headers);
+  }
 
   if (resp.status == 401) {
     const unsuccessfulMessage = document.getElementById('badlogin');
@@ -72,3 +79,23 @@ async function login() {
     window.location.href = resp.url;
   }
 }
+
+// secondFactor prompts for a code from the authenticator app or a recovery code
+// and completes the login.
+async function secondFactor(challenge, headers) {
+  const code = window.prompt(
+    'Enter the code from your authenticator app or a recovery code',
+  );
+
+  const options = {
+    method: 'POST',
+    body: JSON.stringify({
+      challenge: challenge.challenge,
+      code: (code || '').trim(),
+      form: 'true',
+    }),
+    headers: headers,
+  };
+
+  return fetch('/homegym/login/totp', options);
+}
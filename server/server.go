@@ -3,12 +3,16 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/certs"
 	"github.com/scottbrodersen/homegym/dal"
 	"github.com/scottbrodersen/homegym/server/public"
 	"github.com/scottbrodersen/homegym/server/secured"
@@ -31,15 +35,33 @@ var samesite http.SameSite = http.SameSiteLaxMode
 var isSafe bool = false
 
 type requestAuthorizer interface {
-	IssueToken(username string, pwd string) (*string, *string, error)
+	IssueToken(username, pwd, userAgent, remoteAddr string) (*string, *string, error)
 	ValidateToken(tokenString, sessionID string) (*string, error)
 	TokenClaims(tokenString string) (auth.Claims, error)
 	TokenTTL() int
 	SessionTTL() int
+	EndSession(sessionID string) error
+	Sessions(username string) ([]auth.Session, error)
+	RevokeSession(username, sessionID string) error
+	PasswordChangeRequired(username string) (bool, error)
+	Lockouts() ([]auth.Lockout, error)
+	ClearLockout(kind, id string) error
+	CompleteLogin(challenge, code, userAgent, remoteAddr string) (*string, *string, error)
+	TOTPStatus(username string) (*auth.TOTPStatus, error)
+	EnrollTOTP(username string) (string, error)
+	ConfirmTOTP(username, code, remoteAddr string) ([]string, error)
+	DisableTOTP(username, pwd, code, remoteAddr string) error
+	CreateAPIToken(username, name string, scopes []string, ttl time.Duration) (string, *auth.APIToken, error)
+	APITokens(username string) ([]auth.APIToken, error)
+	RevokeAPIToken(username, tokenID string) error
+	ValidateAPIToken(secret string) (string, string, []string, error)
 }
 
 const homePath string = "/homegym/home/"
 
+// accountPath is the path to the API that users who must change their password can use.
+const accountPath string = "/homegym/api/account"
+
 // frontend app internal routes
 var internalRoutes []string = []string{
 	"/homegym/event/",
@@ -48,6 +70,7 @@ var internalRoutes []string = []string{
 	"/homegym/programs/",
 	"/homegym/analyze/",
 	"/homegym/download/",
+	"/homegym/tokens/",
 }
 
 var secureMux *http.ServeMux
@@ -66,7 +89,15 @@ func init() {
 	secureMux.HandleFunc("/homegym/api/exercises/", ExerciseTypesApi)
 	secureMux.HandleFunc("/homegym/api/events/", EventsApi)
 	secureMux.HandleFunc("/homegym/api/dailystats/", DailyStatsApi)
+	secureMux.HandleFunc("/homegym/api/analytics", AnalyticsApi)
 	secureMux.HandleFunc("/homegym/api/admin/", AdminApi)
+	secureMux.HandleFunc("/homegym/api/export", ExportApi)
+	secureMux.HandleFunc("/homegym/api/import", ImportApi)
+	secureMux.HandleFunc("/homegym/api/import/csv", ImportCSVApi)
+	secureMux.HandleFunc("/homegym/api/sessions", SessionsApi)
+	secureMux.HandleFunc("/homegym/api/sessions/", SessionsApi)
+	secureMux.HandleFunc("/homegym/api/account", AccountApi)
+	secureMux.HandleFunc("/homegym/api/account/", AccountApi)
 	secureFileServer := GymFileServer(secured.SecuredEFS)
 	secureMux.Handle("/homegym/home/dist/", http.StripPrefix("/homegym/home", secureFileServer))
 	//secureMux.Handle("/homegym/home/assets/", http.StripPrefix("/homegym/home", secureFileServer))
@@ -90,7 +121,10 @@ func init() {
 
 	// specific paths for initial authentication requests
 	publicMux.HandleFunc("/homegym/login", HandleLogin)
+	publicMux.HandleFunc("/homegym/login/totp", HandleLoginTOTP)
 	publicMux.HandleFunc("/homegym/signup", HandleSignup)
+	publicMux.HandleFunc("/homegym/logout", HandleLogout)
+	publicMux.HandleFunc(caCertPath, HandleCACert)
 }
 
 func standardHeaders(header *http.Header) {
@@ -103,13 +137,34 @@ func standardHeaders(header *http.Header) {
 	header.Add("Access-Control-Allow-Credentials", "true")
 }
 
-func StartUnsafe(shutdown shutdownAction, port int) {
-	shutdown(http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", port), NewRequestLogger(publicMux)))
+// StartUnsafe serves HTTP on the listen address, in host:port format.
+// Cookies are not secure.
+func StartUnsafe(shutdown shutdownAction, addr string) {
+	slog.Info("serving HTTP", "address", addr)
+	shutdown(http.ListenAndServe(addr, NewRequestLogger(publicMux)))
+}
+
+// StartSafe serves HTTPS on the listen address, in host:port format, using the certificate and key files.
+func StartSafe(shutdown shutdownAction, addr, certFile, keyFile string) {
+	isSafe = true
+	slog.Info("serving HTTPS", "address", addr)
+	shutdown(http.ListenAndServeTLS(addr, certFile, keyFile, NewRequestLogger(publicMux)))
 }
 
-func StartSafe(shutdown shutdownAction, port int) {
-	shutdown(http.ListenAndServeTLS("0.0.0.0:443", "../homegym.crt", "../homegym.key", NewRequestLogger(publicMux)))
+// StartManagedTLS serves HTTPS on the listen address, in host:port format, using a certificate that the manager
+// provisions and renews. The certificate of the local CA is served at /homegym/ca.crt.
+func StartManagedTLS(shutdown shutdownAction, addr string, manager *certs.Manager) {
 	isSafe = true
+	caCert = manager.CACert
+
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   NewRequestLogger(publicMux),
+		TLSConfig: &tls.Config{GetCertificate: manager.GetCertificate},
+	}
+
+	slog.Info("serving HTTPS with a managed certificate", "address", addr, "ca", caCertPath)
+	shutdown(srv.ListenAndServeTLS("", ""))
 }
 
 type shutdownAction func(err error)
@@ -2,10 +2,13 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/scottbrodersen/homegym/archive"
 	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
 	"github.com/scottbrodersen/homegym/programs"
 	"github.com/scottbrodersen/homegym/workoutlog"
 	"github.com/stretchr/testify/mock"
@@ -73,6 +76,11 @@ func testContext() context.Context {
 	return context.WithValue(context.WithValue(c, usernameKey, testUserName), roleKey, string(auth.User))
 }
 
+func testAdminContext() context.Context {
+	c := context.Background()
+	return context.WithValue(context.WithValue(c, usernameKey, testUserName), roleKey, string(auth.Admin))
+}
+
 func newMockActivityAdmin() *mockActivityAdmin {
 	return new(mockActivityAdmin)
 }
@@ -113,6 +121,18 @@ func (maa *mockActivityAdmin) UpdateActivityExercises(userID string, updated wor
 	return args.Error(0)
 }
 
+func (maa *mockActivityAdmin) DeleteActivity(userID, activityID string) error {
+	args := maa.Called(userID, activityID)
+
+	return args.Error(0)
+}
+
+func (maa *mockActivityAdmin) MergeActivities(userID, srcActivityID, dstActivityID string) error {
+	args := maa.Called(userID, srcActivityID, dstActivityID)
+
+	return args.Error(0)
+}
+
 func newMockUserAdmin() *mockUserAdmin {
 	return new(mockUserAdmin)
 }
@@ -125,7 +145,7 @@ func (m *mockUserAdmin) NewUser(username string, role auth.Role, email, password
 	args := m.Called(username, role, email, password)
 
 	if args.Error(1) != nil {
-		return nil, fmt.Errorf("test error")
+		return nil, args.Error(1)
 	}
 
 	return args.Get(0).(*workoutlog.User), nil
@@ -144,6 +164,92 @@ func (m *mockUserAdmin) GetUser(username string) (*workoutlog.User, error) {
 
 }
 
+func (m *mockUserAdmin) ChangeEmail(username, email string) (*workoutlog.User, error) {
+	args := m.Called(username, email)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*workoutlog.User), nil
+}
+
+func (m *mockUserAdmin) ChangePassword(username, currentPassword, newPassword string) error {
+	args := m.Called(username, currentPassword, newPassword)
+
+	return args.Error(0)
+}
+
+func (m *mockUserAdmin) ListUsers() ([]workoutlog.User, error) {
+	args := m.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]workoutlog.User), nil
+}
+
+func (m *mockUserAdmin) ChangeUserRole(username string, role auth.Role) error {
+	args := m.Called(username, role)
+
+	return args.Error(0)
+}
+
+func (m *mockUserAdmin) ResetPassword(username, password string) error {
+	args := m.Called(username, password)
+
+	return args.Error(0)
+}
+
+func (m *mockUserAdmin) SetUserDisabled(username string, disabled bool) error {
+	args := m.Called(username, disabled)
+
+	return args.Error(0)
+}
+
+func (m *mockUserAdmin) DeleteUser(username string) error {
+	args := m.Called(username)
+
+	return args.Error(0)
+}
+
+func (m *mockUserAdmin) SignUp(username, email, password, inviteCode string) (*workoutlog.User, error) {
+	args := m.Called(username, email, password, inviteCode)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*workoutlog.User), nil
+}
+
+func (m *mockUserAdmin) CreateInvite(ttl time.Duration) (*workoutlog.Invite, error) {
+	args := m.Called(ttl)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*workoutlog.Invite), nil
+}
+
+func (m *mockUserAdmin) ListInvites() ([]workoutlog.Invite, error) {
+	args := m.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]workoutlog.Invite), nil
+}
+
+func (m *mockUserAdmin) DeleteInvite(code string) error {
+	args := m.Called(code)
+
+	return args.Error(0)
+}
+
 type MockAuthorizer struct {
 	tokenTTL   int
 	sessionTTL int
@@ -164,8 +270,8 @@ func (a *MockAuthorizer) SessionTTL() int {
 	return a.sessionTTL
 }
 
-func (a *MockAuthorizer) IssueToken(username string, pwd string) (*string, *string, error) {
-	args := a.Called(username, pwd)
+func (a *MockAuthorizer) IssueToken(username, pwd, userAgent, remoteAddr string) (*string, *string, error) {
+	args := a.Called(username, pwd, userAgent, remoteAddr)
 
 	if args.Error(2) != nil {
 		return nil, nil, args.Error(2)
@@ -185,6 +291,128 @@ func (a *MockAuthorizer) ValidateToken(tokenString, sessionID string) (*string,
 	return args.Get(0).(*string), nil
 }
 
+func (a *MockAuthorizer) EndSession(sessionID string) error {
+	args := a.Called(sessionID)
+
+	return args.Error(0)
+}
+
+func (a *MockAuthorizer) Sessions(username string) ([]auth.Session, error) {
+	args := a.Called(username)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]auth.Session), nil
+}
+
+func (a *MockAuthorizer) RevokeSession(username, sessionID string) error {
+	args := a.Called(username, sessionID)
+
+	return args.Error(0)
+}
+
+func (a *MockAuthorizer) PasswordChangeRequired(username string) (bool, error) {
+	args := a.Called(username)
+
+	return args.Bool(0), args.Error(1)
+}
+
+func (a *MockAuthorizer) Lockouts() ([]auth.Lockout, error) {
+	args := a.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]auth.Lockout), nil
+}
+
+func (a *MockAuthorizer) ClearLockout(kind, id string) error {
+	args := a.Called(kind, id)
+
+	return args.Error(0)
+}
+
+func (a *MockAuthorizer) CompleteLogin(challenge, code, userAgent, remoteAddr string) (*string, *string, error) {
+	args := a.Called(challenge, code, userAgent, remoteAddr)
+
+	if args.Error(2) != nil {
+		return nil, nil, args.Error(2)
+	}
+
+	return args.Get(0).(*string), args.Get(1).(*string), nil
+}
+
+func (a *MockAuthorizer) TOTPStatus(username string) (*auth.TOTPStatus, error) {
+	args := a.Called(username)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*auth.TOTPStatus), nil
+}
+
+func (a *MockAuthorizer) EnrollTOTP(username string) (string, error) {
+	args := a.Called(username)
+
+	return args.String(0), args.Error(1)
+}
+
+func (a *MockAuthorizer) ConfirmTOTP(username, code, remoteAddr string) ([]string, error) {
+	args := a.Called(username, code, remoteAddr)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]string), nil
+}
+
+func (a *MockAuthorizer) DisableTOTP(username, pwd, code, remoteAddr string) error {
+	args := a.Called(username, pwd, code, remoteAddr)
+
+	return args.Error(0)
+}
+
+func (a *MockAuthorizer) CreateAPIToken(username, name string, scopes []string, ttl time.Duration) (string, *auth.APIToken, error) {
+	args := a.Called(username, name, scopes, ttl)
+
+	if args.Error(2) != nil {
+		return "", nil, args.Error(2)
+	}
+
+	return args.String(0), args.Get(1).(*auth.APIToken), nil
+}
+
+func (a *MockAuthorizer) APITokens(username string) ([]auth.APIToken, error) {
+	args := a.Called(username)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]auth.APIToken), nil
+}
+
+func (a *MockAuthorizer) RevokeAPIToken(username, tokenID string) error {
+	args := a.Called(username, tokenID)
+
+	return args.Error(0)
+}
+
+func (a *MockAuthorizer) ValidateAPIToken(secret string) (string, string, []string, error) {
+	args := a.Called(secret)
+
+	if args.Error(3) != nil {
+		return "", "", nil, args.Error(3)
+	}
+
+	return args.String(0), args.String(1), args.Get(2).([]string), nil
+}
+
 func (a *MockAuthorizer) TokenClaims(tokenString string) (auth.Claims, error) {
 	args := a.Called(tokenString)
 
@@ -294,3 +522,87 @@ func (mpm *MockProgramManager) DeactivateProgramInstance(userID, activityID, ins
 
 	return nil
 }
+
+func newMockArchiveAdmin() *MockArchiveAdmin {
+	return new(MockArchiveAdmin)
+}
+
+type MockArchiveAdmin struct {
+	mock.Mock
+}
+
+func (maa *MockArchiveAdmin) Export(userID string) (*archive.Archive, error) {
+	args := maa.Called(userID)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*archive.Archive), nil
+}
+
+func (maa *MockArchiveAdmin) Import(userID string, a archive.Archive) (*archive.ImportSummary, error) {
+	args := maa.Called(userID, a)
+
+	summary, _ := args.Get(0).(*archive.ImportSummary)
+
+	return summary, args.Error(1)
+}
+
+func newMockDatabaseAdmin() *MockDatabaseAdmin {
+	return new(MockDatabaseAdmin)
+}
+
+type MockDatabaseAdmin struct {
+	mock.Mock
+}
+
+func (mda *MockDatabaseAdmin) ListBackups() ([]dal.Backup, error) {
+	args := mda.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]dal.Backup), nil
+}
+
+func (mda *MockDatabaseAdmin) CreateBackup() (*dal.Backup, error) {
+	args := mda.Called()
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*dal.Backup), nil
+}
+
+func (mda *MockDatabaseAdmin) OpenBackup(backupID string) (io.ReadCloser, error) {
+	args := mda.Called(backupID)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(io.ReadCloser), nil
+}
+
+func (mda *MockDatabaseAdmin) UploadBackup(file io.Reader) (*dal.Backup, error) {
+	args := mda.Called(file)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*dal.Backup), nil
+}
+
+func (mda *MockDatabaseAdmin) RestoreBackup(backupID string) (*dal.RestoreSummary, error) {
+	args := mda.Called(backupID)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*dal.RestoreSummary), nil
+}
@@ -1,15 +1,18 @@
 package workoutlog
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/programs"
 )
 
 var ErrActivityNameTaken error = errors.New("activity name not unique")
 var ErrNotFound error = errors.New("not found")
+var ErrActivityInUse error = errors.New("activity is in use")
 var ActivityManager ActivityAdmin = &ActivityMaker{}
 
 // The Activity type defines routines for interacting with activities in the database.
@@ -18,6 +21,8 @@ type ActivityAdmin interface {
 	GetActivityNames(userID string) ([]*Activity, error)
 	RenameActivity(userID string, a Activity) error
 	UpdateActivityExercises(userID string, updated Activity) error
+	DeleteActivity(userID, activityID string) error
+	MergeActivities(userID, srcActivityID, dstActivityID string) error
 }
 
 // The ActivityMaker type implements ActivityAdmin
@@ -224,3 +229,80 @@ func (am *ActivityMaker) UpdateActivityExercises(userID string, updated Activity
 
 	return nil
 }
+
+// DeleteActivity deletes an activity and its associations with exercise types.
+// Activities that have events or programs are not deleted and ErrActivityInUse is returned.
+func (am *ActivityMaker) DeleteActivity(userID, activityID string) error {
+	if _, err := getActivity(userID, activityID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete activity: %w", err)
+	}
+
+	hasEvents, err := dal.DB.HasActivityEvents(userID, activityID)
+	if err != nil {
+		return fmt.Errorf("failed to delete activity: %w", err)
+	}
+	if hasEvents {
+		return fmt.Errorf("%w: activity has events", ErrActivityInUse)
+	}
+
+	activityPrograms, err := programs.ProgramManager.GetProgramsPageForActivity(userID, activityID, "", 2)
+	if err != nil {
+		return fmt.Errorf("failed to delete activity: %w", err)
+	}
+	if len(activityPrograms) > 0 {
+		return fmt.Errorf("%w: activity has programs", ErrActivityInUse)
+	}
+
+	if err := dal.DB.DeleteActivity(userID, activityID); err != nil {
+		return fmt.Errorf("failed to delete activity: %w", err)
+	}
+
+	return nil
+}
+
+// MergeActivities moves the events, programs, and exercise types of the source activity to the destination activity.
+// The destination keeps its active program, if it has one.
+// The source activity is deleted.
+// The merge is not atomic. When it fails, some items might be moved already and the merge can be repeated to complete it.
+func (am *ActivityMaker) MergeActivities(userID, srcActivityID, dstActivityID string) error {
+	if srcActivityID == dstActivityID {
+		return fmt.Errorf("cannot merge an activity with itself")
+	}
+
+	for _, id := range []string{srcActivityID, dstActivityID} {
+		if _, err := getActivity(userID, id); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("failed to merge activities: %w", err)
+		}
+	}
+
+	if err := dal.DB.MergeActivities(userID, srcActivityID, dstActivityID, setActivityID(dstActivityID)); err != nil {
+		return fmt.Errorf("failed to merge activities: %w", err)
+	}
+
+	return nil
+}
+
+// setActivityID returns a function that sets the activityID property of a stored JSON object.
+// Other properties are preserved as-is.
+func setActivityID(activityID string) func([]byte) ([]byte, error) {
+	return func(value []byte) ([]byte, error) {
+		obj := map[string]json.RawMessage{}
+		if err := json.Unmarshal(value, &obj); err != nil {
+			return nil, err
+		}
+
+		id, err := json.Marshal(activityID)
+		if err != nil {
+			return nil, err
+		}
+		obj["activityID"] = id
+
+		return json.Marshal(obj)
+	}
+}
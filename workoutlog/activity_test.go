@@ -1,6 +1,7 @@
 package workoutlog
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
@@ -124,5 +125,76 @@ func TestActivities(t *testing.T) {
 			db.AssertCalled(t, "ReadActivity", testUserID, testActivityID)
 			db.AssertCalled(t, "UpdateActivityExercises", testUserID, testActivityID, []string{"testid3"}, []string{"testid2"})
 		})
+
+		Convey("When we delete an activity that has no events or programs", func() {
+			db.On("ReadActivity", mock.Anything, mock.Anything).Return(&testActivityName, []string{}, nil)
+			db.On("HasActivityEvents", testUserID, testActivityID).Return(false, nil)
+			db.On("GetProgramPage", testUserID, testActivityID, "", 2).Return([][]byte{}, nil)
+			db.On("DeleteActivity", testUserID, testActivityID).Return(nil)
+
+			err := ActivityManager.DeleteActivity(testUserID, testActivityID)
+
+			So(err, ShouldBeNil)
+			db.AssertCalled(t, "DeleteActivity", testUserID, testActivityID)
+		})
+
+		Convey("When we delete an activity that has events", func() {
+			db.On("ReadActivity", mock.Anything, mock.Anything).Return(&testActivityName, []string{}, nil)
+			db.On("HasActivityEvents", testUserID, testActivityID).Return(true, nil)
+
+			err := ActivityManager.DeleteActivity(testUserID, testActivityID)
+
+			So(errors.Is(err, ErrActivityInUse), ShouldBeTrue)
+			db.AssertNotCalled(t, "DeleteActivity", mock.Anything, mock.Anything)
+		})
+
+		Convey("When we delete an activity that has programs", func() {
+			db.On("ReadActivity", mock.Anything, mock.Anything).Return(&testActivityName, []string{}, nil)
+			db.On("HasActivityEvents", testUserID, testActivityID).Return(false, nil)
+			db.On("GetProgramPage", testUserID, testActivityID, "", 2).Return([][]byte{[]byte(`{"id":"p1"}`)}, nil)
+
+			err := ActivityManager.DeleteActivity(testUserID, testActivityID)
+
+			So(errors.Is(err, ErrActivityInUse), ShouldBeTrue)
+			db.AssertNotCalled(t, "DeleteActivity", mock.Anything, mock.Anything)
+		})
+
+		Convey("When we delete an activity that does not exist", func() {
+			db.On("ReadActivity", mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+			err := ActivityManager.DeleteActivity(testUserID, testActivityID)
+
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("When we merge activities", func() {
+			dstActivityID := "dstActivityID"
+			db.On("ReadActivity", mock.Anything, mock.Anything).Return(&testActivityName, []string{}, nil)
+			db.On("MergeActivities", testUserID, testActivityID, dstActivityID, mock.Anything).Return(nil)
+
+			err := ActivityManager.MergeActivities(testUserID, testActivityID, dstActivityID)
+
+			So(err, ShouldBeNil)
+			db.AssertCalled(t, "MergeActivities", testUserID, testActivityID, dstActivityID, mock.Anything)
+
+			Convey("Then stored values are rewritten to reference the destination activity", func() {
+				rewrite := db.Calls[len(db.Calls)-1].Arguments.Get(3).(func([]byte) ([]byte, error))
+				rewritten, err := rewrite([]byte(`{"id":"e1","activityID":"testActivityID","date":1}`))
+				So(err, ShouldBeNil)
+
+				obj := map[string]interface{}{}
+				So(json.Unmarshal(rewritten, &obj), ShouldBeNil)
+				So(obj["activityID"], ShouldEqual, dstActivityID)
+				So(obj["id"], ShouldEqual, "e1")
+				So(obj["date"], ShouldEqual, 1)
+			})
+		})
+
+		Convey("When we merge an activity with itself", func() {
+			err := ActivityManager.MergeActivities(testUserID, testActivityID, testActivityID)
+
+			So(err, ShouldNotBeNil)
+			db.AssertNotCalled(t, "MergeActivities", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
 	})
 }
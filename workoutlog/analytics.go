@@ -0,0 +1,409 @@
+package workoutlog
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/scottbrodersen/homegym/programs"
+)
+
+// Periods that analytics are grouped by.
+const (
+	PeriodDay        = "day"
+	PeriodWeek       = "week" // weeks start on Monday
+	PeriodMonth      = "month"
+	PeriodMicrocycle = "microcycle" // the microcycles of a program instance
+)
+
+// Groupings of the sets of an analytics period.
+const (
+	GroupExercise = "exercise" // exercise type
+	GroupBasis    = "basis"    // an exercise type and its variations
+	GroupActivity = "activity"
+)
+
+var ErrInvalidAnalyticsQuery = errors.New("invalid analytics query")
+
+// An AnalyticsQuery selects the sets that analytics are calculated for and how they are grouped.
+// GroupBy is empty to calculate a single group for each period.
+// Location is the time zone of the day, week, and month boundaries. The default is UTC.
+// ProgramID and ProgramInstanceID identify the program instance of the microcycle period. Only events of the instance are included.
+type AnalyticsQuery struct {
+	Period            string
+	GroupBy           string
+	ActivityID        string
+	Filter            ExerciseFilter
+	Location          *time.Location
+	ProgramID         string
+	ProgramInstanceID string
+}
+
+func (q AnalyticsQuery) validate() error {
+	if !slices.Contains([]string{PeriodDay, PeriodWeek, PeriodMonth, PeriodMicrocycle}, q.Period) {
+		return fmt.Errorf("%w: unknown period %q", ErrInvalidAnalyticsQuery, q.Period)
+	}
+
+	if !slices.Contains([]string{"", GroupExercise, GroupBasis, GroupActivity}, q.GroupBy) {
+		return fmt.Errorf("%w: unknown grouping %q", ErrInvalidAnalyticsQuery, q.GroupBy)
+	}
+
+	if q.Period == PeriodMicrocycle && (q.ProgramID == "" || q.ProgramInstanceID == "") {
+		return fmt.Errorf("%w: microcycle periods require a program instance", ErrInvalidAnalyticsQuery)
+	}
+
+	return nil
+}
+
+// Analytics are the statistics of the sets that were performed within a date range.
+// Buckets are in chronological order. Periods without sets are omitted.
+type Analytics struct {
+	Period  string            `json:"period"`
+	GroupBy string            `json:"groupBy,omitempty"`
+	Buckets []AnalyticsBucket `json:"buckets"`
+}
+
+// An AnalyticsBucket contains the statistics of a period.
+// Start is the start of the period in seconds since epoch. Label is the title of microcycles.
+type AnalyticsBucket struct {
+	Start  int64            `json:"start"`
+	Label  string           `json:"label,omitempty"`
+	Groups []AnalyticsGroup `json:"groups"`
+}
+
+// An AnalyticsGroup contains the statistics of an exercise type, basis family, or activity for a period.
+// ID and Name are empty when sets are not grouped.
+// Sessions is the number of events in which the group was performed.
+// Volumes contains the statistics of each volume type so that unlike volumes are not added together.
+type AnalyticsGroup struct {
+	ID       string                  `json:"id,omitempty"`
+	Name     string                  `json:"name,omitempty"`
+	Sessions int                     `json:"sessions"`
+	Volumes  map[string]*VolumeStats `json:"volumes"`
+}
+
+// VolumeStats are the statistics of the sets of a volume type.
+// Reps is the number of successful reps of count volumes and Tonnage is the weight that was lifted for them.
+// Time is in seconds and Distance is in m.
+// Intensities contains the statistics of each intensity type except bodyweight.
+type VolumeStats struct {
+	Sets        int                        `json:"sets"`
+	Reps        float32                    `json:"reps,omitempty"`
+	Tonnage     float32                    `json:"tonnage,omitempty"`
+	Time        float32                    `json:"time,omitempty"`
+	Distance    float32                    `json:"distance,omitempty"`
+	Intensities map[string]*IntensityStats `json:"intensities,omitempty"`
+}
+
+// IntensityStats are the statistics of the intensities of the sets of a volume type.
+// Average is the average intensity weighted by volume.
+// Distribution maps intensity zones to the volume that was performed in the zone. The key of a zone is its lower bound:
+//   - hrZone and rpe intensities are zoned by whole values
+//   - percentOfMax intensities, and weight intensities as a percentage of the current 1RM, are zoned in steps of 10%
+//   - pace intensities are zoned by whole minutes per km
+type IntensityStats struct {
+	Average      float32            `json:"average"`
+	Distribution map[string]float32 `json:"distribution,omitempty"`
+	weighted     float64
+	volume       float64
+}
+
+// intensityZone returns the zone of an intensity, or an empty string when the intensity is not zoned.
+func intensityZone(intensityType string, intensity, oneRM float32) string {
+	zone := func(v float64) string {
+		return strconv.Itoa(int(math.Floor(v)))
+	}
+
+	switch intensityType {
+	case "hrZone", "rpe":
+		return zone(float64(intensity))
+	case "percentOfMax":
+		return zone(float64(intensity)/10) + "0"
+	case "weight":
+		if oneRM > 0 {
+			return zone(float64(intensity/oneRM)*10) + "0"
+		}
+	case "pace":
+		return zone(float64(intensity) / 60)
+	}
+
+	return ""
+}
+
+// addSet adds the statistics of a set to the group.
+func (g *AnalyticsGroup) addSet(et ExerciseType, intensity float32, set []float32, oneRM float32) {
+	stats, ok := g.Volumes[et.VolumeType]
+	if !ok {
+		stats = &VolumeStats{}
+		g.Volumes[et.VolumeType] = stats
+	}
+
+	volume := float32(0)
+	for _, v := range set {
+		volume += v
+	}
+
+	stats.Sets++
+
+	switch et.VolumeType {
+	case "count":
+		stats.Reps += volume
+		if et.IntensityType == "weight" {
+			stats.Tonnage += intensity * volume
+		}
+	case "time":
+		stats.Time += volume
+	case "distance":
+		stats.Distance += volume
+	}
+
+	if et.IntensityType == "bodyweight" {
+		return
+	}
+
+	if stats.Intensities == nil {
+		stats.Intensities = map[string]*IntensityStats{}
+	}
+	intensityStats, ok := stats.Intensities[et.IntensityType]
+	if !ok {
+		intensityStats = &IntensityStats{}
+		stats.Intensities[et.IntensityType] = intensityStats
+	}
+
+	intensityStats.weighted += float64(intensity * volume)
+	intensityStats.volume += float64(volume)
+	if intensityStats.volume > 0 {
+		intensityStats.Average = float32(math.Round(intensityStats.weighted/intensityStats.volume*10) / 10)
+	}
+
+	if zone := intensityZone(et.IntensityType, intensity, oneRM); zone != "" && volume > 0 {
+		if intensityStats.Distribution == nil {
+			intensityStats.Distribution = map[string]float32{}
+		}
+		intensityStats.Distribution[zone] += volume
+	}
+}
+
+// periodStart returns the start of the day, week, or month that a date is in.
+func periodStart(period string, date int64, loc *time.Location) int64 {
+	t := time.Unix(date, 0).In(loc)
+
+	switch period {
+	case PeriodWeek:
+		// Monday is the first day of the week
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, loc).Unix()
+	case PeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Unix()
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Unix()
+}
+
+// microcycles returns the microcycles of a program instance and the index of the microcycle of each event of the instance.
+// The start of each microcycle is calculated from the start date of the instance and the spans of the previous microcycles.
+func microcycles(userID, programID, instanceID string) ([]AnalyticsBucket, map[string]int, error) {
+	page, err := programs.ProgramManager.GetProgramInstancesPage(userID, programID, instanceID, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(page) == 0 || page[0].ID != instanceID {
+		return nil, nil, fmt.Errorf("program instance %s: %w", instanceID, ErrNotFound)
+	}
+
+	instance := page[0]
+	cycles := []AnalyticsBucket{}
+	workoutCycles := []int{} // the microcycle of each workout of the instance
+	start := instance.StartTime
+
+	for _, block := range instance.Blocks {
+		for _, cycle := range block.MicroCycles {
+			for range cycle.Workouts {
+				workoutCycles = append(workoutCycles, len(cycles))
+			}
+			cycles = append(cycles, AnalyticsBucket{Start: start, Label: cycle.Title})
+			start += int64(cycle.Span) * 24 * 60 * 60
+		}
+	}
+
+	eventCycles := map[string]int{}
+	for index, eventID := range instance.Events {
+		if index >= 0 && index < len(workoutCycles) {
+			eventCycles[eventID] = workoutCycles[index]
+		}
+	}
+
+	return cycles, eventCycles, nil
+}
+
+// basisOf returns the exercise type at the root of the chain of variations that an exercise type belongs to.
+func basisOf(et ExerciseType, types map[string]ExerciseType) ExerciseType {
+	seen := map[string]bool{}
+	for et.Basis != "" && !seen[et.ID] {
+		seen[et.ID] = true
+		basis, ok := types[et.Basis]
+		if !ok {
+			break
+		}
+		et = basis
+	}
+
+	return et
+}
+
+// GetAnalytics returns the statistics of the sets of the events that match a query.
+// Sets are grouped into periods and, optionally, by exercise type, basis family, or activity.
+// When the ExerciseTypes field of the query filter is empty, sets of all exercise types are included.
+// The sets of composite exercise types are attributed to their components unless the filter names the composite.
+func (em eventManager) GetAnalytics(userID string, query AnalyticsQuery) (*Analytics, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	loc := query.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	exerciseTypes, err := ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise types: %w", err)
+	}
+
+	types := map[string]ExerciseType{}
+	for _, t := range exerciseTypes {
+		types[t.ID] = t
+	}
+
+	activityNames := map[string]string{}
+	if query.GroupBy == GroupActivity {
+		activities, err := ActivityManager.GetActivityNames(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get activities: %w", err)
+		}
+		for _, a := range activities {
+			activityNames[a.ID] = a.Name
+		}
+	}
+
+	var cycles []AnalyticsBucket
+	var eventCycles map[string]int
+	if query.Period == PeriodMicrocycle {
+		cycles, eventCycles, err = microcycles(userID, query.ProgramID, query.ProgramInstanceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get microcycles: %w", err)
+		}
+	}
+
+	// the current 1RM of weight exercise types is read once, when first needed
+	oneRMs := map[string]float32{}
+	oneRMOf := func(et ExerciseType) (float32, error) {
+		if et.IntensityType != "weight" {
+			return 0, nil
+		}
+		if oneRM, ok := oneRMs[et.ID]; ok {
+			return oneRM, nil
+		}
+		oneRM, _, err := ExerciseManager.Current1RM(userID, et.ID, Epley)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get 1RM: %w", err)
+		}
+		oneRMs[et.ID] = oneRM
+		return oneRM, nil
+	}
+
+	buckets := map[int64]*AnalyticsBucket{}
+	groups := map[int64]map[string]*AnalyticsGroup{}
+
+	err = em.forEachEvent(userID, query.ActivityID, query.Filter, func(event Event) error {
+		bucket := AnalyticsBucket{Start: periodStart(query.Period, event.Date, loc)}
+		if query.Period == PeriodMicrocycle {
+			cycle, ok := eventCycles[event.ID]
+			if !ok {
+				return nil
+			}
+			bucket = cycles[cycle]
+		}
+
+		if _, ok := buckets[bucket.Start]; !ok {
+			buckets[bucket.Start] = &bucket
+			groups[bucket.Start] = map[string]*AnalyticsGroup{}
+		}
+
+		performed := map[*AnalyticsGroup]bool{}
+
+		attributed := []ExerciseInstance{}
+		for _, instance := range event.Exercises {
+			attributed = append(attributed, query.Filter.attribute(instance, types)...)
+		}
+
+		for _, instance := range attributed {
+			exerciseType := types[instance.TypeID]
+
+			groupID, groupName := "", ""
+			switch query.GroupBy {
+			case GroupExercise:
+				groupID, groupName = exerciseType.ID, exerciseType.Name
+			case GroupBasis:
+				basis := basisOf(exerciseType, types)
+				groupID, groupName = basis.ID, basis.Name
+			case GroupActivity:
+				groupID, groupName = event.ActivityID, activityNames[event.ActivityID]
+			}
+
+			group, ok := groups[bucket.Start][groupID]
+			if !ok {
+				group = &AnalyticsGroup{ID: groupID, Name: groupName, Volumes: map[string]*VolumeStats{}}
+				groups[bucket.Start][groupID] = group
+			}
+
+			oneRM, err := oneRMOf(exerciseType)
+			if err != nil {
+				return err
+			}
+
+			for _, segment := range instance.Segments {
+				for _, set := range segment.Volume {
+					group.addSet(exerciseType, segment.Intensity, set, oneRM)
+					performed[group] = true
+				}
+			}
+		}
+
+		for group := range performed {
+			group.Sessions++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate analytics: %w", err)
+	}
+
+	analytics := Analytics{Period: query.Period, GroupBy: query.GroupBy, Buckets: []AnalyticsBucket{}}
+
+	for _, start := range slices.Sorted(maps.Keys(buckets)) {
+		bucket := buckets[start]
+		for _, group := range groups[start] {
+			if len(group.Volumes) > 0 {
+				bucket.Groups = append(bucket.Groups, *group)
+			}
+		}
+		if len(bucket.Groups) == 0 {
+			continue
+		}
+
+		slices.SortFunc(bucket.Groups, func(a, b AnalyticsGroup) int {
+			return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+		})
+		analytics.Buckets = append(analytics.Buckets, *bucket)
+	}
+
+	return &analytics, nil
+}
@@ -0,0 +1,205 @@
+package workoutlog
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/programs"
+)
+
+func TestAnalytics(t *testing.T) {
+	squat := ExerciseType{ID: "squat-id", Name: "squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+	frontSquat := ExerciseType{ID: "front-squat-id", Name: "front squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2, Basis: squat.ID}
+	plank := ExerciseType{ID: "plank-id", Name: "plank", IntensityType: "bodyweight", VolumeType: "time"}
+	run := ExerciseType{ID: "run-id", Name: "run", IntensityType: "pace", VolumeType: "distance"}
+
+	week1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix() // Monday
+	week2 := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC).Unix()
+	day := int64(24 * 60 * 60)
+
+	Convey("Given a user with events", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+
+		db.On("GetActivityNames", testUserID).Return(map[string]string{"lifting-id": "lifting", "running-id": "running"}, nil)
+		eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{squat, frontSquat, plank, run}, nil)
+		eMgr.On("Current1RM", testUserID, squat.ID, mock.Anything).Return(float32(200), false, nil)
+		eMgr.On("Current1RM", testUserID, frontSquat.ID, mock.Anything).Return(float32(100), true, nil)
+
+		events := []Event{
+			{ID: "event3", ActivityID: "running-id", Date: week2 + day + 3600},
+			{ID: "event2", ActivityID: "lifting-id", Date: week1 + 2*day + 3600},
+			{ID: "event1", ActivityID: "lifting-id", Date: week1 + 3600},
+		}
+		eventsByte := [][]byte{}
+		for _, e := range events {
+			eventByte, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			eventsByte = append(eventsByte, eventByte)
+		}
+
+		instance := func(et ExerciseType, index int, segments ...ExerciseSegment) []byte {
+			instanceByte, err := json.Marshal(ExerciseInstance{TypeID: et.ID, Index: index, Segments: segments})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return instanceByte
+		}
+
+		db.On("GetEventPage", testUserID, "", int64(0), DefaultPageSize).Return(eventsByte, nil)
+		db.On("GetEventExercises", testUserID, "event3").Return([][]byte{
+			instance(run, 0, ExerciseSegment{Intensity: 330, Volume: [][]float32{{5000}}}),
+		}, nil)
+		db.On("GetEventExercises", testUserID, "event2").Return([][]byte{
+			instance(frontSquat, 0, ExerciseSegment{Intensity: 80, Volume: [][]float32{{1, 1, 1}}}),
+			instance(squat, 1, ExerciseSegment{Intensity: 150, Volume: [][]float32{{1, 1, 0}}}),
+		}, nil)
+		db.On("GetEventExercises", testUserID, "event1").Return([][]byte{
+			instance(squat, 0, ExerciseSegment{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}}}),
+			instance(plank, 1, ExerciseSegment{Intensity: 1, Volume: [][]float32{{60}}}),
+		}, nil)
+
+		Convey("When we get weekly analytics", func() {
+			analytics, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodWeek})
+
+			Convey("Then the sets of each week are summarized separately for each volume type", func() {
+				So(err, ShouldBeNil)
+				So(analytics.Buckets, ShouldHaveLength, 2)
+
+				So(analytics.Buckets[0].Start, ShouldEqual, week1)
+				So(analytics.Buckets[0].Groups, ShouldHaveLength, 1)
+				group := analytics.Buckets[0].Groups[0]
+				So(group.Sessions, ShouldEqual, 2)
+				So(*group.Volumes["time"], ShouldResemble, VolumeStats{Sets: 1, Time: 60})
+
+				count := group.Volumes["count"]
+				So(count.Sets, ShouldEqual, 3)
+				So(count.Reps, ShouldEqual, 10)
+				So(count.Tonnage, ShouldEqual, 1040)
+				So(count.Intensities["weight"].Average, ShouldEqual, 104)
+				So(count.Intensities["weight"].Distribution, ShouldResemble, map[string]float32{"50": 5, "70": 2, "80": 3})
+
+				So(analytics.Buckets[1].Start, ShouldEqual, week2)
+				distance := analytics.Buckets[1].Groups[0].Volumes["distance"]
+				So(distance.Distance, ShouldEqual, 5000)
+				So(distance.Intensities["pace"].Average, ShouldEqual, 330)
+				So(distance.Intensities["pace"].Distribution, ShouldResemble, map[string]float32{"5": 5000})
+			})
+		})
+
+		Convey("When we get daily analytics grouped by basis family", func() {
+			analytics, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodDay, GroupBy: GroupBasis})
+
+			Convey("Then variations are grouped with the exercise type they are based on", func() {
+				So(err, ShouldBeNil)
+				So(analytics.Buckets, ShouldHaveLength, 3)
+				So(analytics.Buckets[1].Start, ShouldEqual, week1+2*day)
+				So(analytics.Buckets[1].Groups, ShouldHaveLength, 1)
+				So(analytics.Buckets[1].Groups[0].ID, ShouldEqual, squat.ID)
+				So(analytics.Buckets[1].Groups[0].Volumes["count"].Sets, ShouldEqual, 2)
+
+				names := []string{}
+				for _, g := range analytics.Buckets[0].Groups {
+					names = append(names, g.Name)
+				}
+				So(names, ShouldResemble, []string{"plank", "squat"})
+			})
+		})
+
+		Convey("When we get monthly analytics grouped by activity", func() {
+			analytics, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodMonth, GroupBy: GroupActivity})
+
+			So(err, ShouldBeNil)
+			So(analytics.Buckets, ShouldHaveLength, 1)
+			So(analytics.Buckets[0].Groups, ShouldHaveLength, 2)
+			So(analytics.Buckets[0].Groups[0].Name, ShouldEqual, "lifting")
+			So(analytics.Buckets[0].Groups[0].Sessions, ShouldEqual, 2)
+			So(analytics.Buckets[0].Groups[1].Name, ShouldEqual, "running")
+			So(analytics.Buckets[0].Groups[1].Sessions, ShouldEqual, 1)
+		})
+
+		Convey("When we get analytics of an exercise type", func() {
+			analytics, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodMonth, GroupBy: GroupExercise, Filter: ExerciseFilter{ExerciseTypes: []string{squat.ID}}})
+
+			So(err, ShouldBeNil)
+			So(analytics.Buckets[0].Groups, ShouldHaveLength, 1)
+			So(analytics.Buckets[0].Groups[0].Name, ShouldEqual, "squat")
+			So(analytics.Buckets[0].Groups[0].Volumes["count"].Reps, ShouldEqual, 7)
+		})
+
+		Convey("When we get analytics of an exercise type and its variations", func() {
+			filter := ExerciseFilter{ExerciseTypes: []string{squat.ID}, Variations: true}
+			analytics, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodMonth, GroupBy: GroupBasis, Filter: filter})
+
+			So(err, ShouldBeNil)
+			So(analytics.Buckets[0].Groups, ShouldHaveLength, 1)
+			So(analytics.Buckets[0].Groups[0].Name, ShouldEqual, "squat")
+			So(analytics.Buckets[0].Groups[0].Volumes["count"].Reps, ShouldEqual, 10)
+		})
+
+		Convey("When we get analytics by the microcycles of a program instance", func() {
+			workout := programs.Workout{Title: "workout"}
+			programInstance := programs.ProgramInstance{
+				Program: programs.Program{
+					Blocks: []programs.Block{{Title: "block", MicroCycles: []programs.MicroCycle{
+						{Title: "week 1", Span: 7, Workouts: []programs.Workout{workout, workout}},
+						{Title: "week 2", Span: 7, Workouts: []programs.Workout{workout}},
+					}}},
+				},
+				ID:        "instance-id",
+				ProgramID: "program-id",
+				StartTime: week1,
+				Events:    map[int]string{0: "event1", 2: "event3"},
+			}
+			instanceByte, err := json.Marshal(programInstance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.On("GetProgramInstancePage", testUserID, "program-id", "instance-id", 1).Return([][]byte{instanceByte}, nil)
+
+			analytics, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodMicrocycle, ProgramID: "program-id", ProgramInstanceID: "instance-id"})
+
+			Convey("Then only the events of the program instance are included", func() {
+				So(err, ShouldBeNil)
+				So(analytics.Buckets, ShouldHaveLength, 2)
+				So(analytics.Buckets[0].Label, ShouldEqual, "week 1")
+				So(analytics.Buckets[0].Start, ShouldEqual, week1)
+				So(analytics.Buckets[0].Groups[0].Sessions, ShouldEqual, 1)
+				So(analytics.Buckets[0].Groups[0].Volumes["count"].Reps, ShouldEqual, 5)
+				So(analytics.Buckets[1].Label, ShouldEqual, "week 2")
+				So(analytics.Buckets[1].Start, ShouldEqual, week2)
+			})
+		})
+
+		Convey("When the query is invalid", func() {
+			_, err := EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: "year"})
+			So(errors.Is(err, ErrInvalidAnalyticsQuery), ShouldBeTrue)
+
+			_, err = EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodWeek, GroupBy: "colour"})
+			So(errors.Is(err, ErrInvalidAnalyticsQuery), ShouldBeTrue)
+
+			_, err = EventManager.GetAnalytics(testUserID, AnalyticsQuery{Period: PeriodMicrocycle})
+			So(errors.Is(err, ErrInvalidAnalyticsQuery), ShouldBeTrue)
+		})
+	})
+
+	Convey("When we get the start of periods in a time zone", t, func() {
+		est := time.FixedZone("EST", -5*60*60)
+		date := time.Date(2024, 1, 3, 2, 0, 0, 0, time.UTC).Unix() // Tuesday evening in EST
+
+		So(periodStart(PeriodDay, date, est), ShouldEqual, time.Date(2024, 1, 2, 0, 0, 0, 0, est).Unix())
+		So(periodStart(PeriodWeek, date, est), ShouldEqual, time.Date(2024, 1, 1, 0, 0, 0, 0, est).Unix())
+		So(periodStart(PeriodMonth, date, est), ShouldEqual, time.Date(2024, 1, 1, 0, 0, 0, 0, est).Unix())
+	})
+}
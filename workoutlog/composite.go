@@ -0,0 +1,144 @@
+package workoutlog
+
+import (
+	"maps"
+	"slices"
+)
+
+// isComposite returns true when an exercise type is composed of other exercise types.
+func (et ExerciseType) isComposite() bool {
+	return len(et.Composition) > 0
+}
+
+// compositeReps returns the number of reps of component exercises that one rep of an exercise type includes.
+// Returns 1 for exercise types that are not composites.
+func (et ExerciseType) compositeReps() float32 {
+	if !et.isComposite() {
+		return 1
+	}
+
+	reps := 0
+	for _, n := range et.Composition {
+		reps += n
+	}
+
+	return float32(reps)
+}
+
+// ExpandInstance returns an instance of each component exercise type of a composite instance.
+// Each successful rep of a set of the composite is a set of each component with the number of reps that the
+// composition specifies, performed at the intensity of the composite. Failed reps of the composite are not included.
+// Sets of the composite are not combined into larger sets of the components, so that the records of the
+// components are not set with more reps than were performed without rest.
+// Returns the instance when the exercise type is not a composite.
+func (et ExerciseType) ExpandInstance(ei *ExerciseInstance) []ExerciseInstance {
+	if !et.isComposite() {
+		return []ExerciseInstance{*ei}
+	}
+
+	expanded := []ExerciseInstance{}
+
+	for _, componentID := range slices.Sorted(maps.Keys(et.Composition)) {
+		component := ExerciseInstance{TypeID: componentID, Index: ei.Index, Segments: []ExerciseSegment{}}
+
+		for _, segment := range ei.Segments {
+			componentSegment := ExerciseSegment{Intensity: segment.Intensity, Volume: [][]float32{}}
+
+			for _, set := range segment.Volume {
+				for _, rep := range set {
+					// only successful reps are included
+					for range int(rep) {
+						componentSet := make([]float32, et.Composition[componentID])
+						for i := range componentSet {
+							componentSet[i] = 1
+						}
+						componentSegment.Volume = append(componentSegment.Volume, componentSet)
+					}
+				}
+			}
+
+			if len(componentSegment.Volume) > 0 {
+				component.Segments = append(component.Segments, componentSegment)
+			}
+		}
+
+		expanded = append(expanded, component)
+	}
+
+	return expanded
+}
+
+// includes returns true when the exercise types of a filter include an exercise type.
+// All exercise types are included when the filter has no exercise types.
+// When the Variations field is true, the variations of the exercise types of the filter are also included.
+func (f ExerciseFilter) includes(et ExerciseType, types map[string]ExerciseType) bool {
+	if len(f.ExerciseTypes) == 0 || slices.Contains(f.ExerciseTypes, et.ID) {
+		return true
+	}
+
+	if !f.Variations {
+		return false
+	}
+
+	seen := map[string]bool{}
+	for et.Basis != "" && !seen[et.ID] {
+		if slices.Contains(f.ExerciseTypes, et.Basis) {
+			return true
+		}
+		seen[et.ID] = true
+		et = types[et.Basis]
+	}
+
+	return false
+}
+
+// attribute returns the instances that a filter attributes the work of an instance to.
+// Composite instances are expanded into their components unless the filter names the composite exercise type.
+// Returns no instances when the filter does not include the exercise type.
+func (f ExerciseFilter) attribute(instance ExerciseInstance, types map[string]ExerciseType) []ExerciseInstance {
+	exerciseType, ok := types[instance.TypeID]
+	if !ok {
+		return nil
+	}
+
+	if !exerciseType.isComposite() || slices.Contains(f.ExerciseTypes, exerciseType.ID) {
+		if f.includes(exerciseType, types) {
+			return []ExerciseInstance{instance}
+		}
+		return nil
+	}
+
+	attributed := []ExerciseInstance{}
+	for _, component := range exerciseType.ExpandInstance(&instance) {
+		componentType, ok := types[component.TypeID]
+		if ok && f.includes(componentType, types) {
+			attributed = append(attributed, component)
+		}
+	}
+
+	return attributed
+}
+
+// readTypes returns the IDs of the exercise types whose instances a filter can attribute work from:
+// the exercise types that it includes and the composites of those types.
+func (f ExerciseFilter) readTypes(types map[string]ExerciseType) []string {
+	typeIDs := []string{}
+
+	for _, t := range types {
+		if f.includes(t, types) {
+			typeIDs = append(typeIDs, t.ID)
+			continue
+		}
+
+		for componentID := range t.Composition {
+			if component, ok := types[componentID]; ok && f.includes(component, types) {
+				typeIDs = append(typeIDs, t.ID)
+				break
+			}
+		}
+	}
+
+	slices.Sort(typeIDs)
+
+	return typeIDs
+}
@@ -0,0 +1,90 @@
+package workoutlog
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestComposite(t *testing.T) {
+	clean := ExerciseType{ID: "clean-id", Name: "clean", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+	jerk := ExerciseType{ID: "jerk-id", Name: "jerk", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+	splitJerk := ExerciseType{ID: "split-jerk-id", Name: "split jerk", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2, Basis: jerk.ID}
+	complex := ExerciseType{ID: "complex-id", Name: "clean and jerks", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2,
+		Composition: map[string]int{clean.ID: 1, splitJerk.ID: 2}}
+
+	types := map[string]ExerciseType{clean.ID: clean, jerk.ID: jerk, splitJerk.ID: splitJerk, complex.ID: complex}
+
+	instance := ExerciseInstance{
+		TypeID: complex.ID,
+		Index:  3,
+		Segments: []ExerciseSegment{
+			{Intensity: 100, Volume: [][]float32{{1, 1}, {0, 0}}},
+			{Intensity: 110, Volume: [][]float32{{1, 0}}},
+		},
+	}
+
+	Convey("When we expand a composite instance", t, func() {
+		expanded := complex.ExpandInstance(&instance)
+
+		Convey("Then each successful rep is a set of each component at the same intensity", func() {
+			So(expanded, ShouldResemble, []ExerciseInstance{
+				{TypeID: clean.ID, Index: 3, Segments: []ExerciseSegment{
+					{Intensity: 100, Volume: [][]float32{{1}, {1}}},
+					{Intensity: 110, Volume: [][]float32{{1}}},
+				}},
+				{TypeID: splitJerk.ID, Index: 3, Segments: []ExerciseSegment{
+					{Intensity: 100, Volume: [][]float32{{1, 1}, {1, 1}}},
+					{Intensity: 110, Volume: [][]float32{{1, 1}}},
+				}},
+			})
+		})
+	})
+
+	Convey("When we calculate the metrics of a composite instance", t, func() {
+		load, volume, maxIntensity := complex.CalculateMetrics(&instance)
+
+		Convey("Then the reps of all components are counted", func() {
+			So(volume, ShouldEqual, 9)
+			So(load, ShouldEqual, 3*2*100+3*110)
+			So(maxIntensity, ShouldEqual, 110)
+		})
+	})
+
+	Convey("When a filter attributes the work of a composite instance", t, func() {
+		Convey("Then the components are attributed when the filter has no exercise types", func() {
+			attributed := ExerciseFilter{}.attribute(instance, types)
+
+			So(attributed, ShouldHaveLength, 2)
+			So(attributed[0].TypeID, ShouldEqual, clean.ID)
+			So(attributed[1].TypeID, ShouldEqual, splitJerk.ID)
+		})
+
+		Convey("Then only the components that the filter includes are attributed", func() {
+			attributed := ExerciseFilter{ExerciseTypes: []string{clean.ID}}.attribute(instance, types)
+
+			So(attributed, ShouldHaveLength, 1)
+			So(attributed[0].TypeID, ShouldEqual, clean.ID)
+		})
+
+		Convey("Then components are attributed to their basis when variations are included", func() {
+			So(ExerciseFilter{ExerciseTypes: []string{jerk.ID}}.attribute(instance, types), ShouldBeEmpty)
+
+			attributed := ExerciseFilter{ExerciseTypes: []string{jerk.ID}, Variations: true}.attribute(instance, types)
+
+			So(attributed, ShouldHaveLength, 1)
+			So(attributed[0].TypeID, ShouldEqual, splitJerk.ID)
+		})
+
+		Convey("Then the composite is attributed when the filter names it", func() {
+			attributed := ExerciseFilter{ExerciseTypes: []string{complex.ID}}.attribute(instance, types)
+
+			So(attributed, ShouldResemble, []ExerciseInstance{instance})
+		})
+	})
+
+	Convey("When we get the exercise types that a filter reads", t, func() {
+		So(ExerciseFilter{ExerciseTypes: []string{jerk.ID}, Variations: true}.readTypes(types), ShouldResemble, []string{complex.ID, jerk.ID, splitJerk.ID})
+		So(ExerciseFilter{ExerciseTypes: []string{clean.ID}}.readTypes(types), ShouldResemble, []string{clean.ID, complex.ID})
+	})
+}
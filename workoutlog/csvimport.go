@@ -0,0 +1,572 @@
+package workoutlog
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// An ErrInvalidCSV generates an error for when a CSV file or its column mapping cannot be used.
+type ErrInvalidCSV struct {
+	Message string
+}
+
+func (e ErrInvalidCSV) Error() string {
+	return fmt.Sprintf("invalid csv: %s", e.Message)
+}
+
+// CSVImportManager provides an implementation of CSVImporter.
+var CSVImportManager CSVImporter = csvImporter{}
+
+// The CSVImporter type defines routines for importing workout history from CSV files.
+type CSVImporter interface {
+	ImportCSV(userID string, csvFile io.Reader, spec CSVImportSpec, dryRun bool) (*CSVImportReport, error)
+}
+
+// A CSVImportSpec describes how the rows of a CSV file are interpreted.
+// Each row of the file is a set of an exercise.
+// ActivityID is the activity of rows that do not identify an activity by name.
+// DateFormat is a Go time layout and defaults to 2006-01-02.
+// ExerciseNames maps exercise names in the file to the names of exercise types.
+type CSVImportSpec struct {
+	ActivityID    string            `json:"activityID"`
+	DateFormat    string            `json:"dateFormat"`
+	Columns       CSVColumns        `json:"columns"`
+	ExerciseNames map[string]string `json:"exerciseNames"`
+}
+
+// CSVColumns stores the column headings of the values in a CSV file.
+// Date and Exercise are required. Unused values are empty.
+// Distance is in metres. Time is in seconds or h:mm:ss format.
+type CSVColumns struct {
+	Date     string `json:"date"`
+	Activity string `json:"activity"`
+	Exercise string `json:"exercise"`
+	Weight   string `json:"weight"`
+	Reps     string `json:"reps"`
+	RPE      string `json:"rpe"`
+	Distance string `json:"distance"`
+	Time     string `json:"time"`
+}
+
+// A CSVImportReport describes the events and exercise types that are created from a CSV file.
+// For dry runs the report describes what would be created.
+// Rows that cannot be imported are reported as errors and are skipped.
+// When a write fails, the report lists the exercise types and events that were created
+// before the failure and Failure describes the row that could not be written.
+type CSVImportReport struct {
+	DryRun           bool             `json:"dryRun"`
+	Rows             int              `json:"rows"`
+	Events           []CSVImportEvent `json:"events"`
+	NewExerciseTypes []ExerciseType   `json:"newExerciseTypes"`
+	Errors           []CSVRowError    `json:"errors"`
+	Failure          *CSVRowError     `json:"failure,omitempty"`
+}
+
+// A CSVImportEvent summarizes an event that is created from the rows of a CSV file.
+type CSVImportEvent struct {
+	ID         string `json:"id,omitempty"`
+	ActivityID string `json:"activityID"`
+	Date       int64  `json:"date"`
+	Exercises  int    `json:"exercises"`
+	Sets       int    `json:"sets"`
+}
+
+// A CSVRowError describes why a row of a CSV file cannot be imported.
+// Row is the number of the row in the file, where the header is row 1.
+type CSVRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type csvImporter struct{}
+
+// csvRow stores the values of a row of a CSV file.
+// Zero values indicate that the value is not present.
+type csvRow struct {
+	date     time.Time
+	activity string
+	exercise string
+	weight   float32
+	reps     float32
+	rpe      float32
+	distance float32
+	time     float32
+}
+
+// csvEvent collects the exercise instances of the rows for a date and activity.
+type csvEvent struct {
+	report    CSVImportEvent
+	rows      []int    // the rows of the file that the event is created from
+	order     []string // exercise type names in the order they were performed
+	types     map[string]*ExerciseType
+	instances map[string]*ExerciseInstance
+}
+
+// ImportCSV creates events from the rows of a CSV file.
+// Rows are grouped into an event for each date and activity.
+// Exercise types are matched by name, ignoring case. Types that do not exist are created
+// using the intensity and volume types that are inferred from the values of the row.
+// When dryRun is true, nothing is written to the database.
+func (ci csvImporter) ImportCSV(userID string, csvFile io.Reader, spec CSVImportSpec, dryRun bool) (*CSVImportReport, error) {
+	if spec.Columns.Date == "" || spec.Columns.Exercise == "" {
+		return nil, ErrInvalidCSV{Message: "the date and exercise columns are required"}
+	}
+	if spec.ActivityID == "" && spec.Columns.Activity == "" {
+		return nil, ErrInvalidCSV{Message: "an activity ID or activity column is required"}
+	}
+	if spec.Columns.Reps == "" && spec.Columns.Distance == "" && spec.Columns.Time == "" {
+		return nil, ErrInvalidCSV{Message: "a reps, distance, or time column is required"}
+	}
+	if spec.DateFormat == "" {
+		spec.DateFormat = "2006-01-02"
+	}
+
+	reader := csv.NewReader(csvFile)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrInvalidCSV{Message: fmt.Sprintf("cannot read header: %s", err.Error())}
+	}
+
+	columns, err := spec.Columns.indexes(header)
+	if err != nil {
+		return nil, err
+	}
+
+	activities, err := ActivityManager.GetActivityNames(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activities: %w", err)
+	}
+
+	if spec.ActivityID != "" && !slices.ContainsFunc(activities, func(a *Activity) bool { return a.ID == spec.ActivityID }) {
+		return nil, ErrInvalidCSV{Message: "activity not found"}
+	}
+
+	existingTypes, err := ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise types: %w", err)
+	}
+
+	types := map[string]*ExerciseType{}
+	for _, t := range existingTypes {
+		// prefer types that are not archived when names differ only by case
+		if existing, ok := types[strings.ToLower(t.Name)]; ok && !existing.Archived {
+			continue
+		}
+		types[strings.ToLower(t.Name)] = &t
+	}
+
+	report := CSVImportReport{DryRun: dryRun, Events: []CSVImportEvent{}, NewExerciseTypes: []ExerciseType{}, Errors: []CSVRowError{}}
+	newTypes := []*ExerciseType{}
+	newTypeRows := map[*ExerciseType]int{}
+	events := []*csvEvent{}
+	eventsByKey := map[string]*csvEvent{}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		report.Rows++
+		if err != nil {
+			report.Errors = append(report.Errors, CSVRowError{Row: line, Message: err.Error()})
+			continue
+		}
+
+		row, err := parseCSVRow(record, columns, spec.DateFormat)
+		if err != nil {
+			report.Errors = append(report.Errors, CSVRowError{Row: line, Message: err.Error()})
+			continue
+		}
+
+		activityID := spec.ActivityID
+		if row.activity != "" {
+			idx := slices.IndexFunc(activities, func(a *Activity) bool { return strings.EqualFold(a.Name, row.activity) })
+			if idx < 0 {
+				report.Errors = append(report.Errors, CSVRowError{Row: line, Message: fmt.Sprintf("activity not found: %s", row.activity)})
+				continue
+			}
+			activityID = activities[idx].ID
+		}
+
+		name := row.exercise
+		if mapped, ok := spec.ExerciseNames[name]; ok {
+			name = mapped
+		}
+
+		exerciseType, ok := types[strings.ToLower(name)]
+		if !ok {
+			exerciseType, err = inferExerciseType(name, row)
+			if err != nil {
+				report.Errors = append(report.Errors, CSVRowError{Row: line, Message: err.Error()})
+				continue
+			}
+			types[strings.ToLower(name)] = exerciseType
+			newTypes = append(newTypes, exerciseType)
+			newTypeRows[exerciseType] = line
+		}
+
+		segment, err := row.segment(*exerciseType)
+		if err != nil {
+			report.Errors = append(report.Errors, CSVRowError{Row: line, Message: err.Error()})
+			continue
+		}
+
+		instance := ExerciseInstance{Segments: []ExerciseSegment{segment}}
+		if err := exerciseType.ValidateInstance(&instance); err != nil {
+			report.Errors = append(report.Errors, CSVRowError{Row: line, Message: err.Error()})
+			continue
+		}
+
+		eventKey := row.date.Format(time.DateOnly) + "#" + activityID
+		event, ok := eventsByKey[eventKey]
+		if !ok {
+			event = &csvEvent{
+				report:    CSVImportEvent{ActivityID: activityID, Date: row.date.Unix()},
+				types:     map[string]*ExerciseType{},
+				instances: map[string]*ExerciseInstance{},
+			}
+			eventsByKey[eventKey] = event
+			events = append(events, event)
+		}
+		event.rows = append(event.rows, line)
+		event.add(name, exerciseType, instance.Segments[0])
+	}
+
+	// validate the events that the rows are grouped into so that nothing is written for a bad file
+	for _, e := range events {
+		if err := e.validate(); err != nil {
+			return nil, ErrInvalidCSV{Message: fmt.Sprintf("row %d: %s", e.rows[0], err.Error())}
+		}
+	}
+
+	if !dryRun {
+		if failure, err := createCSVEvents(userID, newTypes, newTypeRows, events); err != nil {
+			// report what was created before the failure
+			report.Failure = failure
+			for _, t := range newTypes {
+				if t.ID != "" {
+					report.NewExerciseTypes = append(report.NewExerciseTypes, *t)
+				}
+			}
+			for _, e := range events {
+				if e.report.ID != "" {
+					report.Events = append(report.Events, e.report)
+				}
+			}
+			return &report, err
+		}
+	}
+
+	for _, t := range newTypes {
+		report.NewExerciseTypes = append(report.NewExerciseTypes, *t)
+	}
+
+	for _, e := range events {
+		report.Events = append(report.Events, e.report)
+	}
+
+	return &report, nil
+}
+
+// createCSVEvents writes the new exercise types and the events to the database.
+// When a write fails, the row that the failed item is created from is returned with the error.
+func createCSVEvents(userID string, newTypes []*ExerciseType, newTypeRows map[*ExerciseType]int, events []*csvEvent) (*CSVRowError, error) {
+	for _, t := range newTypes {
+		id, err := ExerciseManager.NewExerciseType(userID, t.Name, t.IntensityType, t.VolumeType, t.VolumeConstraint, nil, "")
+		if err != nil {
+			err = fmt.Errorf("failed to add exercise type %s: %w", t.Name, err)
+			return &CSVRowError{Row: newTypeRows[t], Message: err.Error()}, err
+		}
+		t.ID = *id
+	}
+
+	for _, e := range events {
+		activity := Activity{ID: e.report.ActivityID}
+		event := Event{ActivityID: e.report.ActivityID, Date: e.report.Date, Exercises: map[int]ExerciseInstance{}}
+
+		for i, name := range e.order {
+			if err := activity.AddExerciseToActivity(userID, e.types[name].ID); err != nil {
+				err = fmt.Errorf("failed to add exercise type to activity: %w", err)
+				return &CSVRowError{Row: e.rows[0], Message: err.Error()}, err
+			}
+
+			instance := *e.instances[name]
+			instance.TypeID = e.types[name].ID
+			instance.Index = i
+			event.Exercises[i] = instance
+		}
+
+		id, _, err := EventManager.NewEvent(userID, event)
+		if err != nil {
+			err = fmt.Errorf("failed to add event: %w", err)
+			return &CSVRowError{Row: e.rows[0], Message: err.Error()}, err
+		}
+		e.report.ID = *id
+	}
+
+	return nil, nil
+}
+
+// validate checks the exercise instances that are created from the rows of an event.
+func (e *csvEvent) validate() error {
+	if e.report.ActivityID == "" || e.report.Date == 0 {
+		return ErrInvalidEvent
+	}
+
+	for i, name := range e.order {
+		instance := *e.instances[name]
+		instance.Index = i
+		if err := e.types[name].ValidateInstance(&instance); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// add appends a set to the exercise instance of an event.
+// Consecutive sets at the same intensity are stored in the same segment.
+func (e *csvEvent) add(name string, exerciseType *ExerciseType, segment ExerciseSegment) {
+	instance, ok := e.instances[name]
+	if !ok {
+		instance = &ExerciseInstance{Segments: []ExerciseSegment{}}
+		e.instances[name] = instance
+		e.types[name] = exerciseType
+		e.order = append(e.order, name)
+		e.report.Exercises++
+	}
+	e.report.Sets++
+
+	last := len(instance.Segments) - 1
+	if last >= 0 && instance.Segments[last].Intensity == segment.Intensity && instance.Segments[last].RPE == segment.RPE {
+		instance.Segments[last].Volume = append(instance.Segments[last].Volume, segment.Volume...)
+		return
+	}
+
+	instance.Segments = append(instance.Segments, segment)
+}
+
+// indexes returns the index of each used column, keyed by field name.
+func (cc CSVColumns) indexes(header []string) (map[string]int, error) {
+	fields := map[string]string{
+		"date":     cc.Date,
+		"activity": cc.Activity,
+		"exercise": cc.Exercise,
+		"weight":   cc.Weight,
+		"reps":     cc.Reps,
+		"rpe":      cc.RPE,
+		"distance": cc.Distance,
+		"time":     cc.Time,
+	}
+
+	indexes := map[string]int{}
+	for field, heading := range fields {
+		if heading == "" {
+			continue
+		}
+		idx := slices.IndexFunc(header, func(h string) bool { return strings.TrimSpace(h) == heading })
+		if idx < 0 {
+			return nil, ErrInvalidCSV{Message: fmt.Sprintf("column not found: %s", heading)}
+		}
+		indexes[field] = idx
+	}
+
+	return indexes, nil
+}
+
+// parseCSVRow reads the values of a row from the mapped columns.
+func parseCSVRow(record []string, columns map[string]int, dateFormat string) (csvRow, error) {
+	row := csvRow{}
+
+	value := func(field string) string {
+		idx, ok := columns[field]
+		if !ok || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
+	number := func(field string) (float32, error) {
+		v := value(field)
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad %s value: %s", field, v)
+		}
+		return float32(n), nil
+	}
+
+	date, err := time.Parse(dateFormat, value("date"))
+	if err != nil {
+		return row, fmt.Errorf("bad date value: %s", value("date"))
+	}
+	row.date = date
+
+	row.activity = value("activity")
+	row.exercise = value("exercise")
+	if row.exercise == "" {
+		return row, fmt.Errorf("exercise name is required")
+	}
+
+	if row.weight, err = number("weight"); err != nil {
+		return row, err
+	}
+	if row.reps, err = number("reps"); err != nil {
+		return row, err
+	}
+	if row.rpe, err = number("rpe"); err != nil {
+		return row, err
+	}
+	if row.distance, err = number("distance"); err != nil {
+		return row, err
+	}
+	if v := value("time"); v != "" {
+		if row.time, err = parseDuration(v); err != nil {
+			return row, fmt.Errorf("bad time value: %s", v)
+		}
+	}
+
+	return row, nil
+}
+
+// segment returns an exercise segment for the set that is stored in the row.
+// The volume and intensity values are selected according to the exercise type.
+func (r csvRow) segment(et ExerciseType) (ExerciseSegment, error) {
+	segment := ExerciseSegment{}
+
+	switch et.VolumeType {
+	case "count":
+		if r.reps < 1 {
+			return segment, fmt.Errorf("reps are required for %s", et.Name)
+		}
+		set := make([]float32, int(r.reps))
+		for i := range set {
+			set[i] = 1
+		}
+		segment.Volume = [][]float32{set}
+	case "distance":
+		if r.distance <= 0 {
+			return segment, fmt.Errorf("distance is required for %s", et.Name)
+		}
+		segment.Volume = [][]float32{{r.distance}}
+	case "time":
+		if r.time <= 0 {
+			return segment, fmt.Errorf("time is required for %s", et.Name)
+		}
+		segment.Volume = [][]float32{{r.time}}
+	}
+
+	switch et.IntensityType {
+	case "weight", "percentOfMax":
+		segment.Intensity = r.weight
+		segment.RPE = r.rpe
+	case "bodyweight":
+		segment.Intensity = 1
+	case "rpe":
+		segment.Intensity = r.rpe
+	case "pace":
+		// seconds per km
+		if r.distance > 0 {
+			segment.Intensity = r.time / (r.distance / 1000)
+		}
+	case "hrZone":
+		return segment, fmt.Errorf("heart rate zones cannot be imported for %s", et.Name)
+	}
+
+	return segment, nil
+}
+
+// inferExerciseType returns an exercise type that can store the values of a row.
+func inferExerciseType(name string, r csvRow) (*ExerciseType, error) {
+	et := ExerciseType{Name: name}
+
+	switch {
+	case r.reps > 0:
+		et.VolumeType = "count"
+		et.VolumeConstraint = 1
+		switch {
+		case r.weight > 0:
+			et.IntensityType = "weight"
+		case r.rpe > 0:
+			et.IntensityType = "rpe"
+		default:
+			et.IntensityType = "bodyweight"
+		}
+	case r.distance > 0:
+		et.VolumeType = "distance"
+		switch {
+		case r.rpe > 0:
+			et.IntensityType = "rpe"
+		case r.time > 0:
+			et.IntensityType = "pace"
+		case r.weight > 0:
+			et.IntensityType = "weight"
+		}
+	case r.time > 0:
+		et.VolumeType = "time"
+		if r.rpe > 0 {
+			et.IntensityType = "rpe"
+		}
+	}
+
+	if et.VolumeType == "" || et.IntensityType == "" {
+		return nil, fmt.Errorf("cannot determine the exercise type for %s", name)
+	}
+
+	// validation requires an ID, which is generated when the type is created
+	et.ID = "new"
+	if err := et.Validate(); err != nil {
+		return nil, err
+	}
+	et.ID = ""
+
+	return &et, nil
+}
+
+// parseDuration parses a number of seconds or a duration in h:mm:ss or mm:ss format.
+func parseDuration(value string) (float32, error) {
+	if !strings.Contains(value, ":") {
+		seconds, err := strconv.ParseFloat(value, 32)
+		return float32(seconds), err
+	}
+
+	seconds := float64(0)
+	for _, part := range strings.Split(value, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds = seconds*60 + v
+	}
+
+	return float32(seconds), nil
+}
+
+type MockCSVImporter struct {
+	mock.Mock
+}
+
+func NewMockCSVImporter() *MockCSVImporter {
+	return new(MockCSVImporter)
+}
+
+func (m *MockCSVImporter) ImportCSV(userID string, csvFile io.Reader, spec CSVImportSpec, dryRun bool) (*CSVImportReport, error) {
+	args := m.Called(userID, csvFile, spec, dryRun)
+
+	report, _ := args.Get(0).(*CSVImportReport)
+
+	return report, args.Error(1)
+}
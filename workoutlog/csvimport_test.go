@@ -0,0 +1,174 @@
+package workoutlog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/scottbrodersen/homegym/dal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+const testCSV = `Date,Workout,Exercise Name,Weight,Reps,RPE,Distance,Seconds
+2024-01-05,Lifting,Squat,100,5,8,,
+2024-01-05,Lifting,Squat,100,5,8,,
+2024-01-05,Lifting,Squat,110,3,9,,
+2024-01-05,Lifting,Pull Up,,8,,,
+2024-01-06,Running,Easy Run,,,,5000,25:00
+2024-01-06,Lifting,Squat,heavy,5,,,
+2024-01-07,Swimming,Squat,100,5,,,
+`
+
+func testCSVSpec() CSVImportSpec {
+	return CSVImportSpec{
+		Columns: CSVColumns{
+			Date:     "Date",
+			Activity: "Workout",
+			Exercise: "Exercise Name",
+			Weight:   "Weight",
+			Reps:     "Reps",
+			RPE:      "RPE",
+			Distance: "Distance",
+			Time:     "Seconds",
+		},
+		ExerciseNames: map[string]string{"Pull Up": "pullup"},
+	}
+}
+
+func TestCSVImport(t *testing.T) {
+	squat := ExerciseType{ID: "squat-id", Name: "squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 1}
+
+	Convey("Given a user with activities and exercise types", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+
+		db.On("GetActivityNames", testUserID).Return(map[string]string{"lifting-id": "Lifting", "running-id": "Running"}, nil)
+		eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{squat}, nil)
+
+		Convey("When we do a dry run of a CSV import", func() {
+			report, err := CSVImportManager.ImportCSV(testUserID, strings.NewReader(testCSV), testCSVSpec(), true)
+			So(err, ShouldBeNil)
+
+			Convey("Then rows are grouped into events by date and activity", func() {
+				So(report.DryRun, ShouldBeTrue)
+				So(report.Rows, ShouldEqual, 7)
+				So(len(report.Events), ShouldEqual, 2)
+				So(report.Events[0].ActivityID, ShouldEqual, "lifting-id")
+				So(report.Events[0].Exercises, ShouldEqual, 2)
+				So(report.Events[0].Sets, ShouldEqual, 4)
+				So(report.Events[0].ID, ShouldBeEmpty)
+				So(report.Events[1].ActivityID, ShouldEqual, "running-id")
+			})
+
+			Convey("Then missing exercise types are inferred from the values", func() {
+				So(len(report.NewExerciseTypes), ShouldEqual, 2)
+				So(report.NewExerciseTypes[0].Name, ShouldEqual, "pullup")
+				So(report.NewExerciseTypes[0].IntensityType, ShouldEqual, "bodyweight")
+				So(report.NewExerciseTypes[1].Name, ShouldEqual, "Easy Run")
+				So(report.NewExerciseTypes[1].IntensityType, ShouldEqual, "pace")
+				So(report.NewExerciseTypes[1].VolumeType, ShouldEqual, "distance")
+			})
+
+			Convey("Then rows that cannot be imported are reported", func() {
+				So(len(report.Errors), ShouldEqual, 2)
+				So(report.Errors[0].Row, ShouldEqual, 7)
+				So(report.Errors[0].Message, ShouldContainSubstring, "weight")
+				So(report.Errors[1].Row, ShouldEqual, 8)
+				So(report.Errors[1].Message, ShouldContainSubstring, "activity not found")
+			})
+
+			Convey("Then nothing is written", func() {
+				eMgr.AssertNotCalled(t, "NewExerciseType", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+		})
+
+		Convey("When we import a CSV file", func() {
+			eventMgr := NewMockEventAdmin()
+			EventManager = eventMgr
+			defer func() { EventManager = new(eventManager) }()
+
+			pullupID := "pullup-id"
+			eMgr.On("NewExerciseType", testUserID, "pullup", "bodyweight", "count", 1, mock.Anything, "").Return(&pullupID, nil)
+			db.On("ReadActivity", testUserID, "lifting-id").Return(&testActivityName, []string{squat.ID, pullupID}, nil)
+
+			eventID := "event-id"
+			var added Event
+			eventMgr.On("NewEvent", testUserID, mock.Anything).Run(func(args mock.Arguments) { added = args.Get(1).(Event) }).Return(&eventID, nil, nil)
+
+			csvFile := strings.Join(strings.Split(testCSV, "\n")[0:5], "\n")
+			report, err := CSVImportManager.ImportCSV(testUserID, strings.NewReader(csvFile), testCSVSpec(), false)
+			So(err, ShouldBeNil)
+
+			Convey("Then the event is created with an instance for each exercise", func() {
+				So(report.Events[0].ID, ShouldEqual, eventID)
+				So(added.ActivityID, ShouldEqual, "lifting-id")
+				So(len(added.Exercises), ShouldEqual, 2)
+
+				squatInstance := added.Exercises[0]
+				So(squatInstance.TypeID, ShouldEqual, squat.ID)
+				So(len(squatInstance.Segments), ShouldEqual, 2)
+				So(squatInstance.Segments[0].Intensity, ShouldEqual, 100)
+				So(squatInstance.Segments[0].Volume, ShouldResemble, [][]float32{{1, 1, 1, 1, 1}, {1, 1, 1, 1, 1}})
+				So(squatInstance.Segments[0].RPE, ShouldEqual, 8)
+				So(squatInstance.Segments[1].Intensity, ShouldEqual, 110)
+				So(squatInstance.Segments[1].RPE, ShouldEqual, 9)
+
+				So(added.Exercises[1].TypeID, ShouldEqual, pullupID)
+				So(added.Exercises[1].Index, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When an event cannot be written", func() {
+			eventMgr := NewMockEventAdmin()
+			EventManager = eventMgr
+			defer func() { EventManager = new(eventManager) }()
+
+			pullupID := "pullup-id"
+			runID := "run-id"
+			eMgr.On("NewExerciseType", testUserID, "pullup", "bodyweight", "count", 1, mock.Anything, "").Return(&pullupID, nil)
+			eMgr.On("NewExerciseType", testUserID, "Easy Run", "pace", "distance", 0, mock.Anything, "").Return(&runID, nil)
+			db.On("ReadActivity", testUserID, "lifting-id").Return(&testActivityName, []string{squat.ID, pullupID}, nil)
+			db.On("ReadActivity", testUserID, "running-id").Return(&testActivityName, []string{runID}, nil)
+
+			eventID := "event-id"
+			eventMgr.On("NewEvent", testUserID, mock.MatchedBy(func(e Event) bool { return e.ActivityID == "lifting-id" })).Return(&eventID, nil, nil)
+			eventMgr.On("NewEvent", testUserID, mock.MatchedBy(func(e Event) bool { return e.ActivityID == "running-id" })).Return(nil, nil, errors.New("write failed"))
+
+			csvFile := strings.Join(strings.Split(testCSV, "\n")[0:6], "\n")
+			report, err := CSVImportManager.ImportCSV(testUserID, strings.NewReader(csvFile), testCSVSpec(), false)
+
+			Convey("Then the error is returned with the items that were created and the failing row", func() {
+				So(err, ShouldNotBeNil)
+				So(len(report.NewExerciseTypes), ShouldEqual, 2)
+				So(len(report.Events), ShouldEqual, 1)
+				So(report.Events[0].ID, ShouldEqual, eventID)
+				So(report.Failure.Row, ShouldEqual, 6)
+				So(report.Failure.Message, ShouldContainSubstring, "write failed")
+			})
+		})
+
+		Convey("When the rows of an event cannot make a valid event", func() {
+			csvFile := "Date,Workout,Exercise Name,Weight,Reps,RPE,Distance,Seconds\n2024-01-05,Lifting,Pull Up,,8,,,\n1970-01-01,Lifting,Pull Up,,8,,,\n"
+			_, err := CSVImportManager.ImportCSV(testUserID, strings.NewReader(csvFile), testCSVSpec(), false)
+
+			Convey("Then nothing is written", func() {
+				So(err, ShouldHaveSameTypeAs, ErrInvalidCSV{})
+				So(err.Error(), ShouldContainSubstring, "row 3")
+				eMgr.AssertNotCalled(t, "NewExerciseType", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+		})
+
+		Convey("When the column mapping references a missing column", func() {
+			spec := testCSVSpec()
+			spec.Columns.Time = "Duration"
+
+			_, err := CSVImportManager.ImportCSV(testUserID, strings.NewReader(testCSV), spec, true)
+
+			So(err, ShouldHaveSameTypeAs, ErrInvalidCSV{})
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package workoutlog
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -20,13 +21,17 @@ var ErrInvalidEvent = fmt.Errorf("invalid event")
 
 // The EventAdmin type defines routines for interacting with workout events in the database.
 type EventAdmin interface {
-	NewEvent(userID string, event Event) (*string, error)
+	NewEvent(userID string, event Event) (*string, []PersonalRecord, error)
 	GetPageOfEvents(userID string, previousEvent Event, pageSize int) ([]Event, error)
 	GetCachedExerciseType(exerciseTypeID string) *ExerciseType
 	GetEventExercises(userID, eventID string) (map[int]ExerciseInstance, error)
-	UpdateEvent(userID string, currentDate int64, event Event) error
+	UpdateEvent(userID string, currentDate int64, event Event) ([]PersonalRecord, error)
 	GetPageOfInstances(userID string, filter ExerciseFilter, pageSize int) ([]int64, [][]ExerciseInstance, error)
 	DeleteEvent(userID string, event Event) error
+	ExportSets(userID, activityID string, filter ExerciseFilter, emit func(ExportedSet) error) error
+	GetOneRMSeries(userID, exerciseID string, formula OneRMFormula, filter ExerciseFilter) ([]int64, []float32, error)
+	GetAnalytics(userID string, query AnalyticsQuery) (*Analytics, error)
+	GetTrainingLoad(userID string, startDate, endDate int64, loc *time.Location) (*TrainingLoad, error)
 }
 
 type eventManager struct{}
@@ -42,13 +47,26 @@ type Event struct {
 
 // An EventMeta stores metadata for an event.
 // A zero value represents nil (value was not recorded).
+// Overall is a performance rating from 1 to 5, RPE is the session rating of perceived exertion from 1 to 10,
+// and Duration is in minutes.
 type EventMeta struct {
-	Overall int    `json:"overall"`
-	Notes   string `json:"notes"`
+	Overall  int    `json:"overall"`
+	RPE      int    `json:"rpe,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	Notes    string `json:"notes"`
 }
 
+// maxRPE is the highest RPE of a session or a set.
+const maxRPE = 10
+
 var exerciseTypeCache sync.Map = sync.Map{}
 
+// ClearExerciseTypeCache removes the cached exercise types.
+// Call it when the database is replaced.
+func ClearExerciseTypeCache() {
+	exerciseTypeCache.Clear()
+}
+
 func (em eventManager) GetCachedExerciseType(exerciseTypeID string) *ExerciseType {
 	cachedType, ok := exerciseTypeCache.Load(exerciseTypeID)
 	if !ok {
@@ -62,82 +80,128 @@ func (em eventManager) GetCachedExerciseType(exerciseTypeID string) *ExerciseTyp
 // NewEvent adds a new event to the database
 // The event data consists of everything but the exercises which are added subsequently in separate calls.
 // If exercises are included in the event they are ignored.
-// A pointer to the generated event id is returned.
-func (em eventManager) NewEvent(userID string, event Event) (*string, error) {
-	if userID == "" || event.ActivityID == "" || event.Date == 0 {
-		return nil, ErrInvalidEvent
+// A pointer to the generated event id is returned with the personal records that the event set.
+// Failing to update personal records does not prevent the event from being added.
+func (em eventManager) NewEvent(userID string, event Event) (*string, []PersonalRecord, error) {
+	if userID == "" || event.ActivityID == "" || event.Date == 0 || event.RPE < 0 || event.RPE > maxRPE {
+		return nil, nil, ErrInvalidEvent
 	}
 
 	event.ID = uuid.New().String()
 
 	eventJson, err := json.Marshal(event)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create event: %w", err)
+		return nil, nil, fmt.Errorf("failed to create event: %w", err)
 	}
 
 	// prepare exercise instances
 	typeIDs, exercisesJSON, err := prepEventExercises(userID, event.ActivityID, event.Exercises)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create event: %w", err)
+		return nil, nil, fmt.Errorf("failed to create event: %w", err)
 	}
 
 	err = dal.DB.AddEvent(userID, event.ID, event.ActivityID, event.Date, eventJson, typeIDs, exercisesJSON)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add event: %w", err)
+		return nil, nil, fmt.Errorf("failed to add event: %w", err)
 	}
 
-	return &event.ID, nil
+	records, err := recordEvent(userID, event, nil)
+	if err != nil {
+		slog.Error("failed to update personal records", "user", userID, "event", event.ID, "error", err.Error())
+		records = []PersonalRecord{}
+	}
+
+	return &event.ID, records, nil
 }
 
 // UpdateEvent replaces a stored event.
-func (em eventManager) UpdateEvent(userID string, currentDate int64, event Event) error {
-	if userID == "" || event.ID == "" || event.ActivityID == "" || event.Date == 0 {
-		return ErrInvalidEvent
+// Returns the personal records that the updated event set.
+// Failing to update personal records does not prevent the event from being updated.
+func (em eventManager) UpdateEvent(userID string, currentDate int64, event Event) ([]PersonalRecord, error) {
+	if userID == "" || event.ID == "" || event.ActivityID == "" || event.Date == 0 || event.RPE < 0 || event.RPE > maxRPE {
+		return nil, ErrInvalidEvent
 	}
 
 	// Make sure the event exists
 	existing, err := dal.DB.GetEvent(userID, event.ID, currentDate)
 	if err != nil {
-		return fmt.Errorf("failed to update event: %w", err)
+		return nil, fmt.Errorf("failed to update event: %w", err)
 	}
 	if existing == nil {
-		return ErrNotFound
+		return nil, ErrNotFound
 	}
 
 	eventJson, err := json.Marshal(event)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// prepare exercise instances
 	typeIDs, exercisesJSON, err := prepEventExercises(userID, event.ActivityID, event.Exercises)
 	if err != nil {
-		return fmt.Errorf("failed to update event: %w", err)
+		return nil, fmt.Errorf("failed to update event: %w", err)
+	}
+
+	// records set by the stored version of the event are removed by rebuilding the histories of its exercise types
+	previousTypes, err := em.eventExerciseTypes(userID, event.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", err)
 	}
 
 	err = dal.DB.UpdateEvent(userID, event.ID, event.ActivityID, currentDate, event.Date, eventJson, typeIDs, exercisesJSON)
 	if err != nil {
-		return fmt.Errorf("failed to update event: %w", err)
+		return nil, fmt.Errorf("failed to update event: %w", err)
 	}
 
-	return nil
+	records, err := recordEvent(userID, event, previousTypes)
+	if err != nil {
+		slog.Error("failed to update personal records", "user", userID, "event", event.ID, "error", err.Error())
+		records = []PersonalRecord{}
+	}
+
+	return records, nil
 }
 
 // DeleteEvent removes an event from the database.
 // The event must already exist in the database.
+// The personal records that the event set are removed.
 func (em eventManager) DeleteEvent(userID string, event Event) error {
 	if userID == "" || event.ID == "" || event.ActivityID == "" || event.Date == 0 {
 		return ErrInvalidEvent
 	}
 
+	previousTypes, err := em.eventExerciseTypes(userID, event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+
 	if err := dal.DB.DeleteEvent(userID, event.ID, event.ActivityID, event.Date); err != nil {
 		slog.Error("failed to delete event.", "error", err.Error())
 		return fmt.Errorf("failed to delete event: %w", err)
 	}
 
+	if _, err := recordEvent(userID, Event{ID: event.ID, Date: event.Date}, previousTypes); err != nil {
+		slog.Error("failed to update personal records", "user", userID, "event", event.ID, "error", err.Error())
+	}
+
 	return nil
 }
 
+// eventExerciseTypes returns the IDs of the exercise types of the stored exercise instances of an event.
+func (em eventManager) eventExerciseTypes(userID, eventID string) ([]string, error) {
+	instances, err := em.GetEventExercises(userID, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	typeIDs := []string{}
+	for _, inst := range instances {
+		typeIDs = append(typeIDs, inst.TypeID)
+	}
+
+	return typeIDs, nil
+}
+
 func prepEventExercises(userID, activityID string, exerciseInstances map[int]ExerciseInstance) (map[int]string, map[int][]byte, error) {
 	exInstances := map[int][]byte{}
 	exTypeIDs := map[int]string{}
@@ -153,7 +217,7 @@ func prepEventExercises(userID, activityID string, exerciseInstances map[int]Exe
 			return nil, nil, err
 		}
 
-		err = exerciseType.validateInstance(&inst)
+		err = exerciseType.ValidateInstance(&inst)
 		if err != nil {
 			return nil, nil, ErrInvalidEvent
 		}
@@ -189,6 +253,12 @@ func (em eventManager) GetPageOfEvents(userID string, previousEvent Event, pageS
 		return nil, fmt.Errorf("failed to get events: %w", err)
 	}
 
+	return em.readEvents(userID, eventsByte)
+}
+
+// readEvents unmarshals stored events and gets their exercise instances.
+func (em eventManager) readEvents(userID string, eventsByte [][]byte) ([]Event, error) {
+	var err error
 	events := []Event{}
 
 	for _, v := range eventsByte {
@@ -233,74 +303,148 @@ func (em eventManager) GetEventExercises(userID, eventID string) (map[int]Exerci
 	return instances, nil
 }
 
-// GetPageOfInstances gets a maximum of 3000 event instances
-// The pageSize is slightly exceeded when the instances added from an event causes the total count to surpass the page limit.
-// The returned map uses a date as the key, and the value is an array of instances that were performed on that date.
-// The StartDate field of filter indicates the earliest date of the event to include in the page. Set to 0 to get the first page.
+// GetPageOfInstances gets a page of exercise instances that were performed within a date range.
+// The default page size is 3000 instances. The page size is exceeded when needed to include all instances of the last event of the page.
+// The returned slices are parallel: each date is that of an event and the instances are those that were performed in the event.
+// The StartDate field of filter indicates the most recent date to include. Set to 0 to start at the most recent event.
+// When the ExerciseTypes field of filter is empty, instances of all exercise types are included.
+// Composite instances are expanded into instances of their components unless the filter names the composite exercise type.
 func (em eventManager) GetPageOfInstances(userID string, filter ExerciseFilter, pageSize int) ([]int64, [][]ExerciseInstance, error) {
-
-	return getPageOfExercises(em, userID, filter, pageSize)
-}
-
-func getPageOfExercises(eventManager EventAdmin, userID string, filter ExerciseFilter, pageSize int) ([]int64, [][]ExerciseInstance, error) {
 	if pageSize == 0 {
 		pageSize = 3000 // exercise instances
 	}
 
-	dateStack := []int64{}
-	instancesStack := [][]ExerciseInstance{}
+	exerciseTypes, err := ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get exercise types: %w", err)
+	}
 
-	instanceCount := 0
-	startEvent := Event{}
+	types := map[string]ExerciseType{}
+	for _, t := range exerciseTypes {
+		types[t.ID] = t
+	}
 
-	if filter.StartDate == 0 {
-		filter.StartDate = time.Now().Unix()
+	typeIDs := filter.readTypes(types)
+
+	// the instances of each exercise type are read in pages of at most chunkSize instances and merged in event order
+	// so that no more than about pageSize instances are read in total, plus one page for each exercise type
+	chunkSize := min(pageSize, DefaultPageSize)
+	cursors := []*instanceCursor{}
+	for _, typeID := range typeIDs {
+		cursors = append(cursors, &instanceCursor{typeID: typeID, startDate: filter.StartDate})
 	}
-	startEvent.Date = filter.StartDate
 
-	for instanceCount < pageSize+1 {
-		// get 100 events starting at the start date
-		events, err := eventManager.GetPageOfEvents(userID, startEvent, DefaultPageSize)
-		if err != nil {
-			slog.Error("failed to get exercises", "user", userID, "error", err.Error())
-			return nil, nil, err
+	dateStack := []int64{}
+	instancesStack := [][]ExerciseInstance{}
+	eventIDStack := []string{}
+	instanceCount := 0
+
+	for {
+		var next *instanceCursor
+		for _, c := range cursors {
+			ok, err := c.fill(userID, filter.EndDate, chunkSize)
+			if err != nil {
+				slog.Error("failed to get exercises", "user", userID, "error", err.Error())
+				return nil, nil, err
+			}
+			if ok && (next == nil || c.after(next)) {
+				next = c
+			}
+		}
+		if next == nil {
+			break
 		}
 
-		if len(events) == 0 {
+		date, eventID, stored := next.pop()
+		last := len(eventIDStack) - 1
+		newEvent := last < 0 || eventIDStack[last] != eventID
+
+		// each page of instances ends at an event boundary
+		if newEvent && instanceCount >= pageSize {
 			break
-		} else {
-			// prepare for next page of events
-			startEvent = events[len(events)-1]
 		}
 
-		// get the exercise instances for each event
-		for _, evt := range events {
-			if evt.Date <= filter.StartDate && evt.Date >= filter.EndDate {
-				// TODO: limit the number of exercises in an event
-				eventInstances, err := EventManager.GetEventExercises(userID, evt.ID)
-				if err != nil {
-					slog.Error("failed to get exercise instances", "user", userID, "error", err.Error())
-					return nil, nil, err
-				}
-				// add them to the map if the pass through the filter
-				instances := []ExerciseInstance{}
-				for _, i := range eventInstances {
-					if len(filter.ExerciseTypes) == 0 || slices.Index(filter.ExerciseTypes, i.TypeID) >= 0 {
-						instances = append(instances, i)
-						instanceCount++
-					}
-				}
-				if len(instances) > 0 {
-					dateStack = append(dateStack, evt.Date)
-					instancesStack = append(instancesStack, instances)
-				}
-			}
+		instance := ExerciseInstance{}
+		if err := json.Unmarshal(stored, &instance); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal stored exercise instance: %w", err)
+		}
 
+		attributed := filter.attribute(instance, types)
+		if len(attributed) == 0 {
+			continue
 		}
+
+		if newEvent {
+			dateStack = append(dateStack, date)
+			eventIDStack = append(eventIDStack, eventID)
+			instancesStack = append(instancesStack, []ExerciseInstance{})
+			last++
+		}
+		instancesStack[last] = append(instancesStack[last], attributed...)
+		instanceCount += len(attributed)
 	}
+
+	for _, instances := range instancesStack {
+		slices.SortFunc(instances, func(a, b ExerciseInstance) int {
+			return cmp.Compare(a.Index, b.Index)
+		})
+	}
+
 	return dateStack, instancesStack, nil
 }
 
+// An instanceCursor reads the indexed instances of an exercise type page by page, most recent first.
+type instanceCursor struct {
+	typeID          string
+	startDate       int64
+	previousEventID string
+	dates           []int64
+	eventIDs        []string
+	stored          [][]byte
+	done            bool
+}
+
+// fill reads the next page of instances when the current page is used up.
+// Returns false when there are no more instances.
+func (c *instanceCursor) fill(userID string, endDate int64, pageSize int) (bool, error) {
+	if len(c.stored) > 0 {
+		return true, nil
+	}
+	if c.done {
+		return false, nil
+	}
+
+	dates, eventIDs, stored, err := dal.DB.GetExerciseInstancePage(userID, c.typeID, c.previousEventID, c.startDate, endDate, pageSize)
+	if err != nil {
+		return false, err
+	}
+
+	c.dates, c.eventIDs, c.stored = dates, eventIDs, stored
+	c.done = len(stored) < pageSize
+	if len(stored) > 0 {
+		c.startDate = dates[len(dates)-1]
+		c.previousEventID = eventIDs[len(eventIDs)-1]
+	}
+
+	return len(stored) > 0, nil
+}
+
+// after returns true when the next instance of c was performed after the next instance of other.
+func (c *instanceCursor) after(other *instanceCursor) bool {
+	if c.dates[0] != other.dates[0] {
+		return c.dates[0] > other.dates[0]
+	}
+	return c.eventIDs[0] > other.eventIDs[0]
+}
+
+// pop removes and returns the next instance.
+func (c *instanceCursor) pop() (int64, string, []byte) {
+	date, eventID, stored := c.dates[0], c.eventIDs[0], c.stored[0]
+	c.dates, c.eventIDs, c.stored = c.dates[1:], c.eventIDs[1:], c.stored[1:]
+
+	return date, eventID, stored
+}
+
 func checkActivityForExerciseType(userID, activityID, exerciseTypeID string) error {
 	_, exerciseIDs, err := dal.DB.ReadActivity(userID, activityID)
 	if err != nil {
@@ -340,20 +484,28 @@ func (e *MockEventAdmin) GetCachedExerciseType(exerciseTypeID string) *ExerciseT
 
 }
 
-func (e *MockEventAdmin) NewEvent(userID string, event Event) (*string, error) {
+func (e *MockEventAdmin) NewEvent(userID string, event Event) (*string, []PersonalRecord, error) {
 	args := e.Called(userID, event)
 
-	if args.Error(1) != nil {
-		return nil, args.Error(1)
+	if args.Error(2) != nil {
+		return nil, nil, args.Error(2)
 	}
 
-	return args.Get(0).(*string), nil
+	records, _ := args.Get(1).([]PersonalRecord)
+
+	return args.Get(0).(*string), records, nil
 }
 
-func (e *MockEventAdmin) UpdateEvent(userID string, currentDate int64, event Event) error {
+func (e *MockEventAdmin) UpdateEvent(userID string, currentDate int64, event Event) ([]PersonalRecord, error) {
 	args := e.Called(userID, currentDate, event)
 
-	return args.Error(0)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	records, _ := args.Get(0).([]PersonalRecord)
+
+	return records, nil
 }
 
 func (e *MockEventAdmin) GetEventExercises(userID, eventID string) (map[int]ExerciseInstance, error) {
@@ -392,3 +544,47 @@ func (e *MockEventAdmin) DeleteEvent(userID string, event Event) error {
 
 	return args.Error(0)
 }
+
+func (e *MockEventAdmin) ExportSets(userID, activityID string, filter ExerciseFilter, emit func(ExportedSet) error) error {
+	args := e.Called(userID, activityID, filter, emit)
+
+	if sets, ok := args.Get(0).([]ExportedSet); ok {
+		for _, set := range sets {
+			if err := emit(set); err != nil {
+				return err
+			}
+		}
+	}
+
+	return args.Error(1)
+}
+
+func (e *MockEventAdmin) GetOneRMSeries(userID, exerciseID string, formula OneRMFormula, filter ExerciseFilter) ([]int64, []float32, error) {
+	args := e.Called(userID, exerciseID, formula, filter)
+
+	if args.Error(2) != nil {
+		return nil, nil, args.Error(2)
+	}
+
+	return args.Get(0).([]int64), args.Get(1).([]float32), nil
+}
+
+func (e *MockEventAdmin) GetAnalytics(userID string, query AnalyticsQuery) (*Analytics, error) {
+	args := e.Called(userID, query)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*Analytics), nil
+}
+
+func (e *MockEventAdmin) GetTrainingLoad(userID string, startDate, endDate int64, loc *time.Location) (*TrainingLoad, error) {
+	args := e.Called(userID, startDate, endDate, loc)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*TrainingLoad), nil
+}
@@ -2,6 +2,8 @@ package workoutlog
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand"
 	"testing"
 	"time"
@@ -84,19 +86,29 @@ func TestEvents(t *testing.T) {
 			db.On("AddEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 			newEvent := newTestEvent()
-			eventID, err := EventManager.NewEvent(testUserID, newEvent)
+			eventID, _, err := EventManager.NewEvent(testUserID, newEvent)
 
 			So(err, ShouldBeNil)
 			So(eventID, ShouldNotBeEmpty)
 		})
 
+		Convey("when we create an event with a session RPE that is out of range", func() {
+			newEvent := newTestEvent()
+			newEvent.RPE = 11
+			_, _, err := EventManager.NewEvent(testUserID, newEvent)
+
+			So(errors.Is(err, ErrInvalidEvent), ShouldBeTrue)
+			db.AssertNotCalled(t, "AddEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+
 		Convey("when we update an event", func() {
 			db.On("GetEvent", mock.Anything, mock.Anything, mock.Anything).Return([]byte("test event"), nil)
 			db.On("UpdateEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			db.On("GetEventExercises", testUserID, testEventID).Return([][]byte{}, nil)
 
 			newEvent := newTestEvent()
 			newEvent.ID = testEventID
-			err := EventManager.UpdateEvent(testUserID, testDate, newEvent)
+			_, err := EventManager.UpdateEvent(testUserID, testDate, newEvent)
 
 			So(err, ShouldBeNil)
 		})
@@ -138,142 +150,186 @@ func TestEvents(t *testing.T) {
 		Convey("When we delete an event", func() {
 			db.On("DeleteEvent", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
+			exerciseBytes, err := json.Marshal(testExerciseInstance)
+			if err != nil {
+				t.Fatal()
+			}
+			db.On("GetEventExercises", testUserID, "testID").Return([][]byte{exerciseBytes}, nil)
+			eMgr.On("GetExerciseType", testUserID, exerciseType.ID).Return(&exerciseType, nil)
+			eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{exerciseType}, nil)
+			db.On("GetRecords", testUserID, exerciseType.ID).Return([]byte(`[{"kind":"weight","reps":1,"value":5,"eventID":"testID"}]`), nil)
+			db.On("GetExerciseInstancePage", testUserID, exerciseType.ID, "", int64(0), int64(0), DefaultPageSize).Return([]int64{}, []string{}, [][]byte{}, nil)
+			db.On("SetRecords", testUserID, exerciseType.ID, mock.Anything).Return(nil)
+
 			testEvent := newTestEvent()
 			testEvent.ID = "testID"
 			testEvent.Exercises = map[int]ExerciseInstance{0: testExerciseInstance}
-			err := EventManager.DeleteEvent(testUserID, testEvent)
+			err = EventManager.DeleteEvent(testUserID, testEvent)
 
 			So(err, ShouldBeNil)
+
+			Convey("Then the records that the event set are removed", func() {
+				db.AssertCalled(t, "SetRecords", testUserID, exerciseType.ID, []byte("[]"))
+			})
 		})
 	})
 
-	Convey("Given an event manager", t, func() {
-		mockEM := NewMockEventAdmin()
+	Convey("Given a dal client with indexed exercise instances", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+
 		numEvents := 4
 		testEvents = []Event{}
-		for i := 0; i < numEvents; i++ {
+		for i := numEvents - 1; i >= 0; i-- {
 			testEvents = append(testEvents, testEventMaker(testDate+int64(i)))
 		}
 
-		testEventExercises := testEvents[0].Exercises
+		// mocks a page of instances of an exercise type, one instance per event, most recent first
+		mockInstancePage := func(typeID string, index int) {
+			dates := []int64{}
+			eventIDs := []string{}
+			stored := [][]byte{}
+			for i, evt := range testEvents {
+				instance, err := json.Marshal(ExerciseInstance{TypeID: typeID, Index: index})
+				if err != nil {
+					t.Fatal()
+				}
+				dates = append(dates, evt.Date)
+				eventIDs = append(eventIDs, fmt.Sprintf("event%d", numEvents-i))
+				stored = append(stored, instance)
+			}
+			db.On("GetExerciseInstancePage", testUserID, typeID, "", mock.Anything, mock.Anything, mock.Anything).Return(dates, eventIDs, stored, nil)
+		}
+		mockInstancePage(exerciseType1.ID, 1)
+		mockInstancePage(exerciseType2.ID, 2)
+		mockInstancePage(exerciseType3.ID, 3)
+		eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{exerciseType1, exerciseType2, exerciseType3}, nil)
 
 		Convey("When we get a page of exercise instances, unfiltered, and the page is not full", func() {
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return(testEvents, nil).Once()
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{StartDate: time.Now().Unix()}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 0)
+			dates, instances, err := EventManager.GetPageOfInstances(testUserID, ExerciseFilter{}, 0)
 
 			So(err, ShouldBeNil)
 			So(len(dates), ShouldEqual, numEvents)
 			So(len(instances), ShouldEqual, numEvents)
-			for _, inst := range instances {
+			for i, inst := range instances {
+				So(dates[i], ShouldEqual, testEvents[i].Date)
 				So(len(inst), ShouldEqual, 3)
+				So(inst[0].TypeID, ShouldEqual, exerciseType1.ID)
+				So(inst[2].TypeID, ShouldEqual, exerciseType3.ID)
 			}
 		})
 
 		Convey("When we get a full page of exercise instances, unfiltered", func() {
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return(testEvents, nil).Once()
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{StartDate: time.Now().Unix()}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 4)
-
-			So(err, ShouldBeNil)
-			So(len(dates), ShouldEqual, 4)
-			So(len(instances), ShouldEqual, 4)
-			for _, inst := range instances {
-				So(len(inst), ShouldEqual, 3)
-			}
-		})
-
-		Convey("When we get instances from multiple pages of events, unfiltered", func() {
-			// force event page size of 1
-			for i := 0; i < numEvents; i++ {
-				mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{testEvents[i]}, nil).Once()
-			}
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{StartDate: time.Now().Unix()}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 0)
+			dates, instances, err := EventManager.GetPageOfInstances(testUserID, ExerciseFilter{}, 4)
 
 			So(err, ShouldBeNil)
-			So(len(dates), ShouldEqual, numEvents)
-			So(len(instances), ShouldEqual, numEvents)
+			So(len(dates), ShouldEqual, 2)
+			So(len(instances), ShouldEqual, 2)
+			So(dates[0], ShouldBeGreaterThan, dates[1])
 			for _, inst := range instances {
 				So(len(inst), ShouldEqual, 3)
 			}
 		})
 
 		Convey("When we filter to return a single exercise type", func() {
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return(testEvents, nil).Once()
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{StartDate: time.Now().Unix(), ExerciseTypes: []string{"id1"}}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 0)
+			filter := ExerciseFilter{StartDate: time.Now().Unix(), ExerciseTypes: []string{exerciseType1.ID}}
+			dates, instances, err := EventManager.GetPageOfInstances(testUserID, filter, 0)
 
 			So(err, ShouldBeNil)
 			So(len(dates), ShouldEqual, numEvents)
 			So(len(instances), ShouldEqual, numEvents)
 			for _, inst := range instances {
 				So(len(inst), ShouldEqual, 1)
-				So(inst[0].TypeID, ShouldEqual, "id1")
+				So(inst[0].TypeID, ShouldEqual, exerciseType1.ID)
 			}
+			db.AssertNotCalled(t, "GetExerciseInstancePage", testUserID, exerciseType2.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 		})
 
 		Convey("When we filter on multiple exercise types", func() {
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return(testEvents, nil).Once()
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{StartDate: time.Now().Unix(), ExerciseTypes: []string{"id1"}}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 0)
+			filter := ExerciseFilter{ExerciseTypes: []string{exerciseType1.ID, exerciseType3.ID}}
+			dates, instances, err := EventManager.GetPageOfInstances(testUserID, filter, 0)
 
 			So(err, ShouldBeNil)
 			So(len(dates), ShouldEqual, numEvents)
-			So(len(instances), ShouldEqual, numEvents)
 			for _, inst := range instances {
-				So(len(inst), ShouldEqual, 1)
-				So(inst[0].TypeID, ShouldEqual, "id1")
+				So(len(inst), ShouldEqual, 2)
+				So(inst[0].TypeID, ShouldEqual, exerciseType1.ID)
+				So(inst[1].TypeID, ShouldEqual, exerciseType3.ID)
 			}
 		})
 
-		Convey("When we set the end date", func() {
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return(testEvents, nil).Once()
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{EndDate: testEvents[1].Date}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 0)
+		Convey("When we set the start and end dates", func() {
+			filter := ExerciseFilter{StartDate: testEvents[1].Date, EndDate: testEvents[2].Date, ExerciseTypes: []string{exerciseType1.ID}}
+			_, _, err := EventManager.GetPageOfInstances(testUserID, filter, 0)
 
 			So(err, ShouldBeNil)
-			So(len(dates), ShouldEqual, 3)
-			So(len(instances), ShouldEqual, 3)
-			for _, inst := range instances {
-				So(len(inst), ShouldEqual, 3)
-			}
+			db.AssertCalled(t, "GetExerciseInstancePage", testUserID, exerciseType1.ID, "", testEvents[1].Date, testEvents[2].Date, DefaultPageSize)
 		})
 
-		Convey("When we set a start date", func() {
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return(testEvents, nil).Once()
-			mockEM.On("GetPageOfEvents", mock.Anything, mock.Anything, mock.Anything).Return([]Event{}, nil).Once()
-			mockEM.On("GetEventExercises", mock.Anything, mock.Anything).Return(testEventExercises, nil).Times(len(testEvents))
-			EventManager = mockEM
-			filter := ExerciseFilter{StartDate: testEvents[1].Date}
-			dates, instances, err := getPageOfExercises(EventManager, testUserID, filter, 0)
-
-			So(err, ShouldBeNil)
-			So(len(dates), ShouldEqual, 2)
-			So(len(instances), ShouldEqual, 2)
-			for _, inst := range instances {
-				So(len(inst), ShouldEqual, 3)
+		Convey("When the instances of an exercise type span more than one read", func() {
+			db := dal.NewMockDal()
+			dal.DB = db
+			marshal := func(typeID string) []byte {
+				instance, err := json.Marshal(ExerciseInstance{TypeID: typeID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return instance
 			}
+			dates := []int64{testEvents[0].Date, testEvents[1].Date, testEvents[2].Date}
+			db.On("GetExerciseInstancePage", testUserID, exerciseType1.ID, "", int64(0), int64(0), 2).Return(dates[:2], []string{"event3", "event2"}, [][]byte{marshal(exerciseType1.ID), marshal(exerciseType1.ID)}, nil)
+			db.On("GetExerciseInstancePage", testUserID, exerciseType1.ID, "event2", dates[1], int64(0), 2).Return(dates[2:], []string{"event1"}, [][]byte{marshal(exerciseType1.ID)}, nil)
+			db.On("GetExerciseInstancePage", testUserID, exerciseType2.ID, "", int64(0), int64(0), 2).Return(dates[1:2], []string{"event2"}, [][]byte{marshal(exerciseType2.ID)}, nil)
+
+			filter := ExerciseFilter{ExerciseTypes: []string{exerciseType1.ID, exerciseType2.ID}}
+			dates, instances, err := EventManager.GetPageOfInstances(testUserID, filter, 2)
+
+			Convey("Then the instances are merged in event order and each type is read in pages", func() {
+				So(err, ShouldBeNil)
+				So(dates, ShouldResemble, []int64{testEvents[0].Date, testEvents[1].Date})
+				So(len(instances), ShouldEqual, 2)
+				So(len(instances[0]), ShouldEqual, 1)
+				So(len(instances[1]), ShouldEqual, 2)
+				db.AssertNumberOfCalls(t, "GetExerciseInstancePage", 3)
+			})
+		})
 
+		Convey("When we filter on a component of a composite exercise type", func() {
+			composite := ExerciseType{ID: "id4", VolumeType: "count", Composition: map[string]int{exerciseType1.ID: 1, exerciseType2.ID: 2}}
+			mockInstancePage(composite.ID, 4)
+
+			eMgr := NewMockExerciseManager()
+			ExerciseManager = eMgr
+			eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{exerciseType1, exerciseType2, exerciseType3, composite}, nil)
+
+			filter := ExerciseFilter{ExerciseTypes: []string{exerciseType2.ID}}
+			dates, instances, err := EventManager.GetPageOfInstances(testUserID, filter, 0)
+
+			Convey("Then the composite instances are attributed to the component", func() {
+				So(err, ShouldBeNil)
+				So(len(dates), ShouldEqual, numEvents)
+				for _, inst := range instances {
+					So(len(inst), ShouldEqual, 2)
+					So(inst[0].TypeID, ShouldEqual, exerciseType2.ID)
+					So(inst[1].TypeID, ShouldEqual, exerciseType2.ID)
+					So(inst[1].Index, ShouldEqual, 4)
+				}
+				db.AssertNotCalled(t, "GetExerciseInstancePage", testUserID, exerciseType1.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+
+			Convey("Then the composite instances are not expanded when the filter names the composite", func() {
+				filter.ExerciseTypes = []string{composite.ID}
+				_, instances, err := EventManager.GetPageOfInstances(testUserID, filter, 0)
+
+				So(err, ShouldBeNil)
+				for _, inst := range instances {
+					So(len(inst), ShouldEqual, 1)
+					So(inst[0].TypeID, ShouldEqual, composite.ID)
+				}
+			})
 		})
 	})
 }
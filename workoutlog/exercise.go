@@ -2,16 +2,20 @@ package workoutlog
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/scottbrodersen/homegym/dal"
+	"github.com/scottbrodersen/homegym/programs"
 
 	"github.com/stretchr/testify/mock"
 )
 
 var ErrNameNotUnique = fmt.Errorf("name is not unique")
+var ErrExerciseTypeInUse = errors.New("exercise type is in use")
 
 // ExerciseManager provides an implementation of ExerciseAdmin.
 var ExerciseManager ExerciseAdmin = new(exerciseManager)
@@ -22,10 +26,13 @@ type ExerciseAdmin interface {
 	UpdateExerciseType(userID, exerciseID, name, intensity, volume string, volConstraint int, composition map[string]int, basis string) error
 	GetExerciseTypes(userID string) ([]ExerciseType, error)
 	GetExerciseType(userID, exerciseID string) (*ExerciseType, error)
+	DeleteExerciseType(userID, exerciseID string, archive bool) (bool, error)
 	SetPR(userID, exerciseID string, value int) error
 	GetPR(userID, exerciseID string) (int, error)
 	Set1RM(userID, exerciseID string, value int) error
 	Get1RM(userID, exerciseID string) (int, error)
+	Current1RM(userID, exerciseID string, formula OneRMFormula) (float32, bool, error)
+	GetRecords(userID, exerciseID string) ([]PersonalRecord, error)
 }
 
 // An ExerciseFilter stores criteria for retrieving exercise types from the database.
@@ -33,6 +40,7 @@ type ExerciseFilter struct {
 	StartDate     int64
 	EndDate       int64
 	ExerciseTypes []string
+	Variations    bool // include the variations of ExerciseTypes
 }
 
 // An exerciseManager implements the ExerciseAdmin type.
@@ -54,7 +62,7 @@ func (ea *exerciseManager) NewExerciseType(userID, name, intensity, volume strin
 		Basis:            basis,
 	}
 
-	if err := newType.validate(); err != nil {
+	if err := newType.Validate(); err != nil {
 		return nil, fmt.Errorf("cannot add exercise type: %w", err)
 	}
 
@@ -97,7 +105,7 @@ func (ea *exerciseManager) UpdateExerciseType(userID, exerciseID, name, intensit
 		Basis:            basis,
 	}
 
-	if err := updated.validate(); err != nil {
+	if err := updated.Validate(); err != nil {
 		return fmt.Errorf("invalid exercise type: %w", err)
 	}
 
@@ -137,6 +145,8 @@ func (ea *exerciseManager) UpdateExerciseType(userID, exerciseID, name, intensit
 		return fmt.Errorf("problem with exercise type: %w", err)
 	}
 
+	updated.Archived = eType.Archived
+
 	eTypeJSON, err := json.Marshal(updated)
 	if err != nil {
 		return fmt.Errorf("failed to marshal exercise type: %w", err)
@@ -190,6 +200,59 @@ func (ea *exerciseManager) GetExerciseType(userID, exerciseID string) (*Exercise
 	return exerciseType, nil
 }
 
+// DeleteExerciseType removes an exercise type and its 1RM, PR, and record history from the database.
+// When the type is referenced by logged exercises, activities, other exercise types, or programs
+// it is archived instead if archive is true. Otherwise an ErrExerciseTypeInUse error is returned.
+// Returns true when the type was archived instead of deleted.
+func (ea *exerciseManager) DeleteExerciseType(userID, exerciseID string, archive bool) (bool, error) {
+	exerciseByte, err := dal.DB.GetExercise(userID, exerciseID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete exercise type: %w", err)
+	}
+
+	if exerciseByte == nil {
+		return false, ErrNotFound
+	}
+
+	references, err := exerciseTypeReferences(*ea, userID, exerciseID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check exercise type references: %w", err)
+	}
+
+	// remove from cache so it doesn't become stale
+	exerciseTypeCache.Delete(exerciseID)
+
+	if len(references) > 0 {
+		if !archive {
+			return false, fmt.Errorf("%w: referenced by %s", ErrExerciseTypeInUse, strings.Join(references, ", "))
+		}
+
+		eType := new(ExerciseType)
+		if err := json.Unmarshal(exerciseByte, eType); err != nil {
+			return false, fmt.Errorf("failed to unmarshal exercise type: %w", err)
+		}
+
+		eType.Archived = true
+
+		eTypeJSON, err := json.Marshal(eType)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal exercise type: %w", err)
+		}
+
+		if err := dal.DB.UpdateExercise(userID, exerciseID, eTypeJSON); err != nil {
+			return false, fmt.Errorf("failed to archive exercise type: %w", err)
+		}
+
+		return true, nil
+	}
+
+	if err := dal.DB.DeleteExercise(userID, exerciseID); err != nil {
+		return false, fmt.Errorf("failed to delete exercise type: %w", err)
+	}
+
+	return false, nil
+}
+
 func (ea *exerciseManager) SetPR(userID, exerciseID string, value int) error {
 	err := dal.DB.AddPR(userID, exerciseID, value)
 	if err != nil {
@@ -225,6 +288,44 @@ func (ea *exerciseManager) Get1RM(userID, exerciseID string) (int, error) {
 
 }
 
+// GetRecords returns the personal record history of an exercise type in chronological order.
+// The history is derived from the logged instances of the exercise type when it is not stored.
+func (ea *exerciseManager) GetRecords(userID, exerciseID string) ([]PersonalRecord, error) {
+	history, err := readRecords(userID, exerciseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	if history != nil {
+		return history, nil
+	}
+
+	exerciseByte, err := dal.DB.GetExercise(userID, exerciseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	if exerciseByte == nil {
+		return nil, ErrNotFound
+	}
+
+	eType := ExerciseType{}
+	if err := json.Unmarshal(exerciseByte, &eType); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal exercise type: %w", err)
+	}
+
+	if !eType.hasRecords() {
+		return []PersonalRecord{}, nil
+	}
+
+	history, err = rebuildRecords(userID, eType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	return history, nil
+}
+
 func isTypeNameAvailable(ea exerciseManager, userID, name string) (bool, error) {
 	types, err := ea.GetExerciseTypes(userID)
 	if err != nil {
@@ -277,6 +378,122 @@ func checkDependencies(em exerciseManager, userID string, eType ExerciseType) er
 	return nil
 }
 
+// exerciseTypeReferences returns a description of each item that references an exercise type.
+// Returns an empty slice when the exercise type is not referenced.
+func exerciseTypeReferences(em exerciseManager, userID, exerciseID string) ([]string, error) {
+	references := []string{}
+
+	hasInstances, err := dal.DB.HasExerciseInstances(userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasInstances {
+		references = append(references, "logged events")
+	}
+
+	exerciseTypes, err := em.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range exerciseTypes {
+		if _, ok := e.Composition[exerciseID]; ok {
+			references = append(references, fmt.Sprintf("composite %s", e.Name))
+		}
+		if e.Basis == exerciseID {
+			references = append(references, fmt.Sprintf("variation %s", e.Name))
+		}
+	}
+
+	activities, err := dal.DB.GetActivityNames(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for activityID, activityName := range activities {
+		_, exerciseIDs, err := dal.DB.ReadActivity(userID, activityID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range exerciseIDs {
+			if id == exerciseID {
+				references = append(references, fmt.Sprintf("activity %s", activityName))
+				break
+			}
+		}
+
+		programRefs, err := programReferences(userID, activityID, exerciseID)
+		if err != nil {
+			return nil, err
+		}
+
+		references = append(references, programRefs...)
+	}
+
+	return references, nil
+}
+
+// programReferences returns the titles of the programs and program instances of an activity that include an exercise type.
+func programReferences(userID, activityID, exerciseID string) ([]string, error) {
+	references := []string{}
+	pageSize := 100
+	previousProgramID := ""
+
+	for {
+		page, err := programs.ProgramManager.GetProgramsPageForActivity(userID, activityID, previousProgramID, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range page {
+			if p.UsesExerciseType(exerciseID) {
+				references = append(references, fmt.Sprintf("program %s", p.Title))
+			}
+
+			instanceRefs, err := programInstanceReferences(userID, p.ID, exerciseID)
+			if err != nil {
+				return nil, err
+			}
+			references = append(references, instanceRefs...)
+		}
+
+		if len(page) < pageSize {
+			break
+		}
+		previousProgramID = page[len(page)-1].ID
+	}
+
+	return references, nil
+}
+
+func programInstanceReferences(userID, programID, exerciseID string) ([]string, error) {
+	references := []string{}
+	pageSize := 100
+	previousInstanceID := ""
+
+	for {
+		page, err := programs.ProgramManager.GetProgramInstancesPage(userID, programID, previousInstanceID, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pi := range page {
+			if pi.UsesExerciseType(exerciseID) {
+				references = append(references, fmt.Sprintf("program instance %s", pi.Title))
+			}
+		}
+
+		if len(page) < pageSize {
+			break
+		}
+		previousInstanceID = page[len(page)-1].ID
+	}
+
+	return references, nil
+}
+
 func NewMockExerciseManager() *mockExerciseManager {
 	return new(mockExerciseManager)
 }
@@ -321,6 +538,12 @@ func (m *mockExerciseManager) GetExerciseType(userID, exerciseID string) (*Exerc
 	return args.Get(0).(*ExerciseType), nil
 }
 
+func (m *mockExerciseManager) DeleteExerciseType(userID, exerciseID string, archive bool) (bool, error) {
+	args := m.Called(userID, exerciseID, archive)
+
+	return args.Bool(0), args.Error(1)
+}
+
 func (m *mockExerciseManager) SetPR(userID, exerciseID string, value int) error {
 	args := m.Called(userID, exerciseID, value)
 	return args.Error(0)
@@ -348,3 +571,23 @@ func (m *mockExerciseManager) Get1RM(userID, exerciseID string) (int, error) {
 
 	return args.Int(0), nil
 }
+
+func (m *mockExerciseManager) GetRecords(userID, exerciseID string) ([]PersonalRecord, error) {
+	args := m.Called(userID, exerciseID)
+
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]PersonalRecord), nil
+}
+
+func (m *mockExerciseManager) Current1RM(userID, exerciseID string, formula OneRMFormula) (float32, bool, error) {
+	args := m.Called(userID, exerciseID, formula)
+
+	if args.Error(2) != nil {
+		return 0, false, args.Error(2)
+	}
+
+	return args.Get(0).(float32), args.Bool(1), nil
+}
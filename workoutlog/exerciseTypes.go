@@ -18,6 +18,7 @@ func (ec ErrInvalidExercise) Error() string {
 // An ExerciseType defines an exercise and is a factory for ExerciseInstance structs.
 // Translates instances between the user interface and the db
 // Composition indicates that an exercise is composed of other exercises. Limited to Count types.
+// Archived indicates that the type is retained only because existing items still reference it.
 type ExerciseType struct {
 	Name             string         `json:"name"`
 	ID               string         `json:"id"`
@@ -26,6 +27,7 @@ type ExerciseType struct {
 	VolumeConstraint int            `json:"volumeConstraint"` // for ui, not generally useful for aerobic activities
 	Composition      map[string]int `json:"composition"`      // key is exercise ID, value is number of reps
 	Basis            string         `json:"basis"`            // id of exercise of which this is a variation
+	Archived         bool           `json:"archived,omitempty"`
 }
 
 // ExerciseInstance stores data about the performance of an exercise type.
@@ -41,9 +43,11 @@ type ExerciseInstance struct {
 // The volume type and constraint of the exercise type dictates the shape of the values in the inner array.
 // Time and distance types store a single float32 in the inner array.
 // Count types store one or more values of 1 or 0 in the array, depending on the volume constraint
+// RPE is the optional rating of perceived exertion of the sets, from 1 to 10, which is used to estimate 1RMs.
 type ExerciseSegment struct {
 	Intensity float32     `json:"intensity"`
 	Volume    [][]float32 `json:"volume"`
+	RPE       float32     `json:"rpe,omitempty"`
 }
 
 // volumeConstraints indicates the type of values that can be expressed for volumes.
@@ -65,14 +69,14 @@ func (e ExerciseType) CreateInstance() ExerciseInstance {
 	}
 }
 
-// validateInstance ensures intensity and volume values are valid for the exercise type
+// ValidateInstance ensures intensity and volume values are valid for the exercise type
 // It also massages data for some types:
 //   - scale-based intensity values are stripped of decimals
 //   - distance and weight intensity values are truncated to single decimals
 //   - bodyweight intensity values are set to 1
 //   - non-rep-based volume values are truncated to single decimals
 //   - time-based intensities are stripped of decimals
-func (et ExerciseType) validateInstance(ei *ExerciseInstance) error {
+func (et ExerciseType) ValidateInstance(ei *ExerciseInstance) error {
 	if ei.Index < 0 {
 		return fmt.Errorf("index must be > 0")
 	}
@@ -107,6 +111,10 @@ func (et ExerciseType) validateInstance(ei *ExerciseInstance) error {
 			ei.Segments[i].Intensity = float32(math.Floor(float64(segment.Intensity)))
 		}
 
+		if segment.RPE != 0 && (segment.RPE < 1 || segment.RPE > maxRPE) {
+			return ErrInvalidExercise{Message: "set RPE must be between 1 and 10"}
+		}
+
 		// Validate volume values
 		for j, set := range segment.Volume {
 
@@ -135,8 +143,8 @@ func (et ExerciseType) validateInstance(ei *ExerciseInstance) error {
 	return nil
 }
 
-// validate ensures the exercise type is correctly defined.
-func (e ExerciseType) validate() error {
+// Validate ensures the exercise type is correctly defined.
+func (e ExerciseType) Validate() error {
 	if e.Name == "" {
 		return ErrInvalidExercise{Message: "name cannot be empty"}
 	}
@@ -203,6 +211,7 @@ func (e ExerciseType) validate() error {
 }
 
 // CalculateMetrics returns the load and volume that was performed for an exercise instance.
+// The volume of a composite includes the reps of all of its components.
 func (et ExerciseType) CalculateMetrics(ei *ExerciseInstance) (load, volume, maxIntensity float32) {
 	load = 0
 	volume = 0
@@ -220,6 +229,11 @@ func (et ExerciseType) CalculateMetrics(ei *ExerciseInstance) (load, volume, max
 		volumeFactor = float32(1) / 1000
 	}
 
+	// each rep of a composite is the reps of each of its components
+	if et.VolumeType == "count" {
+		volumeFactor = et.compositeReps()
+	}
+
 	// for weight and count, volume is the total reps and load is total weight * reps
 	// for distance, volume is number of km
 	// for time, volume is number of hours
@@ -237,3 +251,55 @@ func (et ExerciseType) CalculateMetrics(ei *ExerciseInstance) (load, volume, max
 	}
 	return
 }
+
+// BestEfforts returns the best performance of each kind of personal record for an exercise instance.
+// For weight intensities with count volumes the efforts are the heaviest weight for each number of reps and the highest 1RM estimated with the Epley formula.
+// For distance volumes the effort is the longest distance of a set in m.
+// For pace intensities the effort is the fastest pace in s/km.
+// The returned records are not dated.
+func (et ExerciseType) BestEfforts(ei *ExerciseInstance) []PersonalRecord {
+	efforts := []PersonalRecord{}
+
+	keep := func(r PersonalRecord) {
+		r.ExerciseID = et.ID
+		efforts = append(efforts, r)
+	}
+
+	for _, segment := range ei.Segments {
+		if segment.Intensity <= 0 {
+			continue
+		}
+
+		for _, set := range segment.Volume {
+			amount := float32(0)
+			for _, v := range set {
+				if et.VolumeType == "count" {
+					amount += v
+				} else if v > amount {
+					amount = v
+				}
+			}
+			if amount <= 0 {
+				continue
+			}
+
+			if et.IntensityType == "weight" && et.VolumeType == "count" {
+				reps := int(amount)
+				keep(PersonalRecord{Kind: RecordWeight, Reps: reps, Value: segment.Intensity})
+				if oneRM := Epley.Estimate(segment.Intensity, reps, 0); oneRM > 0 {
+					keep(PersonalRecord{Kind: RecordOneRM, Value: oneRM})
+				}
+			}
+
+			if et.VolumeType == "distance" {
+				keep(PersonalRecord{Kind: RecordDistance, Value: amount})
+			}
+
+			if et.IntensityType == "pace" {
+				keep(PersonalRecord{Kind: RecordPace, Value: segment.Intensity})
+			}
+		}
+	}
+
+	return mergeEfforts(efforts)
+}
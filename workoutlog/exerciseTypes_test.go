@@ -182,7 +182,7 @@ func TestExerciseTypes(t *testing.T) {
 
 		Convey("Then the validation is as expected", func() {
 			for _, v := range tests {
-				So(v.Etype.validate() == nil, ShouldEqual, v.Valid)
+				So(v.Etype.Validate() == nil, ShouldEqual, v.Valid)
 			}
 		})
 	})
@@ -199,7 +199,7 @@ func TestExerciseTypes(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err = exType.validateInstance(&exIncoming)
+			err = exType.ValidateInstance(&exIncoming)
 
 			So(err, ShouldBeNil)
 		})
@@ -211,7 +211,7 @@ func TestExerciseTypes(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err := exType.validateInstance(&exIncoming)
+			err := exType.ValidateInstance(&exIncoming)
 
 			So(err, ShouldNotBeNil)
 
@@ -224,11 +224,19 @@ func TestExerciseTypes(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err := exType.validateInstance(&exIncoming)
+			err := exType.ValidateInstance(&exIncoming)
 
 			So(err, ShouldNotBeNil)
 
 		})
+
+		Convey("When we ingest an exercise instance with a set RPE", func() {
+			exIncoming := ExerciseInstance{Segments: []ExerciseSegment{{Intensity: 5, Volume: [][]float32{{1, 1}}, RPE: 8.5}}}
+			So(exType.ValidateInstance(&exIncoming), ShouldBeNil)
+
+			exIncoming.Segments[0].RPE = 11
+			So(exType.ValidateInstance(&exIncoming), ShouldNotBeNil)
+		})
 	})
 
 }
@@ -98,6 +98,71 @@ func TestExercises(t *testing.T) {
 			})
 		})
 
+		Convey("When we delete an exercise type that is not referenced", func() {
+			exerciseJson, _ := json.Marshal(testExerciseType())
+			db.On("GetExercise", mock.Anything, testExerciseID).Return(exerciseJson, nil)
+			db.On("HasExerciseInstances", mock.Anything, testExerciseID).Return(false, nil)
+			db.On("GetExercises", mock.Anything).Return([][]byte{exerciseJson}, nil)
+			db.On("GetActivityNames", mock.Anything).Return(map[string]string{testActivityID: testActivityName}, nil)
+			db.On("ReadActivity", mock.Anything, testActivityID).Return(&testActivityName, []string{}, nil)
+			db.On("GetProgramPage", mock.Anything, testActivityID, mock.Anything, mock.Anything).Return([][]byte{}, nil)
+			db.On("DeleteExercise", mock.Anything, testExerciseID).Return(nil)
+
+			archived, err := ExerciseManager.DeleteExerciseType(testUserID, testExerciseID, false)
+
+			Convey("Then the exercise type is deleted", func() {
+				So(err, ShouldBeNil)
+				So(archived, ShouldBeFalse)
+				db.AssertCalled(t, "DeleteExercise", testUserID, testExerciseID)
+			})
+		})
+
+		Convey("When we delete an exercise type that an activity references", func() {
+			exerciseJson, _ := json.Marshal(testExerciseType())
+			db.On("GetExercise", mock.Anything, testExerciseID).Return(exerciseJson, nil)
+			db.On("HasExerciseInstances", mock.Anything, testExerciseID).Return(false, nil)
+			db.On("GetExercises", mock.Anything).Return([][]byte{exerciseJson}, nil)
+			db.On("GetActivityNames", mock.Anything).Return(map[string]string{testActivityID: testActivityName}, nil)
+			db.On("ReadActivity", mock.Anything, testActivityID).Return(&testActivityName, []string{testExerciseID}, nil)
+			db.On("GetProgramPage", mock.Anything, testActivityID, mock.Anything, mock.Anything).Return([][]byte{}, nil)
+			db.On("UpdateExercise", mock.Anything, testExerciseID, mock.Anything).Return(nil)
+
+			Convey("Then an error is returned when we do not archive", func() {
+				archived, err := ExerciseManager.DeleteExerciseType(testUserID, testExerciseID, false)
+
+				So(errors.Is(err, ErrExerciseTypeInUse), ShouldBeTrue)
+				So(archived, ShouldBeFalse)
+				db.AssertNotCalled(t, "DeleteExercise", mock.Anything, mock.Anything)
+			})
+
+			Convey("Then the exercise type is archived when we archive", func() {
+				archived, err := ExerciseManager.DeleteExerciseType(testUserID, testExerciseID, true)
+
+				So(err, ShouldBeNil)
+				So(archived, ShouldBeTrue)
+				db.AssertNotCalled(t, "DeleteExercise", mock.Anything, mock.Anything)
+
+				stored := db.Calls[len(db.Calls)-1].Arguments.Get(2).([]byte)
+				eType := ExerciseType{}
+				So(json.Unmarshal(stored, &eType), ShouldBeNil)
+				So(eType.Archived, ShouldBeTrue)
+			})
+		})
+
+		Convey("When we delete an exercise type that is logged in an event", func() {
+			exerciseJson, _ := json.Marshal(testExerciseType())
+			db.On("GetExercise", mock.Anything, testExerciseID).Return(exerciseJson, nil)
+			db.On("HasExerciseInstances", mock.Anything, testExerciseID).Return(true, nil)
+			db.On("GetExercises", mock.Anything).Return([][]byte{exerciseJson}, nil)
+			db.On("GetActivityNames", mock.Anything).Return(map[string]string{}, nil)
+
+			_, err := ExerciseManager.DeleteExerciseType(testUserID, testExerciseID, false)
+
+			Convey("Then an error is returned", func() {
+				So(errors.Is(err, ErrExerciseTypeInUse), ShouldBeTrue)
+			})
+		})
+
 		Convey("When we set a PR", func() {
 			db.On("AddPR", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
@@ -0,0 +1,150 @@
+package workoutlog
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// An ExportedSet is a set of an exercise instance that is flattened for export.
+// Volume is the sum of the values of the set, such as the number of successful reps or the distance.
+type ExportedSet struct {
+	Date          int64   `json:"date"`
+	Activity      string  `json:"activity"`
+	Exercise      string  `json:"exercise"`
+	Intensity     float32 `json:"intensity"`
+	IntensityType string  `json:"intensityType"`
+	Volume        float32 `json:"volume"`
+	VolumeType    string  `json:"volumeType"`
+}
+
+// ExportSets calls emit for each set of the events that match the filter, starting with the most recent event.
+// Events are read a page at a time so that all events are not held in memory.
+// Set activityID to an empty string to include all activities.
+// When the ExerciseTypes field of filter is empty, sets of all exercise types are included.
+// Exporting stops at the first error that emit returns.
+func (em eventManager) ExportSets(userID, activityID string, filter ExerciseFilter, emit func(ExportedSet) error) error {
+	activities, err := ActivityManager.GetActivityNames(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get activities: %w", err)
+	}
+
+	activityNames := map[string]string{}
+	for _, a := range activities {
+		activityNames[a.ID] = a.Name
+	}
+
+	exerciseTypes, err := ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get exercise types: %w", err)
+	}
+
+	types := map[string]ExerciseType{}
+	for _, t := range exerciseTypes {
+		types[t.ID] = t
+	}
+
+	return em.forEachEvent(userID, activityID, filter, func(event Event) error {
+		for _, index := range slices.Sorted(maps.Keys(event.Exercises)) {
+			instance := event.Exercises[index]
+			if len(filter.ExerciseTypes) > 0 && !slices.Contains(filter.ExerciseTypes, instance.TypeID) {
+				continue
+			}
+
+			exerciseType := types[instance.TypeID]
+			for _, segment := range instance.Segments {
+				for _, set := range segment.Volume {
+					volume := float32(0)
+					for _, v := range set {
+						volume += v
+					}
+
+					err := emit(ExportedSet{
+						Date:          event.Date,
+						Activity:      activityNames[event.ActivityID],
+						Exercise:      exerciseType.Name,
+						Intensity:     segment.Intensity,
+						IntensityType: exerciseType.IntensityType,
+						Volume:        volume,
+						VolumeType:    exerciseType.VolumeType,
+					})
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// forEachEvent calls fn with each event within the date range of filter, starting with the most recent event.
+// Events are read a page at a time so that all events are not held in memory.
+// When activityID is not empty, only the events of the activity are read.
+// The ExerciseTypes field of filter is ignored. Iteration stops at the first error that fn returns.
+func (em eventManager) forEachEvent(userID, activityID string, filter ExerciseFilter, fn func(Event) error) error {
+	if activityID != "" {
+		return em.forEachActivityEvent(userID, activityID, filter, fn)
+	}
+
+	// events are read in reverse order, so start after the most recent date to include
+	previousEvent := Event{}
+	if filter.StartDate != 0 {
+		previousEvent.Date = filter.StartDate + 1
+	}
+
+	for {
+		events, err := em.GetPageOfEvents(userID, previousEvent, DefaultPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if event.Date < filter.EndDate {
+				return nil
+			}
+
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < DefaultPageSize {
+			return nil
+		}
+		previousEvent = events[len(events)-1]
+	}
+}
+
+// forEachActivityEvent calls fn with each event of an activity within the date range of filter,
+// starting with the most recent event.
+// Events are read a page at a time from the activity index.
+func (em eventManager) forEachActivityEvent(userID, activityID string, filter ExerciseFilter, fn func(Event) error) error {
+	previousEvent := Event{Date: filter.StartDate}
+
+	for {
+		eventsByte, err := dal.DB.GetActivityEventPage(userID, activityID, previousEvent.ID, previousEvent.Date, filter.EndDate, DefaultPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to get events: %w", err)
+		}
+
+		events, err := em.readEvents(userID, eventsByte)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < DefaultPageSize {
+			return nil
+		}
+		previousEvent = events[len(events)-1]
+	}
+}
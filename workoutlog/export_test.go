@@ -0,0 +1,122 @@
+package workoutlog
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/scottbrodersen/homegym/dal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExportSets(t *testing.T) {
+	squat := ExerciseType{ID: "squat-id", Name: "squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+	run := ExerciseType{ID: "run-id", Name: "run", IntensityType: "rpe", VolumeType: "distance"}
+
+	Convey("Given a user with events", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+
+		db.On("GetActivityNames", testUserID).Return(map[string]string{"lifting-id": "lifting", "running-id": "running"}, nil)
+		eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{squat, run}, nil)
+
+		events := []Event{
+			{ID: "event3", ActivityID: "running-id", Date: 3000},
+			{ID: "event2", ActivityID: "lifting-id", Date: 2000},
+			{ID: "event1", ActivityID: "lifting-id", Date: 1000},
+		}
+		eventsByte := [][]byte{}
+		for _, e := range events {
+			eventByte, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			eventsByte = append(eventsByte, eventByte)
+		}
+
+		instance := func(et ExerciseType, index int, segments ...ExerciseSegment) []byte {
+			instanceByte, err := json.Marshal(ExerciseInstance{TypeID: et.ID, Index: index, Segments: segments})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return instanceByte
+		}
+
+		db.On("GetEventPage", testUserID, "", int64(0), DefaultPageSize).Return(eventsByte, nil)
+		db.On("GetEventExercises", testUserID, "event3").Return([][]byte{
+			instance(run, 0, ExerciseSegment{Intensity: 6, Volume: [][]float32{{5000}}}),
+		}, nil)
+		db.On("GetEventExercises", testUserID, "event2").Return([][]byte{
+			instance(squat, 0, ExerciseSegment{Intensity: 100, Volume: [][]float32{{1, 1, 1}, {1, 1, 0}}}, ExerciseSegment{Intensity: 110, Volume: [][]float32{{1}}}),
+			instance(run, 1, ExerciseSegment{Intensity: 3, Volume: [][]float32{{400}}}),
+		}, nil)
+		db.On("GetEventExercises", testUserID, "event1").Return([][]byte{
+			instance(squat, 0, ExerciseSegment{Intensity: 90, Volume: [][]float32{{1, 1, 1, 1, 1}}}),
+		}, nil)
+
+		exported := []ExportedSet{}
+		collect := func(set ExportedSet) error {
+			exported = append(exported, set)
+			return nil
+		}
+
+		Convey("When we export all sets", func() {
+			err := EventManager.ExportSets(testUserID, "", ExerciseFilter{}, collect)
+
+			Convey("Then there is one row for each set, most recent first", func() {
+				So(err, ShouldBeNil)
+				So(len(exported), ShouldEqual, 6)
+				So(exported[0], ShouldResemble, ExportedSet{Date: 3000, Activity: "running", Exercise: "run", Intensity: 6, IntensityType: "rpe", Volume: 5000, VolumeType: "distance"})
+				So(exported[1], ShouldResemble, ExportedSet{Date: 2000, Activity: "lifting", Exercise: "squat", Intensity: 100, IntensityType: "weight", Volume: 3, VolumeType: "count"})
+				So(exported[2].Volume, ShouldEqual, 2)
+				So(exported[3].Intensity, ShouldEqual, 110)
+				So(exported[4].Exercise, ShouldEqual, "run")
+				So(exported[5].Date, ShouldEqual, 1000)
+			})
+		})
+
+		Convey("When we export the sets of an activity and exercise type", func() {
+			db.On("GetActivityEventPage", testUserID, "lifting-id", "", int64(0), int64(0), DefaultPageSize).Return(eventsByte[1:], nil)
+
+			err := EventManager.ExportSets(testUserID, "lifting-id", ExerciseFilter{ExerciseTypes: []string{squat.ID}}, collect)
+
+			So(err, ShouldBeNil)
+			So(len(exported), ShouldEqual, 4)
+			for _, set := range exported {
+				So(set.Exercise, ShouldEqual, "squat")
+			}
+
+			Convey("Then the events are read from the activity index", func() {
+				db.AssertNotCalled(t, "GetEventPage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+		})
+
+		Convey("When we export the sets of a date range", func() {
+			db.On("GetEventPage", testUserID, "", int64(2001), DefaultPageSize).Return(eventsByte[1:], nil)
+
+			err := EventManager.ExportSets(testUserID, "", ExerciseFilter{StartDate: 2000, EndDate: 1500}, collect)
+
+			So(err, ShouldBeNil)
+			So(len(exported), ShouldEqual, 4)
+			for _, set := range exported {
+				So(set.Date, ShouldEqual, 2000)
+			}
+		})
+
+		Convey("When emitting a set fails", func() {
+			emitErr := errors.New("connection closed")
+			emitted := 0
+			err := EventManager.ExportSets(testUserID, "", ExerciseFilter{}, func(set ExportedSet) error {
+				emitted++
+				return emitErr
+			})
+
+			So(err, ShouldEqual, emitErr)
+			So(emitted, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,281 @@
+package workoutlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// A OneRMFormula estimates the 1RM of a lift from the weight and the number of reps.
+type OneRMFormula string
+
+const (
+	// weight * (1 + reps/30)
+	Epley OneRMFormula = "epley"
+	// weight * 36 / (37 - reps)
+	Brzycki OneRMFormula = "brzycki"
+	// weight * reps^0.1
+	Lombardi OneRMFormula = "lombardi"
+	// weight / the percentage of 1RM for the reps and RPE in Tuchscherer's RPE chart
+	RPETable OneRMFormula = "rpe"
+)
+
+// MaxOneRMReps is the most reps of a set that are used to estimate a 1RM.
+// Estimates from longer sets are unreliable.
+const MaxOneRMReps = 10
+
+// OneRMWindow is the time in seconds before the most recent estimated 1RM within which estimates are used to determine the current 1RM.
+const OneRMWindow int64 = 12 * 7 * 24 * 60 * 60
+
+// rpePercentages are the percentages of 1RM of the RPE chart.
+// Each half point of RPE below 10 is equivalent to one more rep so the chart is stored as a single sequence
+// that is indexed by 2*reps + 2*(10-RPE) - 2.
+var rpePercentages = []float64{
+	100, 97.8, 95.5, 93.9, 92.2, 90.7, 89.2, 87.8, 86.3, 85.0,
+	83.7, 82.4, 81.1, 79.9, 78.6, 77.4, 76.2, 75.1, 73.9, 72.3,
+	70.7, 69.4, 68.0, 66.7, 65.3, 64.0,
+}
+
+// lowestRPE is the lowest RPE of the RPE chart.
+const lowestRPE = 6.5
+
+// ParseOneRMFormula returns the formula with the given name.
+// The default formula is Epley.
+func ParseOneRMFormula(name string) (OneRMFormula, error) {
+	if name == "" {
+		return Epley, nil
+	}
+
+	switch formula := OneRMFormula(name); formula {
+	case Epley, Brzycki, Lombardi, RPETable:
+		return formula, nil
+	}
+
+	return "", fmt.Errorf("unknown 1RM formula %q", name)
+}
+
+// Estimate returns the estimated 1RM of a weight that was lifted for a number of reps, rounded to one decimal.
+// The RPE is used only by the RPETable formula and is rounded to the nearest half point. An RPE of 0 is interpreted as 10.
+// Returns 0 when reps is not between 1 and MaxOneRMReps, or when the RPE is not in the RPE chart.
+func (f OneRMFormula) Estimate(weight float32, reps int, rpe float32) float32 {
+	if weight <= 0 || reps < 1 || reps > MaxOneRMReps {
+		return 0
+	}
+
+	w := float64(weight)
+	r := float64(reps)
+	var oneRM float64
+
+	switch f {
+	case Epley:
+		oneRM = w
+		if reps > 1 {
+			oneRM = w * (1 + r/30)
+		}
+	case Brzycki:
+		oneRM = w * 36 / (37 - r)
+	case Lombardi:
+		oneRM = w * math.Pow(r, 0.1)
+	case RPETable:
+		if rpe == 0 {
+			rpe = 10
+		}
+		halfPoints := math.Round(float64(rpe) * 2)
+		if halfPoints > 20 || halfPoints < lowestRPE*2 {
+			return 0
+		}
+		oneRM = w * 100 / rpePercentages[2*reps+int(20-halfPoints)-2]
+	default:
+		return 0
+	}
+
+	return float32(math.Round(oneRM*10) / 10)
+}
+
+// A SetOneRM is the estimated 1RM of a set.
+type SetOneRM struct {
+	Weight float32 `json:"weight"`
+	Reps   int     `json:"reps"`
+	OneRM  float32 `json:"oneRM"`
+}
+
+// estimatesOneRM returns true when a 1RM can be estimated for an exercise type.
+func (et ExerciseType) estimatesOneRM() bool {
+	return et.IntensityType == "weight" && et.VolumeType == "count"
+}
+
+// EstimateOneRM returns the estimated 1RM of each set of an exercise instance and the highest of the estimates.
+// Reps are the successful reps of a set. Sets that a 1RM cannot be estimated from are not included.
+// The RPETable formula uses the RPE of each segment, and assumes that sets without an RPE were taken to RPE 10.
+// Returns no sets for exercise types that do not use weight intensities and count volumes.
+func (et ExerciseType) EstimateOneRM(ei *ExerciseInstance, formula OneRMFormula) ([]SetOneRM, float32) {
+	sets := []SetOneRM{}
+	best := float32(0)
+
+	if !et.estimatesOneRM() {
+		return sets, best
+	}
+
+	for _, segment := range ei.Segments {
+		for _, set := range segment.Volume {
+			reps := float32(0)
+			for _, rep := range set {
+				reps += rep
+			}
+
+			oneRM := formula.Estimate(segment.Intensity, int(reps), segment.RPE)
+			if oneRM == 0 {
+				continue
+			}
+
+			sets = append(sets, SetOneRM{Weight: segment.Intensity, Reps: int(reps), OneRM: oneRM})
+			best = max(best, oneRM)
+		}
+	}
+
+	return sets, best
+}
+
+// ResolvePercentOfMax returns the weight of a percentOfMax intensity, rounded to one decimal.
+func ResolvePercentOfMax(percent, oneRM float32) float32 {
+	return float32(math.Round(float64(percent*oneRM)/10) / 10)
+}
+
+// forEachInstance calls fn with the instances of an exercise type that were performed within a date range, and the date and event ID of each.
+// Instances are visited in reverse chronological order. Iteration stops when fn returns false.
+// The startDate is the latest date of the range and endDate is the earliest. Set startDate to 0 to start at the most recent instance.
+func forEachInstance(userID, exerciseID string, startDate, endDate int64, fn func(date int64, eventID string, instance ExerciseInstance) bool) error {
+	previousEventID := ""
+
+	for {
+		dates, eventIDs, stored, err := dal.DB.GetExerciseInstancePage(userID, exerciseID, previousEventID, startDate, endDate, DefaultPageSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range stored {
+			instance := ExerciseInstance{}
+			if err := json.Unmarshal(stored[i], &instance); err != nil {
+				return fmt.Errorf("failed to unmarshal stored exercise instance: %w", err)
+			}
+
+			if !fn(dates[i], eventIDs[i], instance) {
+				return nil
+			}
+		}
+
+		if len(stored) < DefaultPageSize {
+			return nil
+		}
+		previousEventID = eventIDs[len(eventIDs)-1]
+		startDate = dates[len(dates)-1]
+	}
+}
+
+// GetOneRMSeries returns the highest estimated 1RM of each event in which an exercise type was performed within a date range.
+// The returned slices are parallel and in chronological order.
+// Events without sets that a 1RM can be estimated from are not included.
+func (em eventManager) GetOneRMSeries(userID, exerciseID string, formula OneRMFormula, filter ExerciseFilter) ([]int64, []float32, error) {
+	exerciseType, err := ExerciseManager.GetExerciseType(userID, exerciseID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get exercise type: %w", err)
+	}
+
+	if !exerciseType.estimatesOneRM() {
+		return nil, nil, ErrInvalidExercise{Message: fmt.Sprintf("1RM cannot be estimated for %s", exerciseType.Name)}
+	}
+
+	dates := []int64{}
+	eventIDs := []string{}
+	oneRMs := []float32{}
+
+	err = forEachInstance(userID, exerciseID, filter.StartDate, filter.EndDate, func(date int64, eventID string, instance ExerciseInstance) bool {
+		_, oneRM := exerciseType.EstimateOneRM(&instance, formula)
+		if oneRM == 0 {
+			return true
+		}
+
+		// an exercise type can be performed more than once in an event
+		if n := len(eventIDs); n > 0 && eventIDs[n-1] == eventID {
+			oneRMs[n-1] = max(oneRMs[n-1], oneRM)
+			return true
+		}
+
+		dates = append(dates, date)
+		eventIDs = append(eventIDs, eventID)
+		oneRMs = append(oneRMs, oneRM)
+
+		return true
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read exercise instances: %w", err)
+	}
+
+	slices.Reverse(dates)
+	slices.Reverse(oneRMs)
+
+	return dates, oneRMs, nil
+}
+
+// Current1RM returns the 1RM of an exercise type that percentOfMax intensities are relative to.
+// The manually entered 1RM is returned when there is one.
+// Otherwise the highest estimate within OneRMWindow of the most recent estimate is returned and estimated is true.
+// Returns 0 when there is neither, and ErrNotFound when the exercise type does not exist.
+func (ea *exerciseManager) Current1RM(userID, exerciseID string, formula OneRMFormula) (oneRM float32, estimated bool, err error) {
+	if EventManager.GetCachedExerciseType(exerciseID) == nil {
+		stored, err := dal.DB.GetExercise(userID, exerciseID)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to get exercise type: %w", err)
+		}
+		if stored == nil {
+			return 0, false, ErrNotFound
+		}
+	}
+
+	manual, err := ea.Get1RM(userID, exerciseID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if manual > 0 {
+		return float32(manual), false, nil
+	}
+
+	exerciseType, err := ea.GetExerciseType(userID, exerciseID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get exercise type: %w", err)
+	}
+
+	if !exerciseType.estimatesOneRM() {
+		return 0, false, nil
+	}
+
+	latest := int64(0)
+
+	err = forEachInstance(userID, exerciseID, 0, 0, func(date int64, eventID string, instance ExerciseInstance) bool {
+		_, estimate := exerciseType.EstimateOneRM(&instance, formula)
+		if estimate == 0 {
+			return true
+		}
+
+		if latest == 0 {
+			latest = date
+		}
+
+		if date < latest-OneRMWindow {
+			return false
+		}
+
+		oneRM = max(oneRM, estimate)
+
+		return true
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read exercise instances: %w", err)
+	}
+
+	return oneRM, oneRM > 0, nil
+}
@@ -0,0 +1,184 @@
+package workoutlog
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+func TestOneRM(t *testing.T) {
+	squat := ExerciseType{ID: "squat-id", Name: "squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+	run := ExerciseType{ID: "run-id", Name: "run", IntensityType: "pace", VolumeType: "distance"}
+
+	Convey("When we estimate a 1RM with each formula", t, func() {
+		So(Epley.Estimate(100, 5, 0), ShouldEqual, float32(116.7))
+		So(Epley.Estimate(100, 1, 0), ShouldEqual, float32(100))
+		So(Brzycki.Estimate(100, 5, 0), ShouldEqual, float32(112.5))
+		So(Lombardi.Estimate(100, 5, 0), ShouldEqual, float32(117.5))
+		So(RPETable.Estimate(100, 5, 0), ShouldEqual, float32(115.9))
+		So(RPETable.Estimate(100, 5, 8), ShouldEqual, float32(123.3))
+		So(RPETable.Estimate(100, 1, 10), ShouldEqual, float32(100))
+	})
+
+	Convey("When we estimate a 1RM that is out of range", t, func() {
+		So(Epley.Estimate(100, 0, 0), ShouldEqual, 0)
+		So(Epley.Estimate(100, MaxOneRMReps+1, 0), ShouldEqual, 0)
+		So(Epley.Estimate(0, 5, 0), ShouldEqual, 0)
+		So(RPETable.Estimate(100, 5, 6), ShouldEqual, 0)
+		So(OneRMFormula("guess").Estimate(100, 5, 0), ShouldEqual, 0)
+	})
+
+	Convey("When we parse formula names", t, func() {
+		formula, err := ParseOneRMFormula("")
+		So(err, ShouldBeNil)
+		So(formula, ShouldEqual, Epley)
+
+		formula, err = ParseOneRMFormula("brzycki")
+		So(err, ShouldBeNil)
+		So(formula, ShouldEqual, Brzycki)
+
+		_, err = ParseOneRMFormula("guess")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When we resolve a percentage of a 1RM", t, func() {
+		So(ResolvePercentOfMax(80, 142.5), ShouldEqual, float32(114))
+	})
+
+	Convey("Given an instance of a weight and count exercise type", t, func() {
+		instance := ExerciseInstance{
+			TypeID: squat.ID,
+			Segments: []ExerciseSegment{
+				{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}, {1, 1, 1, 0, 0}}},
+				{Intensity: 60, Volume: [][]float32{{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}},
+			},
+		}
+
+		Convey("When we estimate the 1RM of each set", func() {
+			sets, best := squat.EstimateOneRM(&instance, Epley)
+
+			Convey("Then sets with too many reps are ignored", func() {
+				So(sets, ShouldResemble, []SetOneRM{
+					{Weight: 100, Reps: 5, OneRM: 116.7},
+					{Weight: 100, Reps: 3, OneRM: 110},
+				})
+				So(best, ShouldEqual, float32(116.7))
+			})
+		})
+
+		Convey("When we estimate the 1RM of sets with an RPE", func() {
+			instance.Segments[0].RPE = 8
+			sets, best := squat.EstimateOneRM(&instance, RPETable)
+
+			Convey("Then the RPE of the segment is used", func() {
+				So(sets, ShouldResemble, []SetOneRM{
+					{Weight: 100, Reps: 5, OneRM: 123.3},
+					{Weight: 100, Reps: 3, OneRM: 115.9},
+				})
+				So(best, ShouldEqual, float32(123.3))
+			})
+		})
+
+		Convey("When we estimate the 1RM for an exercise type without weight and count", func() {
+			sets, best := run.EstimateOneRM(&instance, Epley)
+
+			So(sets, ShouldBeEmpty)
+			So(best, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a dal client and an exercise manager", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+		eMgr.On("GetExerciseType", testUserID, squat.ID).Return(&squat, nil)
+		eMgr.On("GetExerciseType", testUserID, run.ID).Return(&run, nil)
+
+		instanceJSON := func(segments ...ExerciseSegment) []byte {
+			instance, err := json.Marshal(ExerciseInstance{TypeID: squat.ID, Segments: segments})
+			if err != nil {
+				t.Fatal()
+			}
+			return instance
+		}
+
+		Convey("When we get the 1RM series of an exercise type", func() {
+			db.On("GetExerciseInstancePage", testUserID, squat.ID, "", int64(0), int64(0), DefaultPageSize).Return(
+				[]int64{3000, 3000, 2000, 1000},
+				[]string{"event-3", "event-3", "event-2", "event-1"},
+				[][]byte{
+					instanceJSON(ExerciseSegment{Intensity: 110, Volume: [][]float32{{1}}}),
+					instanceJSON(ExerciseSegment{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}}}),
+					instanceJSON(ExerciseSegment{Intensity: 40, Volume: [][]float32{{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}}),
+					instanceJSON(ExerciseSegment{Intensity: 100, Volume: [][]float32{{1, 1, 1}}}),
+				},
+				nil)
+
+			dates, oneRMs, err := EventManager.GetOneRMSeries(testUserID, squat.ID, Epley, ExerciseFilter{})
+
+			Convey("Then the best estimate of each event is returned in chronological order", func() {
+				So(err, ShouldBeNil)
+				So(dates, ShouldResemble, []int64{1000, 3000})
+				So(oneRMs, ShouldResemble, []float32{110, 116.7})
+			})
+		})
+
+		Convey("When we get the 1RM series of an exercise type that does not use weight and count", func() {
+			_, _, err := EventManager.GetOneRMSeries(testUserID, run.ID, Epley, ExerciseFilter{})
+
+			So(errors.As(err, &ErrInvalidExercise{}), ShouldBeTrue)
+		})
+
+		Convey("When we get the current 1RM of an exercise type with a manual 1RM", func() {
+			db.On("GetOneRM", testUserID, squat.ID).Return(140, nil)
+			exerciseTypeCache.Store(squat.ID, squat)
+			defer exerciseTypeCache.Delete(squat.ID)
+
+			oneRM, estimated, err := new(exerciseManager).Current1RM(testUserID, squat.ID, Epley)
+
+			Convey("Then the manual 1RM is returned", func() {
+				So(err, ShouldBeNil)
+				So(oneRM, ShouldEqual, float32(140))
+				So(estimated, ShouldBeFalse)
+			})
+		})
+
+		Convey("When we get the current 1RM of an exercise type without a manual 1RM", func() {
+			db.On("GetOneRM", testUserID, squat.ID).Return(0, nil)
+			db.On("GetExerciseInstancePage", testUserID, squat.ID, "", int64(0), int64(0), DefaultPageSize).Return(
+				[]int64{OneRMWindow + 5000, OneRMWindow + 4000, 1000},
+				[]string{"event-3", "event-2", "event-1"},
+				[][]byte{
+					instanceJSON(ExerciseSegment{Intensity: 100, Volume: [][]float32{{1, 1, 1}}}),
+					instanceJSON(ExerciseSegment{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}}}),
+					instanceJSON(ExerciseSegment{Intensity: 130, Volume: [][]float32{{1}}}),
+				},
+				nil)
+			exerciseTypeCache.Store(squat.ID, squat)
+			defer exerciseTypeCache.Delete(squat.ID)
+
+			oneRM, estimated, err := new(exerciseManager).Current1RM(testUserID, squat.ID, Epley)
+
+			Convey("Then the best estimate within the window of the latest estimate is returned", func() {
+				So(err, ShouldBeNil)
+				So(oneRM, ShouldEqual, float32(116.7))
+				So(estimated, ShouldBeTrue)
+			})
+		})
+
+		Convey("When we get the current 1RM of an exercise type that does not exist", func() {
+			db.On("GetOneRM", testUserID, "missing").Return(0, nil)
+			db.On("GetExercise", testUserID, "missing").Return([]byte(nil), nil)
+
+			_, _, err := new(exerciseManager).Current1RM(testUserID, "missing", Epley)
+
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+	})
+}
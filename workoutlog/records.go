@@ -0,0 +1,323 @@
+package workoutlog
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// Kinds of personal records.
+const (
+	RecordWeight   = "weight"   // heaviest weight lifted for a number of reps
+	RecordOneRM    = "e1rm"     // highest estimated 1RM
+	RecordDistance = "distance" // longest distance of a set
+	RecordPace     = "pace"     // fastest pace
+)
+
+// A PersonalRecord is a performance of an exercise type that beat all previous performances of the same kind.
+// Reps is the number of reps of weight records.
+// Previous is the value of the record that was beaten, or 0 for the first record of its kind.
+// Date and EventID identify the event in which the record was set.
+type PersonalRecord struct {
+	ExerciseID string  `json:"exerciseID"`
+	Kind       string  `json:"kind"`
+	Reps       int     `json:"reps,omitempty"`
+	Value      float32 `json:"value"`
+	Previous   float32 `json:"previous,omitempty"`
+	Date       int64   `json:"date"`
+	EventID    string  `json:"eventID"`
+}
+
+// name identifies the records that are compared with each other.
+func (r PersonalRecord) name() string {
+	if r.Kind == RecordWeight {
+		return fmt.Sprintf("%s-%d", r.Kind, r.Reps)
+	}
+
+	return r.Kind
+}
+
+// beats returns true when a record is better than another record of the same kind.
+// Lower paces are better.
+func (r PersonalRecord) beats(other PersonalRecord) bool {
+	if r.Kind == RecordPace {
+		return r.Value < other.Value
+	}
+
+	return r.Value > other.Value
+}
+
+// hasRecords returns true when personal records are tracked for an exercise type.
+func (et ExerciseType) hasRecords() bool {
+	return (et.IntensityType == "weight" && et.VolumeType == "count") || et.VolumeType == "distance" || et.IntensityType == "pace"
+}
+
+// sortRecords sorts records by date, kind, and reps.
+func sortRecords(records []PersonalRecord) {
+	slices.SortFunc(records, func(a, b PersonalRecord) int {
+		if a.Date != b.Date {
+			return cmp.Compare(a.Date, b.Date)
+		}
+		if a.Kind != b.Kind {
+			return strings.Compare(a.Kind, b.Kind)
+		}
+		return cmp.Compare(a.Reps, b.Reps)
+	})
+}
+
+// mergeEfforts keeps the best of the efforts of each kind.
+func mergeEfforts(efforts []PersonalRecord) []PersonalRecord {
+	merged := slices.Collect(maps.Values(bestRecords(efforts)))
+	sortRecords(merged)
+
+	return merged
+}
+
+// newRecords returns the efforts that beat the best records and dates them with the event.
+// The new records replace the beaten records in bests.
+func newRecords(bests map[string]PersonalRecord, efforts []PersonalRecord, eventID string, date int64) []PersonalRecord {
+	records := []PersonalRecord{}
+
+	for _, e := range efforts {
+		current, ok := bests[e.name()]
+		if ok {
+			if !e.beats(current) {
+				continue
+			}
+			e.Previous = current.Value
+		}
+		e.Date = date
+		e.EventID = eventID
+
+		bests[e.name()] = e
+		records = append(records, e)
+	}
+
+	return records
+}
+
+// readRecords returns the stored record history of an exercise type in chronological order.
+// Returns nil when no history is stored.
+func readRecords(userID, exerciseID string) ([]PersonalRecord, error) {
+	stored, err := dal.DB.GetRecords(userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored == nil {
+		return nil, nil
+	}
+
+	history := []PersonalRecord{}
+	if err := json.Unmarshal(stored, &history); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal records: %w", err)
+	}
+
+	return history, nil
+}
+
+func storeRecords(userID, exerciseID string, history []PersonalRecord) error {
+	historyJSON, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal records: %w", err)
+	}
+
+	return dal.DB.SetRecords(userID, exerciseID, historyJSON)
+}
+
+// rebuildRecords derives the record history of an exercise type from all of its logged instances and stores it.
+// The instances of composite exercise types that include the exercise type contribute the efforts of the component.
+func rebuildRecords(userID string, et ExerciseType) ([]PersonalRecord, error) {
+	type performance struct {
+		date    int64
+		eventID string
+		efforts []PersonalRecord
+	}
+
+	performances := []*performance{}
+	byEvent := map[string]*performance{}
+
+	addEfforts := func(date int64, eventID string, efforts []PersonalRecord) {
+		p, ok := byEvent[eventID]
+		if !ok {
+			p = &performance{date: date, eventID: eventID}
+			byEvent[eventID] = p
+			performances = append(performances, p)
+		}
+		p.efforts = append(p.efforts, efforts...)
+	}
+
+	err := forEachInstance(userID, et.ID, 0, 0, func(date int64, eventID string, instance ExerciseInstance) bool {
+		addEfforts(date, eventID, et.BestEfforts(&instance))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exerciseTypes, err := ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise types: %w", err)
+	}
+
+	for _, composite := range exerciseTypes {
+		if _, ok := composite.Composition[et.ID]; !ok {
+			continue
+		}
+
+		err := forEachInstance(userID, composite.ID, 0, 0, func(date int64, eventID string, instance ExerciseInstance) bool {
+			for _, component := range composite.ExpandInstance(&instance) {
+				if component.TypeID == et.ID {
+					addEfforts(date, eventID, et.BestEfforts(&component))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// instances are read in reverse chronological order
+	slices.SortStableFunc(performances, func(a, b *performance) int {
+		return cmp.Compare(b.date, a.date)
+	})
+
+	history := []PersonalRecord{}
+	bests := map[string]PersonalRecord{}
+
+	for i := len(performances) - 1; i >= 0; i-- {
+		p := performances[i]
+		history = append(history, newRecords(bests, mergeEfforts(p.efforts), p.eventID, p.date)...)
+	}
+
+	if err := storeRecords(userID, et.ID, history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// recordEvent updates the record histories of the exercise types that were performed in an event
+// and returns the records that the event set.
+// The efforts of the event are compared with the stored history of each exercise type.
+// A history is rebuilt instead when it is not stored, when the event precedes its latest record,
+// or when the exercise type is included in rebuildTypes.
+// Include the types of a previous version of an event in rebuildTypes to remove the records that it set.
+// The components of composite exercise types are recorded with the efforts that the composite includes.
+func recordEvent(userID string, event Event, rebuildTypes []string) ([]PersonalRecord, error) {
+	efforts := map[string][]PersonalRecord{}
+	rebuild := slices.Clone(rebuildTypes)
+
+	for _, typeID := range rebuildTypes {
+		exerciseType, err := ExerciseManager.GetExerciseType(userID, typeID)
+		if err != nil {
+			return nil, err
+		}
+		rebuild = append(rebuild, slices.Collect(maps.Keys(exerciseType.Composition))...)
+	}
+
+	typeIDs := slices.Clone(rebuild)
+
+	for _, instance := range event.Exercises {
+		instanceEfforts, err := effortsOf(userID, instance)
+		if err != nil {
+			return nil, err
+		}
+
+		for typeID, e := range instanceEfforts {
+			efforts[typeID] = append(efforts[typeID], e...)
+			typeIDs = append(typeIDs, typeID)
+		}
+	}
+
+	slices.Sort(typeIDs)
+	typeIDs = slices.Compact(typeIDs)
+
+	records := []PersonalRecord{}
+
+	for _, typeID := range typeIDs {
+		exerciseType, err := ExerciseManager.GetExerciseType(userID, typeID)
+		if err != nil {
+			return nil, err
+		}
+		if !exerciseType.hasRecords() {
+			continue
+		}
+
+		history, err := readRecords(userID, typeID)
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(rebuild, typeID) || history == nil || (len(history) > 0 && event.Date < history[len(history)-1].Date) {
+			history, err = rebuildRecords(userID, *exerciseType)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			set := newRecords(bestRecords(history), mergeEfforts(efforts[typeID]), event.ID, event.Date)
+			if len(set) > 0 {
+				history = append(history, set...)
+				if err := storeRecords(userID, typeID, history); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for _, r := range history {
+			if r.EventID == event.ID {
+				records = append(records, r)
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// effortsOf returns the best efforts of an exercise instance by exercise type.
+// The efforts of a composite instance include the efforts of its components.
+func effortsOf(userID string, instance ExerciseInstance) (map[string][]PersonalRecord, error) {
+	exerciseType, err := ExerciseManager.GetExerciseType(userID, instance.TypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	efforts := map[string][]PersonalRecord{}
+	if exerciseType.hasRecords() {
+		efforts[instance.TypeID] = exerciseType.BestEfforts(&instance)
+	}
+
+	if !exerciseType.isComposite() {
+		return efforts, nil
+	}
+
+	for _, component := range exerciseType.ExpandInstance(&instance) {
+		componentType, err := ExerciseManager.GetExerciseType(userID, component.TypeID)
+		if err != nil {
+			return nil, err
+		}
+		if componentType.hasRecords() {
+			efforts[component.TypeID] = append(efforts[component.TypeID], componentType.BestEfforts(&component)...)
+		}
+	}
+
+	return efforts, nil
+}
+
+// bestRecords returns the current record of each kind in a record history.
+func bestRecords(history []PersonalRecord) map[string]PersonalRecord {
+	bests := map[string]PersonalRecord{}
+	for _, r := range history {
+		if current, ok := bests[r.name()]; !ok || r.beats(current) {
+			bests[r.name()] = r
+		}
+	}
+
+	return bests
+}
@@ -0,0 +1,232 @@
+package workoutlog
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+func TestRecords(t *testing.T) {
+	squat := ExerciseType{ID: "squat-id", Name: "squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+	run := ExerciseType{ID: "run-id", Name: "run", IntensityType: "pace", VolumeType: "distance"}
+	plank := ExerciseType{ID: "plank-id", Name: "plank", IntensityType: "bodyweight", VolumeType: "time"}
+	doubles := ExerciseType{ID: "doubles-id", Name: "squat doubles", IntensityType: "weight", VolumeType: "count", Composition: map[string]int{squat.ID: 2}}
+
+	Convey("Given an instance of a weight and count exercise type", t, func() {
+		instance := ExerciseInstance{
+			TypeID: squat.ID,
+			Segments: []ExerciseSegment{
+				{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}, {1, 1, 1, 0, 0}}},
+				{Intensity: 110, Volume: [][]float32{{1, 1, 1}}},
+			},
+		}
+
+		Convey("When we get the best efforts", func() {
+			efforts := squat.BestEfforts(&instance)
+
+			Convey("Then the heaviest weight for each number of reps and the best estimated 1RM are returned", func() {
+				So(efforts, ShouldResemble, []PersonalRecord{
+					{ExerciseID: squat.ID, Kind: RecordOneRM, Value: 121},
+					{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 3, Value: 110},
+					{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 5, Value: 100},
+				})
+			})
+		})
+	})
+
+	Convey("Given an instance of a pace and distance exercise type", t, func() {
+		instance := ExerciseInstance{
+			TypeID: run.ID,
+			Segments: []ExerciseSegment{
+				{Intensity: 330, Volume: [][]float32{{5000}}},
+				{Intensity: 300, Volume: [][]float32{{1000}}},
+			},
+		}
+
+		Convey("When we get the best efforts", func() {
+			efforts := run.BestEfforts(&instance)
+
+			Convey("Then the longest distance and fastest pace are returned", func() {
+				So(efforts, ShouldResemble, []PersonalRecord{
+					{ExerciseID: run.ID, Kind: RecordDistance, Value: 5000},
+					{ExerciseID: run.ID, Kind: RecordPace, Value: 300},
+				})
+			})
+		})
+	})
+
+	Convey("When we get the best efforts of an exercise type without records", t, func() {
+		instance := ExerciseInstance{TypeID: plank.ID, Segments: []ExerciseSegment{{Intensity: 1, Volume: [][]float32{{60}}}}}
+
+		So(plank.BestEfforts(&instance), ShouldBeEmpty)
+		So(plank.hasRecords(), ShouldBeFalse)
+	})
+
+	Convey("Given a dal client and an exercise manager", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+		eMgr.On("GetExerciseType", testUserID, squat.ID).Return(&squat, nil)
+		eMgr.On("GetExerciseType", testUserID, doubles.ID).Return(&doubles, nil)
+		eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{squat, doubles}, nil)
+		db.On("GetExerciseInstancePage", testUserID, doubles.ID, "", int64(0), int64(0), DefaultPageSize).Return([]int64{}, []string{}, [][]byte{}, nil)
+
+		event := Event{
+			ID:   "event-2",
+			Date: 2000,
+			Exercises: map[int]ExerciseInstance{
+				0: {TypeID: squat.ID, Segments: []ExerciseSegment{{Intensity: 105, Volume: [][]float32{{1, 1, 1, 1, 1}}}}},
+			},
+		}
+
+		history := []PersonalRecord{
+			{ExerciseID: squat.ID, Kind: RecordOneRM, Value: 116.7, Date: 1000, EventID: "event-1"},
+			{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 5, Value: 100, Date: 1000, EventID: "event-1"},
+			{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 1, Value: 110, Date: 1000, EventID: "event-1"},
+		}
+		historyJSON, err := json.Marshal(history)
+		if err != nil {
+			t.Fatal()
+		}
+
+		Convey("When an event beats the stored records", func() {
+			db.On("GetRecords", testUserID, squat.ID).Return(historyJSON, nil)
+			db.On("SetRecords", testUserID, squat.ID, mock.Anything).Return(nil)
+
+			records, err := recordEvent(testUserID, event, nil)
+
+			Convey("Then the new records are returned and appended to the history", func() {
+				So(err, ShouldBeNil)
+				So(records, ShouldResemble, []PersonalRecord{
+					{ExerciseID: squat.ID, Kind: RecordOneRM, Value: 122.5, Previous: 116.7, Date: 2000, EventID: "event-2"},
+					{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 5, Value: 105, Previous: 100, Date: 2000, EventID: "event-2"},
+				})
+
+				stored := []PersonalRecord{}
+				So(json.Unmarshal(db.Calls[1].Arguments.Get(2).([]byte), &stored), ShouldBeNil)
+				So(stored, ShouldHaveLength, 5)
+				So(stored[4].EventID, ShouldEqual, "event-2")
+			})
+		})
+
+		Convey("When an event does not beat the stored records", func() {
+			db.On("GetRecords", testUserID, squat.ID).Return(historyJSON, nil)
+			event.Exercises[0].Segments[0].Intensity = 95
+
+			records, err := recordEvent(testUserID, event, nil)
+
+			Convey("Then no records are returned or stored", func() {
+				So(err, ShouldBeNil)
+				So(records, ShouldBeEmpty)
+				db.AssertNotCalled(t, "SetRecords", mock.Anything, mock.Anything, mock.Anything)
+			})
+		})
+
+		Convey("When no history is stored", func() {
+			previous, err := json.Marshal(ExerciseInstance{TypeID: squat.ID, Segments: []ExerciseSegment{{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}}}}})
+			if err != nil {
+				t.Fatal()
+			}
+			current, err := json.Marshal(event.Exercises[0])
+			if err != nil {
+				t.Fatal()
+			}
+
+			db.On("GetRecords", testUserID, squat.ID).Return(nil, nil)
+			db.On("GetExerciseInstancePage", testUserID, squat.ID, "", int64(0), int64(0), DefaultPageSize).Return([]int64{2000, 1000}, []string{"event-2", "event-1"}, [][]byte{current, previous}, nil)
+			db.On("SetRecords", testUserID, squat.ID, mock.Anything).Return(nil)
+
+			records, err := recordEvent(testUserID, event, nil)
+
+			Convey("Then the history is rebuilt from the logged instances", func() {
+				So(err, ShouldBeNil)
+				So(records, ShouldResemble, []PersonalRecord{
+					{ExerciseID: squat.ID, Kind: RecordOneRM, Value: 122.5, Previous: 116.7, Date: 2000, EventID: "event-2"},
+					{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 5, Value: 105, Previous: 100, Date: 2000, EventID: "event-2"},
+				})
+				db.AssertCalled(t, "SetRecords", testUserID, squat.ID, mock.Anything)
+			})
+		})
+
+		Convey("When the event precedes the latest record", func() {
+			db.On("GetRecords", testUserID, squat.ID).Return(historyJSON, nil)
+			db.On("GetExerciseInstancePage", testUserID, squat.ID, "", int64(0), int64(0), DefaultPageSize).Return([]int64{}, []string{}, [][]byte{}, nil)
+			db.On("SetRecords", testUserID, squat.ID, mock.Anything).Return(nil)
+			event.Date = 500
+
+			_, err := recordEvent(testUserID, event, nil)
+
+			Convey("Then the history is rebuilt", func() {
+				So(err, ShouldBeNil)
+				db.AssertCalled(t, "GetExerciseInstancePage", testUserID, squat.ID, "", int64(0), int64(0), DefaultPageSize)
+			})
+		})
+
+		Convey("When an event includes a composite exercise type", func() {
+			db.On("GetRecords", testUserID, squat.ID).Return(historyJSON, nil)
+			db.On("GetRecords", testUserID, doubles.ID).Return([]byte("[]"), nil)
+			db.On("SetRecords", testUserID, mock.Anything, mock.Anything).Return(nil)
+			event.Exercises[0] = ExerciseInstance{TypeID: doubles.ID, Segments: []ExerciseSegment{{Intensity: 105, Volume: [][]float32{{1, 1, 1}}}}}
+
+			records, err := recordEvent(testUserID, event, nil)
+
+			Convey("Then the composite sets records of its components for the reps of each component set", func() {
+				So(err, ShouldBeNil)
+				So(records, ShouldContain, PersonalRecord{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 2, Value: 105, Date: 2000, EventID: "event-2"})
+				So(records, ShouldNotContain, PersonalRecord{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 6, Value: 105, Date: 2000, EventID: "event-2"})
+				So(records, ShouldContain, PersonalRecord{ExerciseID: doubles.ID, Kind: RecordWeight, Reps: 3, Value: 105, Date: 2000, EventID: "event-2"})
+				db.AssertCalled(t, "SetRecords", testUserID, squat.ID, mock.Anything)
+			})
+		})
+
+		Convey("When the history of a component of a composite is rebuilt", func() {
+			previous, err := json.Marshal(ExerciseInstance{TypeID: squat.ID, Segments: []ExerciseSegment{{Intensity: 100, Volume: [][]float32{{1, 1, 1, 1, 1}}}}})
+			if err != nil {
+				t.Fatal()
+			}
+			composite, err := json.Marshal(ExerciseInstance{TypeID: doubles.ID, Segments: []ExerciseSegment{{Intensity: 110, Volume: [][]float32{{1}}}}})
+			if err != nil {
+				t.Fatal()
+			}
+
+			db := dal.NewMockDal()
+			dal.DB = db
+			db.On("GetExerciseInstancePage", testUserID, squat.ID, "", int64(0), int64(0), DefaultPageSize).Return([]int64{1000}, []string{"event-1"}, [][]byte{previous}, nil)
+			db.On("GetExerciseInstancePage", testUserID, doubles.ID, "", int64(0), int64(0), DefaultPageSize).Return([]int64{2000}, []string{"event-2"}, [][]byte{composite}, nil)
+			db.On("SetRecords", testUserID, squat.ID, mock.Anything).Return(nil)
+
+			rebuilt, err := rebuildRecords(testUserID, squat)
+
+			Convey("Then the instances of the composite are included in chronological order", func() {
+				So(err, ShouldBeNil)
+				So(rebuilt[0].EventID, ShouldEqual, "event-1")
+				So(rebuilt, ShouldContain, PersonalRecord{ExerciseID: squat.ID, Kind: RecordWeight, Reps: 2, Value: 110, Date: 2000, EventID: "event-2"})
+			})
+		})
+
+		Convey("When we get the stored records of an exercise type", func() {
+			db.On("GetRecords", testUserID, squat.ID).Return(historyJSON, nil)
+
+			records, err := new(exerciseManager).GetRecords(testUserID, squat.ID)
+
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, history)
+		})
+
+		Convey("When we get the records of an exercise type that does not exist", func() {
+			db.On("GetRecords", testUserID, "missing").Return(nil, nil)
+			db.On("GetExercise", testUserID, "missing").Return([]byte(nil), nil)
+
+			_, err := new(exerciseManager).GetRecords(testUserID, "missing")
+
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,208 @@
+package workoutlog
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+// A SignupPolicy determines who can sign up for an account.
+// The first user to sign up becomes an admin regardless of the policy.
+type SignupPolicy string
+
+const (
+	// Anyone can sign up.
+	SignupOpen SignupPolicy = "open"
+	// Only admins can create accounts.
+	SignupClosed SignupPolicy = "closed"
+	// Signing up requires an invite code that an admin created.
+	SignupInvite SignupPolicy = "invite"
+)
+
+// DefaultInviteTTL is the time that an invite code is valid when no time is specified.
+const DefaultInviteTTL = 7 * 24 * time.Hour
+
+// Signups is the signup policy in effect.
+// The default is SignupOpen, which is how signup worked before the policy could be configured.
+var Signups SignupPolicy = SignupOpen
+
+// LegacyAdminName is the name of the admin account that earlier versions created with a fixed password.
+const LegacyAdminName = "admin"
+
+// legacyAdminPassword is the fixed password of the legacy admin account. It is published in the source code.
+const legacyAdminPassword = "homegymadmin1234"
+
+var ErrSignupClosed = errors.New("signup is closed")
+var ErrInvalidInvite = errors.New("invalid invite code")
+
+// signupLock ensures that only one user is promoted to admin when the first users sign up at the same time.
+var signupLock sync.Mutex
+
+// An Invite is a single-use code that permits someone to sign up.
+// The expiry time is in seconds since the epoch.
+type Invite struct {
+	Code    string `json:"code"`
+	Expires int64  `json:"expires"`
+}
+
+// ParseSignupPolicy returns the signup policy with the given name.
+func ParseSignupPolicy(name string) (SignupPolicy, error) {
+	switch policy := SignupPolicy(name); policy {
+	case SignupOpen, SignupClosed, SignupInvite:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown signup policy %q", name)
+}
+
+// SignUp creates a user according to the signup policy.
+// The invite code is required when the policy is SignupInvite.
+// When there are no users the user is created as an admin and the policy is not applied.
+func (u *userManager) SignUp(username, email, password, inviteCode string) (*User, error) {
+	signupLock.Lock()
+	defer signupLock.Unlock()
+
+	users, err := dal.DB.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return FrontDesk.NewUser(username, auth.Admin, email, password)
+	}
+
+	switch Signups {
+	case SignupOpen:
+		return FrontDesk.NewUser(username, auth.User, email, password)
+	case SignupInvite:
+		return signUpWithInvite(username, email, password, inviteCode)
+	}
+
+	return nil, ErrSignupClosed
+}
+
+// signUpWithInvite claims the invite code and creates the user.
+// The invite code is restored when the user is not created.
+func signUpWithInvite(username, email, password, inviteCode string) (*User, error) {
+	if inviteCode == "" {
+		return nil, ErrInvalidInvite
+	}
+
+	expires, err := dal.DB.ClaimInvite(inviteCode)
+	if err != nil {
+		return nil, err
+	}
+	if expires == nil {
+		return nil, ErrInvalidInvite
+	}
+	if *expires < time.Now().Unix() {
+		return nil, ErrInvalidInvite
+	}
+
+	user, err := FrontDesk.NewUser(username, auth.User, email, password)
+	if err != nil {
+		if restoreErr := dal.DB.AddInvite(inviteCode, *expires); restoreErr != nil {
+			return nil, errors.Join(err, restoreErr)
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CreateInvite creates an invite code that expires after the ttl.
+func (u *userManager) CreateInvite(ttl time.Duration) (*Invite, error) {
+	if ttl <= 0 {
+		ttl = DefaultInviteTTL
+	}
+
+	codeBytes := make([]byte, 10)
+	if _, err := rand.Read(codeBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate invite code: %w", err)
+	}
+
+	invite := Invite{
+		Code:    base32.StdEncoding.EncodeToString(codeBytes),
+		Expires: time.Now().Add(ttl).Unix(),
+	}
+
+	if err := dal.DB.AddInvite(invite.Code, invite.Expires); err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+// ListInvites returns the invite codes that have not expired, soonest to expire first.
+// Expired invite codes are deleted.
+func (u *userManager) ListInvites() ([]Invite, error) {
+	codes, err := dal.DB.GetInvites()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	invites := []Invite{}
+	for code, expires := range codes {
+		if expires < now {
+			if err := dal.DB.DeleteInvite(code); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		invites = append(invites, Invite{Code: code, Expires: expires})
+	}
+
+	slices.SortFunc(invites, func(a, b Invite) int {
+		return cmp.Compare(a.Expires, b.Expires)
+	})
+
+	return invites, nil
+}
+
+// DeleteInvite deletes an invite code.
+func (u *userManager) DeleteInvite(code string) error {
+	return dal.DB.DeleteInvite(code)
+}
+
+// SecureLegacyAdmin secures the admin account that earlier versions created with a fixed password.
+// When the account still has that password, its password is reset to newPassword and must be changed after the
+// next login. When newPassword is empty the account is disabled instead.
+// Returns true when the account had the fixed password.
+func SecureLegacyAdmin(newPassword string) (bool, error) {
+	user, err := FrontDesk.GetUser(LegacyAdminName)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	pwdUtil := auth.PasswordUtil{Version: user.PwdHashVersion}
+	if err := pwdUtil.ValidatePassword(legacyAdminPassword, user.PwdHash); err != nil {
+		return false, nil
+	}
+
+	if newPassword == "" || newPassword == legacyAdminPassword {
+		if err := FrontDesk.SetUserDisabled(LegacyAdminName, true); err != nil {
+			return true, fmt.Errorf("failed to disable the legacy admin account: %w", err)
+		}
+		return true, nil
+	}
+
+	if err := FrontDesk.ResetPassword(LegacyAdminName, newPassword); err != nil {
+		return true, fmt.Errorf("failed to reset the password of the legacy admin account: %w", err)
+	}
+	if err := FrontDesk.SetUserDisabled(LegacyAdminName, false); err != nil {
+		return true, fmt.Errorf("failed to enable the legacy admin account: %w", err)
+	}
+
+	return true, nil
+}
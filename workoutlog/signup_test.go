@@ -0,0 +1,212 @@
+package workoutlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scottbrodersen/homegym/auth"
+	"github.com/scottbrodersen/homegym/dal"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSignUp(t *testing.T) {
+	testInvite := "TESTINVITE"
+
+	Convey("Given a dal client", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		db.On("GetKeys", auth.KeyTypes.Pepper).Return(map[string][]byte{"test-pepper-key-id": []byte("dGVzdCBwZXBwZXI=")}, map[string][]byte{}, nil)
+		defer func() { Signups = SignupOpen }()
+
+		Convey("When the first user signs up", func() {
+			Signups = SignupClosed
+			db.On("ListUsers").Return([]string{}, nil)
+			db.On("NewUser", testUserName, testEmail, mock.Anything, mock.Anything, string(auth.Admin)).Return(nil)
+
+			user, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, "")
+
+			Convey("Then the user is an admin regardless of the policy", func() {
+				So(err, ShouldBeNil)
+				So(user.Role, ShouldEqual, auth.Admin)
+			})
+		})
+
+		Convey("Given there are users", func() {
+			db.On("ListUsers").Return([]string{"existing"}, nil)
+			db.On("NewUser", testUserName, testEmail, mock.Anything, mock.Anything, string(auth.User)).Return(nil)
+
+			Convey("When signup is open", func() {
+				Signups = SignupOpen
+				user, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, "")
+
+				So(err, ShouldBeNil)
+				So(user.Role, ShouldEqual, auth.User)
+			})
+
+			Convey("When signup is closed", func() {
+				Signups = SignupClosed
+				_, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, "")
+
+				So(err, ShouldEqual, ErrSignupClosed)
+				db.AssertNotCalled(t, "NewUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+
+			Convey("When signup requires an invite and a valid invite code is provided", func() {
+				Signups = SignupInvite
+				expires := time.Now().Add(time.Hour).Unix()
+				db.On("ClaimInvite", testInvite).Return(&expires, nil)
+
+				user, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, testInvite)
+
+				So(err, ShouldBeNil)
+				So(user.Role, ShouldEqual, auth.User)
+				So(db.AssertCalled(t, "ClaimInvite", testInvite), ShouldBeTrue)
+			})
+
+			Convey("When signup requires an invite and an expired invite code is provided", func() {
+				Signups = SignupInvite
+				expires := time.Now().Add(-time.Hour).Unix()
+				db.On("ClaimInvite", testInvite).Return(&expires, nil)
+
+				_, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, testInvite)
+
+				So(err, ShouldEqual, ErrInvalidInvite)
+				db.AssertNotCalled(t, "NewUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			})
+
+			Convey("When signup requires an invite and an unknown invite code is provided", func() {
+				Signups = SignupInvite
+				var none *int64
+				db.On("ClaimInvite", testInvite).Return(none, nil)
+
+				_, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, testInvite)
+
+				So(err, ShouldEqual, ErrInvalidInvite)
+			})
+		})
+
+		Convey("When a user with an invite code cannot be created", func() {
+			Signups = SignupInvite
+			expires := time.Now().Add(time.Hour).Unix()
+			db.On("ListUsers").Return([]string{"existing"}, nil)
+			db.On("ClaimInvite", testInvite).Return(&expires, nil)
+			db.On("NewUser", testUserName, testEmail, mock.Anything, mock.Anything, string(auth.User)).Return(dal.ErrNotUnique)
+			db.On("AddInvite", testInvite, expires).Return(nil)
+
+			_, err := FrontDesk.SignUp(testUserName, testEmail, testPassword, testInvite)
+
+			Convey("Then the invite code is restored", func() {
+				So(err, ShouldEqual, dal.ErrNotUnique)
+				So(db.AssertCalled(t, "AddInvite", testInvite, expires), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestSecureLegacyAdmin(t *testing.T) {
+	Convey("Given a dal client", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+		db.On("ReadUserStatus", LegacyAdminName).Return(&dal.UserStatus{}, nil)
+
+		pwdUtil := auth.PasswordUtil{Version: "v1"}
+		legacyHash, err := pwdUtil.Hash(legacyAdminPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherHash, err := pwdUtil.Hash(testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		email, version, role := testEmail, "v1", string(auth.Admin)
+
+		Convey("When the legacy admin has the fixed password", func() {
+			db.On("ReadUser", LegacyAdminName).Return(&email, &legacyHash, &version, &role, nil)
+			db.On("SetUserDisabled", LegacyAdminName, mock.Anything).Return(nil)
+
+			Convey("And no new password is provided", func() {
+				secured, err := SecureLegacyAdmin("")
+
+				Convey("Then the account is disabled", func() {
+					So(err, ShouldBeNil)
+					So(secured, ShouldBeTrue)
+					db.AssertCalled(t, "SetUserDisabled", LegacyAdminName, true)
+				})
+			})
+
+			Convey("And a new password is provided", func() {
+				db.On("GetKeys", auth.KeyTypes.Pepper).Return(map[string][]byte{"test-pepper-key-id": []byte("dGVzdCBwZXBwZXI=")}, map[string][]byte{}, nil)
+				db.On("UpdateUserPassword", LegacyAdminName, mock.Anything, auth.LatestVersion()).Return(nil)
+				db.On("SetPasswordChangeRequired", LegacyAdminName, true).Return(nil)
+
+				secured, err := SecureLegacyAdmin(testPassword)
+
+				Convey("Then the password is reset and must be changed", func() {
+					So(err, ShouldBeNil)
+					So(secured, ShouldBeTrue)
+					db.AssertCalled(t, "UpdateUserPassword", LegacyAdminName, mock.Anything, auth.LatestVersion())
+					db.AssertCalled(t, "SetPasswordChangeRequired", LegacyAdminName, true)
+					db.AssertCalled(t, "SetUserDisabled", LegacyAdminName, false)
+				})
+			})
+		})
+
+		Convey("When the legacy admin has another password", func() {
+			db.On("ReadUser", LegacyAdminName).Return(&email, &otherHash, &version, &role, nil)
+
+			secured, err := SecureLegacyAdmin("")
+
+			Convey("Then the account is not changed", func() {
+				So(err, ShouldBeNil)
+				So(secured, ShouldBeFalse)
+				db.AssertNotCalled(t, "SetUserDisabled", mock.Anything, mock.Anything)
+			})
+		})
+
+		Convey("When there is no legacy admin", func() {
+			var none *string
+			db.On("ReadUser", LegacyAdminName).Return(none, none, none, none, nil)
+
+			secured, err := SecureLegacyAdmin("")
+
+			So(err, ShouldBeNil)
+			So(secured, ShouldBeFalse)
+		})
+	})
+}
+
+func TestInvites(t *testing.T) {
+	Convey("Given a dal client", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		Convey("When we create an invite", func() {
+			db.On("AddInvite", mock.Anything, mock.Anything).Return(nil)
+
+			invite, err := FrontDesk.CreateInvite(time.Hour)
+
+			Convey("Then an invite code that expires after the ttl is stored", func() {
+				So(err, ShouldBeNil)
+				So(invite.Code, ShouldHaveLength, 16)
+				So(invite.Expires, ShouldAlmostEqual, time.Now().Add(time.Hour).Unix(), 1)
+				So(db.AssertCalled(t, "AddInvite", invite.Code, invite.Expires), ShouldBeTrue)
+			})
+		})
+
+		Convey("When we list invites", func() {
+			now := time.Now().Unix()
+			db.On("GetInvites").Return(map[string]int64{"LATER": now + 200, "SOONER": now + 100, "EXPIRED": now - 100}, nil)
+			db.On("DeleteInvite", "EXPIRED").Return(nil)
+
+			invites, err := FrontDesk.ListInvites()
+
+			Convey("Then the invites that have not expired are returned and expired invites are deleted", func() {
+				So(err, ShouldBeNil)
+				So(invites, ShouldResemble, []Invite{{Code: "SOONER", Expires: now + 100}, {Code: "LATER", Expires: now + 200}})
+				So(db.AssertCalled(t, "DeleteInvite", "EXPIRED"), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,212 @@
+package workoutlog
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// A LoadModel configures how training load is modelled.
+// The acute and chronic loads are exponentially weighted moving averages of daily load over AcuteDays and ChronicDays.
+// Fitness and fatigue are the components of the Banister impulse-response model. They decay with the time constants
+// FitnessDays and FatigueDays, and form is FitnessGain * fitness - FatigueGain * fatigue.
+// Warnings are issued when the acute:chronic workload ratio (ACWR) is below ACWRLow or above ACWRHigh.
+type LoadModel struct {
+	AcuteDays   int
+	ChronicDays int
+	FitnessDays float64
+	FatigueDays float64
+	FitnessGain float64
+	FatigueGain float64
+	ACWRLow     float64
+	ACWRHigh    float64
+}
+
+// Load is the training load model.
+var Load = LoadModel{
+	AcuteDays:   7,
+	ChronicDays: 28,
+	FitnessDays: 42,
+	FatigueDays: 7,
+	FitnessGain: 1,
+	FatigueGain: 2,
+	ACWRLow:     0.8,
+	ACWRHigh:    1.3,
+}
+
+// SetACWRBounds sets the acute:chronic workload ratios outside of which warnings are issued.
+func SetACWRBounds(low, high float64) error {
+	if low <= 0 || high <= low {
+		return fmt.Errorf("invalid ACWR bounds: low %v, high %v", low, high)
+	}
+
+	Load.ACWRLow = low
+	Load.ACWRHigh = high
+
+	return nil
+}
+
+// TrainingLoad is the daily training load of a date range and the models that are derived from it.
+// The slices are parallel and in chronological order. Dates are the start of each day.
+type TrainingLoad struct {
+	Dates    []int64       `json:"dates"`
+	Load     []float32     `json:"load"`
+	Acute    []float32     `json:"acute"`
+	Chronic  []float32     `json:"chronic"`
+	ACWR     []float32     `json:"acwr"`
+	Fitness  []float32     `json:"fitness"`
+	Fatigue  []float32     `json:"fatigue"`
+	Form     []float32     `json:"form"`
+	ACWRLow  float32       `json:"acwrLow"`
+	ACWRHigh float32       `json:"acwrHigh"`
+	Warnings []LoadWarning `json:"warnings"`
+}
+
+// A LoadWarning reports consecutive days on which the ACWR was outside of the bounds of the load model.
+// Start and End are the first and last days and Peak is the ratio that was furthest outside of the bounds.
+type LoadWarning struct {
+	Start   int64   `json:"start"`
+	End     int64   `json:"end"`
+	Peak    float32 `json:"peak"`
+	Message string  `json:"message"`
+}
+
+func round(v float64, places int) float32 {
+	scale := math.Pow(10, float64(places))
+	return float32(math.Round(v*scale) / scale)
+}
+
+// eventLoad returns the training load of an event.
+// The load is the sum of the loads of the exercise instances of the event, as calculated by CalculateMetrics,
+// plus the session RPE multiplied by the duration in minutes when both are recorded.
+// The loads are summed without weighting, so the result is a unitless score that mixes, for example,
+// weight x reps and RPE x minutes. The overall rating is a performance rating and is not part of the load.
+func eventLoad(event Event, types map[string]ExerciseType) float64 {
+	load := float64(0)
+
+	for _, instance := range event.Exercises {
+		exerciseType, ok := types[instance.TypeID]
+		if !ok {
+			continue
+		}
+		instanceLoad, _, _ := exerciseType.CalculateMetrics(&instance)
+		load += float64(instanceLoad)
+	}
+
+	if event.RPE > 0 && event.Duration > 0 {
+		load += float64(event.RPE * event.Duration)
+	}
+
+	return load
+}
+
+// GetTrainingLoad returns the daily training load of the days from endDate to startDate and the models that are derived from it.
+// Loads before endDate are included in the models so that they are established at the start of the range.
+// Days start at midnight in loc.
+func (em eventManager) GetTrainingLoad(userID string, startDate, endDate int64, loc *time.Location) (*TrainingLoad, error) {
+	if endDate > startDate {
+		return nil, fmt.Errorf("end date %d is after start date %d", endDate, startDate)
+	}
+
+	model := Load
+
+	exerciseTypes, err := ExerciseManager.GetExerciseTypes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exercise types: %w", err)
+	}
+
+	types := map[string]ExerciseType{}
+	for _, t := range exerciseTypes {
+		types[t.ID] = t
+	}
+
+	firstDay := time.Unix(periodStart(PeriodDay, endDate, loc), 0).In(loc)
+	warmupDays := int(3 * math.Max(float64(model.ChronicDays), model.FitnessDays))
+	warmup := firstDay.AddDate(0, 0, -warmupDays)
+	lastDay := time.Unix(periodStart(PeriodDay, startDate, loc), 0).In(loc)
+
+	dailyLoads := map[int64]float64{}
+	err = em.forEachEvent(userID, "", ExerciseFilter{StartDate: startDate, EndDate: warmup.Unix()}, func(event Event) error {
+		dailyLoads[periodStart(PeriodDay, event.Date, loc)] += eventLoad(event, types)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	trainingLoad := TrainingLoad{
+		ACWRLow:  float32(model.ACWRLow),
+		ACWRHigh: float32(model.ACWRHigh),
+		Warnings: []LoadWarning{},
+	}
+
+	acuteFactor := 2 / (float64(model.AcuteDays) + 1)
+	chronicFactor := 2 / (float64(model.ChronicDays) + 1)
+	fitnessDecay := math.Exp(-1 / model.FitnessDays)
+	fatigueDecay := math.Exp(-1 / model.FatigueDays)
+
+	var acute, chronic, fitness, fatigue float64
+	daysLogged := 0 // days since the first load
+	var warning *LoadWarning
+
+	for day := warmup; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		load := dailyLoads[day.Unix()]
+
+		acute = load*acuteFactor + acute*(1-acuteFactor)
+		chronic = load*chronicFactor + chronic*(1-chronicFactor)
+		fitness = fitness*fitnessDecay + load
+		fatigue = fatigue*fatigueDecay + load
+
+		if load > 0 || daysLogged > 0 {
+			daysLogged++
+		}
+
+		if day.Before(firstDay) {
+			continue
+		}
+
+		acwr := float64(0)
+		if chronic > 0 {
+			acwr = acute / chronic
+		}
+
+		trainingLoad.Dates = append(trainingLoad.Dates, day.Unix())
+		trainingLoad.Load = append(trainingLoad.Load, round(load, 1))
+		trainingLoad.Acute = append(trainingLoad.Acute, round(acute, 1))
+		trainingLoad.Chronic = append(trainingLoad.Chronic, round(chronic, 1))
+		trainingLoad.ACWR = append(trainingLoad.ACWR, round(acwr, 2))
+		trainingLoad.Fitness = append(trainingLoad.Fitness, round(fitness, 1))
+		trainingLoad.Fatigue = append(trainingLoad.Fatigue, round(fatigue, 1))
+		trainingLoad.Form = append(trainingLoad.Form, round(model.FitnessGain*fitness-model.FatigueGain*fatigue, 1))
+
+		// the ratio is unreliable until the chronic load is established
+		outside := daysLogged >= model.ChronicDays && (acwr < model.ACWRLow || acwr > model.ACWRHigh)
+		if !outside {
+			warning = nil
+			continue
+		}
+
+		high := acwr > model.ACWRHigh
+		if warning != nil && (warning.Peak > float32(model.ACWRHigh)) != high {
+			warning = nil
+		}
+
+		if warning == nil {
+			trainingLoad.Warnings = append(trainingLoad.Warnings, LoadWarning{Start: day.Unix(), Peak: round(acwr, 2)})
+			warning = &trainingLoad.Warnings[len(trainingLoad.Warnings)-1]
+		}
+
+		warning.End = day.Unix()
+		if (high && round(acwr, 2) > warning.Peak) || (!high && round(acwr, 2) < warning.Peak) {
+			warning.Peak = round(acwr, 2)
+		}
+
+		if high {
+			warning.Message = fmt.Sprintf("ACWR peaked at %.2f, above %.2f: the risk of overreaching is high", warning.Peak, model.ACWRHigh)
+		} else {
+			warning.Message = fmt.Sprintf("ACWR fell to %.2f, below %.2f: training load is too low to maintain fitness", warning.Peak, model.ACWRLow)
+		}
+	}
+
+	return &trainingLoad, nil
+}
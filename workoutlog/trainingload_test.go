@@ -0,0 +1,115 @@
+package workoutlog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/scottbrodersen/homegym/dal"
+)
+
+func TestTrainingLoad(t *testing.T) {
+	squat := ExerciseType{ID: "squat-id", Name: "squat", IntensityType: "weight", VolumeType: "count", VolumeConstraint: 2}
+
+	Convey("When we calculate the load of an event", t, func() {
+		event := Event{
+			EventMeta: EventMeta{Overall: 5, RPE: 7, Duration: 60},
+			Exercises: map[int]ExerciseInstance{
+				0: {TypeID: squat.ID, Segments: []ExerciseSegment{{Intensity: 100, Volume: [][]float32{{1, 1, 1}}}}},
+			},
+		}
+		types := map[string]ExerciseType{squat.ID: squat}
+
+		Convey("Then the session RPE load is added to the exercise load", func() {
+			So(eventLoad(event, types), ShouldEqual, 300+7*60)
+		})
+
+		Convey("Then the session RPE load is not added when the duration is not recorded", func() {
+			event.Duration = 0
+			So(eventLoad(event, types), ShouldEqual, 300)
+		})
+
+		Convey("Then the overall rating is not used as the session RPE", func() {
+			event.RPE = 0
+			So(eventLoad(event, types), ShouldEqual, 300)
+		})
+	})
+
+	Convey("When we set the ACWR bounds", t, func() {
+		defaults := Load
+		defer func() { Load = defaults }()
+
+		So(SetACWRBounds(0.7, 1.5), ShouldBeNil)
+		So(Load.ACWRLow, ShouldEqual, 0.7)
+		So(Load.ACWRHigh, ShouldEqual, 1.5)
+
+		So(SetACWRBounds(1.5, 1.5), ShouldNotBeNil)
+		So(SetACWRBounds(0, 1.5), ShouldNotBeNil)
+	})
+
+	Convey("Given a user who trains every day and then increases the load", t, func() {
+		db := dal.NewMockDal()
+		dal.DB = db
+
+		eMgr := NewMockExerciseManager()
+		ExerciseManager = eMgr
+		eMgr.On("GetExerciseTypes", testUserID).Return([]ExerciseType{squat}, nil)
+
+		day := int64(24 * 60 * 60)
+		lastDay := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+		startDate := lastDay + 12*60*60
+
+		// 60 days of events, most recent first, with three times the load in the last 7 days
+		eventsByte := [][]byte{}
+		for i := 0; i < 60; i++ {
+			duration := 10
+			if i < 7 {
+				duration = 30
+			}
+			event := Event{ID: "event", ActivityID: "activity", Date: startDate - int64(i)*day, EventMeta: EventMeta{RPE: 10, Duration: duration}}
+			eventByte, err := json.Marshal(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			eventsByte = append(eventsByte, eventByte)
+		}
+
+		db.On("GetEventPage", testUserID, "", startDate+1, DefaultPageSize).Return(eventsByte, nil)
+		db.On("GetEventExercises", testUserID, mock.Anything).Return([][]byte{}, nil)
+
+		Convey("When we get the training load of the last 10 days", func() {
+			trainingLoad, err := EventManager.GetTrainingLoad(testUserID, startDate, startDate-9*day, time.UTC)
+
+			Convey("Then there is a value for each day", func() {
+				So(err, ShouldBeNil)
+				So(trainingLoad.Dates, ShouldHaveLength, 10)
+				So(trainingLoad.Dates[0], ShouldEqual, lastDay-9*day)
+				So(trainingLoad.Dates[9], ShouldEqual, lastDay)
+				So(trainingLoad.Load[0], ShouldEqual, 100)
+				So(trainingLoad.Load[9], ShouldEqual, 300)
+				So(trainingLoad.Fitness[9], ShouldBeGreaterThan, trainingLoad.Fitness[0])
+				So(trainingLoad.Form[9], ShouldBeLessThan, trainingLoad.Form[0])
+			})
+
+			Convey("Then the increase in load is reported as a single warning", func() {
+				So(trainingLoad.ACWR[0], ShouldAlmostEqual, 1, 0.05)
+				So(trainingLoad.Warnings, ShouldHaveLength, 1)
+
+				warning := trainingLoad.Warnings[0]
+				So(warning.Start, ShouldEqual, lastDay-6*day)
+				So(warning.End, ShouldEqual, lastDay)
+				So(warning.Peak, ShouldBeGreaterThan, Load.ACWRHigh)
+				So(warning.Message, ShouldContainSubstring, "above")
+			})
+		})
+
+		Convey("When the date range ends before it starts", func() {
+			_, err := EventManager.GetTrainingLoad(testUserID, startDate-day, startDate, time.UTC)
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
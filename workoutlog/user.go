@@ -1,7 +1,10 @@
 package workoutlog
 
 import (
+	"errors"
 	"fmt"
+	"net/mail"
+	"time"
 
 	"github.com/scottbrodersen/homegym/auth"
 
@@ -14,23 +17,52 @@ type User struct {
 	Email          string    `validate:"required" json:"email"`
 	PwdHash        string    `json:"-"`
 	PwdHashVersion string    `json:"-"`
+	// Disabled users cannot log in.
+	Disabled bool `json:"disabled"`
+	// PasswordChangeRequired is true when the user must change their password before using the API.
+	PasswordChangeRequired bool `json:"passwordChangeRequired"`
 }
 
+var ErrIncorrectPassword = errors.New("current password is incorrect")
+var ErrInvalidPassword = errors.New("invalid password")
+var ErrInvalidEmail = errors.New("invalid email address")
+var ErrUserNotFound = errors.New("user not found")
+var ErrInvalidRole = errors.New("invalid role")
+var ErrRoleUnchanged = errors.New("role unchanged")
+
 var FrontDesk UserAdmin = new(userManager)
 
 type UserAdmin interface {
 	NewUser(username string, role auth.Role, email, password string) (*User, error)
 	GetUser(username string) (*User, error)
+	ChangeEmail(username, email string) (*User, error)
+	ChangePassword(username, currentPassword, newPassword string) error
+	ListUsers() ([]User, error)
+	ChangeUserRole(username string, role auth.Role) error
+	ResetPassword(username, password string) error
+	SetUserDisabled(username string, disabled bool) error
+	DeleteUser(username string) error
+	SignUp(username, email, password, inviteCode string) (*User, error)
+	CreateInvite(ttl time.Duration) (*Invite, error)
+	ListInvites() ([]Invite, error)
+	DeleteInvite(code string) error
 }
 
 type userManager struct{}
 
 func (u *userManager) NewUser(username string, role auth.Role, email, password string) (*User, error) {
+	if err := dal.CheckUserID(username); err != nil {
+		return nil, err
+	}
+
 	pwdUtil := auth.PasswordUtil{Version: auth.LatestVersion()}
 	hash, err := pwdUtil.Hash(password)
-	if err != nil {
+	if errors.Is(err, auth.ErrBusy) {
 		return nil, err
 	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPassword, err.Error())
+	}
 
 	if err = dal.DB.NewUser(username, email, hash, auth.LatestVersion(), string(role)); err != nil {
 		return nil, err
@@ -54,20 +86,46 @@ func (u *userManager) GetUser(username string) (*User, error) {
 	}
 
 	if email == nil && pwdHash == nil && pwdHashVersion == nil && role == nil {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
+	}
+
+	status, err := dal.DB.ReadUserStatus(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user status: %w", err)
 	}
 
 	var user User = User{
-		ID:             username,
-		Email:          *email,
-		PwdHash:        *pwdHash,
-		PwdHashVersion: *pwdHashVersion,
-		Role:           auth.Role(*role),
+		ID:                     username,
+		Email:                  *email,
+		PwdHash:                *pwdHash,
+		PwdHashVersion:         *pwdHashVersion,
+		Role:                   auth.Role(*role),
+		Disabled:               status.Disabled,
+		PasswordChangeRequired: status.PasswordChangeRequired,
 	}
 
 	return &user, nil
 }
 
+// ListUsers returns all users.
+func (u *userManager) ListUsers() ([]User, error) {
+	ids, err := dal.DB.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+	for _, id := range ids {
+		user, err := FrontDesk.GetUser(id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
 // Updates tables with field values of u
 // Does not update the role, password, or password version
 func (u *User) UpdateUserProfile() error {
@@ -91,21 +149,21 @@ func (u *User) UpdateUserPassword(newPassword string) error {
 
 	// check that the new password is different
 	pwdUtil := auth.PasswordUtil{Version: user.PwdHashVersion}
-	newPwdHash, err := pwdUtil.Hash(newPassword)
-	if err != nil {
+	if err := pwdUtil.ValidatePassword(newPassword, user.PwdHash); err == nil {
+		return fmt.Errorf("%w: password unchanged", ErrInvalidPassword)
+	} else if errors.Is(err, auth.ErrBusy) {
 		return err
 	}
 
-	if newPwdHash == user.PwdHash {
-		return fmt.Errorf("password unchanged")
-	}
-
 	// Use the latest password version when password is updated
 	pwdUtil.Version = auth.LatestVersion()
 	newHash, err := pwdUtil.Hash(newPassword)
-	if err != nil {
+	if errors.Is(err, auth.ErrBusy) {
 		return err
 	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPassword, err.Error())
+	}
 
 	if err := dal.DB.UpdateUserPassword(u.ID, newHash, auth.LatestVersion()); err != nil {
 		return err
@@ -117,14 +175,56 @@ func (u *User) UpdateUserPassword(newPassword string) error {
 	return nil
 }
 
+// ChangeEmail changes the email address of a user and returns the updated user.
+func (u *userManager) ChangeEmail(username, email string) (*User, error) {
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, ErrInvalidEmail
+	}
+
+	user, err := FrontDesk.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Email = email
+	if err := user.UpdateUserProfile(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ChangePassword changes the password of a user after the current password is verified.
+func (u *userManager) ChangePassword(username, currentPassword, newPassword string) error {
+	user, err := FrontDesk.GetUser(username)
+	if err != nil {
+		return err
+	}
+
+	pwdUtil := auth.PasswordUtil{Version: user.PwdHashVersion}
+	if err := pwdUtil.ValidatePassword(currentPassword, user.PwdHash); err != nil {
+		if errors.Is(err, auth.ErrBusy) {
+			return err
+		}
+		return ErrIncorrectPassword
+	}
+
+	return user.UpdateUserPassword(newPassword)
+}
+
+// ChangeUserRole changes the role of a user.
 func (u *userManager) ChangeUserRole(userID string, role auth.Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
 	user, err := FrontDesk.GetUser(userID)
 	if err != nil {
 		return err
 	}
 
 	if user.Role == role {
-		return fmt.Errorf("role unchanged")
+		return ErrRoleUnchanged
 	}
 
 	if err := dal.DB.ChangeUserRole(userID, string(role)); err != nil {
@@ -133,3 +233,44 @@ func (u *userManager) ChangeUserRole(userID string, role auth.Role) error {
 
 	return nil
 }
+
+// ResetPassword sets the password of a user without verifying the current password.
+// The user must change the password after they log in.
+func (u *userManager) ResetPassword(username, password string) error {
+	if _, err := FrontDesk.GetUser(username); err != nil {
+		return err
+	}
+
+	pwdUtil := auth.PasswordUtil{Version: auth.LatestVersion()}
+	hash, err := pwdUtil.Hash(password)
+	if errors.Is(err, auth.ErrBusy) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidPassword, err.Error())
+	}
+
+	if err := dal.DB.UpdateUserPassword(username, hash, auth.LatestVersion()); err != nil {
+		return err
+	}
+
+	return dal.DB.SetPasswordChangeRequired(username, true)
+}
+
+// SetUserDisabled disables or enables a user.
+func (u *userManager) SetUserDisabled(username string, disabled bool) error {
+	if _, err := FrontDesk.GetUser(username); err != nil {
+		return err
+	}
+
+	return dal.DB.SetUserDisabled(username, disabled)
+}
+
+// DeleteUser deletes a user and all of their data.
+func (u *userManager) DeleteUser(username string) error {
+	if _, err := FrontDesk.GetUser(username); err != nil {
+		return err
+	}
+
+	return dal.DB.DeleteUser(username)
+}
@@ -1,6 +1,7 @@
 package workoutlog
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/scottbrodersen/homegym/auth"
@@ -36,6 +37,8 @@ func TestUsers(t *testing.T) {
 	Convey("Given a dal client", t, func() {
 		db := dal.NewMockDal()
 		dal.DB = db
+		db.On("ReadUserStatus", mock.Anything).Return(&dal.UserStatus{}, nil)
+		db.On("GetKeys", auth.KeyTypes.Pepper).Return(map[string][]byte{"test-pepper-key-id": []byte("dGVzdCBwZXBwZXI=")}, map[string][]byte{}, nil)
 		Convey("When we add a user with correct attributes", func() {
 			db.On("NewUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 			user, err := FrontDesk.NewUser(testUserName, testRole, testEmail, testPassword)
@@ -79,6 +82,123 @@ func TestUsers(t *testing.T) {
 				So(hash, ShouldNotEqual, user.PwdHash)
 			})
 		})
+		Convey("When the user changes their password", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+			db.On("UpdateUserPassword", testUserName, mock.Anything, auth.LatestVersion()).Return(nil)
+
+			err := FrontDesk.ChangePassword(testUserName, testPassword, "newpassword0123456789")
+
+			Convey("Then the password is updated", func() {
+				So(err, ShouldBeNil)
+				So(db.AssertCalled(t, "UpdateUserPassword", testUserName, mock.Anything, auth.LatestVersion()), ShouldBeTrue)
+			})
+		})
+		Convey("When the user changes their password with the wrong current password", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+
+			err := FrontDesk.ChangePassword(testUserName, "wrongpassword", "newpassword0123456789")
+
+			Convey("Then the password is not updated", func() {
+				So(err, ShouldEqual, ErrIncorrectPassword)
+				db.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything, mock.Anything)
+			})
+		})
+		Convey("When the user changes their password to a password that is too short", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+
+			err := FrontDesk.ChangePassword(testUserName, testPassword, "short")
+
+			So(errors.Is(err, ErrInvalidPassword), ShouldBeTrue)
+			db.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything, mock.Anything)
+		})
+		Convey("When the user changes their email", func() {
+			newEmail := "new@example.com"
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+			db.On("UpdateUserProfile", testUserName, newEmail).Return(nil)
+
+			user, err := FrontDesk.ChangeEmail(testUserName, newEmail)
+
+			So(err, ShouldBeNil)
+			So(user.Email, ShouldEqual, newEmail)
+			So(db.AssertCalled(t, "UpdateUserProfile", testUserName, newEmail), ShouldBeTrue)
+		})
+		Convey("When the user changes their email to an invalid address", func() {
+			_, err := FrontDesk.ChangeEmail(testUserName, "not an address")
+
+			So(err, ShouldEqual, ErrInvalidEmail)
+			db.AssertNotCalled(t, "UpdateUserProfile", mock.Anything, mock.Anything)
+		})
+		Convey("When we get a user that does not exist", func() {
+			var none *string
+			db.On("ReadUser", testUserName).Return(none, none, none, none, nil)
+
+			_, err := FrontDesk.GetUser(testUserName)
+
+			So(err, ShouldEqual, ErrUserNotFound)
+		})
+		Convey("When we list the users", func() {
+			db.On("ListUsers").Return([]string{testUserName}, nil)
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+
+			users, err := FrontDesk.ListUsers()
+
+			So(err, ShouldBeNil)
+			So(users, ShouldResemble, []User{testUser()})
+		})
+		Convey("When we change the role of a user", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+			db.On("ChangeUserRole", testUserName, string(auth.Admin)).Return(nil)
+
+			err := FrontDesk.ChangeUserRole(testUserName, auth.Admin)
+
+			So(err, ShouldBeNil)
+			So(db.AssertCalled(t, "ChangeUserRole", testUserName, string(auth.Admin)), ShouldBeTrue)
+		})
+		Convey("When we change the role of a user to an unknown role", func() {
+			err := FrontDesk.ChangeUserRole(testUserName, testRole)
+
+			So(err, ShouldEqual, ErrInvalidRole)
+			db.AssertNotCalled(t, "ChangeUserRole", mock.Anything, mock.Anything)
+		})
+		Convey("When we change the role of a user to the role they have", func() {
+			userRole := string(auth.User)
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &userRole, nil)
+
+			err := FrontDesk.ChangeUserRole(testUserName, auth.User)
+
+			So(err, ShouldEqual, ErrRoleUnchanged)
+		})
+		Convey("When we reset the password of a user", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+			db.On("UpdateUserPassword", testUserName, mock.Anything, auth.LatestVersion()).Return(nil)
+			db.On("SetPasswordChangeRequired", testUserName, true).Return(nil)
+
+			err := FrontDesk.ResetPassword(testUserName, "temporarypassword0123")
+
+			Convey("Then the password is updated and must be changed", func() {
+				So(err, ShouldBeNil)
+				So(db.AssertCalled(t, "UpdateUserPassword", testUserName, mock.Anything, auth.LatestVersion()), ShouldBeTrue)
+				So(db.AssertCalled(t, "SetPasswordChangeRequired", testUserName, true), ShouldBeTrue)
+			})
+		})
+		Convey("When we disable a user", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+			db.On("SetUserDisabled", testUserName, true).Return(nil)
+
+			err := FrontDesk.SetUserDisabled(testUserName, true)
+
+			So(err, ShouldBeNil)
+			So(db.AssertCalled(t, "SetUserDisabled", testUserName, true), ShouldBeTrue)
+		})
+		Convey("When we delete a user", func() {
+			db.On("ReadUser", testUserName).Return(&testEmail, &testHash, &passwordVersion, &testRoleStr, nil)
+			db.On("DeleteUser", testUserName).Return(nil)
+
+			err := FrontDesk.DeleteUser(testUserName)
+
+			So(err, ShouldBeNil)
+			So(db.AssertCalled(t, "DeleteUser", testUserName), ShouldBeTrue)
+		})
 		Convey("When we update the user details", func() {
 			user := testUser()
 			user.Email = "new@example.com"